@@ -4,14 +4,15 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/handlers"
 	customMiddleware "github.com/pasanAbeysekara/collaborative-editor/internal/middleware"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/migrate"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rabbitmq/amqp091-go"
@@ -19,6 +20,20 @@ import (
 
 func main() {
 	cfg := config.Load()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrator, closeDB, err := storage.NewMigrator(context.Background(), cfg)
+		if err != nil {
+			log.Fatalf("Unable to initialize migrations: %v\n", err)
+		}
+		err = migrate.RunCommand(context.Background(), migrator, os.Args[2:], os.Stdout)
+		closeDB()
+		if err != nil {
+			log.Fatalf("Migration failed: %v\n", err)
+		}
+		return
+	}
+
 	conn, err := amqp091.Dial(cfg.RabbitMQ_URL)
 	if err != nil { log.Fatalf("Failed to connect to RabbitMQ: %v", err) }
 	defer conn.Close()
@@ -26,16 +41,26 @@ func main() {
 	ch, err := conn.Channel()
 	if err != nil { log.Fatalf("Failed to open a channel: %v", err) }
 	defer ch.Close()
-	auth.Initialize(cfg)
+	if err := auth.Initialize(cfg); err != nil {
+		log.Fatalf("Unable to initialize auth: %v\n", err)
+	}
 
-	pool, err := pgxpool.New(context.Background(), cfg.DatabaseURL)
+	store, closeStore, err := storage.NewStore(context.Background(), cfg)
 	if err != nil {
-		log.Fatalf("Unable to connect to database: %v\n", err)
+		log.Fatalf("Unable to initialize %s storage: %v\n", cfg.StorageBackend, err)
 	}
-	defer pool.Close()
-
-	var store storage.Store = storage.NewPostgresStore(pool)
-    docHandler := &handlers.DocumentHandler{Store: store, AMQPChannel: ch}
+	defer closeStore()
+	if mem, ok := store.(*storage.MemoryStore); ok {
+		// Accounts are registered in user-service's store, not this one.
+		mem.AdoptUnknownUsers()
+	}
+	auth.SetPersonalTokens(handlers.NewPersonalTokenVerifier(store))
+	serviceAuth, err := auth.NewServiceVerifier(cfg, auth.InternalAudience)
+	if err != nil {
+		log.Fatalf("Unable to initialize service authentication: %v\n", err)
+	}
+	docHandler := &handlers.DocumentHandler{Store: store, AMQPChannel: ch}
+	folderHandler := &handlers.FolderHandler{Store: store}
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
@@ -44,19 +69,80 @@ func main() {
 
 	r.Handle("/metrics", promhttp.Handler())
 
-	// Internal route for other services, no auth middleware needed
-	r.Get("/documents/{documentID}/permissions/{userID}", docHandler.CheckPermission)
-	r.Get("/documents/{documentID}", docHandler.GetDocument)
-	r.Put("/documents/{documentID}", docHandler.SaveDocument)
+	// Document reads for users with access or holders of a share link
+	r.Group(func(r chi.Router) {
+		r.Use(docHandler.DocumentAccessMiddleware)
+		r.Get("/documents/{documentID}/content", docHandler.ReadDocument)
+	})
 
-	// Public-facing routes with auth
+	// Public-facing routes with auth. Personal access tokens are limited to
+	// the routes of their scopes.
 	r.Group(func(r chi.Router) {
 		r.Use(auth.JWTMiddleware)
-		r.Get("/documents", docHandler.GetUserDocuments)
-		r.Post("/documents", docHandler.CreateDocument)
-		r.Post("/documents/{documentID}/share", docHandler.ShareDocument)
+
+		r.Group(func(r chi.Router) {
+			r.Use(auth.RequireScope(auth.ScopeReadDocs))
+			r.Get("/documents", docHandler.GetUserDocuments)
+			r.Get("/documents/search", docHandler.SearchDocuments)
+			r.Get("/documents/tags", docHandler.ListTags)
+			r.Get("/documents/{documentID}/tags", docHandler.GetDocumentTags)
+			r.Get("/folders", folderHandler.ListFolders)
+			r.Get("/folders/{folderID}", folderHandler.GetFolder)
+			r.Get("/workspaces", folderHandler.ListWorkspaces)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(auth.RequireScope(auth.ScopeWriteDocs))
+			r.Post("/documents", docHandler.CreateDocument)
+			r.Put("/documents/{documentID}/folder", docHandler.MoveDocument)
+			r.Put("/documents/{documentID}/favorite", docHandler.AddFavorite)
+			r.Delete("/documents/{documentID}/favorite", docHandler.RemoveFavorite)
+			r.Post("/documents/{documentID}/tags", docHandler.AddDocumentTag)
+			r.Delete("/documents/{documentID}/tags/{tag}", docHandler.RemoveDocumentTag)
+			r.Post("/folders", folderHandler.CreateFolder)
+			r.Post("/workspaces", folderHandler.CreateWorkspace)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(auth.RequireScope(auth.ScopeShare))
+			r.Post("/documents/{documentID}/share", docHandler.ShareDocument)
+			r.Get("/documents/{documentID}/invitations", docHandler.ListInvitations)
+			r.Delete("/documents/{documentID}/invitations/{invitationID}", docHandler.CancelInvitation)
+			r.Post("/documents/{documentID}/access-requests", docHandler.RequestAccess)
+			r.Get("/documents/{documentID}/access-requests", docHandler.ListAccessRequests)
+			r.Post("/documents/{documentID}/access-requests/{requestID}/approve", docHandler.ApproveAccessRequest)
+			r.Post("/documents/{documentID}/access-requests/{requestID}/deny", docHandler.DenyAccessRequest)
+			r.Get("/documents/{documentID}/links", docHandler.ListShareLinks)
+			r.Post("/documents/{documentID}/links", docHandler.CreateShareLink)
+			r.Delete("/documents/{documentID}/links/{linkID}", docHandler.RevokeShareLink)
+			r.Post("/folders/{folderID}/share", folderHandler.ShareFolder)
+			r.Post("/workspaces/{workspaceID}/members", folderHandler.AddWorkspaceMember)
+			r.Delete("/workspaces/{workspaceID}/members/{userID}", folderHandler.RemoveWorkspaceMember)
+		})
+	})
+
+	// Internal routes for realtime-service, on their own listener that the
+	// gateway doesn't proxy. Callers identify themselves with service tokens.
+	// Routes under /internal can't be confused with public ones should the
+	// listener ever be exposed.
+	internal := chi.NewRouter()
+	internal.Use(middleware.Logger)
+	internal.Use(middleware.Recoverer)
+	internal.Use(serviceAuth.Middleware("realtime-service"))
+	internal.Route("/internal", func(r chi.Router) {
+		r.Get("/documents/{documentID}", docHandler.GetDocument)
+		r.Put("/documents/{documentID}", docHandler.SaveDocument)
+		r.Get("/documents/{documentID}/permissions/{userID}", docHandler.CheckPermission)
+		r.Post("/documents/{documentID}/opens/{userID}", docHandler.RecordOpen)
+		r.Post("/documents/{documentID}/links/open", docHandler.OpenShareLink)
+		r.Post("/personal-tokens/verify", docHandler.VerifyPersonalToken)
 	})
 
+	go func() {
+		log.Printf("Starting document-service internal listener on port %s...\n", cfg.InternalPort)
+		log.Fatal(http.ListenAndServe(":"+cfg.InternalPort, internal))
+	}()
+
 	log.Printf("Starting document-service on port %s...\n", cfg.Port)
 	http.ListenAndServe(":"+cfg.Port, r)
 }
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/mail"
 	"github.com/rabbitmq/amqp091-go"
 )
 
@@ -13,8 +18,181 @@ func failOnError(err error, msg string) {
 	}
 }
 
+// accountEvent is the payload of the user.* events that carry an account
+// token, and of user.password_changed and user.two_factor_*, which have no
+// token.
+type accountEvent struct {
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// lockedOutEvent is the payload of user.locked_out. UserID is empty when
+// the address has no account.
+type lockedOutEvent struct {
+	UserID      string `json:"user_id"`
+	Email       string `json:"email"`
+	LockedUntil string `json:"locked_until"`
+}
+
+// emailChangedEvent is the payload of user.email_changed; Email is the old
+// address.
+type emailChangedEvent struct {
+	UserID   string `json:"user_id"`
+	Email    string `json:"email"`
+	NewEmail string `json:"new_email"`
+}
+
+// accountDeletedEvent is the payload of user.deleted. Documents is the
+// policy applied to the documents the account owned.
+type accountDeletedEvent struct {
+	UserID     string `json:"user_id"`
+	Email      string `json:"email"`
+	Documents  string `json:"documents"`
+	TransferTo string `json:"transfer_to"`
+}
+
+// invitationEvent is the payload of user.invited. InvitedEmail is set when
+// the document was shared with an address that has no account yet; shares
+// with existing users carry SharedWithUserID instead and aren't mailed.
+type invitationEvent struct {
+	DocumentID       string `json:"document_id"`
+	InvitationID     string `json:"invitation_id"`
+	InvitedEmail     string `json:"invited_email"`
+	Role             string `json:"role"`
+	SharedWithUserID string `json:"shared_with_user_id"`
+	SharedByUserID   string `json:"shared_by_user_id"`
+}
+
+// accessRequestedEvent is the payload of user.access_requested. OwnerEmail
+// is empty when document-service couldn't look the owner up.
+type accessRequestedEvent struct {
+	RequestID       string `json:"request_id"`
+	DocumentID      string `json:"document_id"`
+	DocumentTitle   string `json:"document_title"`
+	OwnerID         string `json:"owner_id"`
+	OwnerEmail      string `json:"owner_email"`
+	RequesterUserID string `json:"requester_user_id"`
+	RequesterEmail  string `json:"requester_email"`
+	Role            string `json:"role"`
+	Message         string `json:"message"`
+}
+
+// accessRequestDecidedEvent is the payload of user.access_request_decided;
+// Status is "approved" or "denied".
+type accessRequestDecidedEvent struct {
+	RequestID       string `json:"request_id"`
+	DocumentID      string `json:"document_id"`
+	DocumentTitle   string `json:"document_title"`
+	RequesterUserID string `json:"requester_user_id"`
+	RequesterEmail  string `json:"requester_email"`
+	Role            string `json:"role"`
+	Status          string `json:"status"`
+}
+
+// personalTokenCreatedEvent is the payload of user.personal_token_created.
+type personalTokenCreatedEvent struct {
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	Scopes    string `json:"scopes"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// handleEvent sends the email for an event, if it calls for one.
+func handleEvent(ctx context.Context, mailer mail.Mailer, baseURL string, d amqp091.Delivery) error {
+	var msg mail.Message
+	switch d.RoutingKey {
+	case "user.verification_requested", "user.password_reset_requested", "user.password_changed",
+		"user.two_factor_enabled", "user.two_factor_disabled":
+		var event accountEvent
+		if err := json.Unmarshal(d.Body, &event); err != nil {
+			return fmt.Errorf("invalid %s event: %w", d.RoutingKey, err)
+		}
+		expiresAt, _ := time.Parse(time.RFC3339, event.ExpiresAt)
+		switch d.RoutingKey {
+		case "user.verification_requested":
+			msg = mail.VerificationEmail(event.Email, baseURL, event.Token, expiresAt)
+		case "user.password_reset_requested":
+			msg = mail.PasswordResetEmail(event.Email, baseURL, event.Token, expiresAt)
+		case "user.two_factor_enabled", "user.two_factor_disabled":
+			msg = mail.TwoFactorChangedEmail(event.Email, d.RoutingKey == "user.two_factor_enabled")
+		default:
+			msg = mail.PasswordChangedEmail(event.Email)
+		}
+	case "user.email_changed":
+		var event emailChangedEvent
+		if err := json.Unmarshal(d.Body, &event); err != nil {
+			return fmt.Errorf("invalid %s event: %w", d.RoutingKey, err)
+		}
+		msg = mail.EmailChangedEmail(event.Email, event.NewEmail)
+	case "user.deleted":
+		var event accountDeletedEvent
+		if err := json.Unmarshal(d.Body, &event); err != nil {
+			return fmt.Errorf("invalid %s event: %w", d.RoutingKey, err)
+		}
+		msg = mail.AccountDeletedEmail(event.Email, event.Documents, event.TransferTo)
+	case "user.personal_token_created":
+		var event personalTokenCreatedEvent
+		if err := json.Unmarshal(d.Body, &event); err != nil {
+			return fmt.Errorf("invalid %s event: %w", d.RoutingKey, err)
+		}
+		expiresAt, _ := time.Parse(time.RFC3339, event.ExpiresAt)
+		msg = mail.PersonalTokenCreatedEmail(event.Email, event.Name, event.Scopes, expiresAt)
+	case "user.invited":
+		var event invitationEvent
+		if err := json.Unmarshal(d.Body, &event); err != nil {
+			return fmt.Errorf("invalid %s event: %w", d.RoutingKey, err)
+		}
+		if event.InvitedEmail == "" {
+			log.Printf(" [x] Document %s was shared with user %s", event.DocumentID, event.SharedWithUserID)
+			return nil
+		}
+		msg = mail.InvitationEmail(event.InvitedEmail, baseURL, event.Role)
+	case "user.access_requested":
+		var event accessRequestedEvent
+		if err := json.Unmarshal(d.Body, &event); err != nil {
+			return fmt.Errorf("invalid %s event: %w", d.RoutingKey, err)
+		}
+		if event.OwnerEmail == "" {
+			log.Printf(" [x] User %s requested access to document %s", event.RequesterUserID, event.DocumentID)
+			return nil
+		}
+		msg = mail.AccessRequestedEmail(event.OwnerEmail, event.DocumentTitle, event.RequesterEmail, event.Role, event.Message)
+	case "user.access_request_decided":
+		var event accessRequestDecidedEvent
+		if err := json.Unmarshal(d.Body, &event); err != nil {
+			return fmt.Errorf("invalid %s event: %w", d.RoutingKey, err)
+		}
+		if event.RequesterEmail == "" {
+			log.Printf(" [x] Access request %s for document %s was %s", event.RequestID, event.DocumentID, event.Status)
+			return nil
+		}
+		msg = mail.AccessRequestDecidedEmail(event.RequesterEmail, event.DocumentTitle, event.Role, event.Status == "approved")
+	case "user.locked_out":
+		var event lockedOutEvent
+		if err := json.Unmarshal(d.Body, &event); err != nil {
+			return fmt.Errorf("invalid %s event: %w", d.RoutingKey, err)
+		}
+		if event.UserID == "" {
+			// Nobody to tell: the failed logins were for an unregistered address.
+			return nil
+		}
+		lockedUntil, _ := time.Parse(time.RFC3339, event.LockedUntil)
+		msg = mail.AccountLockedEmail(event.Email, lockedUntil)
+	default:
+		// Here we would process the message. For now, we just log it.
+		log.Printf(" [x] Received a message with routing key '%s': %s", d.RoutingKey, d.Body)
+		return nil
+	}
+	log.Printf(" [x] Sending email %q for routing key '%s'", msg.Subject, d.RoutingKey)
+	return mailer.Send(ctx, msg)
+}
+
 func main() {
 	cfg := config.Load()
+	mailer := mail.New(cfg)
 
 	conn, err := amqp091.Dial(cfg.RabbitMQ_URL)
 	failOnError(err, "Failed to connect to RabbitMQ")
@@ -75,9 +253,11 @@ func main() {
 
 	go func() {
 		for d := range msgs {
-			// Here we would process the message. For now, we just log it.
-			log.Printf(" [x] Received a message with routing key '%s': %s", d.RoutingKey, d.Body)
-			// Example logic: parse JSON, send email, etc.
+			// Account tokens are not logged; LogMailer prints the whole email
+			// when no SMTP server is configured.
+			if err := handleEvent(context.Background(), mailer, cfg.AppBaseURL, d); err != nil {
+				log.Printf("ERROR: Failed to handle message with routing key '%s': %v", d.RoutingKey, err)
+			}
 		}
 	}()
 
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/mail"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestHandleEventMailsInvitations(t *testing.T) {
+	mailer := &mail.MemoryMailer{}
+	invite := amqp091.Delivery{
+		RoutingKey: "user.invited",
+		Body:       []byte(`{"document_id":"doc-1","invitation_id":"inv-1","invited_email":"new@example.com","role":"editor","shared_by_user_id":"owner-1"}`),
+	}
+	if err := handleEvent(context.Background(), mailer, "https://editor.example.com/", invite); err != nil {
+		t.Fatalf("handleEvent: %v", err)
+	}
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To != "new@example.com" {
+		t.Fatalf("sent = %+v, want one email to the invitee", sent)
+	}
+	if !strings.Contains(sent[0].Body, "https://editor.example.com/register?email=new%40example.com") ||
+		!strings.Contains(sent[0].Body, "editor") {
+		t.Fatalf("invitation body = %q, want the sign-up link and role", sent[0].Body)
+	}
+
+	// Shares with existing accounts use the same event without an email.
+	share := amqp091.Delivery{
+		RoutingKey: "user.invited",
+		Body:       []byte(`{"document_id":"doc-1","shared_with_user_id":"user-2","shared_by_user_id":"owner-1"}`),
+	}
+	if err := handleEvent(context.Background(), mailer, "https://editor.example.com", share); err != nil {
+		t.Fatalf("handleEvent: %v", err)
+	}
+	if len(mailer.Sent()) != 1 {
+		t.Fatalf("share with an existing user sent %d emails, want none", len(mailer.Sent())-1)
+	}
+}
+
+func TestHandleEventMailsAccountLinks(t *testing.T) {
+	tests := []struct {
+		routingKey string
+		link       string
+	}{
+		{"user.verification_requested", "https://editor.example.com/verify-email?token=tok%2Fen"},
+		{"user.password_reset_requested", "https://editor.example.com/reset-password?token=tok%2Fen"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.routingKey, func(t *testing.T) {
+			mailer := &mail.MemoryMailer{}
+			d := amqp091.Delivery{
+				RoutingKey: tt.routingKey,
+				Body:       []byte(`{"user_id":"user-1","email":"someone@example.com","token":"tok/en","expires_at":"2030-01-02T03:04:05Z"}`),
+			}
+			if err := handleEvent(context.Background(), mailer, "https://editor.example.com", d); err != nil {
+				t.Fatalf("handleEvent: %v", err)
+			}
+			sent := mailer.Sent()
+			if len(sent) != 1 || sent[0].To != "someone@example.com" {
+				t.Fatalf("sent = %+v, want one email to the account", sent)
+			}
+			if !strings.Contains(sent[0].Body, tt.link) || !strings.Contains(sent[0].Body, "02 Jan 2030") {
+				t.Fatalf("body = %q, want link %s and the expiry", sent[0].Body, tt.link)
+			}
+		})
+	}
+}
+
+func TestHandleEventMailsAccessRequests(t *testing.T) {
+	mailer := &mail.MemoryMailer{}
+	requested := amqp091.Delivery{
+		RoutingKey: "user.access_requested",
+		Body: []byte(`{"request_id":"req-1","document_id":"doc-1","document_title":"Roadmap","owner_id":"owner-1","owner_email":"owner@example.com",` +
+			`"requester_user_id":"user-2","requester_email":"asker@example.com","role":"editor","message":"I'm reviewing it"}`),
+	}
+	if err := handleEvent(context.Background(), mailer, "https://editor.example.com", requested); err != nil {
+		t.Fatalf("handleEvent: %v", err)
+	}
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To != "owner@example.com" {
+		t.Fatalf("sent = %+v, want one email to the owner", sent)
+	}
+	for _, want := range []string{"asker@example.com", "editor", "Roadmap", "I'm reviewing it"} {
+		if !strings.Contains(sent[0].Body, want) {
+			t.Fatalf("request body = %q, want it to mention %q", sent[0].Body, want)
+		}
+	}
+
+	for _, status := range []string{"approved", "denied"} {
+		decided := amqp091.Delivery{
+			RoutingKey: "user.access_request_decided",
+			Body: []byte(`{"request_id":"req-1","document_id":"doc-1","document_title":"Roadmap","requester_user_id":"user-2",` +
+				`"requester_email":"asker@example.com","role":"editor","status":"` + status + `"}`),
+		}
+		if err := handleEvent(context.Background(), mailer, "https://editor.example.com", decided); err != nil {
+			t.Fatalf("handleEvent: %v", err)
+		}
+		sent = mailer.Sent()
+		last := sent[len(sent)-1]
+		if last.To != "asker@example.com" || !strings.Contains(last.Subject, status) || !strings.Contains(last.Body, "Roadmap") {
+			t.Fatalf("%s email = %+v, want it sent to the requester about Roadmap", status, last)
+		}
+	}
+
+	// Without the owner's address there is nobody to mail.
+	unknown := amqp091.Delivery{
+		RoutingKey: "user.access_requested",
+		Body:       []byte(`{"request_id":"req-2","document_id":"doc-1","owner_id":"owner-1","requester_user_id":"user-3","role":"viewer"}`),
+	}
+	if err := handleEvent(context.Background(), mailer, "https://editor.example.com", unknown); err != nil {
+		t.Fatalf("handleEvent: %v", err)
+	}
+	if len(mailer.Sent()) != 3 {
+		t.Fatalf("request without an owner email sent %d emails, want none", len(mailer.Sent())-3)
+	}
+}
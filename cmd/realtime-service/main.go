@@ -12,19 +12,50 @@ import (
 	"github.com/pasanAbeysekara/collaborative-editor/internal/realtime"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/redis/go-redis/v9"
+	"github.com/rabbitmq/amqp091-go"
 )
 
 func main() {
 	cfg := config.Load()
-	auth.Initialize(cfg)
+	if err := auth.Initialize(cfg); err != nil {
+		log.Fatalf("Unable to initialize auth: %v\n", err)
+	}
 
-	redisOpts, _ := redis.ParseURL(cfg.RedisURL)
-	redisClient := redis.NewClient(redisOpts)
-	var cache storage.Cache = storage.NewRedisCache(redisClient)
+	cache, err := storage.NewCache(cfg)
+	if err != nil {
+		log.Fatalf("Unable to initialize %s cache: %v\n", cfg.CacheBackend, err)
+	}
+	defer cache.Close()
 
-	// Pass the service URL from config/env
-	rtManager := realtime.NewManager(cache, cfg.DocumentServiceURL)
+	// Pass the service URL from config/env; it is document-service's internal
+	// listener, which only takes service tokens. Personal access tokens are
+	// checked by document-service too.
+	serviceTokens, err := auth.NewServiceTokenSource(cfg, "realtime-service", auth.InternalAudience)
+	if err != nil {
+		log.Fatalf("Unable to initialize service authentication: %v\n", err)
+	}
+	docs := realtime.NewHTTPDocumentService(cfg.DocumentServiceURL, serviceTokens)
+	auth.SetPersonalTokens(docs)
+	rtManager := realtime.NewManager(cache, docs)
+	rtManager.AllowQueryToken = cfg.WSAllowQueryToken
+	rtManager.AuthCheckInterval = cfg.WSAuthCheck
+
+	// Revoked share links are announced by document-service over RabbitMQ.
+	conn, err := amqp091.Dial(cfg.RabbitMQ_URL)
+	if err != nil {
+		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Fatalf("Failed to open a channel: %v", err)
+	}
+	defer ch.Close()
+
+	if err := rtManager.ConsumeLinkRevocations(ch); err != nil {
+		log.Fatalf("Failed to subscribe to link revocations: %v", err)
+	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
@@ -33,12 +64,13 @@ func main() {
 
 	r.Handle("/metrics", promhttp.Handler())
 
-	// WebSocket endpoint - handles authentication internally via query parameter
+	// WebSocket endpoint - handles authentication internally via a ticket, share link or token
 	r.Get("/ws/doc/{documentID}", rtManager.ServeWS)
+	r.Post("/realtime/link-tickets", rtManager.CreateLinkTicket)
 
 	r.Group(func(r chi.Router) {
-		r.Use(auth.JWTMiddleware)
-		// Add any other endpoints that need JWT middleware here
+		r.Use(auth.JWTMiddleware, auth.RequireScope(auth.ScopeReadDocs))
+		r.Post("/realtime/tickets", rtManager.CreateTicket)
 	})
 
 	log.Printf("Starting realtime-service on port %s...\n", cfg.Port)
@@ -1,35 +1,114 @@
 package main
 
-// This main.go only contains routes for /auth/*
+// This main.go only contains routes for /auth/*, /users/* and /teams/*
 // It uses the same internal packages as before.
 import (
 	"context"
 	"log"
 	"net/http"
+	"os"
+	// Profiles store IANA time zones, which the Alpine image has no database for.
+	_ "time/tzdata"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/handlers"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/lockout"
 	customMiddleware "github.com/pasanAbeysekara/collaborative-editor/internal/middleware"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/migrate"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/oidc"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/totp"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rabbitmq/amqp091-go"
 )
 
 func main() {
 	cfg := config.Load()
-	auth.Initialize(cfg)
 
-	pool, err := pgxpool.New(context.Background(), cfg.DatabaseURL)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrator, closeDB, err := storage.NewMigrator(context.Background(), cfg)
+		if err != nil {
+			log.Fatalf("Unable to initialize migrations: %v\n", err)
+		}
+		err = migrate.RunCommand(context.Background(), migrator, os.Args[2:], os.Stdout)
+		closeDB()
+		if err != nil {
+			log.Fatalf("Migration failed: %v\n", err)
+		}
+		return
+	}
+	if err := auth.Initialize(cfg); err != nil {
+		log.Fatalf("Unable to initialize auth: %v\n", err)
+	}
+
+	store, closeStore, err := storage.NewStore(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Unable to initialize %s storage: %v\n", cfg.StorageBackend, err)
+	}
+	defer closeStore()
+
+	// Verification and password reset emails are sent by notification-service.
+	conn, err := amqp091.Dial(cfg.RabbitMQ_URL)
+	if err != nil {
+		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Fatalf("Failed to open a channel: %v", err)
+	}
+	defer ch.Close()
+	if err := ch.ExchangeDeclare("events", "topic", true, false, false, false, nil); err != nil {
+		log.Fatalf("Failed to declare the events exchange: %v", err)
+	}
+
+	failures, err := lockout.NewStore(cfg)
 	if err != nil {
-		log.Fatalf("Unable to connect to database: %v\n", err)
+		log.Fatalf("Unable to initialize login lockout: %v\n", err)
 	}
-	defer pool.Close()
 
-	var store storage.Store = storage.NewPostgresStore(pool)
-	userHandler := &handlers.UserHandler{Store: store}
+	userHandler := &handlers.UserHandler{
+		Store:            store,
+		AMQPChannel:      ch,
+		RefreshTokenTTL:  cfg.RefreshTokenTTL,
+		VerificationTTL:  cfg.VerificationTTL,
+		PasswordResetTTL: cfg.PasswordResetTTL,
+		RequireVerified:  cfg.RequireVerified,
+		OIDCProvision:    cfg.OIDCProvision,
+		Lockout: lockout.NewGuard(failures, lockout.Policy{
+			MaxFailures:   cfg.LoginMaxFailures,
+			MaxIPFailures: cfg.LoginMaxIPFailures,
+			Lockout:       cfg.LoginLockout,
+			Delay:         cfg.LoginFailureDelay,
+		}),
+		TrustProxyHeaders: cfg.TrustProxyHeaders,
+	}
+	if cfg.OIDCIssuer != "" {
+		states, err := oidc.NewStateStore(cfg)
+		if err != nil {
+			log.Fatalf("Unable to initialize single sign-on: %v\n", err)
+		}
+		userHandler.OIDC = oidc.NewClient(oidc.Config{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+		}, states)
+	}
+	if cfg.TOTPEncryptionKey != "" {
+		userHandler.TOTP, err = totp.NewCipher(cfg.TOTPEncryptionKey)
+		if err != nil {
+			log.Fatalf("Unable to initialize two-factor authentication: %v\n", err)
+		}
+		userHandler.TOTPIssuer = cfg.TOTPIssuer
+	}
+	teamHandler := &handlers.TeamHandler{Store: store}
+	auth.SetPersonalTokens(handlers.NewPersonalTokenVerifier(store))
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
@@ -38,8 +117,48 @@ func main() {
 
 	r.Handle("/metrics", promhttp.Handler())
 
+	r.Get("/.well-known/jwks.json", auth.JWKSHandler)
+
 	r.Post("/auth/register", userHandler.Register)
 	r.Post("/auth/login", userHandler.Login)
+	r.Post("/auth/refresh", userHandler.Refresh)
+	r.Post("/auth/verify-email", userHandler.VerifyEmail)
+	r.Post("/auth/verify-email/resend", userHandler.ResendVerification)
+	r.Post("/auth/forgot-password", userHandler.ForgotPassword)
+	r.Post("/auth/reset-password", userHandler.ResetPassword)
+	if userHandler.OIDC != nil {
+		r.Get("/auth/oidc/login", userHandler.OIDCLogin)
+		r.Get("/auth/oidc/callback", userHandler.OIDCCallback)
+	}
+	if userHandler.TOTP != nil {
+		r.Post("/auth/2fa/verify", userHandler.VerifyLogin)
+	}
+
+	// Personal access tokens are only for documents; the account and teams
+	// need a login session.
+	r.Group(func(r chi.Router) {
+		r.Use(auth.JWTMiddleware, auth.RequireSession)
+		r.Post("/auth/logout", userHandler.Logout)
+		r.Get("/users/me", userHandler.GetMe)
+		r.Patch("/users/me", userHandler.UpdateMe)
+		r.Put("/users/me/password", userHandler.ChangePassword)
+		r.Put("/users/me/email", userHandler.ChangeEmail)
+		r.Delete("/users/me", userHandler.DeleteMe)
+		r.Get("/users/me/export", userHandler.ExportMe)
+		r.Get("/users/me/tokens", userHandler.ListPersonalTokens)
+		r.Post("/users/me/tokens", userHandler.CreatePersonalToken)
+		r.Delete("/users/me/tokens/{tokenID}", userHandler.RevokePersonalToken)
+		if userHandler.TOTP != nil {
+			r.Post("/auth/2fa/setup", userHandler.SetupTOTP)
+			r.Post("/auth/2fa/enable", userHandler.EnableTOTP)
+			r.Post("/auth/2fa/disable", userHandler.DisableTOTP)
+		}
+		r.Get("/teams", teamHandler.ListTeams)
+		r.Post("/teams", teamHandler.CreateTeam)
+		r.Get("/teams/{teamID}/members", teamHandler.GetTeamMembers)
+		r.Post("/teams/{teamID}/members", teamHandler.AddTeamMember)
+		r.Delete("/teams/{teamID}/members/{userID}", teamHandler.RemoveTeamMember)
+	})
 
 	log.Printf("Starting user-service on port %s...\n", cfg.Port)
 	http.ListenAndServe(":"+cfg.Port, r)
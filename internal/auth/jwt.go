@@ -2,19 +2,55 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
 )
 
-var jwtKey []byte
+// tokenSigner signs the tokens this service issues. kid is empty for
+// HS256 tokens signed with JWT_SECRET.
+type tokenSigner struct {
+	method jwt.SigningMethod
+	kid    string
+	key    interface{}
+}
+
+var (
+	signer         *tokenSigner
+	verifier       Verifier
+	publishedKeys  = JWKS{Keys: []JWK{}}
+	issuer         string
+	audience       string
+	accessTokenTTL = 15 * time.Minute
+)
+
+// revocations is replaced by Initialize; the default keeps ParseToken usable
+// without it.
+var revocations RevocationList = NewMemoryRevocationList()
+
+// ErrCannotSign is returned by CreateJWT in services that only verify
+// tokens against user-service's JWKS.
+var ErrCannotSign = errors.New("this service has no key to sign tokens with")
 
+// ErrTokenRevoked is returned for access tokens that were revoked, e.g. by
+// logging out, before they expired.
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// Claims are the claims of an access token. ID (jti) identifies the token
+// on the revocation list.
 type Claims struct {
 	UserID string `json:"userID"`
+	// Personal is set for personal access tokens, which are limited to
+	// Scopes. Neither is ever read from a JWT.
+	Personal bool     `json:"-"`
+	Scopes   []string `json:"-"`
 	jwt.RegisteredClaims
 }
 
@@ -22,21 +58,172 @@ type contextKey string
 
 const UserIDKey contextKey = "userID"
 
-func Initialize(cfg *config.Config) {
-	jwtKey = []byte(cfg.JWTSecret)
+// ClaimsKey holds the *Claims of the request's access token, set by
+// JWTMiddleware.
+const ClaimsKey contextKey = "claims"
+
+// Initialize sets up signing and verification from cfg, in order of
+// preference:
+//
+//   - JWT_SIGNING_KEYS: PEM private keys (RSA or Ed25519). The first one signs
+//     new tokens; all of them verify and are published by JWKSHandler, so a
+//     retiring key stays listed until the tokens it signed have expired.
+//     Only user-service should be given these.
+//   - JWKS_URL: tokens are verified against user-service's published key set
+//     and this service cannot issue any.
+//   - JWT_SECRET: HS256 with a secret shared by every service.
+//
+// It also loads the issuer, audience and access token lifetime and sets up
+// the revocation list.
+func Initialize(cfg *config.Config) error {
+	switch {
+	case len(cfg.JWTSigningKeys) > 0:
+		set := JWKS{Keys: []JWK{}}
+		var active *SigningKey
+		for _, path := range cfg.JWTSigningKeys {
+			key, err := LoadSigningKey(path)
+			if err != nil {
+				return fmt.Errorf("loading JWT signing key: %w", err)
+			}
+			if active == nil {
+				active = key
+			}
+			set.Keys = append(set.Keys, key.JWK())
+		}
+		signer = &tokenSigner{method: active.Method, kid: active.ID, key: active.key}
+		verifier = NewKeySetVerifier(set)
+		publishedKeys = set
+	case cfg.JWKSURL != "":
+		signer = nil
+		verifier = NewJWKSVerifier(cfg.JWKSURL, cfg.JWKSRefresh)
+	case cfg.JWTSecret != "":
+		signer = &tokenSigner{method: jwt.SigningMethodHS256, key: []byte(cfg.JWTSecret)}
+		verifier = NewHMACVerifier([]byte(cfg.JWTSecret))
+	default:
+		return errors.New("one of JWT_SIGNING_KEYS, JWKS_URL or JWT_SECRET is required")
+	}
+
+	issuer = cfg.JWTIssuer
+	audience = cfg.JWTAudience
+	if cfg.AccessTokenTTL > 0 {
+		accessTokenTTL = cfg.AccessTokenTTL
+	}
+
+	list, err := newRevocationList(cfg)
+	if err != nil {
+		return err
+	}
+	revocations = list
+	return nil
+}
+
+// SetVerifier replaces the Verifier chosen by Initialize.
+func SetVerifier(v Verifier) {
+	verifier = v
+}
+
+// AccessTokenTTL is how long tokens from CreateAccessToken are valid.
+func AccessTokenTTL() time.Duration {
+	return accessTokenTTL
+}
+
+// CreateAccessToken issues a short-lived access token for userID.
+func CreateAccessToken(userID string) (string, error) {
+	return CreateJWT(userID, accessTokenTTL)
 }
 
 func CreateJWT(userID string, duration time.Duration) (string, error) {
-	expirationTime := time.Now().Add(duration)
+	if signer == nil {
+		return "", ErrCannotSign
+	}
+
+	now := time.Now()
 	claims := &Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			ID:        uuid.NewString(),
+			Subject:   userID,
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	token := jwt.NewWithClaims(signer.method, claims)
+	if signer.kid != "" {
+		token.Header["kid"] = signer.kid
+	}
+	return token.SignedString(signer.key)
+}
+
+// ParseToken verifies tokenStr's signature, expiry, issuer and audience, and
+// that it has not been revoked. Personal access tokens are looked up instead,
+// once SetPersonalTokens has been called.
+func ParseToken(ctx context.Context, tokenStr string) (*Claims, error) {
+	if tokenStr == "" {
+		return nil, fmt.Errorf("token is required")
+	}
+	if personalTokens != nil && isPersonalToken(tokenStr) {
+		return personalTokens.VerifyPersonalToken(ctx, tokenStr)
+	}
+	if verifier == nil {
+		return nil, errors.New("auth is not initialized")
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		return verifier.VerificationKey(ctx, token)
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	if !claims.VerifyIssuer(issuer, true) || !claims.VerifyAudience(audience, true) {
+		return nil, errors.New("invalid token: wrong issuer or audience")
+	}
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return nil, errors.New("invalid token: missing jti or iat")
+	}
+
+	for _, id := range []string{claims.ID, userRevocationID(claims.UserID)} {
+		revoked, err := revocations.IsRevoked(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("checking token revocation: %w", err)
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
+// RevokeToken puts the token described by claims on the revocation list
+// until it expires.
+func RevokeToken(ctx context.Context, claims *Claims) error {
+	if claims.ExpiresAt == nil {
+		return errors.New("token has no expiry")
+	}
+	return revocations.Revoke(ctx, claims.ID, claims.ExpiresAt.Time)
+}
+
+// userRevocationID is the revocation list entry that stands for every token
+// of userID. Token IDs are UUIDs, so it can't clash with one.
+func userRevocationID(userID string) string {
+	return "user:" + userID
+}
+
+// RevokeUser revokes every access token issued to userID so far, for
+// accounts that are being deleted.
+func RevokeUser(ctx context.Context, userID string) error {
+	return revocations.Revoke(ctx, userRevocationID(userID), time.Now().Add(accessTokenTTL))
+}
+
+// JWKSHandler serves the public keys of JWT_SIGNING_KEYS as a JSON Web Key
+// Set, for /.well-known/jwks.json. The set is empty under HS256.
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(publishedKeys)
 }
 
 func JWTMiddleware(next http.Handler) http.Handler {
@@ -47,43 +234,23 @@ func JWTMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		claims := &Claims{}
-
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return jwtKey, nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, err := ParseToken(r.Context(), strings.TrimPrefix(authHeader, "Bearer "))
+		if err != nil {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-	ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
-	next.ServeHTTP(w, r.WithContext(ctx))
-})
+		ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+		ctx = context.WithValue(ctx, ClaimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
 
 // ValidateTokenFromQuery validates a JWT token from a query parameter and returns the user ID
-func ValidateTokenFromQuery(tokenStr string) (string, error) {
-	if tokenStr == "" {
-		return "", fmt.Errorf("token is required")
-	}
-
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtKey, nil
-	})
-
-	if err != nil || !token.Valid {
-		return "", fmt.Errorf("invalid token: %w", err)
+func ValidateTokenFromQuery(ctx context.Context, tokenStr string) (string, error) {
+	claims, err := ParseToken(ctx, tokenStr)
+	if err != nil {
+		return "", err
 	}
-
 	return claims.UserID, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey is a private key user-service signs access tokens with. ID is
+// the key's RFC 7638 thumbprint and goes in the kid header of every token it
+// signs, so verifiers can pick the matching public key during rotation.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+// LoadSigningKey reads a PEM-encoded RSA (RS256) or Ed25519 (EdDSA) private
+// key, in PKCS#8 or, for RSA, PKCS#1 form.
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key, err := NewSigningKey(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// NewSigningKey wraps an *rsa.PrivateKey or ed25519.PrivateKey.
+func NewSigningKey(private interface{}) (*SigningKey, error) {
+	var method jwt.SigningMethod
+	var signer crypto.Signer
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		method, signer = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		method, signer = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T; use RSA or Ed25519", private)
+	}
+
+	jwk, err := newJWK(method, signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: jwk.Kid, Method: method, key: signer}, nil
+}
+
+// JWK returns the key's public half as published in the JWKS.
+func (k *SigningKey) JWK() JWK {
+	jwk, _ := newJWK(k.Method, k.key.Public())
+	return jwk
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517). Only the members
+// needed for RSA and Ed25519 signature keys are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP (Ed25519)
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var b64 = base64.RawURLEncoding
+
+func newJWK(method jwt.SigningMethod, public crypto.PublicKey) (JWK, error) {
+	var jwk JWK
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		jwk = JWK{Kty: "RSA", N: b64.EncodeToString(pub.N.Bytes()), E: b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())}
+	case ed25519.PublicKey:
+		jwk = JWK{Kty: "OKP", Crv: "Ed25519", X: b64.EncodeToString(pub)}
+	default:
+		return JWK{}, fmt.Errorf("unsupported public key type %T", public)
+	}
+	jwk.Use = "sig"
+	jwk.Alg = method.Alg()
+	jwk.Kid = jwk.thumbprint()
+	return jwk, nil
+}
+
+// thumbprint is the RFC 7638 SHA-256 thumbprint of the key: the hash of its
+// required members, in lexicographic order, without whitespace.
+func (j JWK) thumbprint() string {
+	var members interface{}
+	if j.Kty == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.Kty, j.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return b64.EncodeToString(sum[:])
+}
+
+// PublicKey decodes the key, returning the signing method it is used with.
+func (j JWK) PublicKey() (jwt.SigningMethod, crypto.PublicKey, error) {
+	switch {
+	case j.Kty == "RSA" && (j.Alg == "" || j.Alg == jwt.SigningMethodRS256.Alg()):
+		n, err := b64.DecodeString(j.N)
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := b64.DecodeString(j.E)
+		if err != nil {
+			return nil, nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 {
+			return nil, nil, errors.New("invalid RSA exponent")
+		}
+		return jwt.SigningMethodRS256, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case j.Kty == "OKP" && j.Crv == "Ed25519":
+		x, err := b64.DecodeString(j.X)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, nil, errors.New("invalid Ed25519 key size")
+		}
+		return jwt.SigningMethodEdDSA, ed25519.PublicKey(x), nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported key %q (kty %s, alg %s)", j.Kid, j.Kty, j.Alg)
+	}
+}
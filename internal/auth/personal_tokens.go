@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Scopes of personal access tokens. JWTs are not limited by them.
+const (
+	// ScopeReadDocs lists, searches and reads documents, folders and
+	// workspaces, and opens read-only editing sessions.
+	ScopeReadDocs = "read-docs"
+	// ScopeWriteDocs creates, files and tags documents and folders, and
+	// lets editing sessions change content.
+	ScopeWriteDocs = "write-docs"
+	// ScopeShare manages shares, invitations, share links, access requests
+	// and workspace members.
+	ScopeShare = "share"
+)
+
+// Scopes lists every scope a personal access token can have.
+var Scopes = []string{ScopeReadDocs, ScopeWriteDocs, ScopeShare}
+
+// ValidScope reports whether scope is one of Scopes.
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// PersonalTokenVerifier looks up personal access tokens for ParseToken.
+type PersonalTokenVerifier interface {
+	// VerifyPersonalToken returns the claims of token, built with
+	// PersonalTokenClaims, or an error if it is unknown, revoked or expired.
+	VerifyPersonalToken(ctx context.Context, token string) (*Claims, error)
+}
+
+// personalTokens is nil until SetPersonalTokens is called; services without
+// it only accept JWTs.
+var personalTokens PersonalTokenVerifier
+
+// SetPersonalTokens makes ParseToken, and so JWTMiddleware, accept personal
+// access tokens looked up through v alongside JWTs.
+func SetPersonalTokens(v PersonalTokenVerifier) {
+	personalTokens = v
+}
+
+// PersonalTokenClaims returns the claims for the personal access token
+// tokenID, which acts as userID within scopes until expiresAt.
+func PersonalTokenClaims(tokenID, userID string, scopes []string, expiresAt time.Time) *Claims {
+	return &Claims{
+		UserID:   userID,
+		Personal: true,
+		Scopes:   scopes,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   userID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+}
+
+// isPersonalToken tells personal access tokens from JWTs, which always
+// have three dot-separated parts.
+func isPersonalToken(tokenStr string) bool {
+	return strings.Count(tokenStr, ".") != 2
+}
+
+// HasScope reports whether the token may be used for scope. Only personal
+// access tokens are limited.
+func (c *Claims) HasScope(scope string) bool {
+	return !c.Personal || slices.Contains(c.Scopes, scope)
+}
+
+// RequireScope rejects requests whose token, set by JWTMiddleware, lacks
+// scope.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(ClaimsKey).(*Claims)
+			if !ok {
+				http.Error(w, "Could not get token claims from context", http.StatusInternalServerError)
+				return
+			}
+			if !claims.HasScope(scope) {
+				http.Error(w, "Token lacks the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession rejects personal access tokens, for routes that manage the
+// account itself and need a login session.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value(ClaimsKey).(*Claims)
+		if !ok {
+			http.Error(w, "Could not get token claims from context", http.StatusInternalServerError)
+			return
+		}
+		if claims.Personal {
+			http.Error(w, "Personal access tokens can't be used here", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// RevocationList remembers the IDs (jti) of access tokens that were revoked
+// before they expired, such as the one a user logged out with. An entry only
+// has to outlive its token, so entries are dropped once until has passed.
+type RevocationList interface {
+	Revoke(ctx context.Context, jti string, until time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// RedisRevocationList shares revocations between every service that points
+// at the same Redis.
+type RedisRevocationList struct {
+	client *redis.Client
+}
+
+func NewRedisRevocationList(client *redis.Client) *RedisRevocationList {
+	return &RedisRevocationList{client: client}
+}
+
+func (l *RedisRevocationList) key(jti string) string {
+	return "revoked_jti:" + jti
+}
+
+func (l *RedisRevocationList) Revoke(ctx context.Context, jti string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	return l.client.Set(ctx, l.key(jti), 1, ttl).Err()
+}
+
+func (l *RedisRevocationList) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	n, err := l.client.Exists(ctx, l.key(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// MemoryRevocationList is a process-local RevocationList. A token revoked in
+// one service stays valid in the others until it expires, so it is only
+// meant for local development and tests.
+type MemoryRevocationList struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+func NewMemoryRevocationList() *MemoryRevocationList {
+	return &MemoryRevocationList{entries: make(map[string]time.Time)}
+}
+
+func (l *MemoryRevocationList) Revoke(ctx context.Context, jti string, until time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range l.entries {
+		if !now.Before(exp) {
+			delete(l.entries, id)
+		}
+	}
+	if now.Before(until) {
+		l.entries[jti] = until
+	}
+	return nil
+}
+
+func (l *MemoryRevocationList) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until, exists := l.entries[jti]
+	return exists && time.Now().Before(until), nil
+}
+
+// newRevocationList uses Redis when REDIS_URL is set and falls back to a
+// MemoryRevocationList otherwise.
+func newRevocationList(cfg *config.Config) (RevocationList, error) {
+	if cfg.RedisURL == "" {
+		log.Printf("WARN: REDIS_URL is not set; revoked tokens are only tracked by this process")
+		return NewMemoryRevocationList(), nil
+	}
+	redisOpts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	return NewRedisRevocationList(redis.NewClient(redisOpts)), nil
+}
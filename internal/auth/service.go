@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
+)
+
+// InternalAudience is the aud of service tokens for document-service's
+// internal listener.
+const InternalAudience = "document-service-internal"
+
+// serviceTokenTTL is how long a service token is valid. Sources sign a new
+// one once half of that has passed.
+const serviceTokenTTL = 5 * time.Minute
+
+// ServiceKey is the context key under which ServiceVerifier.Middleware
+// stores the name of the calling service.
+const ServiceKey contextKey = "service"
+
+// serviceAuthChallenge is the WWW-Authenticate header of responses that
+// reject a service token, which tells them apart from the 401 and 403
+// answers of the routes behind the middleware.
+const serviceAuthChallenge = `Bearer realm="internal"`
+
+// errNoServiceKey is returned when neither SERVICE_SIGNING_KEY nor
+// SERVICE_TOKEN_SECRET is set.
+var errNoServiceKey = errors.New("one of SERVICE_SIGNING_KEY or SERVICE_TOKEN_SECRET is required")
+
+// ServiceTokenSource signs the short-lived tokens a service identifies
+// itself with when it calls another service's internal routes. The token's
+// subject is the service's name.
+type ServiceTokenSource struct {
+	service  string
+	audience string
+	signer   tokenSigner
+
+	mu      sync.Mutex
+	token   string
+	renewAt time.Time
+}
+
+// NewServiceTokenSource signs tokens for service with SERVICE_SIGNING_KEY
+// (a PEM RSA or Ed25519 private key) or, failing that, the shared
+// SERVICE_TOKEN_SECRET.
+func NewServiceTokenSource(cfg *config.Config, service, audience string) (*ServiceTokenSource, error) {
+	src := &ServiceTokenSource{service: service, audience: audience}
+	switch {
+	case cfg.ServiceSigningKey != "":
+		key, err := LoadSigningKey(cfg.ServiceSigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading service signing key: %w", err)
+		}
+		src.signer = tokenSigner{method: key.Method, kid: key.ID, key: key.key}
+	case cfg.ServiceTokenSecret != "":
+		src.signer = tokenSigner{method: jwt.SigningMethodHS256, key: []byte(cfg.ServiceTokenSecret)}
+	default:
+		return nil, errNoServiceKey
+	}
+	return src, nil
+}
+
+// Token returns a valid service token, reusing the last one while it has
+// more than half of its lifetime left.
+func (s *ServiceTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.token != "" && now.Before(s.renewAt) {
+		return s.token, nil
+	}
+	claims := jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Subject:   s.service,
+		Issuer:    s.service,
+		Audience:  jwt.ClaimStrings{s.audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(serviceTokenTTL)),
+	}
+	token := jwt.NewWithClaims(s.signer.method, claims)
+	if s.signer.kid != "" {
+		token.Header["kid"] = s.signer.kid
+	}
+	signed, err := token.SignedString(s.signer.key)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.renewAt = signed, now.Add(serviceTokenTTL/2)
+	return signed, nil
+}
+
+// Authorize sets req's Authorization header to a service token.
+func (s *ServiceTokenSource) Authorize(req *http.Request) error {
+	token, err := s.Token()
+	if err != nil {
+		return fmt.Errorf("signing service token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// serviceKey is a public key trusted for one service's tokens.
+type serviceKey struct {
+	service string
+	publicKey
+}
+
+// ServiceVerifier checks the service tokens sent to internal routes.
+// Tokens signed with a key from SERVICE_TRUSTED_KEYS must name that key's
+// service; with SERVICE_TOKEN_SECRET, any holder of the secret can claim
+// any service name.
+type ServiceVerifier struct {
+	audience string
+	secret   []byte
+	keys     map[string]serviceKey
+}
+
+// NewServiceVerifier trusts the public keys in SERVICE_TRUSTED_KEYS, given
+// as service:path pairs naming PEM files, and SERVICE_TOKEN_SECRET if it is
+// set.
+func NewServiceVerifier(cfg *config.Config, audience string) (*ServiceVerifier, error) {
+	v := &ServiceVerifier{audience: audience, keys: map[string]serviceKey{}}
+	if cfg.ServiceTokenSecret != "" {
+		v.secret = []byte(cfg.ServiceTokenSecret)
+	}
+	for _, entry := range cfg.ServiceTrustedKeys {
+		service, path, ok := strings.Cut(entry, ":")
+		if !ok || service == "" || path == "" {
+			return nil, fmt.Errorf("SERVICE_TRUSTED_KEYS entry %q is not service:path", entry)
+		}
+		jwk, err := LoadPublicKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading trusted key of %s: %w", service, err)
+		}
+		method, key, err := jwk.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("loading trusted key of %s: %w", service, err)
+		}
+		v.keys[jwk.Kid] = serviceKey{service: service, publicKey: publicKey{method: method, key: key}}
+	}
+	if v.secret == nil && len(v.keys) == 0 {
+		return nil, errors.New("one of SERVICE_TRUSTED_KEYS or SERVICE_TOKEN_SECRET is required")
+	}
+	return v, nil
+}
+
+// Verify checks tokenStr and returns the name of the service it identifies.
+func (v *ServiceVerifier) Verify(tokenStr string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			if v.secret == nil {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return v.secret, nil
+		}
+		kid, _ := token.Header["kid"].(string)
+		k, ok := v.keys[kid]
+		if !ok {
+			return nil, errUnknownKey
+		}
+		if token.Method.Alg() != k.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %v for key %s", token.Header["alg"], kid)
+		}
+		if claims.Subject != k.service {
+			return nil, fmt.Errorf("key %s does not belong to %q", kid, claims.Subject)
+		}
+		return k.key, nil
+	})
+	if err != nil || !token.Valid {
+		return "", fmt.Errorf("invalid service token: %w", err)
+	}
+	if !claims.VerifyAudience(v.audience, true) {
+		return "", errors.New("invalid service token: wrong audience")
+	}
+	if claims.Subject == "" || claims.ExpiresAt == nil {
+		return "", errors.New("invalid service token: missing sub or exp")
+	}
+	return claims.Subject, nil
+}
+
+// Middleware admits requests carrying a valid service token from one of
+// services and stores the caller's name under ServiceKey.
+func (v *ServiceVerifier) Middleware(services ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				w.Header().Set("WWW-Authenticate", serviceAuthChallenge)
+				http.Error(w, "Service token required", http.StatusUnauthorized)
+				return
+			}
+			service, err := v.Verify(strings.TrimPrefix(authHeader, "Bearer "))
+			if err != nil {
+				log.Printf("Rejected internal request to %s: %v", r.URL.Path, err)
+				w.Header().Set("WWW-Authenticate", serviceAuthChallenge)
+				http.Error(w, "Invalid service token", http.StatusUnauthorized)
+				return
+			}
+			if !slices.Contains(services, service) {
+				log.Printf("Rejected internal request to %s from %s", r.URL.Path, service)
+				w.Header().Set("WWW-Authenticate", serviceAuthChallenge)
+				http.Error(w, "Service "+service+" may not call this route", http.StatusForbidden)
+				return
+			}
+			ctx := context.WithValue(r.Context(), ServiceKey, service)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// IsServiceAuthError reports whether resp is Middleware rejecting the
+// caller's service token, rather than an answer from the route itself.
+func IsServiceAuthError(resp *http.Response) bool {
+	return (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) &&
+		resp.Header.Get("WWW-Authenticate") == serviceAuthChallenge
+}
+
+// LoadPublicKey reads a PEM-encoded RSA or Ed25519 public key in PKIX
+// ("PUBLIC KEY") form, as printed by openssl pkey -pubout.
+func LoadPublicKey(path string) (JWK, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return JWK{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return JWK{}, fmt.Errorf("%s: no PEM data", path)
+	}
+	if block.Type != "PUBLIC KEY" {
+		return JWK{}, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return JWK{}, fmt.Errorf("%s: %w", path, err)
+	}
+	var method jwt.SigningMethod
+	switch public.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return JWK{}, fmt.Errorf("%s: unsupported key type %T; use RSA or Ed25519", path, public)
+	}
+	return newJWK(method, public)
+}
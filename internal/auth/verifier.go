@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+// Verifier supplies the key that checks a token's signature, chosen from the
+// token's alg and kid headers. ParseToken consults it for every token.
+type Verifier interface {
+	VerificationKey(ctx context.Context, token *jwt.Token) (interface{}, error)
+}
+
+// HMACVerifier checks HS256 tokens against the shared JWT_SECRET. Anything
+// holding the secret can also mint tokens.
+type HMACVerifier struct {
+	secret []byte
+}
+
+func NewHMACVerifier(secret []byte) *HMACVerifier {
+	return &HMACVerifier{secret: secret}
+}
+
+func (v *HMACVerifier) VerificationKey(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return v.secret, nil
+}
+
+type publicKey struct {
+	method jwt.SigningMethod
+	key    crypto.PublicKey
+}
+
+// publicKeys indexes the usable keys of set by kid, skipping (and logging)
+// ones it can't decode.
+func publicKeys(set JWKS) map[string]publicKey {
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		method, key, err := jwk.PublicKey()
+		if err != nil {
+			log.Printf("WARN: Skipping JWKS key: %v", err)
+			continue
+		}
+		keys[jwk.Kid] = publicKey{method: method, key: key}
+	}
+	return keys
+}
+
+var errUnknownKey = errors.New("token signed with an unknown key")
+
+// lookupKey returns the key in keys named by token's kid, refusing tokens
+// whose alg doesn't match the key so an RSA public key can never be used as
+// an HMAC secret.
+func lookupKey(keys map[string]publicKey, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	k, ok := keys[kid]
+	if !ok {
+		return nil, errUnknownKey
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %s", token.Header["alg"], kid)
+	}
+	return k.key, nil
+}
+
+// KeySetVerifier checks tokens against a fixed key set. user-service uses it
+// with the public halves of its own signing keys.
+type KeySetVerifier struct {
+	keys map[string]publicKey
+}
+
+func NewKeySetVerifier(set JWKS) *KeySetVerifier {
+	return &KeySetVerifier{keys: publicKeys(set)}
+}
+
+func (v *KeySetVerifier) VerificationKey(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	return lookupKey(v.keys, token)
+}
+
+// jwksMinRefetch limits how often a token with an unknown kid can make
+// JWKSVerifier fetch the key set again.
+const jwksMinRefetch = 10 * time.Second
+
+// JWKSVerifier checks tokens against the key set published by user-service.
+// The set is fetched on first use and again every refreshInterval, or sooner
+// when a token names a kid it hasn't seen, which is how a newly rotated key
+// is picked up. If a fetch fails the previous set stays in use.
+//
+// Fetches run outside the lock and concurrent ones are merged, so tokens
+// signed with a known key are never held up: when the set is merely due for
+// a refresh they are checked against the current one while it is fetched
+// in the background.
+type JWKSVerifier struct {
+	url             string
+	refreshInterval time.Duration
+	client          *http.Client
+	fetches         singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]publicKey
+	checkedAt time.Time
+}
+
+func NewJWKSVerifier(url string, refreshInterval time.Duration) *JWKSVerifier {
+	return &JWKSVerifier{
+		url:             url,
+		refreshInterval: refreshInterval,
+		client:          &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (v *JWKSVerifier) VerificationKey(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	v.mu.Lock()
+	keys := v.keys
+	_, known := keys[kid]
+	since := time.Since(v.checkedAt)
+	v.mu.Unlock()
+
+	switch {
+	case !known && since >= jwksMinRefetch:
+		keys = v.refresh()
+	case known && since >= v.refreshInterval:
+		go v.refresh()
+	}
+	return lookupKey(keys, token)
+}
+
+// refresh fetches the key set, sharing the fetch with concurrent callers,
+// and returns the set in use afterwards. The fetch isn't tied to any one
+// caller's context; the client's timeout bounds it.
+func (v *JWKSVerifier) refresh() map[string]publicKey {
+	keys, _, _ := v.fetches.Do(v.url, func() (interface{}, error) {
+		keys, err := v.fetch(context.Background())
+
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		v.checkedAt = time.Now()
+		if err != nil {
+			log.Printf("WARN: Failed to fetch JWKS from %s: %v", v.url, err)
+		} else {
+			v.keys = keys
+		}
+		return v.keys, nil
+	})
+	return keys.(map[string]publicKey)
+}
+
+func (v *JWKSVerifier) fetch(ctx context.Context) (map[string]publicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	return publicKeys(set), nil
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func newTestSigningKey(t *testing.T) *SigningKey {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewSigningKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func tokenFor(key *SigningKey) *jwt.Token {
+	token := jwt.New(key.Method)
+	token.Header["kid"] = key.ID
+	return token
+}
+
+// jwksServer publishes keys, counting fetches; while gate is non-nil each
+// fetch waits for it to be closed.
+type jwksServer struct {
+	*httptest.Server
+	fetches atomic.Int32
+	gate    chan struct{}
+}
+
+func newJWKSServer(t *testing.T, gate chan struct{}, keys ...*SigningKey) *jwksServer {
+	t.Helper()
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range keys {
+		set.Keys = append(set.Keys, key.JWK())
+	}
+	s := &jwksServer{gate: gate}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		if s.gate != nil {
+			<-s.gate
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestJWKSVerifierMergesConcurrentFetches(t *testing.T) {
+	key := newTestSigningKey(t)
+	gate := make(chan struct{})
+	srv := newJWKSServer(t, gate, key)
+	v := NewJWKSVerifier(srv.URL, time.Hour)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := v.VerificationKey(context.Background(), tokenFor(key))
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("VerificationKey: %v", err)
+		}
+	}
+	if n := srv.fetches.Load(); n != 1 {
+		t.Fatalf("fetched the key set %d times, want once", n)
+	}
+}
+
+func TestJWKSVerifierRefreshDoesNotBlockKnownKeys(t *testing.T) {
+	key := newTestSigningKey(t)
+	srv := newJWKSServer(t, nil, key)
+	v := NewJWKSVerifier(srv.URL, time.Hour)
+	if _, err := v.VerificationKey(context.Background(), tokenFor(key)); err != nil {
+		t.Fatalf("VerificationKey: %v", err)
+	}
+
+	// Make the set due for a refresh and the server hang.
+	gate := make(chan struct{})
+	defer close(gate)
+	srv.gate = gate
+	v.mu.Lock()
+	v.checkedAt = time.Now().Add(-2 * time.Hour)
+	v.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := v.VerificationKey(context.Background(), tokenFor(key))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("VerificationKey: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("VerificationKey waited for the key set refresh")
+	}
+}
+
+func TestJWKSVerifierUnknownKey(t *testing.T) {
+	key, other := newTestSigningKey(t), newTestSigningKey(t)
+	srv := newJWKSServer(t, nil, key)
+	v := NewJWKSVerifier(srv.URL, time.Hour)
+
+	if _, err := v.VerificationKey(context.Background(), tokenFor(other)); err != errUnknownKey {
+		t.Fatalf("VerificationKey = %v, want errUnknownKey", err)
+	}
+	// A second unknown kid right away doesn't fetch the set again.
+	v.VerificationKey(context.Background(), tokenFor(other))
+	if n := srv.fetches.Load(); n != 1 {
+		t.Fatalf("fetched the key set %d times, want once", n)
+	}
+}
@@ -2,17 +2,59 @@ package config
 
 import (
 	"log"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
 type Config struct {
-	Port               string `envconfig:"PORT" default:"8080"`
-	DatabaseURL        string `envconfig:"DATABASE_URL" required:"true"`
-	JWTSecret          string `envconfig:"JWT_SECRET" required:"true"`
-	RedisURL           string `envconfig:"REDIS_URL" required:"true"`
-	DocumentServiceURL string `envconfig:"DOCUMENT_SERVICE_URL"`
-	RabbitMQ_URL       string `envconfig:"RABBITMQ_URL" required:"true"`
+	Port               string        `envconfig:"PORT" default:"8080"`
+	InternalPort       string        `envconfig:"INTERNAL_PORT" default:"8081"`
+	StorageBackend     string        `envconfig:"STORAGE_BACKEND" default:"postgres"`
+	DatabaseURL        string        `envconfig:"DATABASE_URL"`
+	SQLitePath         string        `envconfig:"SQLITE_PATH" default:"collaborative-editor.db"`
+	DBQueryTimeout     time.Duration `envconfig:"DB_QUERY_TIMEOUT" default:"5s"`
+	MigrateOnStartup   bool          `envconfig:"MIGRATE_ON_STARTUP" default:"false"`
+	JWTSecret          string        `envconfig:"JWT_SECRET"`
+	JWTSigningKeys     []string      `envconfig:"JWT_SIGNING_KEYS"`
+	JWKSURL            string        `envconfig:"JWKS_URL"`
+	JWKSRefresh        time.Duration `envconfig:"JWKS_REFRESH_INTERVAL" default:"5m"`
+	JWTIssuer          string        `envconfig:"JWT_ISSUER" default:"collaborative-editor"`
+	JWTAudience        string        `envconfig:"JWT_AUDIENCE" default:"collaborative-editor"`
+	AccessTokenTTL     time.Duration `envconfig:"ACCESS_TOKEN_TTL" default:"15m"`
+	RefreshTokenTTL    time.Duration `envconfig:"REFRESH_TOKEN_TTL" default:"720h"`
+	VerificationTTL    time.Duration `envconfig:"EMAIL_VERIFICATION_TTL" default:"48h"`
+	PasswordResetTTL   time.Duration `envconfig:"PASSWORD_RESET_TTL" default:"1h"`
+	RequireVerified    bool          `envconfig:"REQUIRE_VERIFIED_EMAIL" default:"false"`
+	OIDCIssuer         string        `envconfig:"OIDC_ISSUER_URL"`
+	OIDCClientID       string        `envconfig:"OIDC_CLIENT_ID"`
+	OIDCClientSecret   string        `envconfig:"OIDC_CLIENT_SECRET"`
+	OIDCRedirectURL    string        `envconfig:"OIDC_REDIRECT_URL"`
+	OIDCScopes         []string      `envconfig:"OIDC_SCOPES" default:"openid,email,profile"`
+	OIDCProvision      bool          `envconfig:"OIDC_AUTO_PROVISION" default:"true"`
+	TOTPEncryptionKey  string        `envconfig:"TOTP_ENCRYPTION_KEY"`
+	TOTPIssuer         string        `envconfig:"TOTP_ISSUER" default:"Collaborative Editor"`
+	LoginMaxFailures   int           `envconfig:"LOGIN_MAX_FAILURES" default:"5"`
+	LoginMaxIPFailures int           `envconfig:"LOGIN_MAX_IP_FAILURES" default:"50"`
+	LoginLockout       time.Duration `envconfig:"LOGIN_LOCKOUT_DURATION" default:"15m"`
+	LoginFailureDelay  time.Duration `envconfig:"LOGIN_FAILURE_DELAY" default:"1s"`
+	TrustProxyHeaders  bool          `envconfig:"TRUST_PROXY_HEADERS" default:"false"`
+	CacheBackend       string        `envconfig:"CACHE_BACKEND" default:"redis"`
+	CacheTTL           time.Duration `envconfig:"CACHE_TTL" default:"0"`
+	RedisURL           string        `envconfig:"REDIS_URL"`
+	DocumentServiceURL string        `envconfig:"DOCUMENT_SERVICE_URL"`
+	ServiceTokenSecret string        `envconfig:"SERVICE_TOKEN_SECRET"`
+	ServiceSigningKey  string        `envconfig:"SERVICE_SIGNING_KEY"`
+	ServiceTrustedKeys []string      `envconfig:"SERVICE_TRUSTED_KEYS"`
+	WSAllowQueryToken  bool          `envconfig:"WS_ALLOW_QUERY_TOKEN" default:"false"`
+	WSAuthCheck        time.Duration `envconfig:"WS_AUTH_CHECK_INTERVAL" default:"1m"`
+	RabbitMQ_URL       string        `envconfig:"RABBITMQ_URL" required:"true"`
+	AppBaseURL         string        `envconfig:"APP_BASE_URL" default:"http://localhost:8080"`
+	SMTPHost           string        `envconfig:"SMTP_HOST"`
+	SMTPPort           string        `envconfig:"SMTP_PORT" default:"587"`
+	SMTPUsername       string        `envconfig:"SMTP_USERNAME"`
+	SMTPPassword       string        `envconfig:"SMTP_PASSWORD"`
+	MailFrom           string        `envconfig:"MAIL_FROM" default:"Collaborative Editor <no-reply@localhost>"`
 }
 
 func Load() *Config {
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// MaxAccessRequestMessage caps the note a requester can leave for the owner.
+const MaxAccessRequestMessage = 1000
+
+type AccessRequestRequest struct {
+	Role    string `json:"role"`
+	Message string `json:"message"`
+}
+
+// accessRequestHint is the way forward offered to users who are refused access.
+func accessRequestHint(documentID string) string {
+	return fmt.Sprintf("Forbidden: request access with POST /documents/%s/access-requests", documentID)
+}
+
+// publishEvent publishes event on the events exchange under routingKey,
+// logging rather than failing the request if RabbitMQ is unavailable.
+func (h *DocumentHandler) publishEvent(ctx context.Context, routingKey string, event map[string]string) {
+	eventBody, _ := json.Marshal(event)
+	err := h.AMQPChannel.PublishWithContext(ctx, "events", routingKey, false, false, amqp091.Publishing{
+		ContentType: "application/json",
+		Body:        eventBody,
+	})
+	if err != nil {
+		log.Printf("WARN: Failed to publish %s event for doc %s: %v", routingKey, event["document_id"], err)
+	}
+}
+
+// RequestAccess lets a user without (enough) access ask the owner for a role.
+func (h *DocumentHandler) RequestAccess(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentID")
+
+	var req AccessRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = storage.RoleViewer
+	}
+	if !storage.ValidShareRole(req.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	if len(req.Message) > MaxAccessRequestMessage {
+		http.Error(w, fmt.Sprintf("Message must be at most %d characters", MaxAccessRequestMessage), http.StatusBadRequest)
+		return
+	}
+
+	doc, err := h.Store.GetDocument(r.Context(), documentID)
+	if err != nil {
+		writeStoreError(w, err, "request access")
+		return
+	}
+
+	accessReq, err := h.Store.RequestAccess(r.Context(), documentID, userID, req.Role, req.Message)
+	if err != nil {
+		if errors.Is(err, storage.ErrAlreadyHasAccess) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeStoreError(w, err, "request access")
+		return
+	}
+
+	// The owner is mailed at their address; without it the event is only logged.
+	var ownerEmail string
+	if owner, err := h.Store.GetUserByID(r.Context(), doc.OwnerID); err == nil {
+		ownerEmail = owner.Email
+	} else {
+		log.Printf("WARN: Failed to look up owner %s of doc %s: %v", doc.OwnerID, documentID, err)
+	}
+
+	h.publishEvent(r.Context(), "user.access_requested", map[string]string{
+		"request_id":        accessReq.ID,
+		"document_id":       documentID,
+		"document_title":    doc.Title,
+		"owner_id":          doc.OwnerID,
+		"owner_email":       ownerEmail,
+		"requester_user_id": userID,
+		"requester_email":   accessReq.Email,
+		"role":              accessReq.Role,
+		"message":           accessReq.Message,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(accessReq)
+}
+
+// ListAccessRequests returns the document's pending requests to its owner.
+func (h *DocumentHandler) ListAccessRequests(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	requests, err := h.Store.ListAccessRequests(r.Context(), chi.URLParam(r, "documentID"), ownerID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotDocumentOwner) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		writeStoreError(w, err, "list access requests")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requests)
+}
+
+func (h *DocumentHandler) ApproveAccessRequest(w http.ResponseWriter, r *http.Request) {
+	h.decideAccessRequest(w, r, true)
+}
+
+func (h *DocumentHandler) DenyAccessRequest(w http.ResponseWriter, r *http.Request) {
+	h.decideAccessRequest(w, r, false)
+}
+
+// decideAccessRequest records the owner's decision and lets the requester
+// know through a user.access_request_decided event.
+func (h *DocumentHandler) decideAccessRequest(w http.ResponseWriter, r *http.Request, approve bool) {
+	ownerID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentID")
+	requestID := chi.URLParam(r, "requestID")
+
+	accessReq, err := h.Store.DecideAccessRequest(r.Context(), documentID, requestID, ownerID, approve)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotDocumentOwner):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, storage.ErrNotFound):
+			http.Error(w, "No pending access request found", http.StatusNotFound)
+		default:
+			writeStoreError(w, err, "decide access request")
+		}
+		return
+	}
+
+	var title string
+	if doc, err := h.Store.GetDocument(r.Context(), documentID); err == nil {
+		title = doc.Title
+	}
+
+	h.publishEvent(r.Context(), "user.access_request_decided", map[string]string{
+		"request_id":        accessReq.ID,
+		"document_id":       documentID,
+		"document_title":    title,
+		"requester_user_id": accessReq.UserID,
+		"requester_email":   accessReq.Email,
+		"role":              accessReq.Role,
+		"status":            accessReq.Status,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accessReq)
+}
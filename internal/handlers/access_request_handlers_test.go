@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+// notification-service mails the owner and the requester, so the events
+// carry their addresses.
+func TestAccessRequestEventsCarryEmails(t *testing.T) {
+	store := storage.NewMemoryStore()
+	events := &recordingPublisher{}
+	h := &DocumentHandler{Store: store, AMQPChannel: events}
+	owner := mustCreateUser(t, store)
+	requester := mustCreateUser(t, store)
+	doc, err := store.CreateDocument(context.Background(), "Roadmap", owner.ID)
+	if err != nil {
+		t.Fatalf("CreateDocument: %v", err)
+	}
+
+	rec := serve(h.RequestAccess, http.MethodPost, "/documents/{documentID}/access-requests",
+		"/documents/"+doc.ID+"/access-requests", `{"role":"editor"}`, requester.ID)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("RequestAccess = %d %s, want 201", rec.Code, rec.Body)
+	}
+	requested := events.take("user.access_requested")
+	if len(requested) != 1 || requested[0].body["owner_email"] != owner.Email ||
+		requested[0].body["requester_email"] != requester.Email || requested[0].body["document_title"] != "Roadmap" {
+		t.Fatalf("user.access_requested = %+v, want the owner's and requester's emails", requested)
+	}
+
+	target := "/documents/" + doc.ID + "/access-requests/" + requested[0].body["request_id"] + "/approve"
+	rec = serve(h.ApproveAccessRequest, http.MethodPost, "/documents/{documentID}/access-requests/{requestID}/approve", target, "", owner.ID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ApproveAccessRequest = %d %s, want 200", rec.Code, rec.Body)
+	}
+	decided := events.take("user.access_request_decided")
+	if len(decided) != 1 || decided[0].body["requester_email"] != requester.Email || decided[0].body["status"] != storage.AccessRequestApproved {
+		t.Fatalf("user.access_request_decided = %+v, want the requester's email and approval", decided)
+	}
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+// DeleteAccountRequest confirms an account deletion with the password and
+// says what happens to the documents, workspaces and folders the account
+// owns. TransferTo is the email of the account that gets them under the
+// "transfer" policy.
+type DeleteAccountRequest struct {
+	Password   string `json:"password"`
+	Documents  string `json:"documents"`
+	TransferTo string `json:"transfer_to"`
+}
+
+// DeleteMe deletes the caller's account. Its access tokens stop working at
+// once, and user.deleted is published so notification-service can confirm
+// the deletion by email.
+func (h *UserHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	var req DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !storage.ValidOwnedDocumentsPolicy(req.Documents) {
+		http.Error(w, `documents must be "transfer", "delete" or "orphan"`, http.StatusBadRequest)
+		return
+	}
+	if req.Documents == storage.OwnedDocumentsTransfer && req.TransferTo == "" {
+		http.Error(w, "transfer_to is required to transfer documents", http.StatusBadRequest)
+		return
+	}
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	if !h.checkPassword(w, r, user, req.Password) {
+		return
+	}
+
+	opts := storage.DeleteUserOptions{Documents: req.Documents}
+	if req.Documents == storage.OwnedDocumentsTransfer {
+		heir, err := h.Store.GetUserByEmail(r.Context(), req.TransferTo)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Could not delete account", http.StatusInternalServerError)
+			log.Printf("Error looking up transfer target for user %s: %v", user.ID, err)
+			return
+		}
+		if heir == nil || heir.ID == user.ID {
+			http.Error(w, storage.ErrInvalidTransferTarget.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.TransferTo = heir.ID
+	}
+
+	if err := h.Store.DeleteUser(r.Context(), user.ID, opts); err != nil {
+		if errors.Is(err, storage.ErrInvalidTransferTarget) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Could not delete account", http.StatusInternalServerError)
+		log.Printf("Error deleting user %s: %v", user.ID, err)
+		return
+	}
+	if err := auth.RevokeUser(r.Context(), user.ID); err != nil {
+		log.Printf("WARN: Failed to revoke access tokens of deleted user %s: %v", user.ID, err)
+	}
+	h.publishEvent(r.Context(), "user.deleted", map[string]string{
+		"user_id":     user.ID,
+		"email":       user.Email,
+		"documents":   req.Documents,
+		"transfer_to": req.TransferTo,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exportedDocument is an entry of documents.json in an account export.
+// File names the document's content in the archive; only the documents the
+// user owns have one.
+type exportedDocument struct {
+	*storage.DocumentSummary
+	File string `json:"file,omitempty"`
+}
+
+// exportedSharing is an entry of sharing.json: who one of the user's
+// documents is shared with, and how.
+type exportedSharing struct {
+	DocumentID  string                   `json:"document_id"`
+	Title       string                   `json:"title"`
+	SharedWith  []*storage.DocumentShare `json:"shared_with"`
+	Links       []*storage.ShareLink     `json:"links"`
+	Invitations []*storage.Invitation    `json:"invitations"`
+}
+
+// ExportMe sends the caller a zip archive of their data: profile.json,
+// documents.json listing every document they can access, the content of the
+// ones they own under documents/, sharing.json with how those are shared,
+// and teams.json and workspaces.json.
+func (h *UserHandler) ExportMe(w http.ResponseWriter, r *http.Request) {
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	files, err := h.exportFiles(r.Context(), user)
+	if err != nil {
+		http.Error(w, "Could not export account data", http.StatusInternalServerError)
+		log.Printf("Error exporting data of user %s: %v", user.ID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="collaborative-editor-export.zip"`)
+	zw := zip.NewWriter(w)
+	now := time.Now()
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: now})
+		if err == nil {
+			_, err = fw.Write(f.data)
+		}
+		if err != nil {
+			log.Printf("Error writing data export of user %s: %v", user.ID, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Error writing data export of user %s: %v", user.ID, err)
+	}
+}
+
+type exportFile struct {
+	name string
+	data []byte
+}
+
+// exportFiles gathers the files of user's data export. Everything is read
+// before the response starts, so a failure can still be reported.
+func (h *UserHandler) exportFiles(ctx context.Context, user *storage.User) ([]exportFile, error) {
+	var files []exportFile
+	addJSON := func(name string, v interface{}) {
+		data, _ := json.MarshalIndent(v, "", "  ")
+		files = append(files, exportFile{name: name, data: data})
+	}
+	addJSON("profile.json", profileResponse(user))
+
+	documents := []exportedDocument{}
+	sharing := []exportedSharing{}
+	opts := storage.DocumentListOptions{Limit: storage.MaxListLimit, IncludeContent: true}
+	for {
+		page, err := h.Store.ListDocuments(ctx, user.ID, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range page.Documents {
+			entry := exportedDocument{DocumentSummary: doc}
+			if doc.OwnerID == user.ID {
+				entry.File = fmt.Sprintf("documents/%s.txt", doc.ID)
+				files = append(files, exportFile{name: entry.File, data: []byte(doc.Content)})
+
+				shares, err := h.documentSharing(ctx, doc, user.ID)
+				if err != nil {
+					return nil, err
+				}
+				sharing = append(sharing, *shares)
+			}
+			doc.Content = ""
+			documents = append(documents, entry)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	addJSON("documents.json", documents)
+	addJSON("sharing.json", sharing)
+
+	teams, err := h.Store.ListTeams(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	addJSON("teams.json", teams)
+	workspaces, err := h.Store.ListWorkspaces(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	addJSON("workspaces.json", workspaces)
+	return files, nil
+}
+
+func (h *UserHandler) documentSharing(ctx context.Context, doc *storage.DocumentSummary, ownerID string) (*exportedSharing, error) {
+	shares, err := h.Store.ListDocumentShares(ctx, doc.ID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	links, err := h.Store.ListShareLinks(ctx, doc.ID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	invitations, err := h.Store.ListInvitations(ctx, doc.ID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return &exportedSharing{
+		DocumentID:  doc.ID,
+		Title:       doc.Title,
+		SharedWith:  shares,
+		Links:       links,
+		Invitations: invitations,
+	}, nil
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/password"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// EmailRequest names the account a verification or reset link is sent for.
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// publishEvent publishes event on the events exchange under routingKey,
+// logging rather than failing the request if RabbitMQ is unavailable.
+func (h *UserHandler) publishEvent(ctx context.Context, routingKey string, event map[string]string) {
+	eventBody, _ := json.Marshal(event)
+	err := h.AMQPChannel.PublishWithContext(ctx, "events", routingKey, false, false, amqp091.Publishing{
+		ContentType: "application/json",
+		Body:        eventBody,
+	})
+	if err != nil {
+		log.Printf("WARN: Failed to publish %s event for user %s: %v", routingKey, event["user_id"], err)
+	}
+}
+
+// sendAccountToken issues a token of purpose for user and publishes
+// routingKey so notification-service mails it. Failures are only logged: the
+// user can always ask for another link.
+func (h *UserHandler) sendAccountToken(ctx context.Context, user *storage.User, purpose, routingKey string, ttl time.Duration) {
+	expiresAt := time.Now().Add(ttl)
+	token, err := h.Store.CreateAccountToken(ctx, user.ID, purpose, expiresAt)
+	if err != nil {
+		log.Printf("WARN: Failed to create %s token for user %s: %v", purpose, user.ID, err)
+		return
+	}
+	h.publishEvent(ctx, routingKey, map[string]string{
+		"user_id":    user.ID,
+		"email":      user.Email,
+		"token":      token,
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+func (h *UserHandler) sendVerification(ctx context.Context, user *storage.User) {
+	h.sendAccountToken(ctx, user, storage.TokenVerifyEmail, "user.verification_requested", h.VerificationTTL)
+}
+
+// VerifyEmail confirms the address the token was mailed to and claims the
+// invitations sent to it.
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Store.VerifyEmail(r.Context(), req.Token)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidAccountToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Could not verify email", http.StatusInternalServerError)
+		log.Printf("Error verifying email: %v", err)
+		return
+	}
+	h.claimInvitations(r.Context(), user)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UserResponse{ID: user.ID, Email: user.Email, EmailVerified: user.EmailVerified})
+}
+
+// ResendVerification mails a new verification link, replacing earlier ones.
+// It answers 202 whether or not the address has an unverified account, so it
+// can't be used to find out who is registered.
+func (h *UserHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Store.GetUserByEmail(r.Context(), req.Email)
+	switch {
+	case err == nil:
+		if !user.EmailVerified {
+			h.sendVerification(r.Context(), user)
+		}
+	case !errors.Is(err, storage.ErrNotFound):
+		log.Printf("WARN: Failed to look up user for verification: %v", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ForgotPassword mails a password reset link to the account with the given
+// email, answering 202 whether or not there is one.
+func (h *UserHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Store.GetUserByEmail(r.Context(), req.Email)
+	switch {
+	case err == nil:
+		h.sendAccountToken(r.Context(), user, storage.TokenResetPassword, "user.password_reset_requested", h.PasswordResetTTL)
+	case !errors.Is(err, storage.ErrNotFound):
+		log.Printf("WARN: Failed to look up user for password reset: %v", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword. Every
+// session of the account is ended, so refresh tokens issued before the reset
+// stop working.
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+	// Look the account up first so the password is checked against its
+	// email like at registration. ResetPassword checks the token again.
+	user, err := h.Store.AccountTokenUser(r.Context(), req.Token, storage.TokenResetPassword)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidAccountToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Could not reset password", http.StatusInternalServerError)
+		log.Printf("Error looking up password reset token: %v", err)
+		return
+	}
+	if err := password.Validate(req.Password, user.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err = h.Store.ResetPassword(r.Context(), req.Token, req.Password)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidAccountToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Could not reset password", http.StatusInternalServerError)
+		log.Printf("Error resetting password: %v", err)
+		return
+	}
+
+	// Whoever was locked out can log in with the new password straight away.
+	if h.Lockout != nil {
+		if err := h.Lockout.Succeed(r.Context(), user.Email); err != nil {
+			log.Printf("WARN: Failed to clear login failures for user %s: %v", user.ID, err)
+		}
+	}
+	h.publishEvent(r.Context(), "user.password_changed", map[string]string{
+		"user_id": user.ID,
+		"email":   user.Email,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newAccountHandler() (*UserHandler, *recordingPublisher) {
+	events := &recordingPublisher{}
+	return &UserHandler{
+		Store:            storage.NewMemoryStore(),
+		AMQPChannel:      events,
+		VerificationTTL:  time.Hour,
+		PasswordResetTTL: time.Hour,
+	}, events
+}
+
+// mailedToken returns the token of the single routingKey event published
+// since the last call, as notification-service would mail it.
+func mailedToken(t *testing.T, events *recordingPublisher, routingKey string) string {
+	t.Helper()
+	sent := events.take(routingKey)
+	if len(sent) != 1 || sent[0].body["token"] == "" {
+		t.Fatalf("%s events = %+v, want one with a token", routingKey, sent)
+	}
+	return sent[0].body["token"]
+}
+
+func register(t *testing.T, h *UserHandler, email string) *storage.User {
+	t.Helper()
+	rec := serve(h.Register, http.MethodPost, "/auth/register", "/auth/register",
+		`{"email":"`+email+`","password":"correct horse battery"}`, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Register status = %d: %s", rec.Code, rec.Body)
+	}
+	user, err := h.Store.GetUserByEmail(context.Background(), email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	return user
+}
+
+func verifyEmail(h *UserHandler, token string) int {
+	return serve(h.VerifyEmail, http.MethodPost, "/auth/verify-email", "/auth/verify-email", `{"token":"`+token+`"}`, "").Code
+}
+
+func resetPassword(h *UserHandler, token, password string) int {
+	return serve(h.ResetPassword, http.MethodPost, "/auth/reset-password", "/auth/reset-password",
+		`{"token":"`+token+`","password":"`+password+`"}`, "").Code
+}
+
+func TestVerifyEmailClaimsInvitations(t *testing.T) {
+	h, events := newAccountHandler()
+	ctx := context.Background()
+	owner := mustCreateUser(t, h.Store)
+	doc, err := h.Store.CreateDocument(ctx, "Roadmap", owner.ID)
+	if err != nil {
+		t.Fatalf("CreateDocument: %v", err)
+	}
+	email := "invitee-" + uuid.NewString() + "@example.com"
+	if _, err := h.Store.InviteToDocument(ctx, doc.ID, owner.ID, email, storage.RoleEditor); err != nil {
+		t.Fatalf("InviteToDocument: %v", err)
+	}
+
+	user := register(t, h, email)
+	token := mailedToken(t, events, "user.verification_requested")
+	// Registering with the address doesn't prove it belongs to the caller.
+	if role, _ := h.Store.GetDocumentRole(ctx, doc.ID, user.ID); role != "" {
+		t.Fatalf("role before verification = %q, want none", role)
+	}
+
+	if code := verifyEmail(h, "not-a-token"); code != http.StatusBadRequest {
+		t.Fatalf("VerifyEmail(bad token) status = %d, want 400", code)
+	}
+	rec := serve(h.VerifyEmail, http.MethodPost, "/auth/verify-email", "/auth/verify-email", `{"token":"`+token+`"}`, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("VerifyEmail status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp UserResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || !resp.EmailVerified || resp.ID != user.ID {
+		t.Fatalf("VerifyEmail response = %+v, %v; want %s verified", resp, err, user.ID)
+	}
+	if role, _ := h.Store.GetDocumentRole(ctx, doc.ID, user.ID); role != storage.RoleEditor {
+		t.Fatalf("role after verification = %q, want %q", role, storage.RoleEditor)
+	}
+
+	if code := verifyEmail(h, token); code != http.StatusBadRequest {
+		t.Fatalf("VerifyEmail(reused token) status = %d, want 400", code)
+	}
+}
+
+func TestVerifyEmailRejectsExpiredToken(t *testing.T) {
+	h, events := newAccountHandler()
+	h.VerificationTTL = -time.Minute
+	user := register(t, h, "late-"+uuid.NewString()+"@example.com")
+
+	if code := verifyEmail(h, mailedToken(t, events, "user.verification_requested")); code != http.StatusBadRequest {
+		t.Fatalf("VerifyEmail(expired) status = %d, want 400", code)
+	}
+	got, _ := h.Store.GetUserByID(context.Background(), user.ID)
+	if got.EmailVerified {
+		t.Fatal("expired token verified the email")
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	h, events := newAccountHandler()
+	email := "resend-" + uuid.NewString() + "@example.com"
+	register(t, h, email)
+	first := mailedToken(t, events, "user.verification_requested")
+
+	resend := func(email string) int {
+		return serve(h.ResendVerification, http.MethodPost, "/auth/verify-email/resend", "/auth/verify-email/resend",
+			`{"email":"`+email+`"}`, "").Code
+	}
+
+	if code := resend(email); code != http.StatusAccepted {
+		t.Fatalf("ResendVerification status = %d, want 202", code)
+	}
+	second := mailedToken(t, events, "user.verification_requested")
+	// A new link replaces the old one.
+	if code := verifyEmail(h, first); code != http.StatusBadRequest {
+		t.Fatalf("VerifyEmail(replaced token) status = %d, want 400", code)
+	}
+	if code := verifyEmail(h, second); code != http.StatusOK {
+		t.Fatalf("VerifyEmail(new token) status = %d, want 200", code)
+	}
+
+	// Verified and unknown addresses get the same answer and no email.
+	for _, addr := range []string{email, "nobody@example.com"} {
+		if code := resend(addr); code != http.StatusAccepted {
+			t.Fatalf("ResendVerification(%s) status = %d, want 202", addr, code)
+		}
+	}
+	if sent := events.take("user.verification_requested"); len(sent) != 0 {
+		t.Fatalf("sent %d verification emails, want none", len(sent))
+	}
+}
+
+func TestForgotAndResetPassword(t *testing.T) {
+	h, events := newAccountHandler()
+	local := "forgetful-" + uuid.NewString()[:8]
+	email := local + "@example.com"
+	user := register(t, h, email)
+	session, err := h.Store.CreateRefreshToken(context.Background(), user.ID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	forgot := func(email string) int {
+		return serve(h.ForgotPassword, http.MethodPost, "/auth/forgot-password", "/auth/forgot-password",
+			`{"email":"`+email+`"}`, "").Code
+	}
+	if code := forgot("nobody@example.com"); code != http.StatusAccepted {
+		t.Fatalf("ForgotPassword(unknown) status = %d, want 202", code)
+	}
+	if sent := events.take("user.password_reset_requested"); len(sent) != 0 {
+		t.Fatalf("unknown address got %d reset emails, want none", len(sent))
+	}
+	if code := forgot(email); code != http.StatusAccepted {
+		t.Fatalf("ForgotPassword status = %d, want 202", code)
+	}
+	token := mailedToken(t, events, "user.password_reset_requested")
+
+	// Passwords are held to the same rules as at registration, and a
+	// rejected one leaves the token usable.
+	for _, bad := range []string{"short", "password123", "my " + local + " pass"} {
+		if code := resetPassword(h, token, bad); code != http.StatusBadRequest {
+			t.Fatalf("ResetPassword(%q) status = %d, want 400", bad, code)
+		}
+	}
+	if code := resetPassword(h, "not-a-token", "a new long passphrase"); code != http.StatusBadRequest {
+		t.Fatalf("ResetPassword(bad token) status = %d, want 400", code)
+	}
+
+	if code := resetPassword(h, token, "a new long passphrase"); code != http.StatusNoContent {
+		t.Fatalf("ResetPassword status = %d, want 204", code)
+	}
+	got, _ := h.Store.GetUserByID(context.Background(), user.ID)
+	if err := bcrypt.CompareHashAndPassword([]byte(got.PasswordHash), []byte("a new long passphrase")); err != nil {
+		t.Fatalf("password was not changed: %v", err)
+	}
+	if sent := events.take("user.password_changed"); len(sent) != 1 || sent[0].body["email"] != email {
+		t.Fatalf("password_changed events = %+v, want one for %s", sent, email)
+	}
+	if _, err := h.Store.RotateRefreshToken(context.Background(), session.Token, time.Now().Add(time.Hour)); err == nil {
+		t.Fatal("session from before the reset still works")
+	}
+
+	if code := resetPassword(h, token, "yet another passphrase"); code != http.StatusBadRequest {
+		t.Fatalf("ResetPassword(reused token) status = %d, want 400", code)
+	}
+}
+
+func TestResetPasswordRejectsExpiredToken(t *testing.T) {
+	h, events := newAccountHandler()
+	h.PasswordResetTTL = -time.Minute
+	email := "late-" + uuid.NewString() + "@example.com"
+	register(t, h, email)
+
+	serve(h.ForgotPassword, http.MethodPost, "/auth/forgot-password", "/auth/forgot-password", `{"email":"`+email+`"}`, "")
+	if code := resetPassword(h, mailedToken(t, events, "user.password_reset_requested"), "a new long passphrase"); code != http.StatusBadRequest {
+		t.Fatalf("ResetPassword(expired) status = %d, want 400", code)
+	}
+}
@@ -2,12 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgx/v5"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
 	"github.com/rabbitmq/amqp091-go"
@@ -15,7 +18,7 @@ import (
 
 type DocumentHandler struct {
 	Store       storage.Store
-	AMQPChannel *amqp091.Channel // Add this
+	AMQPChannel Publisher // Add this
 }
 
 type CreateDocumentRequest struct {
@@ -25,7 +28,18 @@ type CreateDocumentRequest struct {
 
 type ShareDocumentRequest struct {
 	TargetUserEmail string `json:"email"`
-	Role            string `json:"role"`
+	// TeamID shares a document with every member of a team instead of a
+	// single user; TargetUserEmail is then ignored.
+	TeamID string `json:"team_id"`
+	Role   string `json:"role"`
+}
+
+type MoveDocumentRequest struct {
+	FolderID string `json:"folder_id"`
+}
+
+type TagRequest struct {
+	Tag string `json:"tag"`
 }
 
 type UpdateDocumentRequest struct {
@@ -55,7 +69,7 @@ func (h *DocumentHandler) CreateDocument(w http.ResponseWriter, r *http.Request)
 		req.Content = ""
 	}
 
-	doc, err := h.Store.CreateDocument(req.Title, userID)
+	doc, err := h.Store.CreateDocument(r.Context(), req.Title, userID)
 	if err != nil {
 		http.Error(w, "Failed to create document", http.StatusInternalServerError)
 		return
@@ -66,6 +80,10 @@ func (h *DocumentHandler) CreateDocument(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(doc)
 }
 
+// GetUserDocuments lists the caller's documents one page at a time.
+// Query parameters: limit, cursor, sort (title|created|updated|last_opened),
+// order (asc|desc), filter (owned|shared), role, folder, tag (repeatable, all
+// must match), favorite=true, and include=content.
 func (h *DocumentHandler) GetUserDocuments(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(auth.UserIDKey).(string)
 	if !ok {
@@ -73,15 +91,112 @@ func (h *DocumentHandler) GetUserDocuments(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	documents, err := h.Store.GetUserDocuments(userID)
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.Store.ListDocuments(r.Context(), userID, opts)
 	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "Failed to retrieve documents", http.StatusInternalServerError)
 		log.Printf("Error retrieving documents for user %s: %v", userID, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(documents)
+	json.NewEncoder(w).Encode(page)
+}
+
+func parseListOptions(q url.Values) (storage.DocumentListOptions, error) {
+	opts := storage.DocumentListOptions{
+		Sort:           q.Get("sort"),
+		Cursor:         q.Get("cursor"),
+		Role:           q.Get("role"),
+		FolderID:       q.Get("folder"),
+		FavoritesOnly:  q.Get("favorite") == "true",
+		IncludeContent: q.Get("include") == "content",
+	}
+
+	for _, raw := range q["tag"] {
+		tag, ok := storage.NormalizeTag(raw)
+		if !ok {
+			return opts, fmt.Errorf("Invalid tag %q", raw)
+		}
+		opts.Tags = append(opts.Tags, tag)
+	}
+
+	if !storage.ValidSort(opts.Sort) {
+		return opts, fmt.Errorf("Invalid sort %q", opts.Sort)
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, fmt.Errorf("Invalid order %q", q.Get("order"))
+	}
+
+	switch filter := q.Get("filter"); filter {
+	case "", storage.OwnershipOwned, storage.OwnershipShared:
+		opts.Ownership = filter
+	default:
+		return opts, fmt.Errorf("Invalid filter %q", filter)
+	}
+
+	if opts.Role != "" && opts.Role != storage.RoleOwner && !storage.ValidShareRole(opts.Role) {
+		return opts, fmt.Errorf("Invalid role %q", opts.Role)
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > storage.MaxListLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", storage.MaxListLimit)
+		}
+		opts.Limit = n
+	}
+	return opts, nil
+}
+
+// SearchDocuments full-text searches the documents the caller can access.
+// Query parameters: q (required) and limit.
+func (h *DocumentHandler) SearchDocuments(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Query parameter q is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := storage.DefaultSearchLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > storage.MaxSearchLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", storage.MaxSearchLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	results, err := h.Store.SearchDocuments(r.Context(), userID, query, limit)
+	if err != nil {
+		http.Error(w, "Failed to search documents", http.StatusInternalServerError)
+		log.Printf("Error searching documents for user %s: %v", userID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
 }
 
 func (h *DocumentHandler) ShareDocument(w http.ResponseWriter, r *http.Request) {
@@ -99,15 +214,39 @@ func (h *DocumentHandler) ShareDocument(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	targetUser, err := h.Store.GetUserByEmail(req.TargetUserEmail)
+	if req.Role == "" {
+		req.Role = storage.RoleEditor
+	}
+	if !storage.ValidShareRole(req.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	if req.TeamID != "" {
+		h.shareDocumentWithTeam(w, r, documentID, ownerID, req)
+		return
+	}
+
+	req.TargetUserEmail = strings.TrimSpace(req.TargetUserEmail)
+	if req.TargetUserEmail == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	targetUser, err := h.Store.GetUserByEmail(r.Context(), req.TargetUserEmail)
+	if errors.Is(err, storage.ErrNotFound) {
+		h.inviteToDocument(w, r, documentID, ownerID, req)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Target user not found", http.StatusNotFound)
+		http.Error(w, "Failed to share document", http.StatusInternalServerError)
+		log.Printf("Error looking up share target: %v", err)
 		return
 	}
 
-	err = h.Store.ShareDocument(documentID, ownerID, targetUser.ID, req.Role)
+	err = h.Store.ShareDocument(r.Context(), documentID, ownerID, targetUser.ID, req.Role)
 	if err != nil {
-		if err.Error() == "permission denied: only the owner can share this document" {
+		if errors.Is(err, storage.ErrNotDocumentOwner) {
 			http.Error(w, err.Error(), http.StatusForbidden)
 			return
 		}
@@ -140,30 +279,202 @@ func (h *DocumentHandler) ShareDocument(w http.ResponseWriter, r *http.Request)
 	fmt.Fprintf(w, "Document %s shared with %s successfully", documentID, req.TargetUserEmail)
 }
 
+func (h *DocumentHandler) shareDocumentWithTeam(w http.ResponseWriter, r *http.Request, documentID, ownerID string, req ShareDocumentRequest) {
+	err := h.Store.ShareDocumentWithTeam(r.Context(), documentID, ownerID, req.TeamID, req.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotDocumentOwner):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, storage.ErrNotFound):
+			http.Error(w, "Team not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Failed to share document", http.StatusInternalServerError)
+			log.Printf("Error sharing document with team: %v", err)
+		}
+		return
+	}
+
+	eventBody, _ := json.Marshal(map[string]string{
+		"document_id":         documentID,
+		"shared_with_team_id": req.TeamID,
+		"shared_by_user_id":   ownerID,
+	})
+	err = h.AMQPChannel.PublishWithContext(r.Context(), "events", "document.shared_with_team", false, false, amqp091.Publishing{
+		ContentType: "application/json",
+		Body:        eventBody,
+	})
+	if err != nil {
+		log.Printf("WARN: Failed to publish document.shared_with_team event for doc %s: %v", documentID, err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Document %s shared with team %s successfully", documentID, req.TeamID)
+}
+
+// MoveDocument files one of the caller's documents in a folder they can
+// edit; an empty folder_id takes it out of its folder.
+func (h *DocumentHandler) MoveDocument(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentID")
+
+	var req MoveDocumentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Store.MoveDocument(r.Context(), documentID, userID, req.FolderID); err != nil {
+		writeStoreError(w, err, "move document")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddFavorite stars a document for the caller.
+func (h *DocumentHandler) AddFavorite(w http.ResponseWriter, r *http.Request) {
+	h.setFavorite(w, r, true)
+}
+
+// RemoveFavorite unstars a document for the caller.
+func (h *DocumentHandler) RemoveFavorite(w http.ResponseWriter, r *http.Request) {
+	h.setFavorite(w, r, false)
+}
+
+func (h *DocumentHandler) setFavorite(w http.ResponseWriter, r *http.Request, favorite bool) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentID")
+
+	if err := h.Store.SetFavorite(r.Context(), documentID, userID, favorite); err != nil {
+		writeStoreError(w, err, "update favorite")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListTags returns the tags on the caller's documents with how often each is used.
+func (h *DocumentHandler) ListTags(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	tags, err := h.Store.ListTags(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, err, "list tags")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+func (h *DocumentHandler) GetDocumentTags(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentID")
+
+	tags, err := h.Store.GetDocumentTags(r.Context(), documentID, userID)
+	if err != nil {
+		writeStoreError(w, err, "get tags")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+// AddDocumentTag tags a document. Tags are shared by everyone with access,
+// so changing them needs the editor role.
+func (h *DocumentHandler) AddDocumentTag(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentID")
+
+	var req TagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	tag, ok := storage.NormalizeTag(req.Tag)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Tag must be 1 to %d characters", storage.MaxTagLength), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Store.AddDocumentTag(r.Context(), documentID, userID, tag); err != nil {
+		writeStoreError(w, err, "add tag")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *DocumentHandler) RemoveDocumentTag(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentID")
+	tag, ok := storage.NormalizeTag(chi.URLParam(r, "tag"))
+	if !ok {
+		http.Error(w, "Invalid tag", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Store.RemoveDocumentTag(r.Context(), documentID, userID, tag); err != nil {
+		writeStoreError(w, err, "remove tag")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CheckPermission answers 200 with the user's role if they can open the
+// document, and 403 otherwise.
 func (h *DocumentHandler) CheckPermission(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "userID")
 	documentID := chi.URLParam(r, "documentID")
 
-	hasPermission, err := h.Store.CheckDocumentPermission(documentID, userID)
-	if err != nil {
+	role, err := h.Store.GetDocumentRole(r.Context(), documentID, userID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
 		http.Error(w, "Internal check failed", http.StatusInternalServerError)
 		return
 	}
 
-	if !hasPermission {
+	if role == "" {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"role": role})
 }
 
 func (h *DocumentHandler) GetDocument(w http.ResponseWriter, r *http.Request) {
 	documentID := chi.URLParam(r, "documentID")
 
-	doc, err := h.Store.GetDocument(documentID)
+	doc, err := h.Store.GetDocument(r.Context(), documentID)
 	if err != nil {
-		if err == pgx.ErrNoRows {
+		if errors.Is(err, storage.ErrNotFound) {
 			http.Error(w, "Document not found", http.StatusNotFound)
 			return
 		}
@@ -185,10 +496,30 @@ func (h *DocumentHandler) SaveDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.Store.UpdateDocument(documentID, req.Content, req.Version)
+	err := h.Store.UpdateDocument(r.Context(), documentID, req.Content, req.Version)
 	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, "Failed to update document", http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+// RecordOpen is called by realtime-service when userID opens documentID.
+func (h *DocumentHandler) RecordOpen(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	documentID := chi.URLParam(r, "documentID")
+
+	if err := h.Store.RecordDocumentOpen(r.Context(), documentID, userID); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to record document open", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+// FolderHandler serves folders and the workspaces they can live in. Documents
+// inside a folder are listed through GET /documents?folder={folderID}.
+type FolderHandler struct {
+	Store storage.Store
+}
+
+type CreateWorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+type CreateFolderRequest struct {
+	Name        string `json:"name"`
+	ParentID    string `json:"parent_id"`
+	WorkspaceID string `json:"workspace_id"`
+}
+
+// FolderResponse is a folder together with the folders directly inside it.
+type FolderResponse struct {
+	*storage.Folder
+	Folders []*storage.Folder `json:"folders"`
+}
+
+// writeStoreError maps storage errors to responses; what describes the
+// failed action for the generic 500 case.
+func writeStoreError(w http.ResponseWriter, err error, what string) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, storage.ErrPermissionDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Failed to "+what, http.StatusInternalServerError)
+		log.Printf("Error trying to %s: %v", what, err)
+	}
+}
+
+func (h *FolderHandler) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	var req CreateWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	ws, err := h.Store.CreateWorkspace(r.Context(), req.Name, userID)
+	if err != nil {
+		writeStoreError(w, err, "create workspace")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ws)
+}
+
+func (h *FolderHandler) ListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	workspaces, err := h.Store.ListWorkspaces(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, err, "list workspaces")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspaces)
+}
+
+// AddWorkspaceMember adds the user with the given email to the workspace, or
+// changes their role if they're already a member. Only the owner may do this.
+func (h *FolderHandler) AddWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	workspaceID := chi.URLParam(r, "workspaceID")
+
+	var req ShareDocumentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = storage.RoleEditor
+	}
+	if !storage.ValidShareRole(req.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	member, err := h.Store.GetUserByEmail(r.Context(), req.TargetUserEmail)
+	if err != nil {
+		http.Error(w, "Target user not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Store.AddWorkspaceMember(r.Context(), workspaceID, ownerID, member.ID, req.Role); err != nil {
+		writeStoreError(w, err, "add workspace member")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *FolderHandler) RemoveWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	workspaceID := chi.URLParam(r, "workspaceID")
+	memberID := chi.URLParam(r, "userID")
+
+	if err := h.Store.RemoveWorkspaceMember(r.Context(), workspaceID, ownerID, memberID); err != nil {
+		writeStoreError(w, err, "remove workspace member")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateFolder creates a folder inside parent_id, at the top of workspace_id,
+// or as a personal top-level folder when neither is given.
+func (h *FolderHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	var req CreateFolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	if req.ParentID != "" && req.WorkspaceID != "" {
+		http.Error(w, "Give either parent_id or workspace_id, not both", http.StatusBadRequest)
+		return
+	}
+
+	folder, err := h.Store.CreateFolder(r.Context(), req.Name, userID, req.ParentID, req.WorkspaceID)
+	if err != nil {
+		writeStoreError(w, err, "create folder")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(folder)
+}
+
+// ListFolders returns the caller's top-level folders: their own, the roots
+// of their workspaces, and folders shared with them directly.
+func (h *FolderHandler) ListFolders(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	folders, err := h.Store.ListFolders(r.Context(), userID, "")
+	if err != nil {
+		writeStoreError(w, err, "list folders")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(folders)
+}
+
+// GetFolder returns a folder and its subfolders.
+func (h *FolderHandler) GetFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	folderID := chi.URLParam(r, "folderID")
+
+	folder, err := h.Store.GetFolder(r.Context(), folderID, userID)
+	if err != nil {
+		writeStoreError(w, err, "get folder")
+		return
+	}
+	children, err := h.Store.ListFolders(r.Context(), userID, folderID)
+	if err != nil {
+		writeStoreError(w, err, "list folders")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FolderResponse{Folder: folder, Folders: children})
+}
+
+// ShareFolder grants a user a role on the folder and everything inside it.
+func (h *FolderHandler) ShareFolder(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	folderID := chi.URLParam(r, "folderID")
+
+	var req ShareDocumentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = storage.RoleEditor
+	}
+	if !storage.ValidShareRole(req.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	targetUser, err := h.Store.GetUserByEmail(r.Context(), req.TargetUserEmail)
+	if err != nil {
+		http.Error(w, "Target user not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Store.ShareFolder(r.Context(), folderID, ownerID, targetUser.ID, req.Role); err != nil {
+		writeStoreError(w, err, "share folder")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// TestMain sets up token signing so handlers that start sessions can issue
+// access tokens.
+func TestMain(m *testing.M) {
+	if err := auth.Initialize(&config.Config{JWTSecret: "handlers-test-secret"}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// event is one message given to a recordingPublisher.
+type event struct {
+	key  string
+	body map[string]string
+}
+
+// recordingPublisher keeps the events handlers publish, in order.
+type recordingPublisher struct {
+	events []event
+}
+
+func (p *recordingPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) error {
+	var body map[string]string
+	json.Unmarshal(msg.Body, &body)
+	p.events = append(p.events, event{key: key, body: body})
+	return nil
+}
+
+// take returns and forgets the events published under key.
+func (p *recordingPublisher) take(key string) []event {
+	var taken, kept []event
+	for _, e := range p.events {
+		if e.key == key {
+			taken = append(taken, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	p.events = kept
+	return taken
+}
+
+// serve routes one request to handler through a chi router registered at
+// pattern, so URL parameters resolve as they do in the services. userID, if
+// set, is put in the context as the auth middleware would.
+func serve(handler http.HandlerFunc, method, pattern, target, body, userID string) *httptest.ResponseRecorder {
+	router := chi.NewRouter()
+	router.Method(method, pattern, handler)
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if userID != "" {
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func mustCreateUser(t *testing.T, store storage.Store) *storage.User {
+	t.Helper()
+	user, err := store.CreateUser(context.Background(), "user-"+uuid.NewString()+"@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// inviteToDocument is ShareDocument for an email with no account yet: it
+// stores a pending invitation and publishes user.invited with the invite so
+// the notification service can email it.
+func (h *DocumentHandler) inviteToDocument(w http.ResponseWriter, r *http.Request, documentID, ownerID string, req ShareDocumentRequest) {
+	if !strings.Contains(req.TargetUserEmail, "@") {
+		http.Error(w, "Invalid email", http.StatusBadRequest)
+		return
+	}
+
+	inv, err := h.Store.InviteToDocument(r.Context(), documentID, ownerID, req.TargetUserEmail, req.Role)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotDocumentOwner) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Failed to invite user", http.StatusInternalServerError)
+		log.Printf("Error inviting to document: %v", err)
+		return
+	}
+
+	eventBody, _ := json.Marshal(map[string]string{
+		"document_id":       documentID,
+		"invitation_id":     inv.ID,
+		"invited_email":     inv.Email,
+		"role":              inv.Role,
+		"shared_by_user_id": ownerID,
+	})
+	err = h.AMQPChannel.PublishWithContext(r.Context(), "events", "user.invited", false, false, amqp091.Publishing{
+		ContentType: "application/json",
+		Body:        eventBody,
+	})
+	if err != nil {
+		log.Printf("WARN: Failed to publish user.invited event for doc %s: %v", documentID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(inv)
+}
+
+// ListInvitations returns the document's pending invitations to the owner.
+func (h *DocumentHandler) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	invitations, err := h.Store.ListInvitations(r.Context(), chi.URLParam(r, "documentID"), ownerID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotDocumentOwner) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Failed to list invitations", http.StatusInternalServerError)
+		log.Printf("Error listing invitations: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+func (h *DocumentHandler) CancelInvitation(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentID")
+	invitationID := chi.URLParam(r, "invitationID")
+
+	if err := h.Store.CancelInvitation(r.Context(), documentID, invitationID, ownerID); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotDocumentOwner):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, storage.ErrNotFound):
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Failed to cancel invitation", http.StatusInternalServerError)
+			log.Printf("Error cancelling invitation: %v", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Share link tokens and passwords are read from these headers. The token
+// may also be given in the link query parameter; the password never is.
+const (
+	ShareTokenHeader    = "X-Share-Token"
+	SharePasswordHeader = "X-Share-Password"
+)
+
+type handlerContextKey string
+
+// DocumentRoleKey holds the caller's role on the document, set by
+// DocumentAccessMiddleware.
+const DocumentRoleKey handlerContextKey = "documentRole"
+
+type CreateShareLinkRequest struct {
+	Role      string     `json:"role"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Password  string     `json:"password"`
+	MaxUses   int        `json:"max_uses"`
+}
+
+type OpenShareLinkRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type OpenShareLinkResponse struct {
+	LinkID string `json:"link_id"`
+	Role   string `json:"role"`
+}
+
+type DocumentContentResponse struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Role      string    `json:"role"`
+}
+
+// CreateShareLink creates a link to the document; the response is the only
+// time its token is returned.
+func (h *DocumentHandler) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentID")
+
+	var req CreateShareLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = storage.RoleViewer
+	}
+	if !storage.ValidShareRole(req.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+	if req.MaxUses < 0 {
+		http.Error(w, "max_uses must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	link, err := h.Store.CreateShareLink(r.Context(), documentID, ownerID, storage.ShareLinkOptions{
+		Role:      req.Role,
+		ExpiresAt: req.ExpiresAt,
+		Password:  req.Password,
+		MaxUses:   req.MaxUses,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrNotDocumentOwner) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+		log.Printf("Error creating share link: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+func (h *DocumentHandler) ListShareLinks(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	links, err := h.Store.ListShareLinks(r.Context(), chi.URLParam(r, "documentID"), ownerID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotDocumentOwner) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Failed to list share links", http.StatusInternalServerError)
+		log.Printf("Error listing share links: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+// RevokeShareLink stops a link from working and publishes link.revoked so
+// realtime-service disconnects everyone who joined through it.
+func (h *DocumentHandler) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	documentID := chi.URLParam(r, "documentID")
+	linkID := chi.URLParam(r, "linkID")
+
+	if err := h.Store.RevokeShareLink(r.Context(), documentID, linkID, ownerID); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotDocumentOwner):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, storage.ErrNotFound):
+			http.Error(w, "Share link not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Failed to revoke share link", http.StatusInternalServerError)
+			log.Printf("Error revoking share link: %v", err)
+		}
+		return
+	}
+
+	eventBody, _ := json.Marshal(map[string]string{
+		"document_id": documentID,
+		"link_id":     linkID,
+	})
+	err := h.AMQPChannel.PublishWithContext(r.Context(), "events", "link.revoked", false, false, amqp091.Publishing{
+		ContentType: "application/json",
+		Body:        eventBody,
+	})
+	if err != nil {
+		log.Printf("WARN: Failed to publish link.revoked event for link %s: %v", linkID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// openShareLink checks token and password against documentID's links and
+// counts a use of the matching one.
+func (h *DocumentHandler) openShareLink(ctx context.Context, documentID, token, password string) (*storage.ShareLink, error) {
+	link, err := h.Store.GetShareLinkByToken(ctx, documentID, token)
+	if err != nil {
+		return nil, err
+	}
+	if !link.Usable(time.Now()) {
+		return nil, storage.ErrLinkUnavailable
+	}
+	if !link.CheckPassword(password) {
+		return nil, storage.ErrLinkPassword
+	}
+	if err := h.Store.UseShareLink(ctx, link.ID); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func writeShareLinkError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		http.Error(w, "Share link not found", http.StatusNotFound)
+	case errors.Is(err, storage.ErrLinkPassword):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, storage.ErrLinkUnavailable):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Failed to open share link", http.StatusInternalServerError)
+		log.Printf("Error opening share link: %v", err)
+	}
+}
+
+// OpenShareLink is called by realtime-service when a client connects with a
+// share link instead of a user token. Every successful call counts as a use.
+func (h *DocumentHandler) OpenShareLink(w http.ResponseWriter, r *http.Request) {
+	documentID := chi.URLParam(r, "documentID")
+
+	var req OpenShareLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	link, err := h.openShareLink(r.Context(), documentID, req.Token, req.Password)
+	if err != nil {
+		writeShareLinkError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OpenShareLinkResponse{LinkID: link.ID, Role: link.Role})
+}
+
+// DocumentAccessMiddleware admits requests for {documentID} that carry
+// either a user token with access to it (and, for a personal access token,
+// the read-docs scope) or a usable share link, and stores the resulting role
+// under DocumentRoleKey. Link requests count as a link use.
+func (h *DocumentHandler) DocumentAccessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		documentID := chi.URLParam(r, "documentID")
+		ctx := r.Context()
+
+		var role string
+		if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+			claims, err := auth.ParseToken(ctx, strings.TrimPrefix(authHeader, "Bearer "))
+			if err != nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			if !claims.HasScope(auth.ScopeReadDocs) {
+				http.Error(w, "Token lacks the "+auth.ScopeReadDocs+" scope", http.StatusForbidden)
+				return
+			}
+			userID := claims.UserID
+			role, err = h.Store.GetDocumentRole(ctx, documentID, userID)
+			if err != nil {
+				writeStoreError(w, err, "check document access")
+				return
+			}
+			if role == "" {
+				http.Error(w, accessRequestHint(documentID), http.StatusForbidden)
+				return
+			}
+			ctx = context.WithValue(ctx, auth.UserIDKey, userID)
+		} else {
+			token := r.Header.Get(ShareTokenHeader)
+			if token == "" {
+				token = r.URL.Query().Get("link")
+			}
+			if token == "" {
+				http.Error(w, "Authorization header or share link required", http.StatusUnauthorized)
+				return
+			}
+			link, err := h.openShareLink(ctx, documentID, token, r.Header.Get(SharePasswordHeader))
+			if err != nil {
+				writeShareLinkError(w, err)
+				return
+			}
+			role = link.Role
+		}
+
+		ctx = context.WithValue(ctx, DocumentRoleKey, role)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ReadDocument returns a document's content to anyone admitted by
+// DocumentAccessMiddleware, along with the role they were admitted with.
+func (h *DocumentHandler) ReadDocument(w http.ResponseWriter, r *http.Request) {
+	role, _ := r.Context().Value(DocumentRoleKey).(string)
+
+	doc, err := h.Store.GetDocument(r.Context(), chi.URLParam(r, "documentID"))
+	if err != nil {
+		writeStoreError(w, err, "get document")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DocumentContentResponse{
+		ID:        doc.ID,
+		Title:     doc.Title,
+		Content:   doc.Content,
+		Version:   doc.Version,
+		UpdatedAt: doc.UpdatedAt,
+		Role:      role,
+	})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/lockout"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+// clientIP returns the address r came from. Behind the gateway that is the
+// X-Real-IP header it sets; otherwise clients could pick their own.
+func (h *UserHandler) clientIP(r *http.Request) string {
+	if h.TrustProxyHeaders {
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allowLogin responds with 429 and returns false if an attempt to log in to
+// email from ip has to wait. If failures can't be looked up the attempt goes
+// ahead, so an outage of Redis doesn't stop everyone logging in.
+func (h *UserHandler) allowLogin(w http.ResponseWriter, r *http.Request, email, ip string) bool {
+	if h.Lockout == nil {
+		return true
+	}
+	st, err := h.Lockout.Check(r.Context(), email, ip)
+	if err != nil {
+		log.Printf("WARN: Failed to check login failures for %q: %v", email, err)
+		return true
+	}
+	if st.Wait <= 0 {
+		return true
+	}
+
+	seconds := int((st.Wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, fmt.Sprintf("Too many failed login attempts; try again in %ds", seconds), http.StatusTooManyRequests)
+	return false
+}
+
+// loginFailed counts a failed attempt to log in to email from ip and
+// publishes it for auditing, along with the lockout it may cause. user is
+// nil if there is no such account.
+func (h *UserHandler) loginFailed(ctx context.Context, email, ip string, user *storage.User, reason string) {
+	var st lockout.Status
+	if h.Lockout != nil {
+		var err error
+		if st, err = h.Lockout.Fail(ctx, email, ip); err != nil {
+			log.Printf("WARN: Failed to count failed login for %q: %v", email, err)
+		}
+	}
+	log.Printf("WARN: Failed login for %q from %s: %s (%d in a row)", email, ip, reason, st.Failures)
+
+	event := map[string]string{
+		"email":    email,
+		"ip":       ip,
+		"reason":   reason,
+		"failures": strconv.Itoa(st.Failures),
+	}
+	if user != nil {
+		event["user_id"] = user.ID
+	}
+	h.publishEvent(ctx, "user.login_failed", event)
+	if st.LockedNow {
+		log.Printf("WARN: Login to %q locked for %s after %d failures", email, st.Wait.Round(time.Second), st.Failures)
+		event["locked_until"] = time.Now().Add(st.Wait).UTC().Format(time.RFC3339)
+		h.publishEvent(ctx, "user.locked_out", event)
+	}
+}
+
+// loginSucceeded clears user's failures and publishes the login for
+// auditing.
+func (h *UserHandler) loginSucceeded(ctx context.Context, user *storage.User, ip string) {
+	if h.Lockout != nil {
+		if err := h.Lockout.Succeed(ctx, user.Email); err != nil {
+			log.Printf("WARN: Failed to clear login failures for user %s: %v", user.ID, err)
+		}
+	}
+	h.publishEvent(ctx, "user.logged_in", map[string]string{
+		"user_id": user.ID,
+		"email":   user.Email,
+		"ip":      ip,
+	})
+}
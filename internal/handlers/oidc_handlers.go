@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/oidc"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+// oidcStateCookie binds a sign-in to the browser that started it.
+const oidcStateCookie = "oidc_state"
+
+var (
+	errSSONoEmail         = errors.New("the identity provider did not share an email address")
+	errSSOUnverifiedEmail = errors.New("an account with this email exists, but the identity provider has not verified the address, so it can't be linked")
+	errSSOUnverifiedLocal = errors.New("an account with this email exists, but its address has not been verified; verify it or reset the password before signing in with single sign-on")
+	errSSONoAccount       = errors.New("no account exists for this email and sign-up through single sign-on is disabled")
+)
+
+// OIDCLogin redirects the browser to the identity provider.
+func (h *UserHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := h.OIDC.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "Could not start single sign-on", http.StatusBadGateway)
+		log.Printf("Error starting OIDC login: %v", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   int(oidc.LoginStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes a sign-in the provider redirected back from and
+// responds with our own tokens, like Login. The account is found by the
+// provider's subject, then by email, which links it, and is otherwise
+// created if OIDCProvision allows it.
+func (h *UserHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if providerErr := q.Get("error"); providerErr != "" {
+		http.Error(w, "Sign-in was not completed: "+providerErr, http.StatusUnauthorized)
+		return
+	}
+
+	state := q.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		http.Error(w, oidc.ErrInvalidState.Error(), http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/auth/oidc", MaxAge: -1})
+
+	identity, err := h.OIDC.Finish(r.Context(), state, q.Get("code"))
+	if err != nil {
+		if errors.Is(err, oidc.ErrInvalidState) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Sign-in failed", http.StatusUnauthorized)
+		log.Printf("WARN: OIDC sign-in failed: %v", err)
+		return
+	}
+
+	user, err := h.userForIdentity(r.Context(), identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, errSSONoEmail), errors.Is(err, errSSOUnverifiedEmail), errors.Is(err, errSSOUnverifiedLocal),
+			errors.Is(err, errSSONoAccount):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "Could not sign in", http.StatusInternalServerError)
+			log.Printf("Error finding account for OIDC subject %s: %v", identity.Subject, err)
+		}
+		return
+	}
+	h.startSession(w, r, user)
+}
+
+// userForIdentity returns the account identity signs in to, linking or
+// creating it on first sign-in. An existing account is only linked when the
+// provider vouches for the email address; otherwise anyone able to register
+// that address at the provider could take the account over. The account
+// must have verified the address too: otherwise whoever registered it
+// first, with a password they know, would gain the real owner's sign-ins.
+func (h *UserHandler) userForIdentity(ctx context.Context, identity *oidc.Identity) (*storage.User, error) {
+	user, err := h.Store.GetUserByIdentity(ctx, identity.Issuer, identity.Subject)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return user, err
+	}
+	if identity.Email == "" {
+		return nil, errSSONoEmail
+	}
+
+	user, err = h.Store.GetUserByEmail(ctx, identity.Email)
+	switch {
+	case err == nil:
+		if !identity.EmailVerified {
+			return nil, errSSOUnverifiedEmail
+		}
+		if !user.EmailVerified {
+			return nil, errSSOUnverifiedLocal
+		}
+		if err := h.Store.LinkIdentity(ctx, user.ID, identity.Issuer, identity.Subject); err != nil {
+			return nil, err
+		}
+		log.Printf("Linked OIDC subject %s to user %s", identity.Subject, user.ID)
+		return user, nil
+	case !errors.Is(err, storage.ErrNotFound):
+		return nil, err
+	case !h.OIDCProvision:
+		return nil, errSSONoAccount
+	}
+
+	user, err = h.Store.CreateUserWithIdentity(ctx, identity.Email, identity.EmailVerified, identity.Issuer, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Provisioned user %s for OIDC subject %s", user.ID, identity.Subject)
+	if user.EmailVerified {
+		h.claimInvitations(ctx, user)
+	} else {
+		h.sendVerification(ctx, user)
+	}
+	return user, nil
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/oidc"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/oidc/oidctest"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+const oidcCallbackURL = "http://editor.test/auth/oidc/callback"
+
+func newOIDCHandler(t *testing.T) (*UserHandler, *oidctest.Provider) {
+	t.Helper()
+	p, err := oidctest.NewProvider("editor", "s3cret")
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	t.Cleanup(p.Close)
+	return &UserHandler{
+		Store:           storage.NewMemoryStore(),
+		AMQPChannel:     &recordingPublisher{},
+		RefreshTokenTTL: time.Hour,
+		VerificationTTL: time.Hour,
+		OIDC: oidc.NewClient(oidc.Config{
+			Issuer:       p.URL,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  oidcCallbackURL,
+			Scopes:       []string{"openid", "email"},
+		}, oidc.NewMemoryStateStore()),
+		OIDCProvision: true,
+	}, p
+}
+
+// startOIDC runs OIDCLogin and the provider's authorization endpoint, and
+// returns the state cookie and the callback query the browser would bring
+// back.
+func startOIDC(t *testing.T, h *UserHandler) (*http.Cookie, url.Values) {
+	t.Helper()
+	rec := serve(h.OIDCLogin, http.MethodGet, "/auth/oidc/login", "/auth/oidc/login", "", "")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("OIDCLogin status = %d: %s", rec.Code, rec.Body)
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("OIDCLogin cookies = %v, want an HttpOnly %s", rec.Result().Cookies(), oidcStateCookie)
+	}
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("GET authorization endpoint: %v", err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing redirect: %v", err)
+	}
+	if got := callback.Query().Get("state"); got != cookie.Value {
+		t.Fatalf("provider returned state %q, cookie holds %q", got, cookie.Value)
+	}
+	return cookie, callback.Query()
+}
+
+func oidcCallback(h *UserHandler, cookie *http.Cookie, query url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	h.OIDCCallback(rec, req)
+	return rec
+}
+
+// signInWithOIDC completes a whole sign-in as the provider's current user.
+func signInWithOIDC(t *testing.T, h *UserHandler) *httptest.ResponseRecorder {
+	t.Helper()
+	cookie, query := startOIDC(t, h)
+	return oidcCallback(h, cookie, query)
+}
+
+func sessionUser(t *testing.T, h *UserHandler, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("OIDCCallback status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp LoginResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Token == "" {
+		t.Fatalf("OIDCCallback response = %+v, %v; want tokens", resp, err)
+	}
+	refresh, err := h.Store.RotateRefreshToken(context.Background(), resp.RefreshToken, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	return refresh.UserID
+}
+
+func verifiedUser(t *testing.T, store storage.Store, email string) *storage.User {
+	t.Helper()
+	ctx := context.Background()
+	user, err := store.CreateUser(ctx, email, "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	token, err := store.CreateAccountToken(ctx, user.ID, storage.TokenVerifyEmail, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateAccountToken: %v", err)
+	}
+	if user, err = store.VerifyEmail(ctx, token); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	return user
+}
+
+func TestOIDCProvisionsNewUser(t *testing.T) {
+	h, p := newOIDCHandler(t)
+	email := "sso-" + uuid.NewString() + "@example.com"
+	p.SetUser(oidctest.User{Subject: "sub-new", Email: email, EmailVerified: true})
+
+	userID := sessionUser(t, h, signInWithOIDC(t, h))
+	user, err := h.Store.GetUserByIdentity(context.Background(), p.URL, "sub-new")
+	if err != nil || user.ID != userID || user.Email != email || !user.EmailVerified {
+		t.Fatalf("GetUserByIdentity = %+v, %v; want verified %s signed in as %s", user, err, email, userID)
+	}
+
+	// Signing in again finds the same account by subject.
+	if again := sessionUser(t, h, signInWithOIDC(t, h)); again != userID {
+		t.Fatalf("second sign-in as %s, want %s", again, userID)
+	}
+}
+
+func TestOIDCCallbackRejectsBadState(t *testing.T) {
+	h, p := newOIDCHandler(t)
+	p.SetUser(oidctest.User{Subject: "sub-state", Email: "state-" + uuid.NewString() + "@example.com", EmailVerified: true})
+	cookie, query := startOIDC(t, h)
+
+	forged := url.Values{"code": {query.Get("code")}, "state": {"forged"}}
+	if rec := oidcCallback(h, cookie, forged); rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback with a forged state status = %d, want 400", rec.Code)
+	}
+	if rec := oidcCallback(h, nil, query); rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback without the state cookie status = %d, want 400", rec.Code)
+	}
+	// A cookie and query that agree still need a state the server issued.
+	other := &http.Cookie{Name: oidcStateCookie, Value: "forged"}
+	if rec := oidcCallback(h, other, forged); rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback with an unknown state status = %d, want 400", rec.Code)
+	}
+
+	sessionUser(t, h, oidcCallback(h, cookie, query))
+	if rec := oidcCallback(h, cookie, query); rec.Code != http.StatusBadRequest {
+		t.Fatalf("replayed callback status = %d, want 400", rec.Code)
+	}
+}
+
+func TestOIDCLinksVerifiedAccount(t *testing.T) {
+	h, p := newOIDCHandler(t)
+	existing := verifiedUser(t, h.Store, "linked-"+uuid.NewString()+"@example.com")
+	p.SetUser(oidctest.User{Subject: "sub-link", Email: existing.Email, EmailVerified: true})
+
+	if userID := sessionUser(t, h, signInWithOIDC(t, h)); userID != existing.ID {
+		t.Fatalf("signed in as %s, want the existing account %s", userID, existing.ID)
+	}
+	if user, err := h.Store.GetUserByIdentity(context.Background(), p.URL, "sub-link"); err != nil || user.ID != existing.ID {
+		t.Fatalf("GetUserByIdentity = %+v, %v; want %s linked", user, err, existing.ID)
+	}
+}
+
+func TestOIDCRefusesUnverifiedProviderEmail(t *testing.T) {
+	h, p := newOIDCHandler(t)
+	existing := verifiedUser(t, h.Store, "victim-"+uuid.NewString()+"@example.com")
+	p.SetUser(oidctest.User{Subject: "sub-attacker", Email: existing.Email})
+
+	if rec := signInWithOIDC(t, h); rec.Code != http.StatusForbidden {
+		t.Fatalf("OIDCCallback status = %d, want 403", rec.Code)
+	}
+	if _, err := h.Store.GetUserByIdentity(context.Background(), p.URL, "sub-attacker"); err == nil {
+		t.Fatal("identity with an unverified email was linked")
+	}
+}
+
+// Whoever registered an address without proving it must not gain the SSO
+// sign-ins of its real owner.
+func TestOIDCRefusesUnverifiedLocalAccount(t *testing.T) {
+	h, p := newOIDCHandler(t)
+	squatter, err := h.Store.CreateUser(context.Background(), "owner-"+uuid.NewString()+"@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	p.SetUser(oidctest.User{Subject: "sub-owner", Email: squatter.Email, EmailVerified: true})
+
+	rec := signInWithOIDC(t, h)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("OIDCCallback status = %d, want 403", rec.Code)
+	}
+	if _, err := h.Store.GetUserByIdentity(context.Background(), p.URL, "sub-owner"); err == nil {
+		t.Fatal("identity was linked to an unverified account")
+	}
+}
+
+func TestOIDCWithoutProvisioning(t *testing.T) {
+	h, p := newOIDCHandler(t)
+	h.OIDCProvision = false
+	email := "stranger-" + uuid.NewString() + "@example.com"
+	p.SetUser(oidctest.User{Subject: "sub-stranger", Email: email, EmailVerified: true})
+
+	if rec := signInWithOIDC(t, h); rec.Code != http.StatusForbidden {
+		t.Fatalf("OIDCCallback status = %d, want 403", rec.Code)
+	}
+	if _, err := h.Store.GetUserByEmail(context.Background(), email); err == nil {
+		t.Fatal("account was created with provisioning turned off")
+	}
+
+	// Existing accounts can still link.
+	existing := verifiedUser(t, h.Store, email)
+	if userID := sessionUser(t, h, signInWithOIDC(t, h)); userID != existing.ID {
+		t.Fatalf("signed in as %s, want %s", userID, existing.ID)
+	}
+}
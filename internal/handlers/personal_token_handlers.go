@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+const (
+	maxPersonalTokenNameLength = 100
+	// Personal access tokens always expire, after 90 days unless asked
+	// otherwise and after a year at most.
+	defaultPersonalTokenDays = 90
+	maxPersonalTokenDays     = 365
+)
+
+// CreatePersonalTokenRequest names a new personal access token and says
+// what it may do and for how many days.
+type CreatePersonalTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// VerifyPersonalTokenRequest is sent by realtime-service, which has no
+// database, to check a personal access token.
+type VerifyPersonalTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// VerifyPersonalTokenResponse describes a valid personal access token.
+type VerifyPersonalTokenResponse struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type storePersonalTokens struct {
+	store storage.Store
+}
+
+// NewPersonalTokenVerifier checks personal access tokens against store, for
+// auth.SetPersonalTokens.
+func NewPersonalTokenVerifier(store storage.Store) auth.PersonalTokenVerifier {
+	return storePersonalTokens{store: store}
+}
+
+func (v storePersonalTokens) VerifyPersonalToken(ctx context.Context, token string) (*auth.Claims, error) {
+	pt, err := v.store.UsePersonalToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return auth.PersonalTokenClaims(pt.ID, pt.UserID, pt.Scopes, pt.ExpiresAt), nil
+}
+
+// validate checks req and normalizes it in place: the name is trimmed,
+// scopes are deduplicated into the order of auth.Scopes and the lifetime
+// defaults to defaultPersonalTokenDays.
+func (req *CreatePersonalTokenRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > maxPersonalTokenNameLength {
+		return fmt.Errorf("name is required and must be at most %d characters", maxPersonalTokenNameLength)
+	}
+	if len(req.Scopes) == 0 {
+		return fmt.Errorf("scopes must list at least one of %s", strings.Join(auth.Scopes, ", "))
+	}
+	requested := map[string]bool{}
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			return fmt.Errorf("unknown scope %q; scopes are %s", scope, strings.Join(auth.Scopes, ", "))
+		}
+		requested[scope] = true
+	}
+	req.Scopes = req.Scopes[:0]
+	for _, scope := range auth.Scopes {
+		if requested[scope] {
+			req.Scopes = append(req.Scopes, scope)
+		}
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultPersonalTokenDays
+	}
+	if req.ExpiresInDays < 1 || req.ExpiresInDays > maxPersonalTokenDays {
+		return fmt.Errorf("expires_in_days must be between 1 and %d", maxPersonalTokenDays)
+	}
+	return nil
+}
+
+// CreatePersonalToken issues a personal access token for the caller. The
+// token is only ever shown in this response; user.personal_token_created is
+// published so notification-service can tell the owner.
+func (h *UserHandler) CreatePersonalToken(w http.ResponseWriter, r *http.Request) {
+	var req CreatePersonalTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+	pt, err := h.Store.CreatePersonalToken(r.Context(), user.ID, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		http.Error(w, "Could not create personal access token", http.StatusInternalServerError)
+		log.Printf("Error creating personal access token for user %s: %v", user.ID, err)
+		return
+	}
+	h.publishEvent(r.Context(), "user.personal_token_created", map[string]string{
+		"user_id":    user.ID,
+		"email":      user.Email,
+		"name":       pt.Name,
+		"scopes":     strings.Join(pt.Scopes, " "),
+		"expires_at": pt.ExpiresAt.UTC().Format(time.RFC3339),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(pt)
+}
+
+// ListPersonalTokens lists the caller's unexpired personal access tokens,
+// without their secrets.
+func (h *UserHandler) ListPersonalTokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+	tokens, err := h.Store.ListPersonalTokens(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Could not list personal access tokens", http.StatusInternalServerError)
+		log.Printf("Error listing personal access tokens of user %s: %v", userID, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// RevokePersonalToken deletes one of the caller's personal access tokens;
+// it stops working at once.
+func (h *UserHandler) RevokePersonalToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+	err := h.Store.RevokePersonalToken(r.Context(), userID, chi.URLParam(r, "tokenID"))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Personal access token not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Could not revoke personal access token", http.StatusInternalServerError)
+		log.Printf("Error revoking personal access token of user %s: %v", userID, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyPersonalToken is the internal route realtime-service checks
+// personal access tokens with. Like auth.ParseToken it records the use.
+func (h *DocumentHandler) VerifyPersonalToken(w http.ResponseWriter, r *http.Request) {
+	var req VerifyPersonalTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+	pt, err := h.Store.UsePersonalToken(r.Context(), req.Token)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidPersonalToken) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		writeStoreError(w, err, "verify personal access token")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VerifyPersonalTokenResponse{
+		ID:        pt.ID,
+		UserID:    pt.UserID,
+		Scopes:    pt.Scopes,
+		ExpiresAt: pt.ExpiresAt,
+	})
+}
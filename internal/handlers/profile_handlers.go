@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/password"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/language"
+)
+
+const (
+	maxDisplayNameLength = 100
+	maxAvatarURLLength   = 2048
+)
+
+var errWrongPassword = errors.New("current password is incorrect")
+
+// ProfileResponse is the caller's own account, as returned by /users/me.
+type ProfileResponse struct {
+	ID               string `json:"id"`
+	Email            string `json:"email"`
+	EmailVerified    bool   `json:"email_verified"`
+	DisplayName      string `json:"display_name"`
+	AvatarURL        string `json:"avatar_url"`
+	Locale           string `json:"locale"`
+	Timezone         string `json:"timezone"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
+}
+
+// UpdateProfileRequest changes the fields that are present; an empty string
+// clears a field.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name"`
+	AvatarURL   *string `json:"avatar_url"`
+	Locale      *string `json:"locale"`
+	Timezone    *string `json:"timezone"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func profileResponse(user *storage.User) ProfileResponse {
+	return ProfileResponse{
+		ID:               user.ID,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerified,
+		DisplayName:      user.DisplayName,
+		AvatarURL:        user.AvatarURL,
+		Locale:           user.Locale,
+		Timezone:         user.Timezone,
+		TwoFactorEnabled: user.TOTPEnabled,
+	}
+}
+
+func writeProfile(w http.ResponseWriter, user *storage.User) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profileResponse(user))
+}
+
+// validate checks the fields of req that are present and normalizes them
+// in place.
+func (req *UpdateProfileRequest) validate() error {
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLength {
+			return fmt.Errorf("display_name must be at most %d characters", maxDisplayNameLength)
+		}
+		if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+			return errors.New("display_name must not contain control characters")
+		}
+		req.DisplayName = &name
+	}
+	if req.AvatarURL != nil && *req.AvatarURL != "" {
+		u, err := url.Parse(*req.AvatarURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(*req.AvatarURL) > maxAvatarURLLength {
+			return fmt.Errorf("avatar_url must be an http or https URL of at most %d characters", maxAvatarURLLength)
+		}
+	}
+	if req.Locale != nil && *req.Locale != "" {
+		tag, err := language.Parse(*req.Locale)
+		if err != nil {
+			return errors.New("locale must be a language tag such as en-GB")
+		}
+		locale := tag.String()
+		req.Locale = &locale
+	}
+	if req.Timezone != nil && *req.Timezone != "" {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "Local" {
+			return errors.New("timezone must be an IANA time zone such as Europe/London")
+		}
+	}
+	return nil
+}
+
+// currentUser loads the user the request's access token was issued to. It
+// responds with an error and returns nil if there is none.
+func (h *UserHandler) currentUser(w http.ResponseWriter, r *http.Request) *storage.User {
+	claims, ok := r.Context().Value(auth.ClaimsKey).(*auth.Claims)
+	if !ok {
+		http.Error(w, "Could not get token claims from context", http.StatusInternalServerError)
+		return nil
+	}
+	user, err := h.Store.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return nil
+		}
+		http.Error(w, "Could not load user", http.StatusInternalServerError)
+		log.Printf("Error loading user %s: %v", claims.UserID, err)
+		return nil
+	}
+	return user
+}
+
+// checkPassword confirms a sensitive change with the caller's password.
+// Wrong passwords count towards the login lockout, so a stolen access token
+// can't be used to guess it. It responds with an error and returns false
+// if the password is wrong.
+func (h *UserHandler) checkPassword(w http.ResponseWriter, r *http.Request, user *storage.User, pw string) bool {
+	ip := h.clientIP(r)
+	if !h.allowLogin(w, r, user.Email, ip) {
+		return false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(pw)); err != nil {
+		h.loginFailed(r.Context(), user.Email, ip, user, "wrong password")
+		http.Error(w, errWrongPassword.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// GetMe returns the caller's profile.
+func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	writeProfile(w, user)
+}
+
+// UpdateMe changes the caller's display name, avatar URL, locale or time
+// zone.
+func (h *UserHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(auth.ClaimsKey).(*auth.Claims)
+	if !ok {
+		http.Error(w, "Could not get token claims from context", http.StatusInternalServerError)
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Store.UpdateProfile(r.Context(), claims.UserID, storage.ProfileUpdate{
+		DisplayName: req.DisplayName,
+		AvatarURL:   req.AvatarURL,
+		Locale:      req.Locale,
+		Timezone:    req.Timezone,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Could not update profile", http.StatusInternalServerError)
+		log.Printf("Error updating profile of user %s: %v", claims.UserID, err)
+		return
+	}
+	writeProfile(w, user)
+}
+
+// ChangePassword sets a new password given the current one. Every session
+// of the account is ended, and the caller gets tokens for a new one.
+// Accounts created through single sign-on have no password; they set one
+// with POST /auth/forgot-password.
+func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	if err := password.Validate(req.NewPassword, user.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.checkPassword(w, r, user, req.CurrentPassword) {
+		return
+	}
+
+	if err := h.Store.ChangePassword(r.Context(), user.ID, req.NewPassword); err != nil {
+		http.Error(w, "Could not change password", http.StatusInternalServerError)
+		log.Printf("Error changing password of user %s: %v", user.ID, err)
+		return
+	}
+	h.publishEvent(r.Context(), "user.password_changed", map[string]string{
+		"user_id": user.ID,
+		"email":   user.Email,
+	})
+	h.issueSession(w, r, user)
+}
+
+// ChangeEmail moves the caller's account to a new email address, given
+// their password. The new address has to be verified again, and the old one
+// is told about the change.
+func (h *UserHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	var req ChangeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if addr, err := mail.ParseAddress(req.Email); err != nil || addr.Address != req.Email {
+		http.Error(w, "email must be a valid email address", http.StatusBadRequest)
+		return
+	}
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	if req.Email == user.Email {
+		http.Error(w, "email is already the account's address", http.StatusBadRequest)
+		return
+	}
+	if !h.checkPassword(w, r, user, req.Password) {
+		return
+	}
+
+	updated, err := h.Store.ChangeEmail(r.Context(), user.ID, req.Email)
+	if err != nil {
+		if errors.Is(err, storage.ErrEmailTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Could not change email", http.StatusInternalServerError)
+		log.Printf("Error changing email of user %s: %v", user.ID, err)
+		return
+	}
+	h.publishEvent(r.Context(), "user.email_changed", map[string]string{
+		"user_id":   user.ID,
+		"email":     user.Email,
+		"new_email": updated.Email,
+	})
+	h.sendVerification(r.Context(), updated)
+	writeProfile(w, updated)
+}
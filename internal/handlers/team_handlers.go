@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+// TeamHandler serves team management in user-service. Documents are shared
+// with a team through document-service's share endpoint.
+type TeamHandler struct {
+	Store storage.Store
+}
+
+type CreateTeamRequest struct {
+	Name string `json:"name"`
+}
+
+type AddTeamMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func (h *TeamHandler) CreateTeam(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	var req CreateTeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	team, err := h.Store.CreateTeam(r.Context(), req.Name, userID)
+	if err != nil {
+		writeStoreError(w, err, "create team")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(team)
+}
+
+func (h *TeamHandler) ListTeams(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	teams, err := h.Store.ListTeams(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, err, "list teams")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(teams)
+}
+
+func (h *TeamHandler) GetTeamMembers(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	teamID := chi.URLParam(r, "teamID")
+
+	members, err := h.Store.GetTeamMembers(r.Context(), teamID, userID)
+	if err != nil {
+		writeStoreError(w, err, "list team members")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// AddTeamMember invites a registered user to the team, or changes the role of
+// an existing member. Owners and admins can add members; only the owner can
+// appoint admins.
+func (h *TeamHandler) AddTeamMember(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	teamID := chi.URLParam(r, "teamID")
+
+	var req AddTeamMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = storage.TeamRoleMember
+	}
+	if !storage.ValidTeamRole(req.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	// Check the caller may manage members before looking the email up, so
+	// the endpoint can't be used to probe which emails are registered.
+	actorRole, err := h.teamRole(r, teamID, actorID)
+	if err != nil {
+		writeStoreError(w, err, "add team member")
+		return
+	}
+	if actorRole != storage.TeamRoleOwner && actorRole != storage.TeamRoleAdmin {
+		http.Error(w, storage.ErrPermissionDenied.Error(), http.StatusForbidden)
+		return
+	}
+
+	user, err := h.Store.GetUserByEmail(r.Context(), req.Email)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "Target user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err, "look up team member")
+		return
+	}
+
+	if err := h.Store.AddTeamMember(r.Context(), teamID, actorID, user.ID, req.Role); err != nil {
+		writeStoreError(w, err, "add team member")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// teamRole returns userID's role in teamID, or storage.ErrNotFound if the
+// team doesn't exist or userID isn't a member.
+func (h *TeamHandler) teamRole(r *http.Request, teamID, userID string) (string, error) {
+	teams, err := h.Store.ListTeams(r.Context(), userID)
+	if err != nil {
+		return "", err
+	}
+	for _, team := range teams {
+		if team.ID == teamID {
+			return team.Role, nil
+		}
+	}
+	return "", storage.ErrNotFound
+}
+
+// RemoveTeamMember removes a member; members can also remove themselves to
+// leave the team.
+func (h *TeamHandler) RemoveTeamMember(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		http.Error(w, "Could not get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	teamID := chi.URLParam(r, "teamID")
+	memberID := chi.URLParam(r, "userID")
+
+	if err := h.Store.RemoveTeamMember(r.Context(), teamID, actorID, memberID); err != nil {
+		writeStoreError(w, err, "remove team member")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
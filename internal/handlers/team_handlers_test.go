@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+// failingEmailStore fails every email lookup as a broken database would.
+type failingEmailStore struct {
+	storage.Store
+}
+
+func (failingEmailStore) GetUserByEmail(ctx context.Context, email string) (*storage.User, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestAddTeamMember(t *testing.T) {
+	store := storage.NewMemoryStore()
+	owner := mustCreateUser(t, store)
+	member := mustCreateUser(t, store)
+	invitee := mustCreateUser(t, store)
+	outsider := mustCreateUser(t, store)
+
+	team, err := store.CreateTeam(context.Background(), "Platform", owner.ID)
+	if err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	if err := store.AddTeamMember(context.Background(), team.ID, owner.ID, member.ID, storage.TeamRoleMember); err != nil {
+		t.Fatalf("AddTeamMember: %v", err)
+	}
+
+	add := func(h *TeamHandler, actorID, email string) int {
+		return serve(h.AddTeamMember, http.MethodPost, "/teams/{teamID}/members", "/teams/"+team.ID+"/members",
+			`{"email":"`+email+`"}`, actorID).Code
+	}
+	h := &TeamHandler{Store: store}
+
+	tests := []struct {
+		name    string
+		h       *TeamHandler
+		actorID string
+		email   string
+		want    int
+	}{
+		{"owner adds user", h, owner.ID, invitee.Email, http.StatusNoContent},
+		{"owner adds unknown email", h, owner.ID, "nobody@example.com", http.StatusNotFound},
+		// Callers who can't manage members learn nothing about the email.
+		{"member probes registered email", h, member.ID, outsider.Email, http.StatusForbidden},
+		{"member probes unknown email", h, member.ID, "nobody@example.com", http.StatusForbidden},
+		{"outsider probes email", h, outsider.ID, member.Email, http.StatusNotFound},
+		{"lookup fails", &TeamHandler{Store: failingEmailStore{store}}, owner.ID, outsider.Email, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := add(tt.h, tt.actorID, tt.email); got != tt.want {
+				t.Fatalf("AddTeamMember status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/totp"
+)
+
+// loginChallengeTTL is how long a user has to enter their code after their
+// password.
+const loginChallengeTTL = 5 * time.Minute
+
+var errInvalidSecondFactor = errors.New("invalid authentication code")
+
+// LoginChallengeResponse is returned by Login and OIDCCallback instead of
+// tokens when the account has two-factor authentication. The login is
+// completed by posting the challenge and a code to POST /auth/2fa/verify.
+type LoginChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	Challenge   string `json:"challenge"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// TOTPSetupResponse carries a new secret. Authenticator apps enroll by
+// scanning OTPAuthURI as a QR code, or from the secret typed in by hand.
+type TOTPSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// TOTPCodeRequest carries a code from the authenticator app or, where
+// accepted, one of the recovery codes instead.
+type TOTPCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TOTPVerifyRequest struct {
+	Challenge string `json:"challenge"`
+	TOTPCodeRequest
+}
+
+// RecoveryCodesResponse lists recovery codes; they are shown only once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// sendLoginChallenge responds with a challenge for user's second factor
+// instead of tokens.
+func (h *UserHandler) sendLoginChallenge(w http.ResponseWriter, r *http.Request, user *storage.User) {
+	if h.TOTP == nil {
+		http.Error(w, "Two-factor authentication is not available", http.StatusServiceUnavailable)
+		log.Printf("ERROR: User %s has two-factor authentication but TOTP_ENCRYPTION_KEY is not set", user.ID)
+		return
+	}
+	challenge, err := h.Store.CreateAccountToken(r.Context(), user.ID, storage.TokenLoginChallenge, time.Now().Add(loginChallengeTTL))
+	if err != nil {
+		http.Error(w, "Could not start login", http.StatusInternalServerError)
+		log.Printf("Error creating login challenge: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LoginChallengeResponse{
+		MFARequired: true,
+		Challenge:   challenge,
+		ExpiresIn:   int(loginChallengeTTL.Seconds()),
+	})
+}
+
+// checkSecondFactor returns errInvalidSecondFactor unless req carries an
+// unused recovery code or a current, unused code for user's secret. Wrong
+// codes count towards the login lockout like wrong passwords, so callers
+// check allowLogin first.
+func (h *UserHandler) checkSecondFactor(ctx context.Context, user *storage.User, ip string, req TOTPCodeRequest) error {
+	err := h.matchSecondFactor(ctx, user.ID, req)
+	if errors.Is(err, errInvalidSecondFactor) {
+		h.loginFailed(ctx, user.Email, ip, user, "wrong authentication code")
+	}
+	return err
+}
+
+func (h *UserHandler) matchSecondFactor(ctx context.Context, userID string, req TOTPCodeRequest) error {
+	if req.RecoveryCode != "" {
+		err := h.Store.UseRecoveryCode(ctx, userID, totp.NormalizeRecoveryCode(req.RecoveryCode))
+		if errors.Is(err, storage.ErrInvalidRecoveryCode) {
+			return errInvalidSecondFactor
+		}
+		return err
+	}
+
+	setup, err := h.Store.GetTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if setup.Secret == nil {
+		return errInvalidSecondFactor
+	}
+	secret, err := h.TOTP.Decrypt(userID, setup.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(secret, req.Code, time.Now())
+	if !ok {
+		return errInvalidSecondFactor
+	}
+	if err := h.Store.RecordTOTPStep(ctx, userID, step); err != nil {
+		if errors.Is(err, storage.ErrTOTPCodeReused) {
+			return errInvalidSecondFactor
+		}
+		return err
+	}
+	return nil
+}
+
+// VerifyLogin completes a login that answered with a challenge. The
+// challenge is used up by the attempt, right or wrong, so a wrong code means
+// logging in again rather than guessing again.
+func (h *UserHandler) VerifyLogin(w http.ResponseWriter, r *http.Request) {
+	var req TOTPVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Challenge == "" {
+		http.Error(w, "challenge is required", http.StatusBadRequest)
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		http.Error(w, "code or recovery_code is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Store.ConsumeAccountToken(r.Context(), req.Challenge, storage.TokenLoginChallenge)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidAccountToken) {
+			http.Error(w, "Login challenge is invalid or expired", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Could not verify login", http.StatusInternalServerError)
+		log.Printf("Error consuming login challenge: %v", err)
+		return
+	}
+	if user.TOTPEnabled {
+		ip := h.clientIP(r)
+		if !h.allowLogin(w, r, user.Email, ip) {
+			return
+		}
+		if err := h.checkSecondFactor(r.Context(), user, ip, req.TOTPCodeRequest); err != nil {
+			if errors.Is(err, errInvalidSecondFactor) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, "Could not verify login", http.StatusInternalServerError)
+			log.Printf("Error checking second factor for user %s: %v", user.ID, err)
+			return
+		}
+		if req.RecoveryCode != "" {
+			log.Printf("User %s logged in with a recovery code", user.ID)
+		}
+	}
+	h.issueSession(w, r, user)
+}
+
+// SetupTOTP starts enrolling the caller in two-factor authentication with a
+// new secret. It has no effect until EnableTOTP confirms a code from it.
+func (h *UserHandler) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(auth.ClaimsKey).(*auth.Claims)
+	if !ok {
+		http.Error(w, "Could not get token claims from context", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.Store.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "Could not set up two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	sealed, err := h.TOTP.Encrypt(user.ID, secret)
+	if err != nil {
+		http.Error(w, "Could not set up two-factor authentication", http.StatusInternalServerError)
+		log.Printf("Error encrypting TOTP secret: %v", err)
+		return
+	}
+	if err := h.Store.SetTOTPSecret(r.Context(), user.ID, sealed); err != nil {
+		if errors.Is(err, storage.ErrTOTPEnabled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Could not set up two-factor authentication", http.StatusInternalServerError)
+		log.Printf("Error storing TOTP secret: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPSetupResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(h.TOTPIssuer, user.Email, secret),
+	})
+}
+
+// EnableTOTP turns on two-factor authentication once the caller proves their
+// authenticator app works, and responds with their recovery codes. Wrong
+// codes count towards the login lockout.
+func (h *UserHandler) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(auth.ClaimsKey).(*auth.Claims)
+	if !ok {
+		http.Error(w, "Could not get token claims from context", http.StatusInternalServerError)
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Store.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	setup, err := h.Store.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case setup.Enabled:
+		http.Error(w, storage.ErrTOTPEnabled.Error(), http.StatusConflict)
+		return
+	case setup.Secret == nil:
+		http.Error(w, "Start with POST /auth/2fa/setup", http.StatusConflict)
+		return
+	}
+	ip := h.clientIP(r)
+	if !h.allowLogin(w, r, user.Email, ip) {
+		return
+	}
+	secret, err := h.TOTP.Decrypt(user.ID, setup.Secret)
+	if err != nil {
+		http.Error(w, "Could not enable two-factor authentication", http.StatusInternalServerError)
+		log.Printf("Error decrypting TOTP secret for user %s: %v", user.ID, err)
+		return
+	}
+	step, ok := totp.Validate(secret, req.Code, time.Now())
+	if !ok {
+		h.loginFailed(r.Context(), user.Email, ip, user, "wrong authentication code")
+		http.Error(w, errInvalidSecondFactor.Error(), http.StatusBadRequest)
+		return
+	}
+
+	codes := totp.GenerateRecoveryCodes()
+	if err := h.Store.EnableTOTP(r.Context(), user.ID, step, codes); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrTOTPEnabled):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, storage.ErrNotFound):
+			http.Error(w, "Start with POST /auth/2fa/setup", http.StatusConflict)
+		default:
+			http.Error(w, "Could not enable two-factor authentication", http.StatusInternalServerError)
+			log.Printf("Error enabling TOTP: %v", err)
+		}
+		return
+	}
+	h.publishTwoFactorEvent(r.Context(), user.ID, "user.two_factor_enabled")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP turns off two-factor authentication. It takes a current code
+// or a recovery code, so a stolen access token alone can't turn it off, and
+// wrong ones count towards the login lockout so it can't guess them either.
+func (h *UserHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(auth.ClaimsKey).(*auth.Claims)
+	if !ok {
+		http.Error(w, "Could not get token claims from context", http.StatusInternalServerError)
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		http.Error(w, "code or recovery_code is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Store.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+	ip := h.clientIP(r)
+	if !h.allowLogin(w, r, user.Email, ip) {
+		return
+	}
+	if err := h.checkSecondFactor(r.Context(), user, ip, req); err != nil {
+		if errors.Is(err, errInvalidSecondFactor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Could not disable two-factor authentication", http.StatusInternalServerError)
+		log.Printf("Error checking second factor for user %s: %v", user.ID, err)
+		return
+	}
+	if err := h.Store.DisableTOTP(r.Context(), user.ID); err != nil {
+		http.Error(w, "Could not disable two-factor authentication", http.StatusInternalServerError)
+		log.Printf("Error disabling TOTP: %v", err)
+		return
+	}
+	h.publishTwoFactorEvent(r.Context(), user.ID, "user.two_factor_disabled")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publishTwoFactorEvent lets notification-service tell the user their
+// second factor changed.
+func (h *UserHandler) publishTwoFactorEvent(ctx context.Context, userID, routingKey string) {
+	user, err := h.Store.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("WARN: Failed to look up user %s for %s event: %v", userID, routingKey, err)
+		return
+	}
+	h.publishEvent(ctx, routingKey, map[string]string{
+		"user_id": user.ID,
+		"email":   user.Email,
+	})
+}
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/lockout"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/totp"
+)
+
+// maxFailures is the lockout threshold of newTOTPHandler.
+const maxFailures = 3
+
+func newTOTPHandler(t *testing.T) (*UserHandler, *recordingPublisher) {
+	t.Helper()
+	h, events := newAccountHandler()
+	cipher, err := totp.NewCipher(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	h.TOTP = cipher
+	h.TOTPIssuer = "Collaborative Editor"
+	h.RefreshTokenTTL = time.Hour
+	h.Lockout = lockout.NewGuard(lockout.NewMemoryStore(), lockout.Policy{
+		MaxFailures:   maxFailures,
+		MaxIPFailures: 100,
+		Lockout:       time.Minute,
+	})
+	return h, events
+}
+
+// withClaims puts userID's claims in the context as the auth middleware
+// would for the /auth/2fa routes.
+func withClaims(handler http.HandlerFunc, userID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := &auth.Claims{UserID: userID}
+		handler(w, r.WithContext(context.WithValue(r.Context(), auth.ClaimsKey, claims)))
+	}
+}
+
+func setupTOTP(t *testing.T, h *UserHandler, user *storage.User) string {
+	t.Helper()
+	rec := serve(withClaims(h.SetupTOTP, user.ID), http.MethodPost, "/auth/2fa/setup", "/auth/2fa/setup", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("SetupTOTP status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp TOTPSetupResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Secret == "" {
+		t.Fatalf("SetupTOTP response = %+v, %v; want a secret", resp, err)
+	}
+	if !strings.HasPrefix(resp.OTPAuthURI, "otpauth://totp/") || !strings.Contains(resp.OTPAuthURI, resp.Secret) {
+		t.Fatalf("OTPAuthURI = %s, want one for the secret", resp.OTPAuthURI)
+	}
+	return resp.Secret
+}
+
+// codeAt returns secret's code offset steps from now. Each step's code is
+// accepted once, so a test entering several uses the next one each time.
+func codeAt(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+	return code
+}
+
+// wrongCode returns a well-formed code that isn't secret's for any step
+// Validate accepts now.
+func wrongCode(t *testing.T, secret string) string {
+	t.Helper()
+	for _, candidate := range []string{"000000", "111111", "222222", "333333"} {
+		if _, ok := totp.Validate(secret, candidate, time.Now()); !ok {
+			return candidate
+		}
+	}
+	t.Fatal("no wrong code found")
+	return ""
+}
+
+func enableTOTP(h *UserHandler, userID, body string) *http.Response {
+	return serve(withClaims(h.EnableTOTP, userID), http.MethodPost, "/auth/2fa/enable", "/auth/2fa/enable", body, "").Result()
+}
+
+func disableTOTP(h *UserHandler, userID, body string) int {
+	return serve(withClaims(h.DisableTOTP, userID), http.MethodPost, "/auth/2fa/disable", "/auth/2fa/disable", body, "").Code
+}
+
+// enroll turns on two-factor authentication for user and returns the secret
+// and recovery codes. The current step's code is used up by it.
+func enroll(t *testing.T, h *UserHandler, user *storage.User) (string, []string) {
+	t.Helper()
+	secret := setupTOTP(t, h, user)
+	resp := enableTOTP(h, user.ID, `{"code":"`+codeAt(t, secret, 0)+`"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("EnableTOTP status = %d", resp.StatusCode)
+	}
+	var codes RecoveryCodesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&codes); err != nil || len(codes.RecoveryCodes) != totp.RecoveryCodeCount {
+		t.Fatalf("EnableTOTP response = %+v, %v; want %d recovery codes", codes, err, totp.RecoveryCodeCount)
+	}
+	return secret, codes.RecoveryCodes
+}
+
+// loginChallenge logs in with email's password and returns the challenge
+// the login answers with.
+func loginChallenge(t *testing.T, h *UserHandler, email string) string {
+	t.Helper()
+	rec := serve(h.Login, http.MethodPost, "/auth/login", "/auth/login",
+		`{"email":"`+email+`","password":"correct horse battery"}`, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Login status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp LoginChallengeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || !resp.MFARequired || resp.Challenge == "" {
+		t.Fatalf("Login response = %+v, %v; want a challenge", resp, err)
+	}
+	return resp.Challenge
+}
+
+func verifyLogin(h *UserHandler, challenge, field, code string) int {
+	return serve(h.VerifyLogin, http.MethodPost, "/auth/2fa/verify", "/auth/2fa/verify",
+		`{"challenge":"`+challenge+`","`+field+`":"`+code+`"}`, "").Code
+}
+
+func TestEnableTOTP(t *testing.T) {
+	h, events := newTOTPHandler(t)
+	user := register(t, h, "enable-"+uuid.NewString()+"@example.com")
+
+	if resp := enableTOTP(h, user.ID, `{"code":"123456"}`); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("EnableTOTP before setup status = %d, want 409", resp.StatusCode)
+	}
+	secret := setupTOTP(t, h, user)
+	if resp := enableTOTP(h, user.ID, `{}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("EnableTOTP without a code status = %d, want 400", resp.StatusCode)
+	}
+	if resp := enableTOTP(h, user.ID, `{"code":"`+wrongCode(t, secret)+`"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("EnableTOTP(wrong code) status = %d, want 400", resp.StatusCode)
+	}
+	if got, _ := h.Store.GetUserByID(context.Background(), user.ID); got.TOTPEnabled {
+		t.Fatal("a wrong code enabled two-factor authentication")
+	}
+
+	if resp := enableTOTP(h, user.ID, `{"code":"`+codeAt(t, secret, 0)+`"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("EnableTOTP status = %d, want 200", resp.StatusCode)
+	}
+	if got, _ := h.Store.GetUserByID(context.Background(), user.ID); !got.TOTPEnabled {
+		t.Fatal("two-factor authentication is not enabled")
+	}
+	if sent := events.take("user.two_factor_enabled"); len(sent) != 1 || sent[0].body["user_id"] != user.ID {
+		t.Fatalf("two_factor_enabled events = %+v, want one for %s", sent, user.ID)
+	}
+
+	if resp := enableTOTP(h, user.ID, `{"code":"`+codeAt(t, secret, 1)+`"}`); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("EnableTOTP when enabled status = %d, want 409", resp.StatusCode)
+	}
+	rec := serve(withClaims(h.SetupTOTP, user.ID), http.MethodPost, "/auth/2fa/setup", "/auth/2fa/setup", "", "")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("SetupTOTP when enabled status = %d, want 409", rec.Code)
+	}
+}
+
+// A stolen access token mustn't be enough to guess codes without limit.
+func TestEnableTOTPCountsWrongCodes(t *testing.T) {
+	h, events := newTOTPHandler(t)
+	user := register(t, h, "guess-enable-"+uuid.NewString()+"@example.com")
+	secret := setupTOTP(t, h, user)
+
+	wrong := `{"code":"` + wrongCode(t, secret) + `"}`
+	for i := 0; i < maxFailures; i++ {
+		if resp := enableTOTP(h, user.ID, wrong); resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("EnableTOTP(wrong code) #%d status = %d, want 400", i+1, resp.StatusCode)
+		}
+	}
+	if sent := events.take("user.login_failed"); len(sent) != maxFailures || sent[0].body["reason"] != "wrong authentication code" {
+		t.Fatalf("login_failed events = %+v, want %d for wrong codes", sent, maxFailures)
+	}
+	resp := enableTOTP(h, user.ID, `{"code":"`+codeAt(t, secret, 0)+`"}`)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("EnableTOTP when locked status = %d, want 429 with Retry-After", resp.StatusCode)
+	}
+}
+
+func TestVerifyLogin(t *testing.T) {
+	h, events := newTOTPHandler(t)
+	email := "verify-" + uuid.NewString() + "@example.com"
+	user := register(t, h, email)
+	secret, _ := enroll(t, h, user)
+
+	challenge := loginChallenge(t, h, email)
+	if code := verifyLogin(h, challenge, "code", wrongCode(t, secret)); code != http.StatusUnauthorized {
+		t.Fatalf("VerifyLogin(wrong code) status = %d, want 401", code)
+	}
+	// The challenge went with the wrong code.
+	if code := verifyLogin(h, challenge, "code", codeAt(t, secret, 1)); code != http.StatusUnauthorized {
+		t.Fatalf("VerifyLogin(used challenge) status = %d, want 401", code)
+	}
+	if code := verifyLogin(h, "not-a-challenge", "code", codeAt(t, secret, 1)); code != http.StatusUnauthorized {
+		t.Fatalf("VerifyLogin(bad challenge) status = %d, want 401", code)
+	}
+
+	next := codeAt(t, secret, 1)
+	rec := serve(h.VerifyLogin, http.MethodPost, "/auth/2fa/verify", "/auth/2fa/verify",
+		`{"challenge":"`+loginChallenge(t, h, email)+`","code":"`+next+`"}`, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("VerifyLogin status = %d: %s", rec.Code, rec.Body)
+	}
+	var tokens LoginResponse
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil || tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Fatalf("VerifyLogin response = %+v, %v; want tokens", tokens, err)
+	}
+	if sent := events.take("user.logged_in"); len(sent) != 1 {
+		t.Fatalf("logged_in events = %+v, want one", sent)
+	}
+
+	// Each code is accepted once.
+	if code := verifyLogin(h, loginChallenge(t, h, email), "code", next); code != http.StatusUnauthorized {
+		t.Fatalf("VerifyLogin(reused code) status = %d, want 401", code)
+	}
+}
+
+func TestVerifyLoginWithRecoveryCode(t *testing.T) {
+	h, _ := newTOTPHandler(t)
+	email := "recover-" + uuid.NewString() + "@example.com"
+	user := register(t, h, email)
+	_, recovery := enroll(t, h, user)
+
+	// Recovery codes may be typed in lower case and without dashes.
+	typed := strings.ToLower(strings.ReplaceAll(recovery[0], "-", ""))
+	if code := verifyLogin(h, loginChallenge(t, h, email), "recovery_code", typed); code != http.StatusOK {
+		t.Fatalf("VerifyLogin(recovery code) status = %d, want 200", code)
+	}
+	if code := verifyLogin(h, loginChallenge(t, h, email), "recovery_code", recovery[0]); code != http.StatusUnauthorized {
+		t.Fatalf("VerifyLogin(used recovery code) status = %d, want 401", code)
+	}
+	if code := verifyLogin(h, loginChallenge(t, h, email), "recovery_code", recovery[1]); code != http.StatusOK {
+		t.Fatalf("VerifyLogin(second recovery code) status = %d, want 200", code)
+	}
+}
+
+func TestDisableTOTP(t *testing.T) {
+	h, events := newTOTPHandler(t)
+	email := "disable-" + uuid.NewString() + "@example.com"
+	user := register(t, h, email)
+	secret, recovery := enroll(t, h, user)
+
+	if code := disableTOTP(h, user.ID, `{}`); code != http.StatusBadRequest {
+		t.Fatalf("DisableTOTP without a code status = %d, want 400", code)
+	}
+	if code := disableTOTP(h, user.ID, `{"code":"`+wrongCode(t, secret)+`"}`); code != http.StatusBadRequest {
+		t.Fatalf("DisableTOTP(wrong code) status = %d, want 400", code)
+	}
+	if code := disableTOTP(h, user.ID, `{"recovery_code":"AAAA-BBBB-CCCC-DDDD"}`); code != http.StatusBadRequest {
+		t.Fatalf("DisableTOTP(wrong recovery code) status = %d, want 400", code)
+	}
+	if code := disableTOTP(h, user.ID, `{"recovery_code":"`+recovery[0]+`"}`); code != http.StatusNoContent {
+		t.Fatalf("DisableTOTP status = %d, want 204", code)
+	}
+	if got, _ := h.Store.GetUserByID(context.Background(), user.ID); got.TOTPEnabled {
+		t.Fatal("two-factor authentication is still enabled")
+	}
+	if sent := events.take("user.two_factor_disabled"); len(sent) != 1 || sent[0].body["email"] != email {
+		t.Fatalf("two_factor_disabled events = %+v, want one for %s", sent, email)
+	}
+	if code := disableTOTP(h, user.ID, `{"code":"`+codeAt(t, secret, 1)+`"}`); code != http.StatusConflict {
+		t.Fatalf("DisableTOTP when disabled status = %d, want 409", code)
+	}
+
+	// Without a second factor the password alone logs in again.
+	rec := serve(h.Login, http.MethodPost, "/auth/login", "/auth/login", `{"email":"`+email+`","password":"correct horse battery"}`, "")
+	var tokens LoginResponse
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); rec.Code != http.StatusOK || err != nil || tokens.Token == "" {
+		t.Fatalf("Login after disabling = %d %+v, want tokens", rec.Code, tokens)
+	}
+}
+
+func TestDisableTOTPCountsWrongCodes(t *testing.T) {
+	h, _ := newTOTPHandler(t)
+	email := "guess-disable-" + uuid.NewString() + "@example.com"
+	user := register(t, h, email)
+	secret, recovery := enroll(t, h, user)
+
+	for i := 0; i < maxFailures; i++ {
+		if code := disableTOTP(h, user.ID, `{"code":"`+wrongCode(t, secret)+`"}`); code != http.StatusBadRequest {
+			t.Fatalf("DisableTOTP(wrong code) #%d status = %d, want 400", i+1, code)
+		}
+	}
+	if code := disableTOTP(h, user.ID, `{"recovery_code":"`+recovery[0]+`"}`); code != http.StatusTooManyRequests {
+		t.Fatalf("DisableTOTP when locked status = %d, want 429", code)
+	}
+	// The lockout is the login one, so it also stops logins.
+	rec := serve(h.Login, http.MethodPost, "/auth/login", "/auth/login", `{"email":"`+email+`","password":"correct horse battery"}`, "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Login when locked status = %d, want 429", rec.Code)
+	}
+	if got, _ := h.Store.GetUserByID(context.Background(), user.ID); !got.TOTPEnabled {
+		t.Fatal("two-factor authentication was disabled while locked")
+	}
+}
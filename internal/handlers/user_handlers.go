@@ -1,17 +1,55 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/lockout"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/oidc"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/password"
 	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/totp"
+	"github.com/rabbitmq/amqp091-go"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Publisher publishes events on a RabbitMQ exchange. *amqp091.Channel
+// implements it; tests record the events instead.
+type Publisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) error
+}
+
 type UserHandler struct {
-	Store storage.Store
+	Store       storage.Store
+	AMQPChannel Publisher
+	// RefreshTokenTTL is how long a refresh token stays valid; every refresh
+	// issues a new one, so sessions last while they are used at least this often.
+	RefreshTokenTTL time.Duration
+	// VerificationTTL and PasswordResetTTL are how long the links mailed by
+	// notification-service stay valid.
+	VerificationTTL  time.Duration
+	PasswordResetTTL time.Duration
+	// RequireVerified refuses logins until the email address is verified.
+	RequireVerified bool
+	// OIDC enables single sign-on when set. OIDCProvision creates accounts
+	// for people who sign in without having one.
+	OIDC          *oidc.Client
+	OIDCProvision bool
+	// TOTP encrypts two-factor secrets and enables two-factor
+	// authentication when set. TOTPIssuer names us in authenticator apps.
+	TOTP       *totp.Cipher
+	TOTPIssuer string
+	// Lockout slows down and locks out repeated failed logins when set.
+	// TrustProxyHeaders takes the client address from the gateway's
+	// X-Real-IP header; only set it when clients can't bypass the gateway.
+	Lockout           *lockout.Guard
+	TrustProxyHeaders bool
 }
 
 type RegisterRequest struct {
@@ -24,13 +62,24 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// LoginResponse is returned by Login, OIDCCallback, VerifyLogin and Refresh. Token is a
+// short-lived access token; RefreshToken is single-use and exchanged for a
+// new pair at POST /auth/refresh.
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 type UserResponse struct {
-	ID    string `json:"id"`
-	Email string `json:"email"`
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
 }
 
 func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -39,16 +88,27 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if req.Email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+	if err := password.Validate(req.Password, req.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	user, err := h.Store.CreateUser(req.Email, req.Password)
+	user, err := h.Store.CreateUser(r.Context(), req.Email, req.Password)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusConflict) // 409 Conflict
 		return
 	}
 
+	h.sendVerification(r.Context(), user)
+
 	resp := UserResponse{
-		ID:    user.ID,
-		Email: user.Email,
+		ID:            user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -56,30 +116,152 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// claimInvitations turns documents shared with a user's email before it had
+// an account into ordinary shares. Only callers that know the user controls
+// the address may claim: VerifyEmail, and OIDC sign-up with an email the
+// provider has verified. The account exists either way, so a failure is only
+// logged.
+func (h *UserHandler) claimInvitations(ctx context.Context, user *storage.User) {
+	claimed, err := h.Store.ClaimInvitations(ctx, user.Email, user.ID)
+	if err != nil {
+		log.Printf("WARN: Failed to claim invitations for user %s: %v", user.ID, err)
+	} else if len(claimed) > 0 {
+		log.Printf("User %s accepted %d pending invitation(s)", user.ID, len(claimed))
+	}
+}
+
+// Login checks a password. Failures are counted per account and per client
+// address; see lockout.Guard.
 func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	ip := h.clientIP(r)
+	if !h.allowLogin(w, r, req.Email, ip) {
+		return
+	}
 
-	user, err := h.Store.GetUserByEmail(req.Email)
+	user, err := h.Store.GetUserByEmail(r.Context(), req.Email)
 	if err != nil {
+		h.loginFailed(r.Context(), req.Email, ip, nil, "unknown account")
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		h.loginFailed(r.Context(), req.Email, ip, user, "wrong password")
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	h.startSession(w, r, user)
+}
+
+// startSession logs user in, however they authenticated, and responds with
+// their tokens, or with a challenge if they have a second factor to enter.
+func (h *UserHandler) startSession(w http.ResponseWriter, r *http.Request, user *storage.User) {
+	if h.RequireVerified && !user.EmailVerified {
+		http.Error(w, "Email address is not verified; request a new link with POST /auth/verify-email/resend", http.StatusForbidden)
+		return
+	}
+	if user.TOTPEnabled {
+		h.sendLoginChallenge(w, r, user)
+		return
+	}
+	h.issueSession(w, r, user)
+}
+
+// issueSession creates a session for a fully authenticated user and
+// responds with its tokens.
+func (h *UserHandler) issueSession(w http.ResponseWriter, r *http.Request, user *storage.User) {
+	refresh, err := h.Store.CreateRefreshToken(r.Context(), user.ID, time.Now().Add(h.RefreshTokenTTL))
+	if err != nil {
+		http.Error(w, "Could not create token", http.StatusInternalServerError)
+		log.Printf("Error creating refresh token: %v", err)
+		return
+	}
+	h.loginSucceeded(r.Context(), user, h.clientIP(r))
+	writeTokens(w, refresh)
+}
 
-	token, err := auth.CreateJWT(user.ID, 24*time.Hour)
+// writeTokens responds with a new access token for refresh's user alongside
+// refresh itself.
+func writeTokens(w http.ResponseWriter, refresh *storage.RefreshToken) {
+	token, err := auth.CreateAccessToken(refresh.UserID)
 	if err != nil {
 		http.Error(w, "Could not create token", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(LoginResponse{Token: token})
+	json.NewEncoder(w).Encode(LoginResponse{
+		Token:        token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(auth.AccessTokenTTL().Seconds()),
+		RefreshToken: refresh.Token,
+	})
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. The old refresh token stops working; presenting it again ends the
+// whole session.
+func (h *UserHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	refresh, err := h.Store.RotateRefreshToken(r.Context(), req.RefreshToken, time.Now().Add(h.RefreshTokenTTL))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrRefreshTokenReused):
+			log.Printf("WARN: Refresh token reuse detected; session revoked")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case errors.Is(err, storage.ErrInvalidRefreshToken):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		default:
+			http.Error(w, "Could not refresh token", http.StatusInternalServerError)
+			log.Printf("Error rotating refresh token: %v", err)
+		}
+		return
+	}
+	writeTokens(w, refresh)
+}
+
+// Logout revokes the access token the request was made with and, if the body
+// carries one, ends the session of refresh_token. Refresh tokens of other
+// users are answered with 404 and left alone.
+func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(auth.ClaimsKey).(*auth.Claims)
+	if !ok {
+		http.Error(w, "Could not get token claims from context", http.StatusInternalServerError)
+		return
+	}
+
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.RefreshToken != "" {
+		err := h.Store.RevokeRefreshToken(r.Context(), claims.UserID, req.RefreshToken)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Refresh token not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Could not log out", http.StatusInternalServerError)
+			log.Printf("Error revoking refresh token: %v", err)
+			return
+		}
+	}
+	if err := auth.RevokeToken(r.Context(), claims); err != nil {
+		http.Error(w, "Could not log out", http.StatusInternalServerError)
+		log.Printf("Error revoking access token: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+// A refresh token only ends its own user's session.
+func TestLogoutRejectsOtherUsersRefreshToken(t *testing.T) {
+	h, _ := newAccountHandler()
+	ctx := context.Background()
+	ada := mustCreateUser(t, h.Store)
+	bob := mustCreateUser(t, h.Store)
+	adaSession, err := h.Store.CreateRefreshToken(ctx, ada.ID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	logout := func(userID, refreshToken string) int {
+		claims := &auth.Claims{UserID: userID, RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		}}
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			h.Logout(w, r.WithContext(context.WithValue(r.Context(), auth.ClaimsKey, claims)))
+		}
+		return serve(handler, http.MethodPost, "/auth/logout", "/auth/logout",
+			`{"refresh_token":"`+refreshToken+`"}`, "").Code
+	}
+
+	if code := logout(bob.ID, adaSession.Token); code != http.StatusNotFound {
+		t.Fatalf("Logout with someone else's refresh token = %d, want 404", code)
+	}
+	next, err := h.Store.RotateRefreshToken(ctx, adaSession.Token, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("RotateRefreshToken after someone else's logout: %v", err)
+	}
+
+	if code := logout(ada.ID, next.Token); code != http.StatusNoContent {
+		t.Fatalf("Logout = %d, want 204", code)
+	}
+	if _, err := h.Store.RotateRefreshToken(ctx, next.Token, time.Now().Add(time.Hour)); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Fatalf("RotateRefreshToken after logout error = %v, want ErrInvalidRefreshToken", err)
+	}
+}
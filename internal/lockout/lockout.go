@@ -0,0 +1,125 @@
+// Package lockout slows down password guessing. Each failed login makes the
+// next attempt on the account wait longer, and too many failures lock the
+// account, or the client address they came from, out for a while.
+package lockout
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// maxDelayShift caps the progressive delay at Delay << maxDelayShift.
+const maxDelayShift = 10
+
+type Policy struct {
+	// MaxFailures locks an account out after this many failures in a row.
+	MaxFailures int
+	// MaxIPFailures locks a client address out after this many failures,
+	// whichever accounts they were for.
+	MaxIPFailures int
+	// Lockout is how long a lockout lasts. Failures are also forgotten
+	// this long after the last one.
+	Lockout time.Duration
+	// Delay is how long an account waits after its first failure; each
+	// further failure doubles it.
+	Delay time.Duration
+}
+
+// Status says whether a login attempt may go ahead.
+type Status struct {
+	// Wait is how long until the next attempt is allowed, zero if now.
+	Wait time.Duration
+	// Locked is set when Wait is a lockout rather than a delay.
+	Locked bool
+	// Failures is how many failures in a row the account has.
+	Failures int
+	// LockedNow is set by the Fail call that locks the account.
+	LockedNow bool
+}
+
+// Guard applies a Policy to the failures counted in a Store.
+type Guard struct {
+	store  Store
+	policy Policy
+}
+
+func NewGuard(store Store, policy Policy) *Guard {
+	return &Guard{store: store, policy: policy}
+}
+
+// Accounts are keyed by email address, whether or not an account exists, so
+// answers don't reveal which addresses are registered.
+func accountKey(account string) string {
+	return "account:" + strings.ToLower(account)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns whether an attempt to log in to account from ip may go
+// ahead.
+func (g *Guard) Check(ctx context.Context, account, ip string) (Status, error) {
+	now := time.Now()
+	acct, err := g.store.Get(ctx, accountKey(account))
+	if err != nil {
+		return Status{}, err
+	}
+	st := g.status(acct, g.policy.MaxFailures, true, now)
+	if ip != "" {
+		addr, err := g.store.Get(ctx, ipKey(ip))
+		if err != nil {
+			return Status{}, err
+		}
+		if ipStatus := g.status(addr, g.policy.MaxIPFailures, false, now); ipStatus.Wait > st.Wait {
+			st.Wait, st.Locked = ipStatus.Wait, ipStatus.Locked
+		}
+	}
+	return st, nil
+}
+
+// Fail counts a failed attempt to log in to account from ip and returns the
+// status it leaves the account in.
+func (g *Guard) Fail(ctx context.Context, account, ip string) (Status, error) {
+	now := time.Now()
+	if ip != "" {
+		if _, err := g.store.Fail(ctx, ipKey(ip), now, g.policy.Lockout); err != nil {
+			return Status{}, err
+		}
+	}
+	acct, err := g.store.Fail(ctx, accountKey(account), now, g.policy.Lockout)
+	if err != nil {
+		return Status{}, err
+	}
+	st := g.status(acct, g.policy.MaxFailures, true, now)
+	st.LockedNow = acct.Failures == g.policy.MaxFailures
+	return st, nil
+}
+
+// Succeed forgets account's failures once someone has logged in to it. The
+// client address keeps its count, so an attacker can't clear it by logging
+// in to an account of their own.
+func (g *Guard) Succeed(ctx context.Context, account string) error {
+	return g.store.Reset(ctx, accountKey(account))
+}
+
+func (g *Guard) status(r Record, maxFailures int, progressive bool, now time.Time) Status {
+	st := Status{Failures: r.Failures}
+	if r.Failures == 0 {
+		return st
+	}
+	var until time.Time
+	switch {
+	case maxFailures > 0 && r.Failures >= maxFailures:
+		until = r.LastFailure.Add(g.policy.Lockout)
+		st.Locked = true
+	case progressive && g.policy.Delay > 0:
+		until = r.LastFailure.Add(g.policy.Delay << min(r.Failures-1, maxDelayShift))
+	}
+	if !now.Before(until) {
+		return Status{Failures: r.Failures}
+	}
+	st.Wait = until.Sub(now)
+	return st
+}
@@ -0,0 +1,139 @@
+package lockout_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/lockout"
+)
+
+// backdatedStore counts each failure as if it happened age ago, so tests can
+// see delays and lockouts run out without waiting for them.
+type backdatedStore struct {
+	lockout.Store
+	age time.Duration
+}
+
+func (s backdatedStore) Fail(ctx context.Context, key string, now time.Time, ttl time.Duration) (lockout.Record, error) {
+	return s.Store.Fail(ctx, key, now.Add(-s.age), ttl)
+}
+
+func fail(t *testing.T, g *lockout.Guard, account, ip string) lockout.Status {
+	t.Helper()
+	st, err := g.Fail(context.Background(), account, ip)
+	if err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+	return st
+}
+
+func check(t *testing.T, g *lockout.Guard, account, ip string) lockout.Status {
+	t.Helper()
+	st, err := g.Check(context.Background(), account, ip)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	return st
+}
+
+func TestAccountLockout(t *testing.T) {
+	g := lockout.NewGuard(lockout.NewMemoryStore(), lockout.Policy{MaxFailures: 3, MaxIPFailures: 100, Lockout: time.Minute})
+
+	for i := 1; i < 3; i++ {
+		if st := fail(t, g, "ada@example.com", "192.0.2.1"); st.Failures != i || st.Wait != 0 || st.Locked || st.LockedNow {
+			t.Fatalf("failure %d: status = %+v, want counted without a wait", i, st)
+		}
+	}
+	st := fail(t, g, "ada@example.com", "192.0.2.1")
+	if !st.LockedNow || !st.Locked || st.Wait <= 59*time.Second || st.Wait > time.Minute {
+		t.Fatalf("failure 3: status = %+v, want locked for a minute", st)
+	}
+	if st := fail(t, g, "ada@example.com", "192.0.2.1"); st.LockedNow || !st.Locked {
+		t.Fatalf("failure 4: status = %+v, want still locked but not newly", st)
+	}
+
+	// The lock is on the account, whatever the address or case.
+	if st := check(t, g, "ADA@example.com", "198.51.100.7"); !st.Locked || st.Wait <= 0 || st.Failures != 4 {
+		t.Fatalf("Check(account from elsewhere) = %+v, want locked", st)
+	}
+	if st := check(t, g, "bob@example.com", "192.0.2.1"); st.Wait != 0 || st.Failures != 0 {
+		t.Fatalf("Check(other account) = %+v, want allowed", st)
+	}
+}
+
+func TestProgressiveDelay(t *testing.T) {
+	g := lockout.NewGuard(lockout.NewMemoryStore(), lockout.Policy{MaxFailures: 10, Lockout: time.Minute, Delay: time.Second})
+
+	if st := check(t, g, "ada@example.com", ""); st.Wait != 0 {
+		t.Fatalf("Check before failures = %+v, want no wait", st)
+	}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		st := fail(t, g, "ada@example.com", "")
+		if st.Locked || st.Wait <= want-time.Second || st.Wait > want {
+			t.Fatalf("failure %d: status = %+v, want a wait of about %s", i+1, st, want)
+		}
+	}
+}
+
+func TestIPLockout(t *testing.T) {
+	g := lockout.NewGuard(lockout.NewMemoryStore(), lockout.Policy{MaxFailures: 100, MaxIPFailures: 3, Lockout: time.Minute})
+
+	for _, account := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		fail(t, g, account, "192.0.2.1")
+	}
+	// Every account is locked from the address, none from anywhere else.
+	if st := check(t, g, "d@example.com", "192.0.2.1"); !st.Locked || st.Wait <= 0 {
+		t.Fatalf("Check(new account, same address) = %+v, want locked", st)
+	}
+	if st := check(t, g, "a@example.com", "198.51.100.7"); st.Wait != 0 || st.Failures != 1 {
+		t.Fatalf("Check(account, other address) = %+v, want allowed with one failure", st)
+	}
+	if st := check(t, g, "a@example.com", ""); st.Wait != 0 {
+		t.Fatalf("Check without an address = %+v, want allowed", st)
+	}
+}
+
+func TestSucceedResetsAccountOnly(t *testing.T) {
+	g := lockout.NewGuard(lockout.NewMemoryStore(), lockout.Policy{MaxFailures: 3, MaxIPFailures: 3, Lockout: time.Minute})
+	ctx := context.Background()
+
+	fail(t, g, "ada@example.com", "192.0.2.1")
+	fail(t, g, "ada@example.com", "192.0.2.1")
+	if err := g.Succeed(ctx, "Ada@example.com"); err != nil {
+		t.Fatalf("Succeed: %v", err)
+	}
+	// The count starts again, so two more failures don't lock the account.
+	if st := fail(t, g, "ada@example.com", "198.51.100.7"); st.Failures != 1 || st.Locked {
+		t.Fatalf("failure after Succeed: status = %+v, want the first", st)
+	}
+
+	// Logging in to an account of one's own doesn't clear the address.
+	fail(t, g, "bob@example.com", "192.0.2.1")
+	if err := g.Succeed(ctx, "bob@example.com"); err != nil {
+		t.Fatalf("Succeed: %v", err)
+	}
+	if st := check(t, g, "carol@example.com", "192.0.2.1"); !st.Locked {
+		t.Fatalf("Check(address after Succeed) = %+v, want still locked", st)
+	}
+}
+
+func TestLockoutExpires(t *testing.T) {
+	policy := lockout.Policy{MaxFailures: 3, Lockout: time.Minute, Delay: time.Second}
+
+	// Failures older than the lockout are forgotten.
+	g := lockout.NewGuard(backdatedStore{lockout.NewMemoryStore(), policy.Lockout + time.Second}, policy)
+	for i := 0; i < 3; i++ {
+		fail(t, g, "ada@example.com", "")
+	}
+	if st := check(t, g, "ada@example.com", ""); st.Wait != 0 || st.Failures != 0 {
+		t.Fatalf("Check after the lockout = %+v, want allowed and forgotten", st)
+	}
+
+	// A delay that has passed no longer applies, but the failure still counts.
+	g = lockout.NewGuard(backdatedStore{lockout.NewMemoryStore(), 5 * time.Second}, policy)
+	fail(t, g, "ada@example.com", "")
+	if st := check(t, g, "ada@example.com", ""); st.Wait != 0 || st.Failures != 1 {
+		t.Fatalf("Check after the delay = %+v, want allowed with one failure", st)
+	}
+}
@@ -0,0 +1,139 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// Record is the failed attempts counted for a key.
+type Record struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Store counts failed attempts. A key's record is forgotten ttl after its
+// last failure.
+type Store interface {
+	Get(ctx context.Context, key string) (Record, error)
+	// Fail counts a failure at now and returns the updated record.
+	Fail(ctx context.Context, key string, now time.Time, ttl time.Duration) (Record, error)
+	Reset(ctx context.Context, key string) error
+}
+
+// RedisStore shares counts between every user-service replica.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) key(key string) string {
+	return "login_failures:" + key
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (Record, error) {
+	fields, err := s.client.HGetAll(ctx, s.key(key)).Result()
+	if err != nil {
+		return Record{}, err
+	}
+	return parseRecord(fields), nil
+}
+
+func parseRecord(fields map[string]string) Record {
+	failures, _ := strconv.Atoi(fields["failures"])
+	last, _ := strconv.ParseInt(fields["last"], 10, 64)
+	return Record{Failures: failures, LastFailure: time.UnixMilli(last)}
+}
+
+func (s *RedisStore) Fail(ctx context.Context, key string, now time.Time, ttl time.Duration) (Record, error) {
+	var failures *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		failures = pipe.HIncrBy(ctx, s.key(key), "failures", 1)
+		pipe.HSet(ctx, s.key(key), "last", now.UnixMilli())
+		pipe.Expire(ctx, s.key(key), ttl)
+		return nil
+	})
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Failures: int(failures.Val()), LastFailure: time.UnixMilli(now.UnixMilli())}, nil
+}
+
+func (s *RedisStore) Reset(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.key(key)).Err()
+}
+
+// MemoryStore is a process-local Store. With several user-service replicas
+// each one counts separately, so attackers get more attempts.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]memoryRecord
+}
+
+type memoryRecord struct {
+	Record
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]memoryRecord)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, exists := s.records[key]
+	if !exists || !time.Now().Before(r.expiresAt) {
+		return Record{}, nil
+	}
+	return r.Record, nil
+}
+
+func (s *MemoryStore) Fail(ctx context.Context, key string, now time.Time, ttl time.Duration) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, r := range s.records {
+		if !now.Before(r.expiresAt) {
+			delete(s.records, k)
+		}
+	}
+	r := s.records[key]
+	r.Failures++
+	r.LastFailure = now
+	r.expiresAt = now.Add(ttl)
+	s.records[key] = r
+	return r.Record, nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// NewStore uses Redis when REDIS_URL is set and falls back to a MemoryStore
+// otherwise.
+func NewStore(cfg *config.Config) (Store, error) {
+	if cfg.RedisURL == "" {
+		log.Printf("WARN: REDIS_URL is not set; failed logins are only counted by this process")
+		return NewMemoryStore(), nil
+	}
+	redisOpts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	return NewRedisStore(redis.NewClient(redisOpts)), nil
+}
@@ -0,0 +1,161 @@
+package mail
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// accountLink builds the frontend link a token is redeemed through, e.g.
+// https://editor.example.com/verify-email?token=....
+func accountLink(baseURL, path, token string) string {
+	return strings.TrimSuffix(baseURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// VerificationEmail asks the owner of to to confirm their address.
+func VerificationEmail(to, baseURL, token string, expiresAt time.Time) Message {
+	return Message{
+		To:      to,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Confirm that this is your email address by opening the link below:\n\n%s\n\n"+
+			"The link expires on %s. If you didn't create an account, you can ignore this email.\n",
+			accountLink(baseURL, "/verify-email", token), expiresAt.UTC().Format(time.RFC1123)),
+	}
+}
+
+// PasswordResetEmail sends the link for choosing a new password.
+func PasswordResetEmail(to, baseURL, token string, expiresAt time.Time) Message {
+	return Message{
+		To:      to,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your account. Choose a new one here:\n\n%s\n\n"+
+			"The link can be used once and expires on %s. If you didn't ask for this, you can ignore this email.\n",
+			accountLink(baseURL, "/reset-password", token), expiresAt.UTC().Format(time.RFC1123)),
+	}
+}
+
+// InvitationEmail tells to, who has no account yet, that a document was
+// shared with them as role, and how to sign up to open it.
+func InvitationEmail(to, baseURL, role string) Message {
+	link := strings.TrimSuffix(baseURL, "/") + "/register?email=" + url.QueryEscape(to)
+	return Message{
+		To:      to,
+		Subject: "A document was shared with you",
+		Body: fmt.Sprintf("Someone shared a document with you as %s. Create an account with this email address to open it:\n\n%s\n\n"+
+			"The document appears in your list once you have verified the address. If you weren't expecting this, you can ignore this email.\n",
+			role, link),
+	}
+}
+
+// PasswordChangedEmail tells the owner of to that their password was changed.
+func PasswordChangedEmail(to string) Message {
+	return Message{
+		To:      to,
+		Subject: "Your password was changed",
+		Body: "The password for your account was just changed and all of your sessions were signed out.\n\n" +
+			"If this wasn't you, reset your password right away.\n",
+	}
+}
+
+// TwoFactorChangedEmail tells the owner of to that two-factor authentication
+// was turned on or off for their account.
+func TwoFactorChangedEmail(to string, enabled bool) Message {
+	state := "turned off"
+	if enabled {
+		state = "turned on"
+	}
+	return Message{
+		To:      to,
+		Subject: "Two-factor authentication was " + state,
+		Body: "Two-factor authentication was just " + state + " for your account.\n\n" +
+			"If this wasn't you, reset your password right away.\n",
+	}
+}
+
+// AccountLockedEmail tells the owner of to that logging in to their account
+// is blocked until until after too many failed attempts.
+func AccountLockedEmail(to string, until time.Time) Message {
+	return Message{
+		To:      to,
+		Subject: "Your account was temporarily locked",
+		Body: "There were too many failed attempts to log in to your account, so logging in is blocked until " +
+			until.UTC().Format("2006-01-02 15:04 MST") + ".\n\n" +
+			"If this wasn't you, someone may be guessing your password. Consider resetting it.\n",
+	}
+}
+
+// EmailChangedEmail tells the owner of the old address to that their
+// account now uses newEmail.
+func EmailChangedEmail(to, newEmail string) Message {
+	return Message{
+		To:      to,
+		Subject: "Your email address was changed",
+		Body: "The email address of your account was just changed to " + newEmail + ".\n\n" +
+			"If this wasn't you, someone else may have access to your account; contact your administrator.\n",
+	}
+}
+
+// AccountDeletedEmail confirms to the owner of to that their account was
+// deleted; documents says what became of the documents it owned.
+func AccountDeletedEmail(to, documents, transferTo string) Message {
+	var fate string
+	switch documents {
+	case "transfer":
+		fate = "The documents, workspaces and folders you owned now belong to " + transferTo + ".\n\n"
+	case "delete":
+		fate = "The documents, workspaces and folders you owned were deleted.\n\n"
+	case "orphan":
+		fate = "The documents you owned were kept for the people they are shared with.\n\n"
+	}
+	return Message{
+		To:      to,
+		Subject: "Your account was deleted",
+		Body: "Your account was deleted and all of its sessions were signed out.\n\n" + fate +
+			"If this wasn't you, contact your administrator right away.\n",
+	}
+}
+
+// PersonalTokenCreatedEmail tells the owner of to that a personal access
+// token named name was created for their account.
+func PersonalTokenCreatedEmail(to, name, scopes string, expiresAt time.Time) Message {
+	return Message{
+		To:      to,
+		Subject: "A personal access token was created",
+		Body: "A personal access token named \"" + name + "\" was just created for your account, with the scopes " +
+			scopes + ". It expires on " + expiresAt.UTC().Format("2006-01-02") + ".\n\n" +
+			"If this wasn't you, revoke it and reset your password right away.\n",
+	}
+}
+
+// AccessRequestedEmail tells to, the owner of the document titled title,
+// that requesterEmail asked for role on it, with their note if they left one.
+func AccessRequestedEmail(to, title, requesterEmail, role, message string) Message {
+	var note string
+	if message != "" {
+		note = "They wrote:\n\n" + message + "\n\n"
+	}
+	return Message{
+		To:      to,
+		Subject: "Access requested to " + title,
+		Body: requesterEmail + " asked for " + role + " access to your document \"" + title + "\".\n\n" + note +
+			"Approve or deny the request from the document's access requests.\n",
+	}
+}
+
+// AccessRequestDecidedEmail tells to whether their request for role on the
+// document titled title was approved.
+func AccessRequestDecidedEmail(to, title, role string, approved bool) Message {
+	if !approved {
+		return Message{
+			To:      to,
+			Subject: "Access request denied",
+			Body:    "The owner of \"" + title + "\" denied your request for " + role + " access.\n",
+		}
+	}
+	return Message{
+		To:      to,
+		Subject: "Access request approved",
+		Body:    "The owner of \"" + title + "\" approved your request; you now have " + role + " access to it.\n",
+	}
+}
@@ -0,0 +1,108 @@
+// Package mail sends the emails notification-service composes from events:
+// over SMTP in production, or to the log when no SMTP server is configured.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
+)
+
+// Message is a plain-text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer delivers mail through an SMTP relay. Authentication is only
+// attempted when a username is configured; net/smtp refuses to send
+// credentials unless the connection is encrypted or to localhost.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: net.JoinHostPort(host, port), from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	sender, err := netmail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", m.from, err)
+	}
+	if _, err := netmail.ParseAddress(msg.To); err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid subject %q", msg.Subject)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", sender.String())
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return smtp.SendMail(m.addr, m.auth, sender.Address, []string{msg.To}, []byte(b.String()))
+}
+
+// LogMailer writes messages to the log instead of sending them, so links in
+// verification and reset emails can be copied from the service's output
+// during local development.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("[mail] To: %s | Subject: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// MemoryMailer keeps every message it is given, for tests.
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns the messages sent so far, oldest first.
+func (m *MemoryMailer) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
+
+// New returns an SMTPMailer when SMTP_HOST is set and a LogMailer otherwise.
+func New(cfg *config.Config) Mailer {
+	if cfg.SMTPHost == "" {
+		log.Printf("WARN: SMTP_HOST is not set; emails are written to the log instead of being sent")
+		return LogMailer{}
+	}
+	return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+}
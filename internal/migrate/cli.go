@@ -0,0 +1,59 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const usage = "usage: migrate up | down [steps] | status"
+
+// RunCommand implements the "migrate" subcommand of the services. args are
+// the arguments after "migrate"; "down" reverts one migration by default.
+func RunCommand(ctx context.Context, m *Migrator, args []string, out io.Writer) error {
+	m.Logf = func(format string, a ...interface{}) {
+		fmt.Fprintf(out, format+"\n", a...)
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf(usage)
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d migration(s) applied\n", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		n, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d migration(s) reverted\n", n)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Fprintf(out, "%03d_%s\t%s\n", s.Version, s.Name, state)
+		}
+	default:
+		return fmt.Errorf(usage)
+	}
+	return nil
+}
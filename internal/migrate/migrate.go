@@ -0,0 +1,187 @@
+// Package migrate applies the embedded SQL migrations and records them in a
+// schema_migrations table so every deploy converges on the same schema.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migration is one numbered schema change. Files are named NNN_name.sql (or
+// NNN_name.up.sql) with an optional NNN_name.down.sql to revert it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Driver is implemented per database engine.
+type Driver interface {
+	// Lock serialises migrators across processes until Unlock is called.
+	Lock(ctx context.Context) error
+	Unlock(ctx context.Context) error
+	// Applied returns the versions recorded in schema_migrations, creating
+	// the table first if needed.
+	Applied(ctx context.Context) (map[int]bool, error)
+	// Apply runs script and records (up) or forgets (down) version atomically.
+	Apply(ctx context.Context, version int, script string, up bool) error
+}
+
+// Migrator runs a set of migrations through a Driver.
+type Migrator struct {
+	driver     Driver
+	migrations []Migration
+	// Logf, when set, is called for every migration applied or reverted.
+	Logf func(format string, args ...interface{})
+}
+
+// New loads the migrations found in dir of fsys.
+func New(driver Driver, fsys fs.FS, dir string) (*Migrator, error) {
+	migrations, err := Load(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{driver: driver, migrations: migrations}, nil
+}
+
+// Load reads and orders the migrations in dir of fsys.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(fileName, ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(fileName, ".sql")
+		down := strings.HasSuffix(base, ".down")
+		base = strings.TrimSuffix(strings.TrimSuffix(base, ".down"), ".up")
+
+		prefix, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no numeric version prefix", fileName)
+		}
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if down {
+			m.Down = string(body)
+		} else {
+			if m.Up != "" {
+				return nil, fmt.Errorf("duplicate migration version %d", version)
+			}
+			m.Up = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has a down script but no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func (m *Migrator) logf(format string, args ...interface{}) {
+	if m.Logf != nil {
+		m.Logf(format, args...)
+	}
+}
+
+// Up applies every pending migration in order and returns how many ran.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	if err := m.driver.Lock(ctx); err != nil {
+		return 0, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer m.driver.Unlock(ctx)
+
+	applied, err := m.driver.Applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, mig := range m.migrations {
+		if applied[mig.Version] {
+			continue
+		}
+		if err := m.driver.Apply(ctx, mig.Version, mig.Up, true); err != nil {
+			return count, fmt.Errorf("migration %03d_%s: %w", mig.Version, mig.Name, err)
+		}
+		m.logf("Applied migration %03d_%s", mig.Version, mig.Name)
+		count++
+	}
+	return count, nil
+}
+
+// Down reverts the most recently applied steps migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if err := m.driver.Lock(ctx); err != nil {
+		return 0, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer m.driver.Unlock(ctx)
+
+	applied, err := m.driver.Applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+		mig := m.migrations[i]
+		if !applied[mig.Version] {
+			continue
+		}
+		if mig.Down == "" {
+			return count, fmt.Errorf("migration %03d_%s cannot be reverted: no down script", mig.Version, mig.Name)
+		}
+		if err := m.driver.Apply(ctx, mig.Version, mig.Down, false); err != nil {
+			return count, fmt.Errorf("reverting migration %03d_%s: %w", mig.Version, mig.Name, err)
+		}
+		m.logf("Reverted migration %03d_%s", mig.Version, mig.Name)
+		count++
+	}
+	return count, nil
+}
+
+// Status describes whether a migration has been applied.
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.driver.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		statuses = append(statuses, Status{Migration: mig, Applied: applied[mig.Version]})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,252 @@
+package migrate_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/migrate"
+	"github.com/pasanAbeysekara/collaborative-editor/migrations"
+	_ "modernc.org/sqlite"
+)
+
+var testMigrations = fstest.MapFS{
+	"m/001_create_notes.sql":      {Data: []byte(`CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT);`)},
+	"m/001_create_notes.down.sql": {Data: []byte(`DROP TABLE notes;`)},
+	"m/002_add_tags.up.sql":       {Data: []byte(`CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT);`)},
+	"m/002_add_tags.down.sql":     {Data: []byte(`DROP TABLE tags;`)},
+	"m/README.md":                 {Data: []byte(`ignored`)},
+}
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newMigrator(t *testing.T, db *sql.DB, fsys fstest.MapFS) *migrate.Migrator {
+	t.Helper()
+	m, err := migrate.New(migrate.NewSQLiteDriver(db), fsys, "m")
+	if err != nil {
+		t.Fatalf("migrate.New: %v", err)
+	}
+	return m
+}
+
+// run executes the migrate subcommand and returns its output.
+func run(t *testing.T, m *migrate.Migrator, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := migrate.RunCommand(context.Background(), m, args, &out)
+	return out.String(), err
+}
+
+func appliedVersions(t *testing.T, db *sql.DB) []int {
+	t.Helper()
+	rows, err := db.Query(`SELECT version FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		t.Fatalf("query schema_migrations: %v", err)
+	}
+	defer rows.Close()
+	versions := []int{}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n); err != nil {
+		t.Fatalf("query sqlite_master: %v", err)
+	}
+	return n == 1
+}
+
+func TestRunCommandUp(t *testing.T) {
+	db := openDB(t)
+	m := newMigrator(t, db, testMigrations)
+
+	out, err := run(t, m, "up")
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	want := "Applied migration 001_create_notes\nApplied migration 002_add_tags\n2 migration(s) applied\n"
+	if out != want {
+		t.Fatalf("up output = %q, want %q", out, want)
+	}
+	if got := appliedVersions(t, db); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Fatalf("schema_migrations = %v, want [1 2]", got)
+	}
+	if !tableExists(t, db, "notes") || !tableExists(t, db, "tags") {
+		t.Fatal("up did not create the migrated tables")
+	}
+
+	// Running again is a no-op.
+	if out, err := run(t, m, "up"); err != nil || out != "0 migration(s) applied\n" {
+		t.Fatalf("second up = %q, %v; want nothing applied", out, err)
+	}
+}
+
+func TestRunCommandStatus(t *testing.T) {
+	db := openDB(t)
+	m := newMigrator(t, db, testMigrations)
+
+	out, err := run(t, m, "status")
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if want := "001_create_notes\tpending\n002_add_tags\tpending\n"; out != want {
+		t.Fatalf("status before up = %q, want %q", out, want)
+	}
+
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if _, err := run(t, m, "down"); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	out, err = run(t, m, "status")
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if want := "001_create_notes\tapplied\n002_add_tags\tpending\n"; out != want {
+		t.Fatalf("status after down = %q, want %q", out, want)
+	}
+}
+
+func TestRunCommandDown(t *testing.T) {
+	db := openDB(t)
+	m := newMigrator(t, db, testMigrations)
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	out, err := run(t, m, "down")
+	if err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	if want := "Reverted migration 002_add_tags\n1 migration(s) reverted\n"; out != want {
+		t.Fatalf("down output = %q, want %q", out, want)
+	}
+	if got := appliedVersions(t, db); !reflect.DeepEqual(got, []int{1}) {
+		t.Fatalf("schema_migrations after down = %v, want [1]", got)
+	}
+	if tableExists(t, db, "tags") {
+		t.Fatal("down left the tags table behind")
+	}
+
+	// Asking for more steps than are applied reverts what there is.
+	out, err = run(t, m, "down", "5")
+	if err != nil {
+		t.Fatalf("down 5: %v", err)
+	}
+	if !strings.HasSuffix(out, "1 migration(s) reverted\n") {
+		t.Fatalf("down 5 output = %q, want one migration reverted", out)
+	}
+	if got := appliedVersions(t, db); len(got) != 0 {
+		t.Fatalf("schema_migrations after down 5 = %v, want empty", got)
+	}
+}
+
+func TestRunCommandRejectsBadArguments(t *testing.T) {
+	m := newMigrator(t, openDB(t), testMigrations)
+	for _, args := range [][]string{nil, {"sideways"}, {"down", "0"}, {"down", "x"}} {
+		if _, err := run(t, m, args...); err == nil {
+			t.Errorf("migrate %v succeeded, want an error", args)
+		}
+	}
+}
+
+func TestRunCommandDownWithoutScript(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/001_create_notes.sql": {Data: []byte(`CREATE TABLE notes (id INTEGER PRIMARY KEY);`)},
+	}
+	db := openDB(t)
+	m := newMigrator(t, db, fsys)
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if _, err := run(t, m, "down"); err == nil {
+		t.Fatal("down without a down script succeeded")
+	}
+	if got := appliedVersions(t, db); !reflect.DeepEqual(got, []int{1}) {
+		t.Fatalf("schema_migrations = %v, want [1]", got)
+	}
+}
+
+// A failing migration is rolled back as a whole and stops the run, while the
+// ones before it stay applied.
+func TestRunCommandUpFailsPartWay(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/001_create_notes.sql": {Data: []byte(`CREATE TABLE notes (id INTEGER PRIMARY KEY);`)},
+		"m/002_broken.sql":       {Data: []byte(`CREATE TABLE half (id INTEGER PRIMARY KEY); CREATE TABLE oops (;`)},
+		"m/003_add_tags.sql":     {Data: []byte(`CREATE TABLE tags (id INTEGER PRIMARY KEY);`)},
+	}
+	db := openDB(t)
+
+	out, err := run(t, newMigrator(t, db, fsys), "up")
+	if err == nil || !strings.Contains(err.Error(), "migration 002_broken") {
+		t.Fatalf("up error = %v, want failure in 002_broken", err)
+	}
+	if out != "Applied migration 001_create_notes\n" {
+		t.Fatalf("up output = %q, want only 001 applied", out)
+	}
+	if got := appliedVersions(t, db); !reflect.DeepEqual(got, []int{1}) {
+		t.Fatalf("schema_migrations = %v, want [1]", got)
+	}
+	if tableExists(t, db, "half") || tableExists(t, db, "tags") {
+		t.Fatal("tables from the failed or later migrations were created")
+	}
+
+	// Once the broken script is fixed the run picks up where it stopped.
+	fsys["m/002_broken.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE half (id INTEGER PRIMARY KEY);`)}
+	out, err = run(t, newMigrator(t, db, fsys), "up")
+	if err != nil {
+		t.Fatalf("up after fix: %v", err)
+	}
+	if !strings.HasSuffix(out, "2 migration(s) applied\n") {
+		t.Fatalf("up after fix output = %q, want two applied", out)
+	}
+	if got := appliedVersions(t, db); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Fatalf("schema_migrations = %v, want [1 2 3]", got)
+	}
+}
+
+// The embedded SQLite schema must migrate all the way up and back down.
+func TestSQLiteSchemaRoundTrip(t *testing.T) {
+	db := openDB(t)
+	m, err := migrate.New(migrate.NewSQLiteDriver(db), migrations.SQLite, "sqlite")
+	if err != nil {
+		t.Fatalf("migrate.New: %v", err)
+	}
+	ctx := context.Background()
+	up, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	down, err := m.Down(ctx, up)
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if down != up {
+		t.Fatalf("Down reverted %d migrations, want %d", down, up)
+	}
+	if got := appliedVersions(t, db); len(got) != 0 {
+		t.Fatalf("schema_migrations after full down = %v, want empty", got)
+	}
+}
@@ -0,0 +1,101 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lockKey is the pg_advisory_lock key shared by every migrating process.
+// The value is arbitrary; it only has to be the same everywhere.
+const lockKey = 7214500918
+
+// PostgresDriver applies migrations to PostgreSQL. Lock takes a session-level
+// advisory lock so several pods starting at once don't race each other.
+type PostgresDriver struct {
+	pool *pgxpool.Pool
+	conn *pgxpool.Conn
+}
+
+func NewPostgresDriver(pool *pgxpool.Pool) *PostgresDriver {
+	return &PostgresDriver{pool: pool}
+}
+
+func (d *PostgresDriver) Lock(ctx context.Context) error {
+	// Advisory locks belong to a session, so hold on to one connection and
+	// run every migration through it until Unlock.
+	conn, err := d.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		conn.Release()
+		return err
+	}
+	d.conn = conn
+	return nil
+}
+
+func (d *PostgresDriver) Unlock(ctx context.Context) error {
+	if d.conn == nil {
+		return nil
+	}
+	_, err := d.conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, lockKey)
+	d.conn.Release()
+	d.conn = nil
+	return err
+}
+
+func (d *PostgresDriver) Applied(ctx context.Context) (map[int]bool, error) {
+	_, err := d.pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.pool.Query(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (d *PostgresDriver) Apply(ctx context.Context, version int, script string, up bool) error {
+	if d.conn == nil {
+		return fmt.Errorf("migration lock is not held")
+	}
+
+	tx, err := d.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return err
+	}
+	if up {
+		_, err = tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version)
+	} else {
+		_, err = tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,118 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SQLiteDriver applies migrations to a SQLite database. Services sharing
+// one database file can start at the same time, so Lock opens a write
+// transaction with BEGIN IMMEDIATE, which SQLite grants to one connection
+// at a time across processes. Others wait up to the connection's busy
+// timeout. Every migration then runs inside that transaction, under its own
+// savepoint, and Unlock commits them.
+type SQLiteDriver struct {
+	db   *sql.DB
+	conn *sql.Conn
+}
+
+func NewSQLiteDriver(db *sql.DB) *SQLiteDriver {
+	return &SQLiteDriver{db: db}
+}
+
+func (d *SQLiteDriver) Lock(ctx context.Context) error {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		conn.Close()
+		return err
+	}
+	d.conn = conn
+	return nil
+}
+
+func (d *SQLiteDriver) Unlock(ctx context.Context) error {
+	if d.conn == nil {
+		return nil
+	}
+	// The migrations applied so far are kept even if a later one failed,
+	// as they would be with PostgreSQL.
+	_, err := d.conn.ExecContext(context.Background(), `COMMIT`)
+	d.conn.Close()
+	d.conn = nil
+	return err
+}
+
+// execer is the part of *sql.DB and *sql.Conn that Applied uses.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Applied reads schema_migrations inside the locked transaction when there
+// is one, so it sees what other processes applied before the lock was
+// granted.
+func (d *SQLiteDriver) Applied(ctx context.Context) (map[int]bool, error) {
+	var db execer = d.db
+	if d.conn != nil {
+		db = d.conn
+	}
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (d *SQLiteDriver) Apply(ctx context.Context, version int, script string, up bool) error {
+	if d.conn == nil {
+		return fmt.Errorf("migration lock is not held")
+	}
+	if _, err := d.conn.ExecContext(ctx, `SAVEPOINT migration`); err != nil {
+		return err
+	}
+	err := d.apply(ctx, version, script, up)
+	if err != nil {
+		d.conn.ExecContext(context.Background(), `ROLLBACK TO migration`)
+	}
+	if _, rerr := d.conn.ExecContext(context.Background(), `RELEASE migration`); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func (d *SQLiteDriver) apply(ctx context.Context, version int, script string, up bool) error {
+	if _, err := d.conn.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	var err error
+	if up {
+		_, err = d.conn.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES (?)`, version)
+	} else {
+		_, err = d.conn.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, version)
+	}
+	return err
+}
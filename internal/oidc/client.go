@@ -0,0 +1,287 @@
+// Package oidc signs users in through an external OpenID Connect identity
+// provider, using the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+)
+
+// LoginStateTTL is how long a user has to complete a sign-in at the
+// identity provider.
+const LoginStateTTL = 10 * time.Minute
+
+// ErrInvalidState is returned by Finish for a state that was never issued,
+// has expired or was already used.
+var ErrInvalidState = errors.New("login state is invalid or expired")
+
+type Config struct {
+	// Issuer is the provider's issuer URL; its metadata is discovered at
+	// Issuer/.well-known/openid-configuration.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider sends the user back to, our
+	// callback endpoint.
+	RedirectURL string
+	Scopes      []string
+}
+
+// Identity is who the provider says signed in.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// metadata is the part of the provider's discovery document we use.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// loginState is kept between Begin and Finish.
+type loginState struct {
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+// Client is an OpenID Connect relying party. The provider's metadata is
+// discovered on first use, so user-service starts even while the provider
+// is unreachable.
+type Client struct {
+	cfg    Config
+	states StateStore
+	client *http.Client
+
+	mu       sync.Mutex
+	meta     *metadata
+	verifier *auth.JWKSVerifier
+}
+
+func NewClient(cfg Config, states StateStore) *Client {
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	return &Client{cfg: cfg, states: states, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (c *Client) discover(ctx context.Context) (*metadata, *auth.JWKSVerifier, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.meta != nil {
+		return c.meta, c.verifier, nil
+	}
+	var meta metadata
+	if err := c.getJSON(ctx, c.cfg.Issuer+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, nil, fmt.Errorf("discovering %s: %w", c.cfg.Issuer, err)
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != c.cfg.Issuer {
+		return nil, nil, fmt.Errorf("provider claims issuer %q, expected %q", meta.Issuer, c.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, nil, errors.New("provider metadata is missing an endpoint")
+	}
+	c.meta = &meta
+	c.verifier = auth.NewJWKSVerifier(meta.JWKSURI, time.Hour)
+	return c.meta, c.verifier, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Begin starts a sign-in. It returns the provider URL to send the user to
+// and the state the callback will carry, which the caller should also bind
+// to the browser (e.g. in a cookie) so a callback can't be replayed into
+// another user's session.
+func (c *Client) Begin(ctx context.Context) (string, string, error) {
+	meta, _, err := c.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	var values [3]string
+	for i := range values {
+		if values[i], err = randomString(); err != nil {
+			return "", "", err
+		}
+	}
+	state, ls := values[0], loginState{Nonce: values[1], CodeVerifier: values[2]}
+	data, _ := json.Marshal(ls)
+	if err := c.states.Put(ctx, state, data, LoginStateTTL); err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(ls.CodeVerifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.cfg.ClientID},
+		"redirect_uri":          {c.cfg.RedirectURL},
+		"scope":                 {strings.Join(c.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {ls.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + params.Encode(), state, nil
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// idTokenClaims are the ID token claims we read. email_verified is a
+// string in some providers.
+type idTokenClaims struct {
+	Email           string      `json:"email"`
+	EmailVerified   interface{} `json:"email_verified"`
+	Name            string      `json:"name"`
+	Nonce           string      `json:"nonce"`
+	AuthorizedParty string      `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// Finish completes the sign-in the provider redirected back with: it
+// consumes state, redeems code with the PKCE verifier and validates the ID
+// token it gets in return.
+func (c *Client) Finish(ctx context.Context, state, code string) (*Identity, error) {
+	if state == "" {
+		return nil, ErrInvalidState
+	}
+	data, err := c.states.Take(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	var ls loginState
+	if err := json.Unmarshal(data, &ls); err != nil {
+		return nil, err
+	}
+	if code == "" {
+		return nil, errors.New("callback has no authorization code")
+	}
+
+	meta, verifier, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rawIDToken, err := c.exchange(ctx, meta.TokenEndpoint, code, ls.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &idTokenClaims{}
+	token, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return verifier.VerificationKey(ctx, token)
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != c.cfg.Issuer:
+		return nil, errors.New("invalid ID token: wrong issuer")
+	case !claims.VerifyAudience(c.cfg.ClientID, true):
+		return nil, errors.New("invalid ID token: wrong audience")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != c.cfg.ClientID:
+		return nil, errors.New("invalid ID token: issued to another party")
+	case claims.ExpiresAt == nil:
+		return nil, errors.New("invalid ID token: no expiry")
+	case claims.Nonce != ls.Nonce:
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	case claims.Subject == "":
+		return nil, errors.New("invalid ID token: no subject")
+	}
+
+	verified := claims.EmailVerified == true || claims.EmailVerified == "true"
+	return &Identity{
+		Issuer:        c.cfg.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: verified,
+		Name:          claims.Name,
+	}, nil
+}
+
+// exchange redeems an authorization code at the token endpoint and returns
+// the ID token. A configured client secret is sent with HTTP Basic auth.
+func (c *Client) exchange(ctx context.Context, endpoint, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if c.cfg.ClientSecret == "" {
+		form.Set("client_id", c.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("token endpoint answered %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return "", fmt.Errorf("token endpoint answered %s: %s %s", resp.Status, tr.Error, tr.ErrorDescription)
+	}
+	if tr.IDToken == "" {
+		return "", errors.New("token endpoint returned no ID token")
+	}
+	return tr.IDToken, nil
+}
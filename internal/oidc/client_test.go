@@ -0,0 +1,170 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/oidc"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/oidc/oidctest"
+)
+
+const redirectURL = "http://editor.test/auth/oidc/callback"
+
+func newProvider(t *testing.T) *oidctest.Provider {
+	t.Helper()
+	p, err := oidctest.NewProvider("editor", "s3cret")
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+func newClient(p *oidctest.Provider) *oidc.Client {
+	return oidc.NewClient(oidc.Config{
+		Issuer:       p.URL,
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email"},
+	}, oidc.NewMemoryStateStore())
+}
+
+// authorize visits authURL at the provider and returns the code and state it
+// redirects back to the callback with.
+func authorize(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(authURL)
+	if err != nil {
+		t.Fatalf("GET authorization endpoint: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorization endpoint answered %s", resp.Status)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing redirect: %v", err)
+	}
+	if !strings.HasPrefix(location.String(), redirectURL+"?") {
+		t.Fatalf("redirected to %s, want the callback", location)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestClientSignIn(t *testing.T) {
+	p := newProvider(t)
+	p.SetUser(oidctest.User{Subject: "sub-1", Email: "ada@example.com", EmailVerified: true, Name: "Ada"})
+	c := newClient(p)
+	ctx := context.Background()
+
+	authURL, state, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	params, _ := url.Parse(authURL)
+	if q := params.Query(); q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" || q.Get("state") != state {
+		t.Fatalf("authorization URL %s lacks PKCE or state", authURL)
+	}
+
+	code, returnedState := authorize(t, authURL)
+	if returnedState != state {
+		t.Fatalf("provider returned state %q, want %q", returnedState, state)
+	}
+	identity, err := c.Finish(ctx, state, code)
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	want := oidc.Identity{Issuer: p.URL, Subject: "sub-1", Email: "ada@example.com", EmailVerified: true, Name: "Ada"}
+	if *identity != want {
+		t.Fatalf("identity = %+v, want %+v", *identity, want)
+	}
+
+	// A state completes one sign-in only.
+	if _, err := c.Finish(ctx, state, code); !errors.Is(err, oidc.ErrInvalidState) {
+		t.Fatalf("Finish(reused state) error = %v, want ErrInvalidState", err)
+	}
+}
+
+func TestClientRejectsUnknownState(t *testing.T) {
+	p := newProvider(t)
+	c := newClient(p)
+	ctx := context.Background()
+
+	authURL, _, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	code, _ := authorize(t, authURL)
+	for _, state := range []string{"", "forged"} {
+		if _, err := c.Finish(ctx, state, code); !errors.Is(err, oidc.ErrInvalidState) {
+			t.Fatalf("Finish(%q) error = %v, want ErrInvalidState", state, err)
+		}
+	}
+}
+
+// A code only redeems with the verifier of the sign-in it was issued for.
+func TestClientPKCEBindsCodeToSignIn(t *testing.T) {
+	p := newProvider(t)
+	c := newClient(p)
+	ctx := context.Background()
+
+	firstURL, _, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	_, secondState, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	code, _ := authorize(t, firstURL)
+	if _, err := c.Finish(ctx, secondState, code); err == nil {
+		t.Fatal("Finish with another sign-in's verifier succeeded")
+	}
+}
+
+func TestClientReportsUnverifiedEmail(t *testing.T) {
+	p := newProvider(t)
+	p.SetUser(oidctest.User{Subject: "sub-2", Email: "bob@example.com"})
+	c := newClient(p)
+	ctx := context.Background()
+
+	authURL, state, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	code, _ := authorize(t, authURL)
+	identity, err := c.Finish(ctx, state, code)
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if identity.EmailVerified || identity.Email != "bob@example.com" {
+		t.Fatalf("identity = %+v, want bob@example.com unverified", identity)
+	}
+}
+
+func TestClientRejectsWrongSecret(t *testing.T) {
+	p := newProvider(t)
+	c := oidc.NewClient(oidc.Config{
+		Issuer:       p.URL,
+		ClientID:     p.ClientID,
+		ClientSecret: "wrong",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid"},
+	}, oidc.NewMemoryStateStore())
+	ctx := context.Background()
+
+	authURL, state, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	code, _ := authorize(t, authURL)
+	if _, err := c.Finish(ctx, state, code); err == nil {
+		t.Fatal("Finish with the wrong client secret succeeded")
+	}
+}
@@ -0,0 +1,207 @@
+// Package oidctest runs a local OpenID Connect provider for testing single
+// sign-on without a real identity provider.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+)
+
+// User is who the provider signs in.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// authRequest is what an authorization code was issued for.
+type authRequest struct {
+	user          User
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// Provider signs in its current User without asking for credentials, but
+// otherwise checks requests like a real provider: the client ID and secret,
+// the redirect URI, single-use codes and the PKCE (S256) verifier. ID tokens
+// are signed with a fresh RSA key published at the JWKS endpoint.
+type Provider struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	key *rsa.PrivateKey
+	kid string
+	set auth.JWKS
+
+	mu    sync.Mutex
+	user  User
+	codes map[string]authRequest
+}
+
+// NewProvider starts a provider for one client. Close it when done.
+func NewProvider(clientID, clientSecret string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	signingKey, err := auth.NewSigningKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		kid:          signingKey.ID,
+		set:          auth.JWKS{Keys: []auth.JWK{signingKey.JWK()}},
+		codes:        make(map[string]authRequest),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.Server = httptest.NewServer(mux)
+	return p, nil
+}
+
+// SetUser changes who the next sign-in is for.
+func (p *Provider) SetUser(u User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.user = u
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/jwks",
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.set)
+}
+
+// authorize approves the request at once and redirects back with a code.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	switch {
+	case q.Get("client_id") != p.ClientID:
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	case err != nil || q.Get("redirect_uri") == "":
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	case q.Get("response_type") != "code":
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	code := rand.Text()
+	p.mu.Lock()
+	p.codes[code] = authRequest{
+		user:          p.user,
+		redirectURI:   q.Get("redirect_uri"),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+	}
+	p.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.ClientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	p.mu.Lock()
+	req, exists := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	if !exists || req.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown code or redirect_uri mismatch")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != req.codeChallenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match code_challenge")
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.URL,
+		"sub":            req.user.Subject,
+		"aud":            p.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          req.nonce,
+		"email":          req.user.Email,
+		"email_verified": req.user.EmailVerified,
+		"name":           req.user.Name,
+	})
+	token.Header["kid"] = p.kid
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
@@ -0,0 +1,103 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// StateStore keeps a sign-in's nonce and PKCE verifier between the redirect
+// to the provider and the callback. Take removes the entry, so each state
+// can only complete one sign-in, and returns ErrInvalidState when there is
+// none.
+type StateStore interface {
+	Put(ctx context.Context, state string, data []byte, ttl time.Duration) error
+	Take(ctx context.Context, state string) ([]byte, error)
+}
+
+// RedisStateStore lets the callback reach any user-service replica.
+type RedisStateStore struct {
+	client *redis.Client
+}
+
+func NewRedisStateStore(client *redis.Client) *RedisStateStore {
+	return &RedisStateStore{client: client}
+}
+
+func (s *RedisStateStore) key(state string) string {
+	return "oidc_state:" + state
+}
+
+func (s *RedisStateStore) Put(ctx context.Context, state string, data []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.key(state), data, ttl).Err()
+}
+
+func (s *RedisStateStore) Take(ctx context.Context, state string) ([]byte, error) {
+	data, err := s.client.GetDel(ctx, s.key(state)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidState
+	}
+	return data, err
+}
+
+// MemoryStateStore is a process-local StateStore, so it only works with a
+// single user-service replica.
+type MemoryStateStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryState
+}
+
+type memoryState struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{entries: make(map[string]memoryState)}
+}
+
+func (s *MemoryStateStore) Put(ctx context.Context, state string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, e := range s.entries {
+		if !now.Before(e.expiresAt) {
+			delete(s.entries, k)
+		}
+	}
+	s.entries[state] = memoryState{data: data, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStateStore) Take(ctx context.Context, state string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, exists := s.entries[state]
+	delete(s.entries, state)
+	if !exists || !time.Now().Before(e.expiresAt) {
+		return nil, ErrInvalidState
+	}
+	return e.data, nil
+}
+
+// NewStateStore uses Redis when REDIS_URL is set and falls back to a
+// MemoryStateStore otherwise.
+func NewStateStore(cfg *config.Config) (StateStore, error) {
+	if cfg.RedisURL == "" {
+		log.Printf("WARN: REDIS_URL is not set; single sign-on only works with one user-service replica")
+		return NewMemoryStateStore(), nil
+	}
+	redisOpts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	return NewRedisStateStore(redis.NewClient(redisOpts)), nil
+}
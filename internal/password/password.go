@@ -0,0 +1,57 @@
+// Package password decides whether a new password is acceptable. Following
+// NIST SP 800-63B it asks for length and rejects easily guessed passwords
+// rather than requiring particular kinds of characters.
+package password
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MinLength = 8
+	// MaxLength is in bytes: bcrypt ignores anything past the 72nd byte.
+	MaxLength = 72
+)
+
+var (
+	ErrTooShort      = fmt.Errorf("password must be at least %d characters long", MinLength)
+	ErrTooLong       = fmt.Errorf("password must be at most %d bytes long", MaxLength)
+	ErrTooCommon     = errors.New("password is too easy to guess")
+	ErrContainsEmail = errors.New("password must not contain your email address")
+)
+
+// common are passwords that show up first in every breach list, compared
+// case-insensitively.
+var common = map[string]bool{
+	"password": true, "password1": true, "password123": true, "passw0rd": true,
+	"12345678": true, "123456789": true, "1234567890": true, "87654321": true,
+	"qwertyui": true, "qwerty123": true, "qwertyuiop": true, "1q2w3e4r": true,
+	"iloveyou": true, "sunshine": true, "princess": true, "football": true,
+	"baseball": true, "welcome1": true, "letmein1": true, "trustno1": true,
+	"superman": true, "starwars": true, "whatever": true, "asdfghjk": true,
+	"abcd1234": true, "abc12345": true, "11111111": true, "00000000": true,
+	"changeme": true, "admin123": true, "computer": true, "internet": true,
+}
+
+// Validate returns why password can't be used by the account with email, or
+// nil if it can.
+func Validate(password, email string) error {
+	switch {
+	case utf8.RuneCountInString(password) < MinLength:
+		return ErrTooShort
+	case len(password) > MaxLength:
+		return ErrTooLong
+	}
+
+	lower := strings.ToLower(password)
+	if common[lower] || strings.Count(lower, lower[:1]) == len(lower) {
+		return ErrTooCommon
+	}
+	if local, _, _ := strings.Cut(strings.ToLower(email), "@"); len(local) >= 4 && strings.Contains(lower, local) {
+		return ErrContainsEmail
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package password_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/password"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		password string
+		email    string
+		want     error
+	}{
+		{"correct horse battery", "ada@example.com", nil},
+		{"Tr0ub4dor&3", "ada@example.com", nil},
+		{"seven77", "ada@example.com", password.ErrTooShort},
+		// Length is counted in characters, not bytes.
+		{"пароль12", "ada@example.com", nil},
+		{"ключ", "ada@example.com", password.ErrTooShort},
+		{strings.Repeat("ab", 36), "ada@example.com", nil},
+		{strings.Repeat("ab", 36) + "c", "ada@example.com", password.ErrTooLong},
+		{strings.Repeat("я", 37), "ada@example.com", password.ErrTooLong},
+		{"password", "ada@example.com", password.ErrTooCommon},
+		{"PassWord123", "ada@example.com", password.ErrTooCommon},
+		{"aaaaaaaaaa", "ada@example.com", password.ErrTooCommon},
+		{"lovelace-rules", "lovelace@example.com", password.ErrContainsEmail},
+		{"I am LOVELACE!", "Lovelace@example.com", password.ErrContainsEmail},
+		// Local parts under four characters are too short to matter.
+		{"my ada password", "ada@example.com", nil},
+	}
+	for _, tt := range tests {
+		if err := password.Validate(tt.password, tt.email); !errors.Is(err, tt.want) {
+			t.Errorf("Validate(%q, %q) = %v, want %v", tt.password, tt.email, err, tt.want)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package realtime
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
 )
 
 const (
@@ -19,10 +22,70 @@ type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
 	send chan *ServerMessage
+	// userID is empty for clients that joined through a share link; linkID
+	// is set for those, so they can be disconnected when it is revoked.
+	userID string
+	linkID string
+
+	// tokenReadOnly is set for sessions of personal access tokens without
+	// the write-docs scope, which stay read-only whatever their role.
+	tokenReadOnly bool
+
+	// The fields below are owned by the hub goroutine once the client is
+	// registered. role is rechecked periodically for users; readOnly
+	// clients receive updates but their operations are dropped. expiresAt
+	// is when the session's token or share link expires, zero for links
+	// that don't.
+	role      string
+	readOnly  bool
+	expiresAt time.Time
+	closing   bool
+}
+
+// authUpdate carries the outcome of an auth_refresh message to the hub.
+type authUpdate struct {
+	client    *Client
+	expiresAt time.Time
+	err       error
+}
+
+// refreshAuth checks token for an auth_refresh message and passes the
+// result to the hub, which owns the session's expiry.
+func (c *Client) refreshAuth(token string) {
+	update := &authUpdate{client: c}
+	if c.userID == "" {
+		update.err = errors.New("share link sessions have no token to refresh")
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), authTimeout)
+		claims, err := auth.ParseToken(ctx, token)
+		cancel()
+		switch {
+		case err != nil:
+			update.err = errors.New("invalid token")
+		case claims.UserID != c.userID:
+			update.err = errors.New("token belongs to a different user")
+		default:
+			update.expiresAt = sessionExpiry(claims)
+		}
+	}
+
+	select {
+	case c.hub.authUpdates <- update:
+	case <-c.hub.done:
+	}
+}
+
+// close ends the connection with a close frame giving reason. It is safe to
+// call from any goroutine; the read loop then unregisters the client.
+func (c *Client) close(reason string) {
+	closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	c.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(writeWait))
+	c.conn.Close()
 }
 
 func (c *Client) readPump() {
 	defer func() {
+		c.hub.manager.untrackLinkClient(c)
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
@@ -42,6 +105,13 @@ func (c *Client) readPump() {
 			continue
 		}
 
+		if op.Type == OpAuthRefresh {
+			var msg AuthRefreshMessage
+			json.Unmarshal(message, &msg)
+			c.refreshAuth(msg.Token)
+			continue
+		}
+
 		payload := &OpPayload{
 			SourceClient: c,
 			Op:           &op,
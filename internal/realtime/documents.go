@@ -0,0 +1,243 @@
+package realtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+// DocumentService is the part of document-service the realtime service
+// depends on. It is an interface so hubs can run against a fake in tests.
+type DocumentService interface {
+	GetDocument(ctx context.Context, documentID string) (*storage.Document, error)
+	SaveDocument(ctx context.Context, documentID, content string, version int) error
+	// DocumentRole returns userID's role on documentID, or "" for no access.
+	DocumentRole(ctx context.Context, documentID, userID string) (string, error)
+	// OpenShareLink checks a share link token and password and counts a use.
+	// It returns storage.ErrNotFound, ErrLinkPassword or ErrLinkUnavailable
+	// when the link can't be used.
+	OpenShareLink(ctx context.Context, documentID, token, password string) (*storage.ShareLink, error)
+	RecordOpen(ctx context.Context, documentID, userID string) error
+	// VerifyPersonalToken checks a personal access token, so the service
+	// can be passed to auth.SetPersonalTokens.
+	VerifyPersonalToken(ctx context.Context, token string) (*auth.Claims, error)
+}
+
+type httpDocumentService struct {
+	baseURL string
+	client  *http.Client
+	tokens  *auth.ServiceTokenSource
+}
+
+// NewHTTPDocumentService talks to document-service's internal listener at
+// baseURL, authenticating every request with a token from tokens. Requests
+// time out so a stalled document-service can't hold up a hub.
+func NewHTTPDocumentService(baseURL string, tokens *auth.ServiceTokenSource) DocumentService {
+	return &httpDocumentService{baseURL: baseURL, client: &http.Client{Timeout: 10 * time.Second}, tokens: tokens}
+}
+
+// do sends req with a service token. Rejections of the token itself are
+// returned as errors so they aren't mistaken for the route's own 401 and
+// 403 answers.
+func (s *httpDocumentService) do(req *http.Request) (*http.Response, error) {
+	if err := s.tokens.Authorize(req); err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if auth.IsServiceAuthError(resp) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("document service rejected our service token with status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (s *httpDocumentService) GetDocument(ctx context.Context, documentID string) (*storage.Document, error) {
+	url := fmt.Sprintf("%s/internal/documents/%s", s.baseURL, documentID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call document service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("document service returned status %d", resp.StatusCode)
+	}
+
+	var doc storage.Document
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode document from service: %w", err)
+	}
+
+	return &doc, nil
+}
+
+func (s *httpDocumentService) SaveDocument(ctx context.Context, documentID, content string, version int) error {
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"content": content,
+		"version": version,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/internal/documents/%s", s.baseURL, documentID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create save request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("document service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpDocumentService) DocumentRole(ctx context.Context, documentID, userID string) (string, error) {
+	url := fmt.Sprintf("%s/internal/documents/%s/permissions/%s", s.baseURL, documentID, userID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return "", nil
+	default:
+		return "", fmt.Errorf("document service returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode permission from service: %w", err)
+	}
+	return body.Role, nil
+}
+
+func (s *httpDocumentService) OpenShareLink(ctx context.Context, documentID, token, password string) (*storage.ShareLink, error) {
+	jsonData, err := json.Marshal(map[string]string{
+		"token":    token,
+		"password": password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/internal/documents/%s/links/open", s.baseURL, documentID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, storage.ErrNotFound
+	case http.StatusUnauthorized:
+		return nil, storage.ErrLinkPassword
+	case http.StatusForbidden:
+		return nil, storage.ErrLinkUnavailable
+	default:
+		return nil, fmt.Errorf("document service returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		LinkID string `json:"link_id"`
+		Role   string `json:"role"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode share link from service: %w", err)
+	}
+	return &storage.ShareLink{ID: body.LinkID, DocumentID: documentID, Role: body.Role}, nil
+}
+
+func (s *httpDocumentService) RecordOpen(ctx context.Context, documentID, userID string) error {
+	url := fmt.Sprintf("%s/internal/documents/%s/opens/%s", s.baseURL, documentID, userID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("document service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpDocumentService) VerifyPersonalToken(ctx context.Context, token string) (*auth.Claims, error) {
+	jsonData, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/internal/personal-tokens/verify", s.baseURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, storage.ErrInvalidPersonalToken
+	default:
+		return nil, fmt.Errorf("document service returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		ID        string    `json:"id"`
+		UserID    string    `json:"user_id"`
+		Scopes    []string  `json:"scopes"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode personal access token from service: %w", err)
+	}
+	return auth.PersonalTokenClaims(body.ID, body.UserID, body.Scopes, body.ExpiresAt), nil
+}
@@ -0,0 +1,64 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+// The client must only use document-service's /internal routes, which the
+// gateway refuses to proxy.
+func TestHTTPDocumentServiceUsesInternalRoutes(t *testing.T) {
+	var saved string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /internal/documents/{documentID}", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(storage.Document{ID: r.PathValue("documentID"), Content: "hello", Version: 3})
+	})
+	mux.HandleFunc("PUT /internal/documents/{documentID}", func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Content string }
+		json.NewDecoder(r.Body).Decode(&body)
+		saved = body.Content
+	})
+	mux.HandleFunc("GET /internal/documents/{documentID}/permissions/{userID}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("userID") != "user-1" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"role": storage.RoleEditor})
+	})
+	mux.HandleFunc("POST /internal/documents/{documentID}/opens/{userID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tokens, err := auth.NewServiceTokenSource(&config.Config{ServiceTokenSecret: "test-secret"}, "realtime-service", auth.InternalAudience)
+	if err != nil {
+		t.Fatalf("NewServiceTokenSource: %v", err)
+	}
+	docs := NewHTTPDocumentService(server.URL, tokens)
+	ctx := context.Background()
+
+	doc, err := docs.GetDocument(ctx, testDocID)
+	if err != nil || doc.ID != testDocID || doc.Content != "hello" || doc.Version != 3 {
+		t.Fatalf("GetDocument = %+v, %v; want %s at version 3", doc, err, testDocID)
+	}
+	if err := docs.SaveDocument(ctx, testDocID, "hello, world", 4); err != nil || saved != "hello, world" {
+		t.Fatalf("SaveDocument = %v, saved %q", err, saved)
+	}
+	if role, err := docs.DocumentRole(ctx, testDocID, "user-1"); err != nil || role != storage.RoleEditor {
+		t.Fatalf("DocumentRole = %q, %v; want %q", role, err, storage.RoleEditor)
+	}
+	if role, err := docs.DocumentRole(ctx, testDocID, "user-2"); err != nil || role != "" {
+		t.Fatalf("DocumentRole(no access) = %q, %v; want none", role, err)
+	}
+	if err := docs.RecordOpen(ctx, testDocID, "user-1"); err != nil {
+		t.Fatalf("RecordOpen: %v", err)
+	}
+}
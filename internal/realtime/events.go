@@ -0,0 +1,46 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// ConsumeLinkRevocations disconnects share link sessions whenever
+// document-service publishes link.revoked. Every realtime instance binds its
+// own exclusive queue, so each one hears about every revocation.
+func (m *Manager) ConsumeLinkRevocations(ch *amqp091.Channel) error {
+	err := ch.ExchangeDeclare("events", "topic", true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	// A server-named, exclusive queue is deleted when this instance goes away.
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return err
+	}
+	if err := ch.QueueBind(q.Name, "link.revoked", "events", false, nil); err != nil {
+		return err
+	}
+
+	msgs, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for d := range msgs {
+			var event struct {
+				LinkID string `json:"link_id"`
+			}
+			if err := json.Unmarshal(d.Body, &event); err != nil || event.LinkID == "" {
+				log.Printf("WARN: Ignoring malformed link.revoked event: %s", d.Body)
+				continue
+			}
+			m.DisconnectLink(event.LinkID)
+		}
+	}()
+	return nil
+}
@@ -1,15 +1,29 @@
 package realtime
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
-	"net/http"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
 	"github.com/redis/go-redis/v9"
 )
 
+// Timeouts for calls a hub makes outside of any request: cache writes happen
+// on every operation, the final save only once when the hub shuts down.
+const (
+	cacheTimeout = 2 * time.Second
+	saveTimeout  = 10 * time.Second
+	// authTimeout bounds token and permission checks of open sessions.
+	authTimeout = 5 * time.Second
+)
+
+// DefaultAuthCheckInterval is how often hubs close sessions whose token has
+// expired and recheck their users' roles, unless Manager.AuthCheckInterval
+// says otherwise.
+const DefaultAuthCheckInterval = time.Minute
+
 type OpPayload struct {
 	SourceClient *Client
 	Op           *Operation
@@ -21,9 +35,19 @@ type Hub struct {
 	incomingOps chan *OpPayload
 	register    chan *Client
 	unregister  chan *Client
-	manager     *Manager
-	content     string
-	version     int
+	authUpdates chan *authUpdate
+	roleUpdates chan roleUpdate
+	// done is closed when run returns, for goroutines reporting back to it.
+	done    chan struct{}
+	manager *Manager
+	content string
+	version int
+}
+
+// roleUpdate is userID's current role on the hub's document, "" for none.
+type roleUpdate struct {
+	userID string
+	role   string
 }
 
 func newHub(docID, initialContent string, initialVersion int, m *Manager) *Hub {
@@ -36,15 +60,22 @@ func newHub(docID, initialContent string, initialVersion int, m *Manager) *Hub {
 		incomingOps: make(chan *OpPayload),
 		register:    make(chan *Client),
 		unregister:  make(chan *Client),
+		authUpdates: make(chan *authUpdate),
+		roleUpdates: make(chan roleUpdate),
+		done:        make(chan struct{}),
 	}
 }
 
 func (h *Hub) applyOperation(op *Operation) {
 	if op.Type == OpInsert {
-		if op.Pos > len(h.content) { op.Pos = len(h.content) }
+		if op.Pos > len(h.content) {
+			op.Pos = len(h.content)
+		}
 		h.content = h.content[:op.Pos] + op.Text + h.content[op.Pos:]
 	} else if op.Type == OpDelete {
-		if op.Pos+op.Len > len(h.content) { op.Len = len(h.content) - op.Pos }
+		if op.Pos+op.Len > len(h.content) {
+			op.Len = len(h.content) - op.Pos
+		}
 		if op.Pos < len(h.content) {
 			h.content = h.content[:op.Pos] + h.content[op.Pos+op.Len:]
 		}
@@ -61,116 +92,256 @@ func invertOperation(op *Operation) *Operation {
 	return nil
 }
 
-
+// run owns the hub state; each event is handled synchronously by one of the
+// handle* methods so they can also be driven directly, one step at a time.
 func (h *Hub) run() {
+	defer close(h.done)
+
+	interval := h.manager.AuthCheckInterval
+	if interval <= 0 {
+		interval = DefaultAuthCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
-			h.clients[client.ID] = client
-			log.Printf("Client %s registered to hub for document %s", client.ID, h.documentID)
-			initialStateMsg := &ServerMessage{
-				Type:    MsgInitialState,
-				Content: h.content,
-				Version: h.version,
-			}
-			select {
-			case client.send <- initialStateMsg:
-				log.Printf("Sent initial state to client %s", client.ID)
-			default:
-				log.Printf("Failed to send initial state to client %s, unregistering.", client.ID)
-				delete(h.clients, client.ID)
-				close(client.send)
+			h.handleRegister(client)
+		case client := <-h.unregister:
+			if h.handleUnregister(client) {
+				log.Printf("Hub for document %s is shutting down.", h.documentID)
+				return
 			}
+		case payload := <-h.incomingOps:
+			h.handleOperation(payload)
+		case update := <-h.authUpdates:
+			h.handleAuthUpdate(update)
+		case update := <-h.roleUpdates:
+			h.handleRoleUpdate(update)
+		case now := <-ticker.C:
+			h.checkSessions(now)
+		}
+	}
+}
+
+func (h *Hub) handleRegister(client *Client) {
+	h.clients[client.ID] = client
+	log.Printf("Client %s registered to hub for document %s", client.ID, h.documentID)
+	initialStateMsg := &ServerMessage{
+		Type:    MsgInitialState,
+		Content: h.content,
+		Version: h.version,
+	}
+	select {
+	case client.send <- initialStateMsg:
+		log.Printf("Sent initial state to client %s", client.ID)
+	default:
+		log.Printf("Failed to send initial state to client %s, unregistering.", client.ID)
+		delete(h.clients, client.ID)
+		close(client.send)
+	}
+}
 
+// handleUnregister removes client and reports whether the hub became empty,
+// in which case the final state has been persisted and the hub removed.
+func (h *Hub) handleUnregister(client *Client) bool {
+	if _, ok := h.clients[client.ID]; !ok {
+		return false
+	}
+	delete(h.clients, client.ID)
+	close(client.send)
+	log.Printf("Client %s unregistered from hub for document %s", client.ID, h.documentID)
 
-		case client := <-h.unregister:
-			if _, ok := h.clients[client.ID]; ok {
-				delete(h.clients, client.ID)
-				close(client.send)
-				log.Printf("Client %s unregistered from hub for document %s", client.ID, h.documentID)
-
-				if len(h.clients) == 0 {
-					log.Printf("Hub for doc %s is now empty. Saving final state via document-service.", h.documentID)
-					
-					jsonData, err := json.Marshal(map[string]interface{}{
-						"content": h.content,
-						"version": h.version,
-					})
-					if err != nil {
-						log.Printf("CRITICAL: Failed to marshal final state for doc %s: %v", h.documentID, err)
-					} else {
-						url := fmt.Sprintf("%s/documents/%s", h.manager.documentServiceURL, h.documentID)
-						req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(jsonData))
-						if err != nil {
-							log.Printf("CRITICAL: Failed to create save request for doc %s: %v", h.documentID, err)
-						} else {
-							req.Header.Set("Content-Type", "application/json")
-							resp, err := http.DefaultClient.Do(req)
-							if err != nil || resp.StatusCode != http.StatusOK {
-								status := 0
-								if resp != nil {
-									status = resp.StatusCode
-								}
-								log.Printf("CRITICAL: Failed to save final state for doc %s via API: %v (status: %d)", h.documentID, err, status)
-							}
-						}
-					}
-
-					if err := h.manager.Cache.ClearDocumentState(context.Background(), h.documentID); err != nil {
-						log.Printf("WARN: Failed to clear cache for doc %s: %v", h.documentID, err)
-					}
-					
-					h.manager.removeHub(h)
-					log.Printf("Hub for document %s is shutting down.", h.documentID)
-					return
-				}
-			}
+	if len(h.clients) > 0 {
+		return false
+	}
 
-		case payload := <-h.incomingOps:
-			op := payload.Op
-			if op.Type == OpUndo {
-				lastOpData, err := h.manager.Cache.PopOperation(context.Background(), h.documentID)
-				if err != nil {
-					if err == redis.Nil { log.Printf("Undo requested for doc %s, but no operations to undo.", h.documentID) } else { log.Printf("ERROR: Failed to pop operation for undo on doc %s: %v", h.documentID, err) }
-					continue
-				}
-				var lastOp Operation
-				if err := json.Unmarshal(lastOpData, &lastOp); err != nil { log.Printf("ERROR: Failed to unmarshal last op for undo: %v", err); continue }
-				invertedOp := invertOperation(&lastOp)
-				if invertedOp == nil { log.Printf("WARN: Could not invert operation of type %s", lastOp.Type); continue }
-				h.applyOperation(invertedOp)
-				invertedOp.Version = h.version
-				if err := h.manager.Cache.SetDocumentState(context.Background(), h.documentID, h.content, h.version); err != nil { log.Printf("WARN: Failed to save state to cache for doc %s after undo: %v", h.documentID, err) }
-				opMsg := &ServerMessage{Type: MsgOperation, Op: invertedOp}
-				for _, client := range h.clients {
-					select {
-					case client.send <- opMsg:
-					default:
-						close(client.send)
-						delete(h.clients, client.ID)
-					}
-				}
-				continue
-			}
-			if op.Version != h.version { log.Printf("Conflict on doc %s: op version %d, server version %d. Op rejected.", h.documentID, op.Version, h.version); continue }
-			if op.Type == OpDelete {
-				if op.Pos+op.Len <= len(h.content) { op.Text = h.content[op.Pos : op.Pos+op.Len] }
-			}
-			h.applyOperation(op)
-			opBytes, err := json.Marshal(op)
-			if err == nil { h.manager.Cache.PushOperation(context.Background(), h.documentID, opBytes) }
-			if err := h.manager.Cache.SetDocumentState(context.Background(), h.documentID, h.content, h.version); err != nil { log.Printf("WARN: Failed to save state to cache for doc %s: %v", h.documentID, err) }
-			op.Version = h.version
-			opMsg := &ServerMessage{Type: MsgOperation, Op: op}
-			for _, client := range h.clients {
-				if client.ID == payload.SourceClient.ID { continue }
-				select {
-				case client.send <- opMsg:
-				default:
-					close(client.send)
-					delete(h.clients, client.ID)
-				}
+	log.Printf("Hub for doc %s is now empty. Saving final state via document-service.", h.documentID)
+	saveCtx, cancel := context.WithTimeout(context.Background(), saveTimeout)
+	err := h.manager.docs.SaveDocument(saveCtx, h.documentID, h.content, h.version)
+	cancel()
+	if err != nil {
+		log.Printf("CRITICAL: Failed to save final state for doc %s via API: %v", h.documentID, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+	if err := h.manager.Cache.ClearDocumentState(ctx, h.documentID); err != nil {
+		log.Printf("WARN: Failed to clear cache for doc %s: %v", h.documentID, err)
+	}
+
+	h.manager.removeHub(h)
+	return true
+}
+
+func (h *Hub) handleOperation(payload *OpPayload) {
+	if payload.SourceClient.readOnly {
+		log.Printf("Dropping operation from read-only client %s", payload.SourceClient.ID)
+		return
+	}
+	op := payload.Op
+	if op.Type == OpUndo {
+		h.handleUndo()
+		return
+	}
+	if op.Version != h.version {
+		log.Printf("Conflict on doc %s: op version %d, server version %d. Op rejected.", h.documentID, op.Version, h.version)
+		return
+	}
+	if op.Type == OpDelete {
+		if op.Pos+op.Len <= len(h.content) {
+			op.Text = h.content[op.Pos : op.Pos+op.Len]
+		}
+	}
+	h.applyOperation(op)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+	opBytes, err := json.Marshal(op)
+	if err == nil {
+		h.manager.Cache.PushOperation(ctx, h.documentID, opBytes)
+	}
+	if err := h.manager.Cache.SetDocumentState(ctx, h.documentID, h.content, h.version); err != nil {
+		log.Printf("WARN: Failed to save state to cache for doc %s: %v", h.documentID, err)
+	}
+	op.Version = h.version
+	h.broadcast(&ServerMessage{Type: MsgOperation, Op: op}, payload.SourceClient)
+}
+
+func (h *Hub) handleUndo() {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+
+	lastOpData, err := h.manager.Cache.PopOperation(ctx, h.documentID)
+	if err != nil {
+		if err == redis.Nil {
+			log.Printf("Undo requested for doc %s, but no operations to undo.", h.documentID)
+		} else {
+			log.Printf("ERROR: Failed to pop operation for undo on doc %s: %v", h.documentID, err)
+		}
+		return
+	}
+	var lastOp Operation
+	if err := json.Unmarshal(lastOpData, &lastOp); err != nil {
+		log.Printf("ERROR: Failed to unmarshal last op for undo: %v", err)
+		return
+	}
+	invertedOp := invertOperation(&lastOp)
+	if invertedOp == nil {
+		log.Printf("WARN: Could not invert operation of type %s", lastOp.Type)
+		return
+	}
+	h.applyOperation(invertedOp)
+	invertedOp.Version = h.version
+	if err := h.manager.Cache.SetDocumentState(ctx, h.documentID, h.content, h.version); err != nil {
+		log.Printf("WARN: Failed to save state to cache for doc %s after undo: %v", h.documentID, err)
+	}
+	// Undo is sent to everyone, including the client that requested it.
+	h.broadcast(&ServerMessage{Type: MsgOperation, Op: invertedOp}, nil)
+}
+
+// broadcast sends msg to every client except skip, dropping clients whose
+// send buffer is full.
+func (h *Hub) broadcast(msg *ServerMessage, skip *Client) {
+	for _, client := range h.clients {
+		if skip != nil && client.ID == skip.ID {
+			continue
+		}
+		h.send(client, msg)
+	}
+}
+
+// send queues msg for client, dropping the client if its buffer is full.
+func (h *Hub) send(client *Client, msg *ServerMessage) {
+	select {
+	case client.send <- msg:
+	default:
+		close(client.send)
+		delete(h.clients, client.ID)
+	}
+}
+
+func (h *Hub) handleAuthUpdate(update *authUpdate) {
+	client, ok := h.clients[update.client.ID]
+	if !ok {
+		return
+	}
+	if update.err != nil {
+		h.send(client, &ServerMessage{Type: MsgAuthError, Error: update.err.Error()})
+		return
+	}
+	client.expiresAt = update.expiresAt
+	h.send(client, &ServerMessage{Type: MsgAuthRefreshed, ExpiresAt: &update.expiresAt})
+}
+
+// checkSessions closes sessions whose token expired without an auth_refresh
+// or whose share link expired, and starts a recheck of every connected
+// user's role.
+func (h *Hub) checkSessions(now time.Time) {
+	seen := make(map[string]bool)
+	var userIDs []string
+	for _, client := range h.clients {
+		if client.closing {
+			continue
+		}
+		if !client.expiresAt.IsZero() && !now.Before(client.expiresAt) {
+			reason := "token expired"
+			if client.linkID != "" {
+				reason = "share link expired"
 			}
+			log.Printf("Closing client %s on doc %s: %s", client.ID, h.documentID, reason)
+			client.closing = true
+			go client.close(reason)
+			continue
+		}
+		if client.userID != "" && !seen[client.userID] {
+			seen[client.userID] = true
+			userIDs = append(userIDs, client.userID)
+		}
+	}
+	if len(userIDs) > 0 {
+		go h.checkRoles(userIDs)
+	}
+}
+
+// checkRoles asks document-service for each user's role and reports it to
+// the hub. It runs outside the hub goroutine so edits aren't held up; users
+// whose check fails keep their current role until the next one.
+func (h *Hub) checkRoles(userIDs []string) {
+	for _, userID := range userIDs {
+		ctx, cancel := context.WithTimeout(context.Background(), authTimeout)
+		role, err := h.manager.docs.DocumentRole(ctx, h.documentID, userID)
+		cancel()
+		if err != nil {
+			log.Printf("WARN: Failed to recheck role of user %s on doc %s: %v", userID, h.documentID, err)
+			continue
+		}
+		select {
+		case h.roleUpdates <- roleUpdate{userID: userID, role: role}:
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// handleRoleUpdate disconnects the user's sessions if they lost access, or
+// applies a changed role to them.
+func (h *Hub) handleRoleUpdate(update roleUpdate) {
+	for _, client := range h.clients {
+		if client.userID != update.userID || client.closing {
+			continue
+		}
+		if update.role == "" {
+			log.Printf("Closing client %s on doc %s: access revoked", client.ID, h.documentID)
+			client.closing = true
+			go client.close("access revoked")
+			continue
+		}
+		if update.role != client.role {
+			client.role = update.role
+			client.readOnly = client.tokenReadOnly || !storage.RoleAtLeast(update.role, storage.RoleEditor)
+			h.send(client, &ServerMessage{Type: MsgRoleChanged, Role: update.role})
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,242 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+const testDocID = "doc-1"
+
+// fakeDocumentService records the final saves of hubs and opens link,
+// if set, with linkPassword.
+type fakeDocumentService struct {
+	saved   string
+	version int
+	saves   int
+
+	link         *storage.ShareLink
+	linkPassword string
+}
+
+func (f *fakeDocumentService) GetDocument(ctx context.Context, documentID string) (*storage.Document, error) {
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeDocumentService) SaveDocument(ctx context.Context, documentID, content string, version int) error {
+	f.saved, f.version = content, version
+	f.saves++
+	return nil
+}
+
+func (f *fakeDocumentService) DocumentRole(ctx context.Context, documentID, userID string) (string, error) {
+	return storage.RoleEditor, nil
+}
+
+func (f *fakeDocumentService) OpenShareLink(ctx context.Context, documentID, token, password string) (*storage.ShareLink, error) {
+	if f.link == nil || token != f.link.Token || documentID != f.link.DocumentID {
+		return nil, storage.ErrNotFound
+	}
+	if password != f.linkPassword {
+		return nil, storage.ErrLinkPassword
+	}
+	return f.link, nil
+}
+
+func (f *fakeDocumentService) RecordOpen(ctx context.Context, documentID, userID string) error {
+	return nil
+}
+
+func (f *fakeDocumentService) VerifyPersonalToken(ctx context.Context, token string) (*auth.Claims, error) {
+	return nil, errors.New("not supported")
+}
+
+// newTestHub returns a hub for testDocID holding content at version 1,
+// registered with its manager as getOrCreateHub would.
+func newTestHub(t *testing.T, content string) (*Hub, *fakeDocumentService) {
+	t.Helper()
+	docs := &fakeDocumentService{}
+	m := NewManager(storage.NewMemoryCache(0), docs)
+	h := newHub(testDocID, content, 1, m)
+	m.hubs[testDocID] = h
+	return h, docs
+}
+
+func newTestClient(id string) *Client {
+	return &Client{ID: id, userID: "user-" + id, role: storage.RoleEditor, send: make(chan *ServerMessage, 8)}
+}
+
+// receive returns the next message queued for c, failing if there is none.
+func receive(t *testing.T, c *Client) *ServerMessage {
+	t.Helper()
+	select {
+	case msg, ok := <-c.send:
+		if !ok {
+			t.Fatalf("client %s: send channel closed", c.ID)
+		}
+		return msg
+	default:
+		t.Fatalf("client %s: no message queued", c.ID)
+		return nil
+	}
+}
+
+func expectNothing(t *testing.T, c *Client) {
+	t.Helper()
+	select {
+	case msg := <-c.send:
+		t.Fatalf("client %s: unexpected message %+v", c.ID, msg)
+	default:
+	}
+}
+
+func TestHubRegisterSendsInitialState(t *testing.T) {
+	h, _ := newTestHub(t, "hello")
+	alice := newTestClient("alice")
+
+	h.handleRegister(alice)
+
+	msg := receive(t, alice)
+	if msg.Type != MsgInitialState || msg.Content != "hello" || msg.Version != 1 {
+		t.Fatalf("initial state = %+v, want hello at version 1", msg)
+	}
+	if h.clients[alice.ID] != alice {
+		t.Fatal("client not registered")
+	}
+}
+
+func TestHubOperationAppliesAndBroadcasts(t *testing.T) {
+	h, _ := newTestHub(t, "hello")
+	alice, bob := newTestClient("alice"), newTestClient("bob")
+	h.handleRegister(alice)
+	h.handleRegister(bob)
+	receive(t, alice)
+	receive(t, bob)
+
+	h.handleOperation(&OpPayload{SourceClient: alice, Op: &Operation{Type: OpInsert, Pos: 5, Text: " world", Version: 1}})
+
+	if h.content != "hello world" || h.version != 2 {
+		t.Fatalf("hub = %q at version %d, want %q at version 2", h.content, h.version, "hello world")
+	}
+	msg := receive(t, bob)
+	if msg.Type != MsgOperation || msg.Op.Text != " world" || msg.Op.Version != 2 {
+		t.Fatalf("broadcast = %+v, want the insert at version 2", msg)
+	}
+	expectNothing(t, alice)
+
+	content, version, err := h.manager.Cache.GetDocumentState(context.Background(), testDocID)
+	if err != nil || content != "hello world" || version != 2 {
+		t.Fatalf("cached state = %q, %d, %v; want the new content at version 2", content, version, err)
+	}
+}
+
+func TestHubOperationRejectsStaleVersion(t *testing.T) {
+	h, _ := newTestHub(t, "hello")
+	alice, bob := newTestClient("alice"), newTestClient("bob")
+	h.handleRegister(alice)
+	h.handleRegister(bob)
+	receive(t, alice)
+	receive(t, bob)
+
+	h.handleOperation(&OpPayload{SourceClient: alice, Op: &Operation{Type: OpDelete, Pos: 0, Len: 1, Version: 0}})
+
+	if h.content != "hello" || h.version != 1 {
+		t.Fatalf("hub = %q at version %d, want it unchanged", h.content, h.version)
+	}
+	expectNothing(t, bob)
+}
+
+func TestHubOperationDropsReadOnlyClients(t *testing.T) {
+	h, _ := newTestHub(t, "hello")
+	viewer, bob := newTestClient("viewer"), newTestClient("bob")
+	viewer.role, viewer.readOnly = storage.RoleViewer, true
+	h.handleRegister(viewer)
+	h.handleRegister(bob)
+	receive(t, viewer)
+	receive(t, bob)
+
+	h.handleOperation(&OpPayload{SourceClient: viewer, Op: &Operation{Type: OpInsert, Pos: 0, Text: "x", Version: 1}})
+
+	if h.content != "hello" || h.version != 1 {
+		t.Fatalf("hub = %q at version %d, want it unchanged", h.content, h.version)
+	}
+	expectNothing(t, bob)
+}
+
+func TestHubUndo(t *testing.T) {
+	h, _ := newTestHub(t, "hello")
+	alice, bob := newTestClient("alice"), newTestClient("bob")
+	h.handleRegister(alice)
+	h.handleRegister(bob)
+	receive(t, alice)
+	receive(t, bob)
+
+	h.handleOperation(&OpPayload{SourceClient: alice, Op: &Operation{Type: OpDelete, Pos: 0, Len: 1, Version: 1}})
+	receive(t, bob)
+	if h.content != "ello" {
+		t.Fatalf("content after delete = %q, want %q", h.content, "ello")
+	}
+
+	h.handleOperation(&OpPayload{SourceClient: alice, Op: &Operation{Type: OpUndo}})
+
+	if h.content != "hello" || h.version != 3 {
+		t.Fatalf("hub after undo = %q at version %d, want %q at version 3", h.content, h.version, "hello")
+	}
+	// The undo goes to everyone, including the client that asked for it.
+	for _, c := range []*Client{alice, bob} {
+		msg := receive(t, c)
+		if msg.Op.Type != OpInsert || msg.Op.Text != "h" || msg.Op.Version != 3 {
+			t.Fatalf("client %s got %+v, want the re-insert at version 3", c.ID, msg.Op)
+		}
+	}
+
+	// Nothing is left to undo.
+	h.handleUndo()
+	if h.content != "hello" || h.version != 3 {
+		t.Fatalf("hub after empty undo = %q at version %d, want it unchanged", h.content, h.version)
+	}
+	if _, err := h.manager.Cache.PopOperation(context.Background(), testDocID); !errors.Is(err, redis.Nil) {
+		t.Fatalf("PopOperation = %v, want redis.Nil", err)
+	}
+}
+
+func TestHubUnregister(t *testing.T) {
+	h, docs := newTestHub(t, "hello")
+	alice, bob := newTestClient("alice"), newTestClient("bob")
+	h.handleRegister(alice)
+	h.handleRegister(bob)
+	receive(t, alice)
+	receive(t, bob)
+	h.handleOperation(&OpPayload{SourceClient: alice, Op: &Operation{Type: OpInsert, Pos: 5, Text: "!", Version: 1}})
+	receive(t, bob)
+
+	if h.handleUnregister(alice) {
+		t.Fatal("hub reported empty with a client left")
+	}
+	if _, ok := <-alice.send; ok {
+		t.Fatal("unregistered client's send channel is still open")
+	}
+	if docs.saves != 0 {
+		t.Fatal("document saved while clients are connected")
+	}
+	if h.handleUnregister(alice) {
+		t.Fatal("unregistering twice emptied the hub")
+	}
+
+	if !h.handleUnregister(bob) {
+		t.Fatal("hub not reported empty after its last client left")
+	}
+	if docs.saves != 1 || docs.saved != "hello!" || docs.version != 2 {
+		t.Fatalf("saved %q at version %d (%d saves), want %q at version 2 once", docs.saved, docs.version, docs.saves, "hello!")
+	}
+	if _, _, err := h.manager.Cache.GetDocumentState(context.Background(), testDocID); !errors.Is(err, redis.Nil) {
+		t.Fatalf("cached state after shutdown: %v, want redis.Nil", err)
+	}
+	if _, ok := h.manager.hubs[testDocID]; ok {
+		t.Fatal("hub still registered with the manager")
+	}
+}
@@ -2,12 +2,14 @@ package realtime
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -55,41 +57,32 @@ var upgrader = websocket.Upgrader{
 }
 
 type Manager struct {
-	hubs               map[string]*Hub
-	mu                 sync.RWMutex
-	Cache              storage.Cache
-	documentServiceURL string
-}
+	hubs  map[string]*Hub
+	mu    sync.RWMutex
+	Cache storage.Cache
+	docs  DocumentService
+	// AllowQueryToken lets clients connect with a JWT in the token query
+	// parameter, which ends up in proxy access logs. Tickets avoid that.
+	AllowQueryToken bool
+	// AuthCheckInterval is how often hubs drop expired sessions and recheck
+	// roles; zero means DefaultAuthCheckInterval.
+	AuthCheckInterval time.Duration
 
-func NewManager(cache storage.Cache, docServiceURL string) *Manager {
-	return &Manager{
-		hubs:               make(map[string]*Hub),
-		Cache:              cache,
-		documentServiceURL: docServiceURL,
-	}
+	// linkClients maps share link IDs to the clients connected through them.
+	linkClients map[string]map[*Client]struct{}
+	linkMu      sync.Mutex
 }
 
-func (m *Manager) getDocumentFromService(documentID string) (*storage.Document, error) {
-	url := fmt.Sprintf("%s/documents/%s", m.documentServiceURL, documentID)
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to call document service: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("document service returned status %d", resp.StatusCode)
-	}
-
-	var doc storage.Document
-	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
-		return nil, fmt.Errorf("failed to decode document from service: %w", err)
+func NewManager(cache storage.Cache, docs DocumentService) *Manager {
+	return &Manager{
+		hubs:        make(map[string]*Hub),
+		Cache:       cache,
+		docs:        docs,
+		linkClients: make(map[string]map[*Client]struct{}),
 	}
-
-	return &doc, nil
 }
 
-func (m *Manager) getOrCreateHub(documentID string) (*Hub, error) {
+func (m *Manager) getOrCreateHub(ctx context.Context, documentID string) (*Hub, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -97,19 +90,19 @@ func (m *Manager) getOrCreateHub(documentID string) (*Hub, error) {
 		return hub, nil
 	}
 
-	content, version, err := m.Cache.GetDocumentState(context.Background(), documentID)
+	content, version, err := m.Cache.GetDocumentState(ctx, documentID)
 	if err == nil {
 		log.Printf("Cache hit for doc %s. Re-creating hub from Redis state.", documentID)
 	} else if err == redis.Nil {
 		log.Printf("Cache miss for doc %s. Loading from PostgreSQL.", documentID)
-		doc, err := m.getDocumentFromService(documentID)
+		doc, err := m.docs.GetDocument(ctx, documentID)
 		if err != nil {
 			return nil, err
 		}
 		content = doc.Content
 		version = doc.Version
 
-		if err := m.Cache.SetDocumentState(context.Background(), documentID, content, version); err != nil {
+		if err := m.Cache.SetDocumentState(ctx, documentID, content, version); err != nil {
 			log.Printf("WARN: Failed to prime cache for doc %s: %v", documentID, err)
 		}
 	} else {
@@ -133,73 +126,170 @@ func (m *Manager) removeHub(hub *Hub) {
 	}
 }
 
+// ServeWS upgrades a connection to the document's hub. Clients authenticate
+// with a ticket from CreateTicket or CreateLinkTicket, in the ticket query
+// parameter or the Sec-WebSocket-Protocol header, or with a share link in
+// link (plus the X-Share-Password header if the link has one). A user JWT in
+// the token query parameter is accepted only while AllowQueryToken is set.
+// Clients whose role is below editor join read-only. User sessions end when
+// their token expires unless the client sends an auth_refresh message first,
+// and when a periodic recheck finds the user has lost access. Share link
+// sessions end when the link expires or is revoked.
 func (m *Manager) ServeWS(w http.ResponseWriter, r *http.Request) {
-	// Extract token from query parameter
-	token := r.URL.Query().Get("token")
-	if token == "" {
-		http.Error(w, "Bad Request: Token query parameter is required", http.StatusBadRequest)
-		return
-	}
-
-	// Validate token and get user ID
-	userID, err := auth.ValidateTokenFromQuery(token)
-	if err != nil {
-		log.Printf("Token validation failed: %v", err)
-		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
-		return
-	}
-
 	documentID := chi.URLParam(r, "documentID")
 	if documentID == "" {
 		http.Error(w, "Bad Request: Document ID is required", http.StatusBadRequest)
 		return
 	}
 
-	hasPermission, err := m.checkPermissions(documentID, userID)
-	if err != nil {
-		log.Printf("Error calling document-service for permissions: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	var userID, linkID, role string
+	var expiresAt time.Time
+	// tokenReadOnly is set for personal access tokens without write-docs.
+	var tokenReadOnly bool
+	var responseHeader http.Header
+	if value, viaProtocol := ticketFromRequest(r); value != "" {
+		t, err := m.redeemTicket(r.Context(), documentID, value)
+		if err != nil {
+			if errors.Is(err, errInvalidTicket) {
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			log.Printf("Error redeeming connection ticket: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if t.LinkID != "" {
+			linkID, role, expiresAt = t.LinkID, t.Role, t.ExpiresAt
+		} else {
+			userID, expiresAt, tokenReadOnly = t.UserID, t.ExpiresAt, t.ReadOnly
+		}
+		if viaProtocol && slices.Contains(websocket.Subprotocols(r), TicketProtocol) {
+			responseHeader = http.Header{"Sec-Websocket-Protocol": {TicketProtocol}}
+		}
+	} else if linkToken := r.URL.Query().Get("link"); linkToken != "" {
+		link, err := m.docs.OpenShareLink(r.Context(), documentID, linkToken, r.Header.Get(SharePasswordHeader))
+		if !checkShareLink(w, err) {
+			return
+		}
+		linkID, role, expiresAt = link.ID, link.Role, linkExpiry(link)
+	} else if token := r.URL.Query().Get("token"); token != "" && m.AllowQueryToken {
+		claims, err := auth.ParseToken(r.Context(), token)
+		if err != nil {
+			log.Printf("Token validation failed: %v", err)
+			http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+			return
+		}
+		if !claims.HasScope(auth.ScopeReadDocs) {
+			http.Error(w, "Forbidden: token lacks the "+auth.ScopeReadDocs+" scope", http.StatusForbidden)
+			return
+		}
+		userID, expiresAt = claims.UserID, sessionExpiry(claims)
+		tokenReadOnly = !claims.HasScope(auth.ScopeWriteDocs)
+	} else {
+		http.Error(w, "Bad Request: ticket or link query parameter is required", http.StatusBadRequest)
 		return
 	}
 
-	if !hasPermission {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
+	if linkID == "" {
+		var err error
+		role, err = m.docs.DocumentRole(r.Context(), documentID, userID)
+		if err != nil {
+			log.Printf("Error calling document-service for permissions: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if role == "" {
+			// Point the client at the way to ask the owner for access.
+			http.Error(w, fmt.Sprintf("Forbidden: request access with POST /documents/%s/access-requests", documentID), http.StatusForbidden)
+			return
+		}
 	}
 
-	hub, err := m.getOrCreateHub(documentID)
+	hub, err := m.getOrCreateHub(r.Context(), documentID)
 	if err != nil {
 		http.Error(w, "Document not found or internal error", http.StatusNotFound)
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Println("Failed to upgrade connection:", err)
 		return
 	}
 
 	client := &Client{
-		ID:   uuid.NewString(),
-		hub:  hub,
-		conn: conn,
-		send: make(chan *ServerMessage, 256),
+		ID:            uuid.NewString(),
+		hub:           hub,
+		conn:          conn,
+		send:          make(chan *ServerMessage, 256),
+		userID:        userID,
+		linkID:        linkID,
+		role:          role,
+		readOnly:      tokenReadOnly || !storage.RoleAtLeast(role, storage.RoleEditor),
+		expiresAt:     expiresAt,
+		tokenReadOnly: tokenReadOnly,
 	}
+	m.trackLinkClient(client)
 	client.hub.register <- client
 
 	go client.writePump()
 	go client.readPump()
 
+	if linkID != "" {
+		log.Printf("Client %s (share link %s, %s) connected to hub for document %s", client.ID, linkID, role, documentID)
+		return
+	}
+
 	log.Printf("Client %s (for user %s) connected to hub for document %s", client.ID, userID, documentID)
+
+	if err := m.docs.RecordOpen(r.Context(), documentID, userID); err != nil {
+		log.Printf("WARN: Failed to record open of doc %s by user %s: %v", documentID, userID, err)
+	}
 }
 
-func (m *Manager) checkPermissions(documentID, userID string) (bool, error) {
-	url := fmt.Sprintf("%s/documents/%s/permissions/%s", m.documentServiceURL, documentID, userID)
-	resp, err := http.Get(url)
-	if err != nil {
-		return false, err
+func (m *Manager) trackLinkClient(c *Client) {
+	if c.linkID == "" {
+		return
 	}
-	defer resp.Body.Close()
+	m.linkMu.Lock()
+	defer m.linkMu.Unlock()
 
-	return resp.StatusCode == http.StatusOK, nil
+	clients, ok := m.linkClients[c.linkID]
+	if !ok {
+		clients = make(map[*Client]struct{})
+		m.linkClients[c.linkID] = clients
+	}
+	clients[c] = struct{}{}
+}
+
+func (m *Manager) untrackLinkClient(c *Client) {
+	if c.linkID == "" {
+		return
+	}
+	m.linkMu.Lock()
+	defer m.linkMu.Unlock()
+
+	delete(m.linkClients[c.linkID], c)
+	if len(m.linkClients[c.linkID]) == 0 {
+		delete(m.linkClients, c.linkID)
+	}
+}
+
+// DisconnectLink closes every connection made through share link linkID.
+// Closing the socket ends the client's read loop, which unregisters it from
+// its hub as for any other disconnect.
+func (m *Manager) DisconnectLink(linkID string) {
+	m.linkMu.Lock()
+	clients := make([]*Client, 0, len(m.linkClients[linkID]))
+	for c := range m.linkClients[linkID] {
+		clients = append(clients, c)
+	}
+	m.linkMu.Unlock()
+
+	for _, c := range clients {
+		c.close("share link revoked")
+	}
+	if len(clients) > 0 {
+		log.Printf("Disconnected %d client(s) of revoked share link %s", len(clients), linkID)
+	}
 }
@@ -1,5 +1,7 @@
 package realtime
 
+import "time"
+
 type OpType string
 
 type ServerMessageType string
@@ -7,22 +9,41 @@ type ServerMessageType string
 const (
 	MsgInitialState ServerMessageType = "initial_state"
 	MsgOperation    ServerMessageType = "operation"
+	// MsgAuthRefreshed and MsgAuthError answer an auth_refresh message.
+	MsgAuthRefreshed ServerMessageType = "auth_refreshed"
+	MsgAuthError     ServerMessageType = "auth_error"
+	// MsgRoleChanged tells a client its role on the document changed while
+	// it was connected; below editor its operations are ignored.
+	MsgRoleChanged ServerMessageType = "role_changed"
 )
 
 // wrapper for all messages sent to clients.
 type ServerMessage struct {
-	Type    ServerMessageType `json:"type"`
-	Content string            `json:"content,omitempty"` // For initial state
-	Version int               `json:"version,omitempty"` // <<< ADD THIS LINE
-	Op      *Operation        `json:"op,omitempty"`      // For operations
+	Type      ServerMessageType `json:"type"`
+	Content   string            `json:"content,omitempty"`    // For initial state
+	Version   int               `json:"version,omitempty"`    // <<< ADD THIS LINE
+	Op        *Operation        `json:"op,omitempty"`         // For operations
+	Role      string            `json:"role,omitempty"`       // For role changes
+	ExpiresAt *time.Time        `json:"expires_at,omitempty"` // For auth_refreshed
+	Error     string            `json:"error,omitempty"`      // For auth_error
 }
 
 const (
 	OpInsert OpType = "insert"
 	OpDelete OpType = "delete"
 	OpUndo   OpType = "undo"
+	// OpAuthRefresh is not an edit: the message is an AuthRefreshMessage
+	// replacing the token the session was authenticated with.
+	OpAuthRefresh OpType = "auth_refresh"
 )
 
+// AuthRefreshMessage lets a client present a fresh access token for the
+// same user before its current one expires, keeping the session open.
+type AuthRefreshMessage struct {
+	Type  OpType `json:"type"`
+	Token string `json:"token"`
+}
+
 type Operation struct {
 	Type    OpType `json:"type"`
 	Pos     int    `json:"pos"`     // Position of the change
@@ -0,0 +1,211 @@
+package realtime
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/auth"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// TicketTTL is how long a connection ticket can be redeemed after it is
+// issued.
+const TicketTTL = 30 * time.Second
+
+// Browsers can't set headers on a WebSocket handshake, so besides the ticket
+// query parameter a ticket can be sent as the subprotocol "ticket.<ticket>",
+// offered together with TicketProtocol, which the server then selects.
+const (
+	TicketProtocol       = "ticket"
+	ticketProtocolPrefix = TicketProtocol + "."
+)
+
+// Share link tokens and passwords are sent in these headers, as to
+// document-service. Passwords are never read from the URL.
+const (
+	ShareTokenHeader    = "X-Share-Token"
+	SharePasswordHeader = "X-Share-Password"
+)
+
+var errInvalidTicket = errors.New("ticket is invalid, expired, already used or for another document")
+
+type CreateTicketRequest struct {
+	DocumentID string `json:"document_id"`
+}
+
+type TicketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// ticket is what a connection ticket is stored as. ExpiresAt is the expiry
+// of the token or share link it was issued for, which the session inherits.
+// ReadOnly is set for personal access tokens without the write-docs scope.
+// Tickets issued for a share link carry its LinkID and Role instead of a
+// user.
+type ticket struct {
+	UserID     string    `json:"user_id,omitempty"`
+	DocumentID string    `json:"document_id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	ReadOnly   bool      `json:"read_only,omitempty"`
+	LinkID     string    `json:"link_id,omitempty"`
+	Role       string    `json:"role,omitempty"`
+}
+
+// sessionExpiry is when a session opened with claims must be refreshed.
+// Personal access tokens last for months, so their sessions are cut to an
+// access token's lifetime and refreshed with the token again, which notices
+// when it was revoked.
+func sessionExpiry(claims *auth.Claims) time.Time {
+	expiresAt := claims.ExpiresAt.Time
+	if limit := time.Now().Add(auth.AccessTokenTTL()); claims.Personal && limit.Before(expiresAt) {
+		return limit
+	}
+	return expiresAt
+}
+
+// linkExpiry is when a session opened through link must end, zero if the
+// link doesn't expire.
+func linkExpiry(link *storage.ShareLink) time.Time {
+	if link.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return *link.ExpiresAt
+}
+
+// CreateTicket issues a single-use ticket that lets the authenticated user
+// open a WebSocket to one document within TicketTTL, so the JWT itself never
+// appears in a URL. Access to the document is checked when the ticket is
+// redeemed.
+func (m *Manager) CreateTicket(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(auth.ClaimsKey).(*auth.Claims)
+	if !ok {
+		http.Error(w, "Could not get token claims from context", http.StatusInternalServerError)
+		return
+	}
+
+	var req CreateTicketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DocumentID == "" {
+		http.Error(w, "document_id is required", http.StatusBadRequest)
+		return
+	}
+
+	m.issueTicket(w, r, ticket{
+		UserID:     claims.UserID,
+		DocumentID: req.DocumentID,
+		ExpiresAt:  sessionExpiry(claims),
+		ReadOnly:   !claims.HasScope(auth.ScopeWriteDocs),
+	})
+}
+
+// CreateLinkTicket issues a ticket for a share link sent in the
+// X-Share-Token and X-Share-Password headers, which browsers can't add to
+// the WebSocket handshake. The link is opened, and its password checked,
+// when the ticket is issued; revoking it or its expiry still closes the
+// connection.
+func (m *Manager) CreateLinkTicket(w http.ResponseWriter, r *http.Request) {
+	var req CreateTicketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DocumentID == "" {
+		http.Error(w, "document_id is required", http.StatusBadRequest)
+		return
+	}
+	token := r.Header.Get(ShareTokenHeader)
+	if token == "" {
+		http.Error(w, ShareTokenHeader+" header is required", http.StatusUnauthorized)
+		return
+	}
+
+	link, err := m.docs.OpenShareLink(r.Context(), req.DocumentID, token, r.Header.Get(SharePasswordHeader))
+	if !checkShareLink(w, err) {
+		return
+	}
+	m.issueTicket(w, r, ticket{
+		DocumentID: req.DocumentID,
+		ExpiresAt:  linkExpiry(link),
+		LinkID:     link.ID,
+		Role:       link.Role,
+	})
+}
+
+// issueTicket stores t under a new random ticket and writes it to w.
+func (m *Manager) issueTicket(w http.ResponseWriter, r *http.Request, t ticket) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Could not create ticket", http.StatusInternalServerError)
+		return
+	}
+	value := base64.RawURLEncoding.EncodeToString(buf)
+
+	data, _ := json.Marshal(t)
+	if err := m.Cache.PutTicket(r.Context(), value, data, TicketTTL); err != nil {
+		http.Error(w, "Could not create ticket", http.StatusInternalServerError)
+		log.Printf("Error storing connection ticket: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(TicketResponse{Ticket: value, ExpiresIn: int(TicketTTL.Seconds())})
+}
+
+// checkShareLink reports whether err from OpenShareLink is nil, and
+// otherwise writes the matching error response.
+func checkShareLink(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrLinkPassword):
+		http.Error(w, "Unauthorized: Invalid share link", http.StatusUnauthorized)
+	case errors.Is(err, storage.ErrLinkUnavailable):
+		http.Error(w, "Forbidden: Share link is no longer valid", http.StatusForbidden)
+	default:
+		log.Printf("Error calling document-service for share link: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// redeemTicket consumes value and returns it if it was issued for
+// documentID.
+func (m *Manager) redeemTicket(ctx context.Context, documentID, value string) (*ticket, error) {
+	data, err := m.Cache.TakeTicket(ctx, value)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, errInvalidTicket
+		}
+		return nil, err
+	}
+
+	var t ticket
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	if t.DocumentID != documentID {
+		return nil, errInvalidTicket
+	}
+	return &t, nil
+}
+
+// ticketFromRequest returns the ticket from the ticket query parameter or
+// the Sec-WebSocket-Protocol header, and whether it came from the header.
+func ticketFromRequest(r *http.Request) (string, bool) {
+	if value := r.URL.Query().Get("ticket"); value != "" {
+		return value, false
+	}
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(protocol), ticketProtocolPrefix); ok && value != "" {
+				return value, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,146 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+)
+
+func postLinkTicket(m *Manager, token, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/realtime/link-tickets", strings.NewReader(`{"document_id":"`+testDocID+`"}`))
+	req.Header.Set(ShareTokenHeader, token)
+	if password != "" {
+		req.Header.Set(SharePasswordHeader, password)
+	}
+	rec := httptest.NewRecorder()
+	m.CreateLinkTicket(rec, req)
+	return rec
+}
+
+func TestCreateLinkTicket(t *testing.T) {
+	docs := &fakeDocumentService{
+		link:         &storage.ShareLink{ID: "link-1", DocumentID: testDocID, Role: storage.RoleViewer, Token: "secret-token"},
+		linkPassword: "hunter2",
+	}
+	m := NewManager(storage.NewMemoryCache(0), docs)
+
+	if rec := postLinkTicket(m, "secret-token", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status %d, want 401", rec.Code)
+	}
+	if rec := postLinkTicket(m, "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("no link: status %d, want 401", rec.Code)
+	}
+
+	rec := postLinkTicket(m, "secret-token", "hunter2")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+	}
+	var resp TicketResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Ticket == "" {
+		t.Fatalf("decoding ticket: %v", err)
+	}
+
+	tk, err := m.redeemTicket(context.Background(), testDocID, resp.Ticket)
+	if err != nil {
+		t.Fatalf("redeemTicket: %v", err)
+	}
+	if tk.LinkID != "link-1" || tk.Role != storage.RoleViewer || tk.UserID != "" {
+		t.Fatalf("ticket = %+v, want link-1 as viewer", tk)
+	}
+	if _, err := m.redeemTicket(context.Background(), testDocID, resp.Ticket); !errors.Is(err, errInvalidTicket) {
+		t.Fatalf("second redeem: %v, want errInvalidTicket", err)
+	}
+}
+
+func TestServeWSRejectsPasswordInQuery(t *testing.T) {
+	docs := &fakeDocumentService{
+		link:         &storage.ShareLink{ID: "link-1", DocumentID: testDocID, Role: storage.RoleViewer, Token: "secret-token"},
+		linkPassword: "hunter2",
+	}
+	m := NewManager(storage.NewMemoryCache(0), docs)
+
+	req := httptest.NewRequest(http.MethodGet, "/ws/doc/"+testDocID+"?link=secret-token&password=hunter2", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("documentID", testDocID)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rec := httptest.NewRecorder()
+	m.ServeWS(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", rec.Code)
+	}
+}
+
+func TestCreateLinkTicketCarriesLinkExpiry(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	docs := &fakeDocumentService{
+		link: &storage.ShareLink{ID: "link-1", DocumentID: testDocID, Role: storage.RoleViewer, Token: "secret-token", ExpiresAt: &expiresAt},
+	}
+	m := NewManager(storage.NewMemoryCache(0), docs)
+
+	rec := postLinkTicket(m, "secret-token", "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+	}
+	var resp TicketResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding ticket: %v", err)
+	}
+	tk, err := m.redeemTicket(context.Background(), testDocID, resp.Ticket)
+	if err != nil {
+		t.Fatalf("redeemTicket: %v", err)
+	}
+	if !tk.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("ticket expires at %v, want the link's expiry %v", tk.ExpiresAt, expiresAt)
+	}
+}
+
+// A session opened through a share link is closed once the link expires,
+// without waiting for it to be revoked.
+func TestServeWSClosesExpiredLinkSession(t *testing.T) {
+	expiresAt := time.Now().Add(200 * time.Millisecond)
+	docs := &fakeDocumentService{
+		link: &storage.ShareLink{ID: "link-1", DocumentID: testDocID, Role: storage.RoleViewer, Token: "secret-token", ExpiresAt: &expiresAt},
+	}
+	m := NewManager(storage.NewMemoryCache(0), docs)
+	m.AuthCheckInterval = 20 * time.Millisecond
+	if err := m.Cache.SetDocumentState(context.Background(), testDocID, "hello", 1); err != nil {
+		t.Fatalf("SetDocumentState: %v", err)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/ws/doc/{documentID}", m.ServeWS)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/doc/" + testDocID + "?link=secret-token"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Text != "share link expired" {
+			t.Fatalf("read error = %v, want close for expired share link", err)
+		}
+		break
+	}
+	if time.Now().Before(expiresAt) {
+		t.Fatal("session closed before the link expired")
+	}
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrAlreadyHasAccess is returned when a user asks for a role they already
+// have, or a lesser one.
+var ErrAlreadyHasAccess = errors.New("user already has this role or higher")
+
+// Access request states. Only pending requests can be listed or decided.
+const (
+	AccessRequestPending  = "pending"
+	AccessRequestApproved = "approved"
+	AccessRequestDenied   = "denied"
+)
+
+// AccessRequest is a user asking a document's owner for Role on it.
+type AccessRequest struct {
+	ID         string     `json:"id"`
+	DocumentID string     `json:"document_id"`
+	UserID     string     `json:"user_id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	Message    string     `json:"message,omitempty"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	DecidedAt  *time.Time `json:"decided_at,omitempty"`
+}
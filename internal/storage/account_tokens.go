@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// Purposes of account tokens, the single-use tokens mailed to a user to
+// prove they control their email address. TokenLoginChallenge is not mailed:
+// it is handed out by a password login that still needs a second factor.
+const (
+	TokenVerifyEmail    = "verify_email"
+	TokenResetPassword  = "reset_password"
+	TokenLoginChallenge = "login_challenge"
+)
+
+// ErrInvalidAccountToken is returned for account tokens that don't exist,
+// have expired, were already used or were issued for another purpose or an
+// email address the account no longer has.
+var ErrInvalidAccountToken = errors.New("token is invalid, expired or already used")
+
+// accountTokenState is what consuming an account token needs to know about
+// it.
+type accountTokenState struct {
+	userID    string
+	purpose   string
+	email     string
+	expiresAt time.Time
+	used      bool
+}
+
+// check returns ErrInvalidAccountToken unless a token in this state can be
+// consumed for purpose at now.
+func (st accountTokenState) check(purpose string, now time.Time) error {
+	if st.used || st.purpose != purpose || !now.Before(st.expiresAt) {
+		return ErrInvalidAccountToken
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/migrate"
+	"github.com/pasanAbeysekara/collaborative-editor/migrations"
+	"github.com/redis/go-redis/v9"
+)
+
+// Storage backends selectable through STORAGE_BACKEND.
+const (
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+	BackendMemory   = "memory"
+)
+
+// Cache backends selectable through CACHE_BACKEND.
+const (
+	CacheBackendRedis  = "redis"
+	CacheBackendMemory = "memory"
+)
+
+// withTimeout bounds ctx by d, unless d is zero.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// NewStore builds the Store selected by cfg.StorageBackend. The returned
+// function releases the backend's resources and must be called on shutdown.
+func NewStore(ctx context.Context, cfg *config.Config) (Store, func(), error) {
+	switch cfg.StorageBackend {
+	case BackendPostgres, "":
+		if cfg.DatabaseURL == "" {
+			return nil, nil, fmt.Errorf("DATABASE_URL is required for the %s storage backend", BackendPostgres)
+		}
+		pool, err := pgxpool.New(ctx, cfg.DatabaseURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to connect to database: %w", err)
+		}
+		if cfg.MigrateOnStartup {
+			if err := migratePostgres(ctx, pool); err != nil {
+				pool.Close()
+				return nil, nil, err
+			}
+		}
+		return NewPostgresStore(pool, cfg.DBQueryTimeout), pool.Close, nil
+	case BackendSQLite:
+		store, err := NewSQLiteStore(cfg.SQLitePath, cfg.DBQueryTimeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open sqlite database %s: %w", cfg.SQLitePath, err)
+		}
+		return store, func() { store.Close() }, nil
+	case BackendMemory:
+		return NewMemoryStore(), func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+}
+
+func migratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	migrator, err := migrate.New(migrate.NewPostgresDriver(pool), migrations.Postgres, ".")
+	if err != nil {
+		return err
+	}
+	migrator.Logf = log.Printf
+	if _, err := migrator.Up(ctx); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return nil
+}
+
+// NewMigrator returns a Migrator for the SQL backend selected by cfg, for the
+// migrate subcommand. The returned function closes the database connection.
+func NewMigrator(ctx context.Context, cfg *config.Config) (*migrate.Migrator, func(), error) {
+	var (
+		migrator *migrate.Migrator
+		closeDB  func()
+		err      error
+	)
+	switch cfg.StorageBackend {
+	case BackendPostgres, "":
+		if cfg.DatabaseURL == "" {
+			return nil, nil, fmt.Errorf("DATABASE_URL is required for the %s storage backend", BackendPostgres)
+		}
+		pool, perr := pgxpool.New(ctx, cfg.DatabaseURL)
+		if perr != nil {
+			return nil, nil, fmt.Errorf("unable to connect to database: %w", perr)
+		}
+		closeDB = pool.Close
+		migrator, err = migrate.New(migrate.NewPostgresDriver(pool), migrations.Postgres, ".")
+	case BackendSQLite:
+		db, derr := openSQLite(cfg.SQLitePath)
+		if derr != nil {
+			return nil, nil, derr
+		}
+		closeDB = func() { db.Close() }
+		migrator, err = migrate.New(migrate.NewSQLiteDriver(db), migrations.SQLite, "sqlite")
+	default:
+		return nil, nil, fmt.Errorf("storage backend %q has no schema to migrate", cfg.StorageBackend)
+	}
+	if err != nil {
+		closeDB()
+		return nil, nil, err
+	}
+	return migrator, closeDB, nil
+}
+
+// NewCache builds the Cache selected by cfg.CacheBackend. CACHE_TTL only
+// applies to the memory backend.
+func NewCache(cfg *config.Config) (Cache, error) {
+	switch cfg.CacheBackend {
+	case CacheBackendRedis, "":
+		if cfg.RedisURL == "" {
+			return nil, fmt.Errorf("REDIS_URL is required for the %s cache backend", CacheBackendRedis)
+		}
+		redisOpts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		return NewRedisCache(redis.NewClient(redisOpts)), nil
+	case CacheBackendMemory:
+		return NewMemoryCache(cfg.CacheTTL), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.CacheBackend)
+	}
+}
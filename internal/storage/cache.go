@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"strconv"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -13,6 +14,12 @@ type Cache interface {
 	ClearDocumentState(ctx context.Context, docID string) error
 	PushOperation(ctx context.Context, docID string, opData []byte) error
 	PopOperation(ctx context.Context, docID string) ([]byte, error)
+	// PutTicket stores data under ticket until ttl passes. TakeTicket returns
+	// and deletes it in one step, so each ticket is redeemed at most once.
+	PutTicket(ctx context.Context, ticket string, data []byte, ttl time.Duration) error
+	TakeTicket(ctx context.Context, ticket string) ([]byte, error)
+	// Close releases the cache's connection or background work.
+	Close() error
 }
 
 type RedisCache struct {
@@ -64,4 +71,16 @@ func (c *RedisCache) PushOperation(ctx context.Context, docID string, opData []b
 func (c *RedisCache) PopOperation(ctx context.Context, docID string) ([]byte, error) {
 	key := "doc_ops:" + docID
 	return c.client.LPop(ctx, key).Bytes()
-}
\ No newline at end of file
+}
+
+func (c *RedisCache) PutTicket(ctx context.Context, ticket string, data []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, "ws_ticket:"+ticket, data, ttl).Err()
+}
+
+func (c *RedisCache) TakeTicket(ctx context.Context, ticket string) ([]byte, error) {
+	return c.client.GetDel(ctx, "ws_ticket:"+ticket).Bytes()
+}
+
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrPermissionDenied is returned when the caller's role on a folder,
+// workspace or document is too low for the requested change.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Workspace is a shared space whose members can see every folder in it.
+// Role is the caller's role: RoleOwner for its owner, otherwise the member role.
+type Workspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	OwnerID   string    `json:"owner_id"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Folder groups documents and other folders. Top-level folders have no
+// ParentID; folders in a workspace carry its ID, inherited from their parent.
+// Role is the caller's effective role on the folder.
+type Folder struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	OwnerID     string    `json:"owner_id"`
+	ParentID    string    `json:"parent_id,omitempty"`
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// roleOrder lists roles from least to most privileged. A user's effective
+// role on a document is the highest one granted on the document itself
+// (directly or through a team), on any folder above it, or through that
+// folder's workspace.
+var roleOrder = []string{RoleViewer, RoleCommenter, RoleEditor, RoleOwner}
+
+// RoleAtLeast reports whether role grants at least as much as min.
+func RoleAtLeast(role, min string) bool {
+	return role != "" && roleRank(role) >= roleRank(min)
+}
+
+// roleRank returns the position of role in roleOrder, counting from 1, or 0
+// for no role.
+func roleRank(role string) int {
+	for i, r := range roleOrder {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// rankRole is the inverse of roleRank.
+func rankRole(rank int) string {
+	if rank < 1 || rank > len(roleOrder) {
+		return ""
+	}
+	return roleOrder[rank-1]
+}
+
+// folderRole is the role reported on a folder: its owner sees RoleOwner even
+// though ownership only grants RoleEditor on the folder's contents.
+func folderRole(f *Folder, userID string, rank int) string {
+	if f.OwnerID == userID {
+		return RoleOwner
+	}
+	return rankRole(rank)
+}
+
+// roleRankSQL is roleRank as a SQL expression over column.
+func roleRankSQL(column string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CASE %s", column)
+	for i, r := range roleOrder {
+		fmt.Fprintf(&b, " WHEN '%s' THEN %d", r, i+1)
+	}
+	b.WriteString(" ELSE 0 END")
+	return b.String()
+}
+
+// rankRoleSQL is rankRole as a SQL expression over expr.
+func rankRoleSQL(expr string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CASE %s", expr)
+	for i, r := range roleOrder {
+		fmt.Fprintf(&b, " WHEN %d THEN '%s'", i+1, r)
+	}
+	b.WriteString(" END")
+	return b.String()
+}
+
+// folderAccessCTE returns a WITH RECURSIVE clause defining
+// folder_access(folder_id, role_rank): every folder the user bound to the
+// placeholder user can see, with the best rank granted to them by folder
+// ownership, a folder share or workspace membership, inherited downwards.
+// The SQL is shared by the Postgres and SQLite stores.
+func folderAccessCTE(user string) string {
+	editor := roleRank(RoleEditor)
+	return fmt.Sprintf(`
+		WITH RECURSIVE folder_grants(folder_id, role_rank) AS (
+			SELECT id, %[2]d FROM folders WHERE owner_id = %[1]s
+			UNION
+			SELECT folder_id, %[3]s FROM folder_permissions WHERE user_id = %[1]s
+			UNION
+			SELECT f.id, %[2]d FROM folders f JOIN workspaces w ON w.id = f.workspace_id WHERE w.owner_id = %[1]s
+			UNION
+			SELECT f.id, %[4]s FROM folders f JOIN workspace_members wm ON wm.workspace_id = f.workspace_id WHERE wm.user_id = %[1]s
+			UNION
+			SELECT f.id, g.role_rank FROM folders f JOIN folder_grants g ON f.parent_id = g.folder_id
+		),
+		folder_access(folder_id, role_rank) AS (
+			SELECT folder_id, MAX(role_rank) FROM folder_grants GROUP BY folder_id
+		)`, user, editor, roleRankSQL("role"), roleRankSQL("wm.role"))
+}
+
+// documentAccessCTE extends folderAccessCTE with
+// document_access(document_id, role_rank) for every document the user can
+// open, combining ownership, direct and team shares, and folder access.
+func documentAccessCTE(user string) string {
+	return folderAccessCTE(user) + fmt.Sprintf(`,
+		document_access(document_id, role_rank) AS (
+			SELECT document_id, MAX(role_rank) FROM (
+				SELECT id AS document_id, %[2]d AS role_rank FROM documents WHERE owner_id = %[1]s
+				UNION ALL
+				SELECT document_id, %[3]s FROM document_permissions WHERE user_id = %[1]s
+				UNION ALL
+				SELECT tdp.document_id, %[4]s FROM team_document_permissions tdp
+					JOIN team_members tm ON tm.team_id = tdp.team_id WHERE tm.user_id = %[1]s
+				UNION ALL
+				SELECT d.id, fa.role_rank FROM documents d JOIN folder_access fa ON fa.folder_id = d.folder_id
+			) grants GROUP BY document_id
+		)`, user, roleRank(RoleOwner), roleRankSQL("role"), roleRankSQL("tdp.role"))
+}
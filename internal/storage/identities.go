@@ -0,0 +1,13 @@
+package storage
+
+import "errors"
+
+// ErrIdentityLinked is returned when an external identity is already linked
+// to an account.
+var ErrIdentityLinked = errors.New("identity is already linked to an account")
+
+// identityKey is how MemoryStore indexes identities; issuers are URLs and
+// never contain a NUL.
+func identityKey(issuer, subject string) string {
+	return issuer + "\x00" + subject
+}
@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"strings"
+	"time"
+)
+
+// Invitation is a share with someone who has no account yet. It turns into a
+// document_permissions row when a user registers with Email.
+type Invitation struct {
+	ID         string    `json:"id"`
+	DocumentID string    `json:"document_id"`
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	InvitedBy  string    `json:"invited_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// invitationEmail is the form invitations are stored and matched in, so an
+// invite still reaches someone who registers with different capitalisation.
+func invitationEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrLinkUnavailable is returned when a share link has been revoked, has
+	// expired or has no uses left.
+	ErrLinkUnavailable = errors.New("share link is revoked, expired or used up")
+	// ErrLinkPassword is returned when a password-protected share link is
+	// opened without its password.
+	ErrLinkPassword = errors.New("share link password is missing or wrong")
+)
+
+// ShareLink grants Role on a document to anyone holding its token, without
+// an account. Only a hash of the token is stored; Token is set once, in the
+// link returned by CreateShareLink.
+type ShareLink struct {
+	ID           string     `json:"id"`
+	DocumentID   string     `json:"document_id"`
+	Role         string     `json:"role"`
+	Token        string     `json:"token,omitempty"`
+	PasswordHash string     `json:"-"`
+	HasPassword  bool       `json:"has_password"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	// MaxUses limits how many times the link can be opened; 0 means no limit.
+	MaxUses   int        `json:"max_uses,omitempty"`
+	Uses      int        `json:"uses"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// ShareLinkOptions are the settings of a new share link. Password is stored
+// hashed; an empty Password means the token alone is enough.
+type ShareLinkOptions struct {
+	Role      string
+	ExpiresAt *time.Time
+	Password  string
+	MaxUses   int
+}
+
+// Usable reports whether the link can still be opened at now. Stores check
+// the same conditions again when the use is counted.
+func (l *ShareLink) Usable(now time.Time) bool {
+	if l.RevokedAt != nil {
+		return false
+	}
+	if l.ExpiresAt != nil && !now.Before(*l.ExpiresAt) {
+		return false
+	}
+	return l.MaxUses == 0 || l.Uses < l.MaxUses
+}
+
+// CheckPassword reports whether password unlocks the link.
+func (l *ShareLink) CheckPassword(password string) bool {
+	if l.PasswordHash == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(l.PasswordHash), []byte(password)) == nil
+}
+
+// newShareLink fills in the token, its hash and the password hash for a link
+// on documentID created by ownerID.
+func newShareLink(documentID, ownerID string, opts ShareLinkOptions) (*ShareLink, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+	link := &ShareLink{
+		DocumentID: documentID,
+		Role:       opts.Role,
+		Token:      token,
+		ExpiresAt:  opts.ExpiresAt,
+		MaxUses:    opts.MaxUses,
+		CreatedBy:  ownerID,
+	}
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, "", err
+		}
+		link.PasswordHash = string(hash)
+		link.HasPassword = true
+	}
+	return link, hashToken(link.Token), nil
+}
+
+// newToken returns 32 random bytes, base64url encoded, for share links,
+// refresh tokens and account tokens.
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken is what share links, refresh tokens and account tokens are stored
+// and looked up by.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
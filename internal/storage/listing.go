@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Sort keys accepted by ListDocuments.
+const (
+	SortTitle      = "title"
+	SortCreated    = "created"
+	SortUpdated    = "updated"
+	SortLastOpened = "last_opened"
+)
+
+// Ownership filters accepted by ListDocuments.
+const (
+	OwnershipAll    = ""
+	OwnershipOwned  = "owned"
+	OwnershipShared = "shared"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 200
+)
+
+// ErrInvalidCursor is returned when a cursor can't be decoded or was issued
+// for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// DocumentListOptions controls ListDocuments. The zero value lists every
+// accessible document by title, DefaultListLimit at a time, without content.
+type DocumentListOptions struct {
+	Sort       string
+	Descending bool
+	Ownership  string
+	// Role keeps only documents where the caller has this role (RoleOwner included).
+	Role string
+	// FolderID keeps only documents filed directly in this folder.
+	FolderID string
+	// Tags keeps only documents carrying every one of these normalized tags.
+	Tags []string
+	// FavoritesOnly keeps only documents the caller has starred.
+	FavoritesOnly  bool
+	Cursor         string
+	Limit          int
+	IncludeContent bool
+}
+
+// DocumentSummary is a document as seen by one user in a listing.
+type DocumentSummary struct {
+	ID           string     `json:"id"`
+	Title        string     `json:"title"`
+	OwnerID      string     `json:"owner_id"`
+	Role         string     `json:"role"`
+	FolderID     string     `json:"folder_id,omitempty"`
+	Favorite     bool       `json:"favorite"`
+	Tags         []string   `json:"tags,omitempty"`
+	Version      int        `json:"version"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	LastOpenedAt *time.Time `json:"last_opened_at,omitempty"`
+	Content      string     `json:"content,omitempty"`
+}
+
+// DocumentPage is one page of ListDocuments. NextCursor is empty on the last page.
+type DocumentPage struct {
+	Documents  []*DocumentSummary `json:"documents"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+// ValidSort reports whether sort is a known sort key (empty means SortTitle).
+func ValidSort(sort string) bool {
+	switch sort {
+	case "", SortTitle, SortCreated, SortUpdated, SortLastOpened:
+		return true
+	}
+	return false
+}
+
+// normalize fills in defaults so every backend interprets options identically.
+func (o DocumentListOptions) normalize() DocumentListOptions {
+	if o.Sort == "" {
+		o.Sort = SortTitle
+	}
+	if o.Limit <= 0 {
+		o.Limit = DefaultListLimit
+	}
+	if o.Limit > MaxListLimit {
+		o.Limit = MaxListLimit
+	}
+	return o
+}
+
+// listCursor is the position after the last document of a page: its sort key
+// (in a backend-specific, order-preserving string form) and its ID.
+type listCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d,omitempty"`
+	Key  string `json:"k"`
+	ID   string `json:"i"`
+}
+
+func encodeCursor(c listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns nil for an empty cursor.
+func decodeCursor(raw string, opts DocumentListOptions) (*listCursor, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != opts.Sort || c.Desc != opts.Descending {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// cursorTimeLayout is fixed width so formatted UTC times sort lexically.
+const cursorTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+func formatCursorTime(t time.Time) string {
+	return t.UTC().Format(cursorTimeLayout)
+}
+
+func parseCursorTime(s string) (time.Time, error) {
+	t, err := time.Parse(cursorTimeLayout, s)
+	if err != nil {
+		return time.Time{}, ErrInvalidCursor
+	}
+	return t, nil
+}
+
+// sortKey returns the cursor key of d for sort, for backends that compare keys in Go.
+func (d *DocumentSummary) sortKey(sort string) string {
+	switch sort {
+	case SortCreated:
+		return formatCursorTime(d.CreatedAt)
+	case SortUpdated:
+		return formatCursorTime(d.UpdatedAt)
+	case SortLastOpened:
+		if d.LastOpenedAt == nil {
+			return formatCursorTime(time.Unix(0, 0))
+		}
+		return formatCursorTime(*d.LastOpenedAt)
+	default:
+		return d.Title
+	}
+}
+
+// pageOf turns up to opts.Limit+1 ordered summaries into a page, using the
+// extra summary only to detect that another page follows.
+func pageOf(summaries []*DocumentSummary, opts DocumentListOptions) *DocumentPage {
+	page := &DocumentPage{Documents: summaries}
+	if page.Documents == nil {
+		page.Documents = []*DocumentSummary{}
+	}
+	if len(summaries) > opts.Limit {
+		page.Documents = summaries[:opts.Limit]
+		last := page.Documents[opts.Limit-1]
+		page.NextCursor = encodeCursor(listCursor{
+			Sort: opts.Sort,
+			Desc: opts.Descending,
+			Key:  last.sortKey(opts.Sort),
+			ID:   last.ID,
+		})
+	}
+	return page
+}
@@ -1,41 +1,177 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID           string
-	Email        string
-	PasswordHash string
+	ID            string
+	Email         string
+	PasswordHash  string
+	EmailVerified bool
+	TOTPEnabled   bool
+	DisplayName   string
+	AvatarURL     string
+	Locale        string
+	Timezone      string
 }
 
 type Document struct {
-	ID      string
-	Title   string
-	OwnerID string
-	Content string
-	Version int
+	ID        string
+	Title     string
+	OwnerID   string
+	Content   string
+	Version   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
+// MemoryStore is a process-local Store. Data is lost on restart and is not
+// shared between services, so it is meant for local development and tests.
 type MemoryStore struct {
 	mu        sync.RWMutex
 	users     map[string]User
 	documents map[string]Document
+	// permissions maps document ID -> user ID -> role, mirroring document_permissions.
+	permissions map[string]map[string]string
+	// opens maps document ID -> user ID -> last time the user opened it.
+	opens  map[string]map[string]time.Time
+	search SearchIndex
+
+	workspaces map[string]Workspace
+	// workspaceMembers maps workspace ID -> user ID -> role.
+	workspaceMembers map[string]map[string]string
+	folders          map[string]Folder
+	// folderPermissions maps folder ID -> user ID -> role, like permissions.
+	folderPermissions map[string]map[string]string
+	// documentFolders maps document ID -> folder ID for filed documents.
+	documentFolders map[string]string
+	// tags maps document ID -> set of tags.
+	tags map[string]map[string]bool
+	// favorites maps document ID -> set of user IDs who starred it.
+	favorites map[string]map[string]bool
+
+	teams map[string]Team
+	// teamMembers maps team ID -> user ID -> membership. Email is filled in
+	// from users when members are listed.
+	teamMembers map[string]map[string]TeamMember
+	// teamPermissions maps document ID -> team ID -> role.
+	teamPermissions map[string]map[string]string
+
+	// invitations maps invitation ID -> pending invitation.
+	invitations map[string]Invitation
+
+	// accessRequests maps request ID -> access request, without Email.
+	accessRequests map[string]AccessRequest
+
+	// links maps link ID -> share link, without its token; linkTokens maps
+	// token hashes to link IDs.
+	links      map[string]ShareLink
+	linkTokens map[string]string
+
+	// refreshTokens maps token hashes to refresh tokens, without Token;
+	// revokedSessions holds the session IDs that were logged out.
+	refreshTokens   map[string]memoryRefreshToken
+	revokedSessions map[string]bool
+
+	// accountTokens maps token hashes to account tokens.
+	accountTokens map[string]accountTokenState
+
+	// personalTokens maps token hashes to personal access tokens, without
+	// Token.
+	personalTokens map[string]PersonalToken
+
+	// identities maps identityKey(issuer, subject) -> user ID.
+	identities map[string]string
+
+	// totp maps user ID -> two-factor setup; recoveryCodes maps user ID ->
+	// recovery code hash -> whether it was used.
+	totp          map[string]TOTP
+	recoveryCodes map[string]map[string]bool
+
+	// adoptUsers is set by AdoptUnknownUsers.
+	adoptUsers bool
+}
+
+type memoryRefreshToken struct {
+	RefreshToken
+	used bool
 }
 
 func NewMemoryStore() *MemoryStore {
+	return NewMemoryStoreWithSearchIndex(NewMemorySearchIndex())
+}
+
+// NewMemoryStoreWithSearchIndex creates a MemoryStore that keeps index up to
+// date and serves SearchDocuments from it.
+func NewMemoryStoreWithSearchIndex(index SearchIndex) *MemoryStore {
 	return &MemoryStore{
-		users:     make(map[string]User),
-		documents: make(map[string]Document),
+		users:       make(map[string]User),
+		documents:   make(map[string]Document),
+		permissions: make(map[string]map[string]string),
+		opens:       make(map[string]map[string]time.Time),
+		search:      index,
+
+		workspaces:        make(map[string]Workspace),
+		workspaceMembers:  make(map[string]map[string]string),
+		folders:           make(map[string]Folder),
+		folderPermissions: make(map[string]map[string]string),
+		documentFolders:   make(map[string]string),
+		tags:              make(map[string]map[string]bool),
+		favorites:         make(map[string]map[string]bool),
+		teams:             make(map[string]Team),
+		teamMembers:       make(map[string]map[string]TeamMember),
+		teamPermissions:   make(map[string]map[string]string),
+		invitations:       make(map[string]Invitation),
+		accessRequests:    make(map[string]AccessRequest),
+		links:             make(map[string]ShareLink),
+		linkTokens:        make(map[string]string),
+		refreshTokens:     make(map[string]memoryRefreshToken),
+		revokedSessions:   make(map[string]bool),
+		accountTokens:     make(map[string]accountTokenState),
+		personalTokens:    make(map[string]PersonalToken),
+		identities:        make(map[string]string),
+		totp:              make(map[string]TOTP),
+		recoveryCodes:     make(map[string]map[string]bool),
 	}
 }
 
-func (s *MemoryStore) CreateUser(email, password string) (*User, error) {
+// AdoptUnknownUsers makes the store create a placeholder account the first
+// time someone it hasn't seen creates a document, workspace or folder or
+// requests access. document-service uses it on the memory backend, where
+// accounts are registered in user-service's own store; the IDs it acts for
+// come from verified access tokens.
+func (s *MemoryStore) AdoptUnknownUsers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.adoptUsers = true
+}
+
+// actorExists reports whether userID, who is acting on the store, has an
+// account, creating a placeholder for them if AdoptUnknownUsers is set.
+// Callers hold s.mu.
+func (s *MemoryStore) actorExists(userID string) bool {
+	if _, exists := s.users[userID]; exists {
+		return true
+	}
+	if !s.adoptUsers || userID == "" {
+		return false
+	}
+	// Like the SQL backends' anonymised accounts, the placeholder's email
+	// can't collide with a real address.
+	s.users[userID] = User{ID: userID, Email: "placeholder:" + userID}
+	return true
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, email, password string) (*User, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -59,7 +195,7 @@ func (s *MemoryStore) CreateUser(email, password string) (*User, error) {
 	return &user, nil
 }
 
-func (s *MemoryStore) GetUserByEmail(email string) (*User, error) {
+func (s *MemoryStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -68,50 +204,1802 @@ func (s *MemoryStore) GetUserByEmail(email string) (*User, error) {
 			return &user, nil
 		}
 	}
-	return nil, fmt.Errorf("user not found")
+	return nil, ErrNotFound
 }
 
-func (s *MemoryStore) GetUserByID(id string) (*User, error) {
+func (s *MemoryStore) GetUserByID(ctx context.Context, id string) (*User, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	user, exists := s.users[id]
 	if !exists {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrNotFound
 	}
 	return &user, nil
 }
 
-func (s *MemoryStore) CreateDocument(title, ownerID string) (*Document, error) {
+func (s *MemoryStore) UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) (*User, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	doc := Document{
-		ID:      uuid.NewString(),
-		Title:   title,
-		OwnerID: ownerID,
+	user, exists := s.users[userID]
+	if !exists {
+		return nil, ErrNotFound
 	}
-	s.documents[doc.ID] = doc
+	update.apply(&user)
+	s.users[userID] = user
+	return &user, nil
+}
 
-	return &doc, nil
+func (s *MemoryStore) ChangePassword(ctx context.Context, userID, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("could not hash password: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return ErrNotFound
+	}
+	user.PasswordHash = string(hashedPassword)
+	s.users[userID] = user
+
+	for _, rt := range s.refreshTokens {
+		if rt.UserID == userID {
+			s.revokedSessions[rt.SessionID] = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ChangeEmail(ctx context.Context, userID, email string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	for _, u := range s.users {
+		if u.Email == email && u.ID != userID {
+			return nil, ErrEmailTaken
+		}
+	}
+	user.Email = email
+	user.EmailVerified = false
+	s.users[userID] = user
+	return &user, nil
+}
+
+// collaboratorsLocked reports whether heir may be given what userID owns:
+// whether they have access to one of its documents or folders, or share a
+// team or workspace with it. s.mu must be held.
+func (s *MemoryStore) collaboratorsLocked(userID, heir string) bool {
+	for id, doc := range s.documents {
+		if _, shared := s.permissions[id][heir]; shared && doc.OwnerID == userID {
+			return true
+		}
+	}
+	for id, folder := range s.folders {
+		if _, shared := s.folderPermissions[id][heir]; shared && folder.OwnerID == userID {
+			return true
+		}
+	}
+	for _, members := range s.teamMembers {
+		_, leaver := members[userID]
+		_, member := members[heir]
+		if leaver && member {
+			return true
+		}
+	}
+	for id, ws := range s.workspaces {
+		_, leaver := s.workspaceMembers[id][userID]
+		_, member := s.workspaceMembers[id][heir]
+		if (leaver || ws.OwnerID == userID) && (member || ws.OwnerID == heir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) DeleteUser(ctx context.Context, userID string, opts DeleteUserOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !ValidOwnedDocumentsPolicy(opts.Documents) {
+		return fmt.Errorf("unknown owned documents policy %q", opts.Documents)
+	}
+	if _, exists := s.users[userID]; !exists {
+		return ErrNotFound
+	}
+	to := opts.TransferTo
+	if opts.Documents == OwnedDocumentsTransfer {
+		if _, exists := s.users[to]; !exists || to == userID || !s.collaboratorsLocked(userID, to) {
+			return ErrInvalidTransferTarget
+		}
+	}
+
+	// Folders in other people's workspaces stay there, with the workspace
+	// owner, whatever the policy.
+	for id, folder := range s.folders {
+		ws, inWorkspace := s.workspaces[folder.WorkspaceID]
+		if folder.OwnerID == userID && inWorkspace && ws.OwnerID != userID {
+			folder.OwnerID = ws.OwnerID
+			s.folders[id] = folder
+		}
+	}
+
+	switch opts.Documents {
+	case OwnedDocumentsTransfer:
+		for id, doc := range s.documents {
+			if doc.OwnerID == userID {
+				doc.OwnerID = to
+				s.documents[id] = doc
+				delete(s.permissions[id], to)
+			}
+		}
+		for id, req := range s.accessRequests {
+			if req.UserID == to && s.documents[req.DocumentID].OwnerID == to {
+				delete(s.accessRequests, id)
+			}
+		}
+		for id, link := range s.links {
+			if link.CreatedBy == userID {
+				link.CreatedBy = to
+				s.links[id] = link
+			}
+		}
+		for id, inv := range s.invitations {
+			if inv.InvitedBy == userID {
+				inv.InvitedBy = to
+				s.invitations[id] = inv
+			}
+		}
+		for id, ws := range s.workspaces {
+			if ws.OwnerID == userID {
+				ws.OwnerID = to
+				s.workspaces[id] = ws
+				delete(s.workspaceMembers[id], to)
+			}
+		}
+		for id, folder := range s.folders {
+			if folder.OwnerID == userID {
+				folder.OwnerID = to
+				s.folders[id] = folder
+				delete(s.folderPermissions[id], to)
+			}
+		}
+	case OwnedDocumentsDelete:
+		for id, doc := range s.documents {
+			if doc.OwnerID == userID {
+				s.deleteDocumentLocked(id)
+			}
+		}
+		for id, ws := range s.workspaces {
+			if ws.OwnerID == userID {
+				s.deleteWorkspaceLocked(id)
+			}
+		}
+		for id, folder := range s.folders {
+			if folder.OwnerID == userID {
+				s.deleteFolderLocked(id)
+			}
+		}
+	case OwnedDocumentsOrphan:
+		for id, link := range s.links {
+			if s.documents[link.DocumentID].OwnerID == userID {
+				s.deleteLinkLocked(id)
+			}
+		}
+		for id, inv := range s.invitations {
+			if s.documents[inv.DocumentID].OwnerID == userID {
+				delete(s.invitations, id)
+			}
+		}
+		for id, req := range s.accessRequests {
+			if s.documents[req.DocumentID].OwnerID == userID {
+				delete(s.accessRequests, id)
+			}
+		}
+	}
+
+	for teamID, members := range s.teamMembers {
+		member, isMember := members[userID]
+		if !isMember {
+			continue
+		}
+		delete(members, userID)
+		if len(members) == 0 {
+			s.deleteTeamLocked(teamID)
+			continue
+		}
+		if member.Role == TeamRoleOwner {
+			var successor *TeamMember
+			for _, m := range members {
+				m := m
+				if successor == nil || teamSuccessorLess(m, *successor) {
+					successor = &m
+				}
+			}
+			successor.Role = TeamRoleOwner
+			members[successor.UserID] = *successor
+		}
+	}
+
+	for _, byUser := range s.permissions {
+		delete(byUser, userID)
+	}
+	for _, byUser := range s.opens {
+		delete(byUser, userID)
+	}
+	for _, byUser := range s.favorites {
+		delete(byUser, userID)
+	}
+	for _, byUser := range s.folderPermissions {
+		delete(byUser, userID)
+	}
+	for _, byUser := range s.workspaceMembers {
+		delete(byUser, userID)
+	}
+	for id, req := range s.accessRequests {
+		if req.UserID == userID {
+			delete(s.accessRequests, id)
+		}
+	}
+	for hash, rt := range s.refreshTokens {
+		if rt.UserID == userID {
+			delete(s.refreshTokens, hash)
+		}
+	}
+	for hash, st := range s.accountTokens {
+		if st.userID == userID {
+			delete(s.accountTokens, hash)
+		}
+	}
+	for hash, pt := range s.personalTokens {
+		if pt.UserID == userID {
+			delete(s.personalTokens, hash)
+		}
+	}
+	for key, id := range s.identities {
+		if id == userID {
+			delete(s.identities, key)
+		}
+	}
+	delete(s.totp, userID)
+	delete(s.recoveryCodes, userID)
+	// Orphaned documents keep pointing at the deleted owner, like the
+	// anonymised users row the SQL backends keep for them.
+	delete(s.users, userID)
+	return nil
+}
+
+// teamSuccessorLess orders the members who could take over a team from its
+// deleted owner: admins first, then whoever joined first.
+func teamSuccessorLess(a, b TeamMember) bool {
+	if (a.Role == TeamRoleAdmin) != (b.Role == TeamRoleAdmin) {
+		return a.Role == TeamRoleAdmin
+	}
+	if !a.JoinedAt.Equal(b.JoinedAt) {
+		return a.JoinedAt.Before(b.JoinedAt)
+	}
+	return a.UserID < b.UserID
+}
+
+// deleteDocumentLocked deletes documentID and everything attached to it,
+// like ON DELETE CASCADE does in the SQL backends.
+func (s *MemoryStore) deleteDocumentLocked(documentID string) {
+	for id, link := range s.links {
+		if link.DocumentID == documentID {
+			s.deleteLinkLocked(id)
+		}
+	}
+	for id, inv := range s.invitations {
+		if inv.DocumentID == documentID {
+			delete(s.invitations, id)
+		}
+	}
+	for id, req := range s.accessRequests {
+		if req.DocumentID == documentID {
+			delete(s.accessRequests, id)
+		}
+	}
+	delete(s.permissions, documentID)
+	delete(s.opens, documentID)
+	delete(s.documentFolders, documentID)
+	delete(s.tags, documentID)
+	delete(s.favorites, documentID)
+	delete(s.teamPermissions, documentID)
+	delete(s.documents, documentID)
+	s.search.Remove(documentID)
+}
+
+func (s *MemoryStore) deleteLinkLocked(linkID string) {
+	for hash, id := range s.linkTokens {
+		if id == linkID {
+			delete(s.linkTokens, hash)
+		}
+	}
+	delete(s.links, linkID)
+}
+
+// deleteFolderLocked deletes folderID and the folders below it; documents
+// filed in them become unfiled.
+func (s *MemoryStore) deleteFolderLocked(folderID string) {
+	for id, folder := range s.folders {
+		if folder.ParentID == folderID {
+			s.deleteFolderLocked(id)
+		}
+	}
+	for docID, id := range s.documentFolders {
+		if id == folderID {
+			delete(s.documentFolders, docID)
+		}
+	}
+	delete(s.folderPermissions, folderID)
+	delete(s.folders, folderID)
+}
+
+func (s *MemoryStore) deleteWorkspaceLocked(workspaceID string) {
+	for id, folder := range s.folders {
+		if folder.WorkspaceID == workspaceID {
+			s.deleteFolderLocked(id)
+		}
+	}
+	delete(s.workspaceMembers, workspaceID)
+	delete(s.workspaces, workspaceID)
+}
+
+func (s *MemoryStore) deleteTeamLocked(teamID string) {
+	for _, byTeam := range s.teamPermissions {
+		delete(byTeam, teamID)
+	}
+	delete(s.teamMembers, teamID)
+	delete(s.teams, teamID)
+}
+
+func (s *MemoryStore) CreateRefreshToken(ctx context.Context, userID string, expiresAt time.Time) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[userID]; !exists {
+		return nil, ErrNotFound
+	}
+	return s.issueRefreshTokenLocked(userID, uuid.NewString(), expiresAt)
+}
+
+func (s *MemoryStore) issueRefreshTokenLocked(userID, sessionID string, expiresAt time.Time) (*RefreshToken, error) {
+	rt, tokenHash, err := newRefreshToken(userID, sessionID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	rt.ID = uuid.NewString()
+	rt.CreatedAt = time.Now()
+	stored := *rt
+	stored.Token = ""
+	s.refreshTokens[tokenHash] = memoryRefreshToken{RefreshToken: stored}
+	return rt, nil
+}
+
+func (s *MemoryStore) RotateRefreshToken(ctx context.Context, token string, expiresAt time.Time) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokenHash := hashToken(token)
+	current, exists := s.refreshTokens[tokenHash]
+	if !exists {
+		return nil, ErrInvalidRefreshToken
+	}
+	state := refreshTokenState{
+		userID:    current.UserID,
+		sessionID: current.SessionID,
+		expiresAt: current.ExpiresAt,
+		used:      current.used,
+		revoked:   s.revokedSessions[current.SessionID],
+	}
+	if err := state.check(time.Now()); err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			s.revokedSessions[current.SessionID] = true
+		}
+		return nil, err
+	}
+
+	current.used = true
+	s.refreshTokens[tokenHash] = current
+	return s.issueRefreshTokenLocked(current.UserID, current.SessionID, expiresAt)
 }
 
-func (s *MemoryStore) GetUserDocuments(userID string) ([]*Document, error) {
+func (s *MemoryStore) RevokeRefreshToken(ctx context.Context, userID, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, exists := s.refreshTokens[hashToken(token)]
+	if !exists {
+		return nil
+	}
+	if current.UserID != userID {
+		return ErrNotFound
+	}
+	s.revokedSessions[current.SessionID] = true
+	return nil
+}
+
+func (s *MemoryStore) CreatePersonalToken(ctx context.Context, userID, name string, scopes []string, expiresAt time.Time) (*PersonalToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[userID]; !exists {
+		return nil, ErrNotFound
+	}
+	pt, tokenHash, err := newPersonalToken(userID, name, scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	pt.ID = uuid.NewString()
+	pt.CreatedAt = time.Now()
+	stored := *pt
+	stored.Token = ""
+	stored.Scopes = append([]string(nil), scopes...)
+	s.personalTokens[tokenHash] = stored
+	return pt, nil
+}
+
+func (s *MemoryStore) ListPersonalTokens(ctx context.Context, userID string) ([]*PersonalToken, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	var documents []*Document
-	for _, doc := range s.documents {
-		if doc.OwnerID == userID {
-			// Create a copy to avoid returning pointer to internal map value
-			docCopy := doc
-			documents = append(documents, &docCopy)
+	now := time.Now()
+	tokens := []*PersonalToken{}
+	for _, pt := range s.personalTokens {
+		if pt.UserID == userID && now.Before(pt.ExpiresAt) {
+			pt := pt
+			pt.Scopes = append([]string(nil), pt.Scopes...)
+			tokens = append(tokens, &pt)
 		}
 	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
 
-	return documents, nil
+func (s *MemoryStore) RevokePersonalToken(ctx context.Context, userID, tokenID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, pt := range s.personalTokens {
+		if pt.ID == tokenID && pt.UserID == userID {
+			delete(s.personalTokens, hash)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryStore) UsePersonalToken(ctx context.Context, token string) (*PersonalToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokenHash := hashToken(token)
+	pt, exists := s.personalTokens[tokenHash]
+	now := time.Now()
+	if !exists || !now.Before(pt.ExpiresAt) {
+		return nil, ErrInvalidPersonalToken
+	}
+	if !pt.usedRecently(now) {
+		pt.LastUsedAt = &now
+		s.personalTokens[tokenHash] = pt
+	}
+	pt.Scopes = append([]string(nil), pt.Scopes...)
+	return &pt, nil
+}
+
+func (s *MemoryStore) CreateAccountToken(ctx context.Context, userID, purpose string, expiresAt time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return "", ErrNotFound
+	}
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	for tokenHash, st := range s.accountTokens {
+		if st.userID == userID && st.purpose == purpose {
+			st.used = true
+			s.accountTokens[tokenHash] = st
+		}
+	}
+	s.accountTokens[hashToken(token)] = accountTokenState{
+		userID:    userID,
+		purpose:   purpose,
+		email:     user.Email,
+		expiresAt: expiresAt,
+	}
+	return token, nil
+}
+
+// consumeAccountTokenLocked marks token used and returns its user, provided
+// it is valid for purpose and the user still has the address it was sent to.
+func (s *MemoryStore) consumeAccountTokenLocked(token, purpose string) (User, error) {
+	tokenHash := hashToken(token)
+	st, exists := s.accountTokens[tokenHash]
+	if !exists {
+		return User{}, ErrInvalidAccountToken
+	}
+	if err := st.check(purpose, time.Now()); err != nil {
+		return User{}, err
+	}
+	user, exists := s.users[st.userID]
+	if !exists || user.Email != st.email {
+		return User{}, ErrInvalidAccountToken
+	}
+	st.used = true
+	s.accountTokens[tokenHash] = st
+	return user, nil
+}
+
+func (s *MemoryStore) AccountTokenUser(ctx context.Context, token, purpose string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, exists := s.accountTokens[hashToken(token)]
+	if !exists {
+		return nil, ErrInvalidAccountToken
+	}
+	if err := st.check(purpose, time.Now()); err != nil {
+		return nil, err
+	}
+	user, exists := s.users[st.userID]
+	if !exists || user.Email != st.email {
+		return nil, ErrInvalidAccountToken
+	}
+	return &user, nil
 }
 
-// Note: This memory store implementation is missing several required methods.
-// In production, you should implement all methods from the Store interface:
-// GetDocument, UpdateDocument, CheckDocumentPermission, ShareDocument
+func (s *MemoryStore) VerifyEmail(ctx context.Context, token string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.consumeAccountTokenLocked(token, TokenVerifyEmail)
+	if err != nil {
+		return nil, err
+	}
+	user.EmailVerified = true
+	s.users[user.ID] = user
+	return &user, nil
+}
+
+func (s *MemoryStore) ResetPassword(ctx context.Context, token, password string) (*User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("could not hash password: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.consumeAccountTokenLocked(token, TokenResetPassword)
+	if err != nil {
+		return nil, err
+	}
+	// The reset link was delivered to the address, which proves it too.
+	user.PasswordHash = string(hashedPassword)
+	user.EmailVerified = true
+	s.users[user.ID] = user
+
+	for _, rt := range s.refreshTokens {
+		if rt.UserID == user.ID {
+			s.revokedSessions[rt.SessionID] = true
+		}
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) ConsumeAccountToken(ctx context.Context, token, purpose string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.consumeAccountTokenLocked(token, purpose)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, exists := s.users[s.identities[identityKey(issuer, subject)]]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) LinkIdentity(ctx context.Context, userID, issuer, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[userID]; !exists {
+		return ErrNotFound
+	}
+	key := identityKey(issuer, subject)
+	if _, linked := s.identities[key]; linked {
+		return ErrIdentityLinked
+	}
+	s.identities[key] = userID
+	return nil
+}
+
+func (s *MemoryStore) CreateUserWithIdentity(ctx context.Context, email string, emailVerified bool, issuer, subject string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Email == email {
+			return nil, fmt.Errorf("user with email %s already exists", email)
+		}
+	}
+	key := identityKey(issuer, subject)
+	if _, linked := s.identities[key]; linked {
+		return nil, ErrIdentityLinked
+	}
+
+	user := User{
+		ID:            uuid.NewString(),
+		Email:         email,
+		EmailVerified: emailVerified,
+	}
+	s.users[user.ID] = user
+	s.identities[key] = user.ID
+	return &user, nil
+}
+
+func (s *MemoryStore) SetTOTPSecret(ctx context.Context, userID string, secret []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[userID]; !exists {
+		return ErrNotFound
+	}
+	if s.totp[userID].Enabled {
+		return ErrTOTPEnabled
+	}
+	s.totp[userID] = TOTP{Secret: append([]byte(nil), secret...)}
+	return nil
+}
+
+func (s *MemoryStore) GetTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.users[userID]; !exists {
+		return nil, ErrNotFound
+	}
+	totp := s.totp[userID]
+	totp.Secret = append([]byte(nil), totp.Secret...)
+	return &totp, nil
+}
+
+func (s *MemoryStore) EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	totp := s.totp[userID]
+	if !exists || totp.Secret == nil {
+		return ErrNotFound
+	}
+	if totp.Enabled {
+		return ErrTOTPEnabled
+	}
+	totp.Enabled = true
+	totp.LastStep = step
+	s.totp[userID] = totp
+	user.TOTPEnabled = true
+	s.users[userID] = user
+
+	codes := make(map[string]bool, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		codes[hashToken(code)] = false
+	}
+	s.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (s *MemoryStore) RecordTOTPStep(ctx context.Context, userID string, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp := s.totp[userID]
+	if _, exists := s.users[userID]; !exists || step <= totp.LastStep {
+		return ErrTOTPCodeReused
+	}
+	totp.LastStep = step
+	s.totp[userID] = totp
+	return nil
+}
+
+func (s *MemoryStore) UseRecoveryCode(ctx context.Context, userID, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codeHash := hashToken(code)
+	used, exists := s.recoveryCodes[userID][codeHash]
+	if !exists || used {
+		return ErrInvalidRecoveryCode
+	}
+	s.recoveryCodes[userID][codeHash] = true
+	return nil
+}
+
+func (s *MemoryStore) DisableTOTP(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return ErrNotFound
+	}
+	user.TOTPEnabled = false
+	s.users[userID] = user
+	delete(s.totp, userID)
+	delete(s.recoveryCodes, userID)
+	return nil
+}
+
+func (s *MemoryStore) CheckDocumentPermission(ctx context.Context, documentID, userID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists {
+		return false, nil
+	}
+	return s.roleLocked(doc, userID) != "", nil
+}
+
+func (s *MemoryStore) GetDocumentRole(ctx context.Context, documentID, userID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists {
+		return "", ErrNotFound
+	}
+	return s.roleLocked(doc, userID), nil
+}
+
+func (s *MemoryStore) CreateDocument(ctx context.Context, title, ownerID string) (*Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.actorExists(ownerID) {
+		return nil, fmt.Errorf("owner %s does not exist", ownerID)
+	}
+
+	now := time.Now()
+	doc := Document{
+		ID:        uuid.NewString(),
+		Title:     title,
+		OwnerID:   ownerID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.documents[doc.ID] = doc
+	s.search.Index(doc.ID, doc.Title, doc.Content)
+
+	return &doc, nil
+}
+
+func (s *MemoryStore) GetDocument(ctx context.Context, documentID string) (*Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return &doc, nil
+}
+
+func (s *MemoryStore) GetUserDocuments(ctx context.Context, userID string) ([]*Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var documents []*Document
+	for _, doc := range s.documents {
+		if s.roleLocked(doc, userID) != "" {
+			// Create a copy to avoid returning pointer to internal map value
+			docCopy := doc
+			documents = append(documents, &docCopy)
+		}
+	}
+
+	// Match the ORDER BY d.title of the SQL stores.
+	sort.Slice(documents, func(i, j int) bool {
+		if documents[i].Title != documents[j].Title {
+			return documents[i].Title < documents[j].Title
+		}
+		return documents[i].ID < documents[j].ID
+	})
+
+	return documents, nil
+}
+
+func (s *MemoryStore) UpdateDocument(ctx context.Context, documentID, content string, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists {
+		return ErrNotFound
+	}
+	doc.Content = content
+	doc.Version = version
+	doc.UpdatedAt = time.Now()
+	s.documents[documentID] = doc
+	s.search.Index(doc.ID, doc.Title, doc.Content)
+	return nil
+}
+
+func (s *MemoryStore) ShareDocument(ctx context.Context, documentID, ownerID, targetUserID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists || doc.OwnerID != ownerID {
+		return ErrNotDocumentOwner
+	}
+	if _, exists := s.users[targetUserID]; !exists {
+		return fmt.Errorf("user %s does not exist", targetUserID)
+	}
+
+	perms, ok := s.permissions[documentID]
+	if !ok {
+		perms = make(map[string]string)
+		s.permissions[documentID] = perms
+	}
+	// Like ON CONFLICT DO NOTHING: an existing grant keeps its original role.
+	if _, exists := perms[targetUserID]; !exists {
+		perms[targetUserID] = role
+	}
+	return nil
+}
+
+func (s *MemoryStore) ShareDocumentWithTeam(ctx context.Context, documentID, ownerID, teamID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists || doc.OwnerID != ownerID {
+		return ErrNotDocumentOwner
+	}
+	if _, exists := s.teams[teamID]; !exists {
+		return ErrNotFound
+	}
+
+	perms, ok := s.teamPermissions[documentID]
+	if !ok {
+		perms = make(map[string]string)
+		s.teamPermissions[documentID] = perms
+	}
+	if _, exists := perms[teamID]; !exists {
+		perms[teamID] = role
+	}
+	return nil
+}
+
+func (s *MemoryStore) InviteToDocument(ctx context.Context, documentID, ownerID, email, role string) (*Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists || doc.OwnerID != ownerID {
+		return nil, ErrNotDocumentOwner
+	}
+
+	email = invitationEmail(email)
+	for _, inv := range s.invitations {
+		if inv.DocumentID == documentID && inv.Email == email {
+			return &inv, nil
+		}
+	}
+	inv := Invitation{
+		ID:         uuid.NewString(),
+		DocumentID: documentID,
+		Email:      email,
+		Role:       role,
+		InvitedBy:  ownerID,
+		CreatedAt:  time.Now(),
+	}
+	s.invitations[inv.ID] = inv
+	return &inv, nil
+}
+
+func (s *MemoryStore) ListDocumentShares(ctx context.Context, documentID, ownerID string) ([]*DocumentShare, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists || doc.OwnerID != ownerID {
+		return nil, ErrNotDocumentOwner
+	}
+
+	users := []*DocumentShare{}
+	for userID, role := range s.permissions[documentID] {
+		users = append(users, &DocumentShare{UserID: userID, Email: s.users[userID].Email, Role: role})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+
+	teams := []*DocumentShare{}
+	for teamID, role := range s.teamPermissions[documentID] {
+		teams = append(teams, &DocumentShare{TeamID: teamID, TeamName: s.teams[teamID].Name, Role: role})
+	}
+	sort.Slice(teams, func(i, j int) bool { return teams[i].TeamName < teams[j].TeamName })
+	return append(users, teams...), nil
+}
+
+func (s *MemoryStore) ListInvitations(ctx context.Context, documentID, ownerID string) ([]*Invitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists || doc.OwnerID != ownerID {
+		return nil, ErrNotDocumentOwner
+	}
+
+	invitations := []*Invitation{}
+	for _, inv := range s.invitations {
+		if inv.DocumentID == documentID {
+			inv := inv
+			invitations = append(invitations, &inv)
+		}
+	}
+	sort.Slice(invitations, func(i, j int) bool {
+		return invitations[i].Email < invitations[j].Email
+	})
+	return invitations, nil
+}
+
+func (s *MemoryStore) CancelInvitation(ctx context.Context, documentID, invitationID, ownerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists || doc.OwnerID != ownerID {
+		return ErrNotDocumentOwner
+	}
+	if inv, exists := s.invitations[invitationID]; !exists || inv.DocumentID != documentID {
+		return ErrNotFound
+	}
+	delete(s.invitations, invitationID)
+	return nil
+}
+
+func (s *MemoryStore) ClaimInvitations(ctx context.Context, email, userID string) ([]*Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[userID]; !exists {
+		return nil, fmt.Errorf("user %s does not exist", userID)
+	}
+
+	email = invitationEmail(email)
+	claimed := []*Invitation{}
+	for id, inv := range s.invitations {
+		if inv.Email != email {
+			continue
+		}
+		perms, ok := s.permissions[inv.DocumentID]
+		if !ok {
+			perms = make(map[string]string)
+			s.permissions[inv.DocumentID] = perms
+		}
+		if _, exists := perms[userID]; !exists {
+			perms[userID] = inv.Role
+		}
+		delete(s.invitations, id)
+		inv := inv
+		claimed = append(claimed, &inv)
+	}
+	return claimed, nil
+}
+
+// accessRequestLocked returns a copy of req with the requester's email.
+// s.mu must be held.
+func (s *MemoryStore) accessRequestLocked(req AccessRequest) *AccessRequest {
+	req.Email = s.users[req.UserID].Email
+	return &req
+}
+
+func (s *MemoryStore) RequestAccess(ctx context.Context, documentID, userID, role, message string) (*AccessRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	if !s.actorExists(userID) {
+		return nil, fmt.Errorf("user %s does not exist", userID)
+	}
+	if roleRank(s.roleLocked(doc, userID)) >= roleRank(role) {
+		return nil, ErrAlreadyHasAccess
+	}
+
+	for id, req := range s.accessRequests {
+		if req.DocumentID == documentID && req.UserID == userID && req.Status == AccessRequestPending {
+			req.Role, req.Message, req.CreatedAt = role, message, time.Now()
+			s.accessRequests[id] = req
+			return s.accessRequestLocked(req), nil
+		}
+	}
+	req := AccessRequest{
+		ID:         uuid.NewString(),
+		DocumentID: documentID,
+		UserID:     userID,
+		Role:       role,
+		Message:    message,
+		Status:     AccessRequestPending,
+		CreatedAt:  time.Now(),
+	}
+	s.accessRequests[req.ID] = req
+	return s.accessRequestLocked(req), nil
+}
+
+func (s *MemoryStore) ListAccessRequests(ctx context.Context, documentID, ownerID string) ([]*AccessRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists || doc.OwnerID != ownerID {
+		return nil, ErrNotDocumentOwner
+	}
+
+	requests := []*AccessRequest{}
+	for _, req := range s.accessRequests {
+		if req.DocumentID == documentID && req.Status == AccessRequestPending {
+			requests = append(requests, s.accessRequestLocked(req))
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if !requests[i].CreatedAt.Equal(requests[j].CreatedAt) {
+			return requests[i].CreatedAt.Before(requests[j].CreatedAt)
+		}
+		return requests[i].ID < requests[j].ID
+	})
+	return requests, nil
+}
+
+func (s *MemoryStore) DecideAccessRequest(ctx context.Context, documentID, requestID, ownerID string, approve bool) (*AccessRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists || doc.OwnerID != ownerID {
+		return nil, ErrNotDocumentOwner
+	}
+	req, exists := s.accessRequests[requestID]
+	if !exists || req.DocumentID != documentID || req.Status != AccessRequestPending {
+		return nil, ErrNotFound
+	}
+
+	now := time.Now()
+	req.DecidedAt = &now
+	req.Status = AccessRequestDenied
+	if approve {
+		req.Status = AccessRequestApproved
+		perms, ok := s.permissions[documentID]
+		if !ok {
+			perms = make(map[string]string)
+			s.permissions[documentID] = perms
+		}
+		if roleRank(req.Role) > roleRank(perms[req.UserID]) {
+			perms[req.UserID] = req.Role
+		}
+	}
+	s.accessRequests[requestID] = req
+	return s.accessRequestLocked(req), nil
+}
+
+func (s *MemoryStore) CreateShareLink(ctx context.Context, documentID, ownerID string, opts ShareLinkOptions) (*ShareLink, error) {
+	link, tokenHash, err := newShareLink(documentID, ownerID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists || doc.OwnerID != ownerID {
+		return nil, ErrNotDocumentOwner
+	}
+
+	link.ID = uuid.NewString()
+	link.CreatedAt = time.Now()
+	stored := *link
+	stored.Token = ""
+	s.links[link.ID] = stored
+	s.linkTokens[tokenHash] = link.ID
+	return link, nil
+}
+
+func (s *MemoryStore) ListShareLinks(ctx context.Context, documentID, ownerID string) ([]*ShareLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists || doc.OwnerID != ownerID {
+		return nil, ErrNotDocumentOwner
+	}
+
+	links := []*ShareLink{}
+	for _, link := range s.links {
+		if link.DocumentID == documentID {
+			link := link
+			links = append(links, &link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if !links[i].CreatedAt.Equal(links[j].CreatedAt) {
+			return links[i].CreatedAt.After(links[j].CreatedAt)
+		}
+		return links[i].ID < links[j].ID
+	})
+	return links, nil
+}
+
+func (s *MemoryStore) RevokeShareLink(ctx context.Context, documentID, linkID, ownerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists || doc.OwnerID != ownerID {
+		return ErrNotDocumentOwner
+	}
+	link, exists := s.links[linkID]
+	if !exists || link.DocumentID != documentID {
+		return ErrNotFound
+	}
+	if link.RevokedAt == nil {
+		now := time.Now()
+		link.RevokedAt = &now
+		s.links[linkID] = link
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetShareLinkByToken(ctx context.Context, documentID, token string) (*ShareLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	link, exists := s.links[s.linkTokens[hashToken(token)]]
+	if !exists || link.DocumentID != documentID {
+		return nil, ErrNotFound
+	}
+	return &link, nil
+}
+
+func (s *MemoryStore) UseShareLink(ctx context.Context, linkID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, exists := s.links[linkID]
+	if !exists {
+		return ErrNotFound
+	}
+	if !link.Usable(time.Now()) {
+		return ErrLinkUnavailable
+	}
+	link.Uses++
+	s.links[linkID] = link
+	return nil
+}
+
+func (s *MemoryStore) ListDocuments(ctx context.Context, userID string, opts DocumentListOptions) (*DocumentPage, error) {
+	opts = opts.normalize()
+	cursor, err := decodeCursor(opts.Cursor, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	var summaries []*DocumentSummary
+	for _, doc := range s.documents {
+		role := s.roleLocked(doc, userID)
+		if role == "" {
+			continue
+		}
+		if opts.Ownership == OwnershipOwned && role != RoleOwner ||
+			opts.Ownership == OwnershipShared && role == RoleOwner ||
+			opts.Role != "" && role != opts.Role ||
+			opts.FolderID != "" && s.documentFolders[doc.ID] != opts.FolderID ||
+			opts.FavoritesOnly && !s.favorites[doc.ID][userID] ||
+			!s.hasTagsLocked(doc.ID, opts.Tags) {
+			continue
+		}
+
+		summary := &DocumentSummary{
+			ID:        doc.ID,
+			Title:     doc.Title,
+			OwnerID:   doc.OwnerID,
+			Role:      role,
+			FolderID:  s.documentFolders[doc.ID],
+			Favorite:  s.favorites[doc.ID][userID],
+			Tags:      s.tagsLocked(doc.ID),
+			Version:   doc.Version,
+			CreatedAt: doc.CreatedAt,
+			UpdatedAt: doc.UpdatedAt,
+		}
+		if openedAt, ok := s.opens[doc.ID][userID]; ok {
+			summary.LastOpenedAt = &openedAt
+		}
+		if opts.IncludeContent {
+			summary.Content = doc.Content
+		}
+		summaries = append(summaries, summary)
+	}
+	s.mu.RUnlock()
+
+	// after reports whether a comes after b in the requested order.
+	after := func(aKey, aID, bKey, bID string) bool {
+		if aKey != bKey {
+			return (aKey > bKey) != opts.Descending
+		}
+		return (aID > bID) != opts.Descending
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return after(summaries[j].sortKey(opts.Sort), summaries[j].ID, summaries[i].sortKey(opts.Sort), summaries[i].ID)
+	})
+
+	var ordered []*DocumentSummary
+	for _, summary := range summaries {
+		if cursor != nil && !after(summary.sortKey(opts.Sort), summary.ID, cursor.Key, cursor.ID) {
+			continue
+		}
+		ordered = append(ordered, summary)
+		if len(ordered) > opts.Limit {
+			break
+		}
+	}
+	return pageOf(ordered, opts), nil
+}
+
+// roleLocked returns userID's role on doc, or "" without access. s.mu must be held.
+func (s *MemoryStore) roleLocked(doc Document, userID string) string {
+	if doc.OwnerID == userID {
+		return RoleOwner
+	}
+	rank := roleRank(s.permissions[doc.ID][userID])
+	for teamID, role := range s.teamPermissions[doc.ID] {
+		if _, member := s.teamMembers[teamID][userID]; member {
+			rank = max(rank, roleRank(role))
+		}
+	}
+	if folderID, filed := s.documentFolders[doc.ID]; filed {
+		rank = max(rank, s.folderRankLocked(folderID, userID))
+	}
+	return rankRole(rank)
+}
+
+// folderRankLocked returns the best rank userID holds on folderID or any
+// folder above it, mirroring folderAccessCTE. s.mu must be held.
+func (s *MemoryStore) folderRankLocked(folderID, userID string) int {
+	rank := 0
+	for id := folderID; id != ""; {
+		folder, exists := s.folders[id]
+		if !exists {
+			break
+		}
+		if folder.OwnerID == userID {
+			rank = max(rank, roleRank(RoleEditor))
+		}
+		rank = max(rank, roleRank(s.folderPermissions[id][userID]))
+		if folder.WorkspaceID != "" {
+			rank = max(rank, roleRank(s.workspaceRoleLocked(folder.WorkspaceID, userID)))
+		}
+		id = folder.ParentID
+	}
+	return rank
+}
+
+// workspaceRoleLocked returns userID's role in workspaceID, with the owner
+// treated as an editor of its contents. s.mu must be held.
+func (s *MemoryStore) workspaceRoleLocked(workspaceID, userID string) string {
+	if s.workspaces[workspaceID].OwnerID == userID {
+		return RoleEditor
+	}
+	return s.workspaceMembers[workspaceID][userID]
+}
+
+func (s *MemoryStore) SearchDocuments(ctx context.Context, userID, query string, limit int) ([]*SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hits := s.search.Search(query, func(documentID string) bool {
+		doc, exists := s.documents[documentID]
+		return exists && s.roleLocked(doc, userID) != ""
+	}, limit)
+
+	results := make([]*SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		doc := s.documents[hit.DocumentID]
+		results = append(results, &SearchResult{
+			ID:      doc.ID,
+			Title:   doc.Title,
+			OwnerID: doc.OwnerID,
+			Role:    s.roleLocked(doc, userID),
+			Rank:    hit.Rank,
+			Snippet: hit.Snippet,
+		})
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) RecordDocumentOpen(ctx context.Context, documentID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.documents[documentID]; !exists {
+		return ErrNotFound
+	}
+	opens, ok := s.opens[documentID]
+	if !ok {
+		opens = make(map[string]time.Time)
+		s.opens[documentID] = opens
+	}
+	opens[userID] = time.Now()
+	return nil
+}
+
+func (s *MemoryStore) MoveDocument(ctx context.Context, documentID, userID, folderID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, exists := s.documents[documentID]
+	if !exists {
+		return ErrNotFound
+	}
+	if doc.OwnerID != userID {
+		return ErrPermissionDenied
+	}
+	if folderID == "" {
+		delete(s.documentFolders, documentID)
+		return nil
+	}
+	if _, exists := s.folders[folderID]; !exists {
+		return ErrNotFound
+	}
+	if s.folderRankLocked(folderID, userID) < roleRank(RoleEditor) {
+		return ErrPermissionDenied
+	}
+	s.documentFolders[documentID] = folderID
+	return nil
+}
+
+func (s *MemoryStore) CreateWorkspace(ctx context.Context, name, ownerID string) (*Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.actorExists(ownerID) {
+		return nil, fmt.Errorf("owner %s does not exist", ownerID)
+	}
+
+	ws := Workspace{ID: uuid.NewString(), Name: name, OwnerID: ownerID}
+	s.workspaces[ws.ID] = ws
+	ws.Role = RoleOwner
+	return &ws, nil
+}
+
+func (s *MemoryStore) ListWorkspaces(ctx context.Context, userID string) ([]*Workspace, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	workspaces := []*Workspace{}
+	for _, ws := range s.workspaces {
+		if ws.OwnerID == userID {
+			ws.Role = RoleOwner
+		} else if role, member := s.workspaceMembers[ws.ID][userID]; member {
+			ws.Role = role
+		} else {
+			continue
+		}
+		workspaces = append(workspaces, &ws)
+	}
+	sort.Slice(workspaces, func(i, j int) bool {
+		if workspaces[i].Name != workspaces[j].Name {
+			return workspaces[i].Name < workspaces[j].Name
+		}
+		return workspaces[i].ID < workspaces[j].ID
+	})
+	return workspaces, nil
+}
+
+func (s *MemoryStore) AddWorkspaceMember(ctx context.Context, workspaceID, ownerID, userID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ws, exists := s.workspaces[workspaceID]
+	if !exists {
+		return ErrNotFound
+	}
+	if ws.OwnerID != ownerID {
+		return ErrPermissionDenied
+	}
+	if _, exists := s.users[userID]; !exists {
+		return fmt.Errorf("user %s does not exist", userID)
+	}
+
+	members, ok := s.workspaceMembers[workspaceID]
+	if !ok {
+		members = make(map[string]string)
+		s.workspaceMembers[workspaceID] = members
+	}
+	members[userID] = role
+	return nil
+}
+
+func (s *MemoryStore) RemoveWorkspaceMember(ctx context.Context, workspaceID, ownerID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ws, exists := s.workspaces[workspaceID]
+	if !exists {
+		return ErrNotFound
+	}
+	if ws.OwnerID != ownerID {
+		return ErrPermissionDenied
+	}
+	if _, member := s.workspaceMembers[workspaceID][userID]; !member {
+		return ErrNotFound
+	}
+	delete(s.workspaceMembers[workspaceID], userID)
+	return nil
+}
+
+func (s *MemoryStore) CreateFolder(ctx context.Context, name, ownerID, parentID, workspaceID string) (*Folder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.actorExists(ownerID) {
+		return nil, fmt.Errorf("owner %s does not exist", ownerID)
+	}
+
+	folder := Folder{ID: uuid.NewString(), Name: name, OwnerID: ownerID}
+	switch {
+	case parentID != "":
+		parent, exists := s.folders[parentID]
+		if !exists {
+			return nil, ErrNotFound
+		}
+		if s.folderRankLocked(parentID, ownerID) < roleRank(RoleEditor) {
+			return nil, ErrPermissionDenied
+		}
+		folder.ParentID = parentID
+		folder.WorkspaceID = parent.WorkspaceID
+	case workspaceID != "":
+		if _, exists := s.workspaces[workspaceID]; !exists {
+			return nil, ErrNotFound
+		}
+		if roleRank(s.workspaceRoleLocked(workspaceID, ownerID)) < roleRank(RoleEditor) {
+			return nil, ErrPermissionDenied
+		}
+		folder.WorkspaceID = workspaceID
+	}
+	s.folders[folder.ID] = folder
+	folder.Role = RoleOwner
+	return &folder, nil
+}
+
+func (s *MemoryStore) GetFolder(ctx context.Context, folderID, userID string) (*Folder, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	folder, exists := s.folders[folderID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	rank := s.folderRankLocked(folderID, userID)
+	if rank == 0 {
+		return nil, ErrPermissionDenied
+	}
+	folder.Role = folderRole(&folder, userID, rank)
+	return &folder, nil
+}
+
+func (s *MemoryStore) ListFolders(ctx context.Context, userID, parentID string) ([]*Folder, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if parentID != "" {
+		if _, exists := s.folders[parentID]; !exists {
+			return nil, ErrNotFound
+		}
+		if s.folderRankLocked(parentID, userID) == 0 {
+			return nil, ErrPermissionDenied
+		}
+	}
+
+	folders := []*Folder{}
+	for _, folder := range s.folders {
+		rank := s.folderRankLocked(folder.ID, userID)
+		if rank == 0 {
+			continue
+		}
+		if parentID != "" && folder.ParentID != parentID ||
+			parentID == "" && folder.ParentID != "" && s.folderRankLocked(folder.ParentID, userID) > 0 {
+			continue
+		}
+		folder.Role = folderRole(&folder, userID, rank)
+		folders = append(folders, &folder)
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		if folders[i].Name != folders[j].Name {
+			return folders[i].Name < folders[j].Name
+		}
+		return folders[i].ID < folders[j].ID
+	})
+	return folders, nil
+}
+
+func (s *MemoryStore) ShareFolder(ctx context.Context, folderID, ownerID, targetUserID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	folder, exists := s.folders[folderID]
+	if !exists {
+		return ErrNotFound
+	}
+	if folder.OwnerID != ownerID {
+		return ErrPermissionDenied
+	}
+	if _, exists := s.users[targetUserID]; !exists {
+		return fmt.Errorf("user %s does not exist", targetUserID)
+	}
+
+	perms, ok := s.folderPermissions[folderID]
+	if !ok {
+		perms = make(map[string]string)
+		s.folderPermissions[folderID] = perms
+	}
+	// Like ShareDocument: an existing grant keeps its original role.
+	if _, exists := perms[targetUserID]; !exists {
+		perms[targetUserID] = role
+	}
+	return nil
+}
+
+// hasTagsLocked reports whether documentID carries every tag. s.mu must be held.
+func (s *MemoryStore) hasTagsLocked(documentID string, tags []string) bool {
+	for _, tag := range tags {
+		if !s.tags[documentID][tag] {
+			return false
+		}
+	}
+	return true
+}
+
+// tagsLocked returns documentID's tags in order, or nil. s.mu must be held.
+func (s *MemoryStore) tagsLocked(documentID string) []string {
+	var tags []string
+	for tag := range s.tags[documentID] {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// documentRoleLocked returns userID's role on documentID, ErrNotFound if the
+// document doesn't exist, or ErrPermissionDenied if the role is below min.
+// s.mu must be held.
+func (s *MemoryStore) documentRoleLocked(documentID, userID, min string) (string, error) {
+	doc, exists := s.documents[documentID]
+	if !exists {
+		return "", ErrNotFound
+	}
+	role := s.roleLocked(doc, userID)
+	if roleRank(role) < roleRank(min) {
+		return "", ErrPermissionDenied
+	}
+	return role, nil
+}
+
+func (s *MemoryStore) SetFavorite(ctx context.Context, documentID, userID string, favorite bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.documentRoleLocked(documentID, userID, RoleViewer); err != nil {
+		return err
+	}
+	if !favorite {
+		delete(s.favorites[documentID], userID)
+		return nil
+	}
+	users, ok := s.favorites[documentID]
+	if !ok {
+		users = make(map[string]bool)
+		s.favorites[documentID] = users
+	}
+	users[userID] = true
+	return nil
+}
+
+func (s *MemoryStore) GetDocumentTags(ctx context.Context, documentID, userID string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.documentRoleLocked(documentID, userID, RoleViewer); err != nil {
+		return nil, err
+	}
+	tags := s.tagsLocked(documentID)
+	if tags == nil {
+		tags = []string{}
+	}
+	return tags, nil
+}
+
+func (s *MemoryStore) AddDocumentTag(ctx context.Context, documentID, userID, tag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.documentRoleLocked(documentID, userID, RoleEditor); err != nil {
+		return err
+	}
+	tags, ok := s.tags[documentID]
+	if !ok {
+		tags = make(map[string]bool)
+		s.tags[documentID] = tags
+	}
+	tags[tag] = true
+	return nil
+}
+
+func (s *MemoryStore) RemoveDocumentTag(ctx context.Context, documentID, userID, tag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.documentRoleLocked(documentID, userID, RoleEditor); err != nil {
+		return err
+	}
+	delete(s.tags[documentID], tag)
+	return nil
+}
+
+func (s *MemoryStore) ListTags(ctx context.Context, userID string) ([]*TagCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for documentID, tags := range s.tags {
+		doc, exists := s.documents[documentID]
+		if !exists || s.roleLocked(doc, userID) == "" {
+			continue
+		}
+		for tag := range tags {
+			counts[tag]++
+		}
+	}
+
+	result := []*TagCount{}
+	for tag, count := range counts {
+		result = append(result, &TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Tag < result[j].Tag })
+	return result, nil
+}
+
+func (s *MemoryStore) CreateTeam(ctx context.Context, name, ownerID string) (*Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[ownerID]; !exists {
+		return nil, fmt.Errorf("owner %s does not exist", ownerID)
+	}
+
+	now := time.Now()
+	team := Team{ID: uuid.NewString(), Name: name, CreatedAt: now}
+	s.teams[team.ID] = team
+	s.teamMembers[team.ID] = map[string]TeamMember{
+		ownerID: {UserID: ownerID, Role: TeamRoleOwner, JoinedAt: now},
+	}
+	team.Role = TeamRoleOwner
+	return &team, nil
+}
+
+func (s *MemoryStore) ListTeams(ctx context.Context, userID string) ([]*Team, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	teams := []*Team{}
+	for _, team := range s.teams {
+		member, ok := s.teamMembers[team.ID][userID]
+		if !ok {
+			continue
+		}
+		team.Role = member.Role
+		teams = append(teams, &team)
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		if teams[i].Name != teams[j].Name {
+			return teams[i].Name < teams[j].Name
+		}
+		return teams[i].ID < teams[j].ID
+	})
+	return teams, nil
+}
+
+func (s *MemoryStore) GetTeamMembers(ctx context.Context, teamID, userID string) ([]*TeamMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.teams[teamID]; !exists {
+		return nil, ErrNotFound
+	}
+	if _, member := s.teamMembers[teamID][userID]; !member {
+		return nil, ErrPermissionDenied
+	}
+
+	members := []*TeamMember{}
+	for _, member := range s.teamMembers[teamID] {
+		user := s.users[member.UserID]
+		member.Email, member.DisplayName, member.AvatarURL = user.Email, user.DisplayName, user.AvatarURL
+		members = append(members, &member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Email < members[j].Email })
+	return members, nil
+}
+
+func (s *MemoryStore) AddTeamMember(ctx context.Context, teamID, actorID, userID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.teams[teamID]; !exists {
+		return ErrNotFound
+	}
+	if _, exists := s.users[userID]; !exists {
+		return fmt.Errorf("user %s does not exist", userID)
+	}
+	members := s.teamMembers[teamID]
+	current, isMember := members[userID]
+	if !canChangeTeamMember(members[actorID].Role, current.Role, role, actorID == userID) {
+		return ErrPermissionDenied
+	}
+
+	if !isMember {
+		current = TeamMember{UserID: userID, JoinedAt: time.Now()}
+	}
+	current.Role = role
+	members[userID] = current
+	return nil
+}
+
+func (s *MemoryStore) RemoveTeamMember(ctx context.Context, teamID, actorID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.teams[teamID]; !exists {
+		return ErrNotFound
+	}
+	members := s.teamMembers[teamID]
+	current, isMember := members[userID]
+	if !canChangeTeamMember(members[actorID].Role, current.Role, "", actorID == userID) {
+		return ErrPermissionDenied
+	}
+	if !isMember {
+		return ErrNotFound
+	}
+	delete(members, userID)
+	return nil
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MemoryCache is an in-process Cache with the same semantics as RedisCache:
+// misses are reported as redis.Nil, the op log behaves like LPUSH/LPOP and
+// ClearDocumentState only drops the state hash. It lets the realtime service
+// run without a Redis server. Expired entries are dropped when they are next
+// read and by a sweep every ttl (or DefaultCacheSweepInterval), until Close.
+type MemoryCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	now    func() time.Time
+	states map[string]cachedState
+	ops    map[string]cachedOps
+	// tickets always expire, whatever ttl is.
+	tickets map[string]cachedTicket
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// DefaultCacheSweepInterval is how often a MemoryCache without a ttl sweeps
+// out expired tickets.
+const DefaultCacheSweepInterval = time.Minute
+
+type cachedTicket struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+type cachedState struct {
+	content   string
+	version   int
+	expiresAt time.Time
+}
+
+type cachedOps struct {
+	// stack holds the list with its head (the LPUSH/LPOP end) last.
+	stack     [][]byte
+	expiresAt time.Time
+}
+
+// NewMemoryCache creates a MemoryCache. When ttl is positive every write
+// (re)sets the key's expiry, as an EXPIRE following the write would in Redis.
+func NewMemoryCache(ttl time.Duration) *MemoryCache {
+	c := &MemoryCache{
+		ttl:    ttl,
+		now:    time.Now,
+		states: make(map[string]cachedState),
+		ops:    make(map[string]cachedOps),
+
+		tickets: make(map[string]cachedTicket),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	interval := ttl
+	if interval <= 0 {
+		interval = DefaultCacheSweepInterval
+	}
+	go c.sweepEvery(interval)
+	return c
+}
+
+// sweepEvery drops expired entries every interval until Close, so the state
+// of documents nobody opens again doesn't stay in memory.
+func (c *MemoryCache) sweepEvery(interval time.Duration) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.sweep()
+		}
+	}
+}
+
+func (c *MemoryCache) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for docID, state := range c.states {
+		if c.expired(state.expiresAt) {
+			delete(c.states, docID)
+		}
+	}
+	for docID, list := range c.ops {
+		if c.expired(list.expiresAt) {
+			delete(c.ops, docID)
+		}
+	}
+	for t, cached := range c.tickets {
+		if c.expired(cached.expiresAt) {
+			delete(c.tickets, t)
+		}
+	}
+}
+
+// Close stops the sweep. The cache can still be used afterwards; expired
+// entries are then only dropped when they are read.
+func (c *MemoryCache) Close() error {
+	c.closeOnce.Do(func() { close(c.stop) })
+	<-c.done
+	return nil
+}
+
+func (c *MemoryCache) expiry() time.Time {
+	if c.ttl <= 0 {
+		return time.Time{}
+	}
+	return c.now().Add(c.ttl)
+}
+
+func (c *MemoryCache) expired(expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && !c.now().Before(expiresAt)
+}
+
+func (c *MemoryCache) GetDocumentState(ctx context.Context, docID string) (string, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, ok := c.states[docID]
+	if !ok {
+		return "", 0, redis.Nil
+	}
+	if c.expired(state.expiresAt) {
+		delete(c.states, docID)
+		return "", 0, redis.Nil
+	}
+	return state.content, state.version, nil
+}
+
+func (c *MemoryCache) SetDocumentState(ctx context.Context, docID, content string, version int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.states[docID] = cachedState{content: content, version: version, expiresAt: c.expiry()}
+	return nil
+}
+
+func (c *MemoryCache) ClearDocumentState(ctx context.Context, docID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.states, docID)
+	return nil
+}
+
+func (c *MemoryCache) PushOperation(ctx context.Context, docID string, opData []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := c.ops[docID]
+	if c.expired(list.expiresAt) {
+		list = cachedOps{}
+	}
+	// Copy so callers can reuse their buffer, as they could with a network write.
+	list.stack = append(list.stack, append([]byte(nil), opData...))
+	list.expiresAt = c.expiry()
+	c.ops[docID] = list
+	return nil
+}
+
+func (c *MemoryCache) PopOperation(ctx context.Context, docID string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list, ok := c.ops[docID]
+	if !ok {
+		return nil, redis.Nil
+	}
+	if c.expired(list.expiresAt) {
+		delete(c.ops, docID)
+		return nil, redis.Nil
+	}
+
+	last := len(list.stack) - 1
+	opData := list.stack[last]
+	list.stack = list.stack[:last]
+	// Redis deletes a list once its last element is popped.
+	if len(list.stack) == 0 {
+		delete(c.ops, docID)
+	} else {
+		c.ops[docID] = list
+	}
+	return opData, nil
+}
+
+func (c *MemoryCache) PutTicket(ctx context.Context, ticket string, data []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tickets[ticket] = cachedTicket{data: append([]byte(nil), data...), expiresAt: c.now().Add(ttl)}
+	return nil
+}
+
+func (c *MemoryCache) TakeTicket(ctx context.Context, ticket string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.tickets[ticket]
+	if !ok {
+		return nil, redis.Nil
+	}
+	delete(c.tickets, ticket)
+	if c.expired(cached.expiresAt) {
+		return nil, redis.Nil
+	}
+	return cached.data, nil
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Entries nobody reads again are swept out once they expire.
+func TestMemoryCacheSweepsExpiredEntries(t *testing.T) {
+	c := NewMemoryCache(10 * time.Millisecond)
+	defer c.Close()
+	ctx := context.Background()
+
+	if err := c.SetDocumentState(ctx, "doc-1", "hello", 1); err != nil {
+		t.Fatalf("SetDocumentState: %v", err)
+	}
+	if err := c.PushOperation(ctx, "doc-1", []byte(`{"type":"insert"}`)); err != nil {
+		t.Fatalf("PushOperation: %v", err)
+	}
+	if err := c.PutTicket(ctx, "ticket-1", []byte("user-1"), 10*time.Millisecond); err != nil {
+		t.Fatalf("PutTicket: %v", err)
+	}
+
+	size := func() int {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.states) + len(c.ops) + len(c.tickets)
+	}
+	deadline := time.Now().Add(time.Second)
+	for size() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d entries left a second after they expired, want none", size())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Close stops the sweep and can be called more than once.
+	c.Close()
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close twice: %v", err)
+	}
+}
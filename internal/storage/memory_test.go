@@ -0,0 +1,58 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage/storagetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storagetest.TestStore(t, func(t *testing.T) storage.Store {
+		return storage.NewMemoryStore()
+	})
+}
+
+// document-service's memory store only learns of accounts from the access
+// tokens of the people using it.
+func TestMemoryStoreAdoptsUnknownUsers(t *testing.T) {
+	s := storage.NewMemoryStore()
+	ctx := context.Background()
+	owner := uuid.NewString()
+
+	if _, err := s.CreateDocument(ctx, "Roadmap", owner); err == nil {
+		t.Fatal("CreateDocument for an unknown owner succeeded without AdoptUnknownUsers")
+	}
+
+	s.AdoptUnknownUsers()
+	doc, err := s.CreateDocument(ctx, "Roadmap", owner)
+	if err != nil {
+		t.Fatalf("CreateDocument: %v", err)
+	}
+	if ok, err := s.CheckDocumentPermission(ctx, doc.ID, owner); err != nil || !ok {
+		t.Fatalf("owner permission = %v, %v; want true", ok, err)
+	}
+	if _, err := s.CreateWorkspace(ctx, "Team", uuid.NewString()); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	if _, err := s.CreateFolder(ctx, "Specs", uuid.NewString(), "", ""); err != nil {
+		t.Fatalf("CreateFolder: %v", err)
+	}
+	requester := uuid.NewString()
+	if _, err := s.RequestAccess(ctx, doc.ID, requester, storage.RoleViewer, ""); err != nil {
+		t.Fatalf("RequestAccess: %v", err)
+	}
+
+	user, err := s.GetUserByID(ctx, owner)
+	if err != nil || user.Email == "" || user.PasswordHash != "" {
+		t.Fatalf("placeholder = %+v, %v; want an account without a password or real email", user, err)
+	}
+
+	// Only the actions document-service takes for its caller adopt them.
+	if _, err := s.CreateRefreshToken(ctx, uuid.NewString(), time.Now().Add(time.Hour)); err == nil {
+		t.Fatal("CreateRefreshToken for an unknown user succeeded")
+	}
+}
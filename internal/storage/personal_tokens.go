@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// PersonalTokenPrefix starts every personal access token, so they are easy
+// to recognise in logs and for secret scanners.
+const PersonalTokenPrefix = "cep_"
+
+// personalTokenUseInterval is how stale LastUsedAt may get: a token used
+// again within it is not written back on every request.
+const personalTokenUseInterval = time.Minute
+
+// ErrInvalidPersonalToken is returned for personal access tokens that don't
+// exist, were revoked or have expired.
+var ErrInvalidPersonalToken = errors.New("personal access token is invalid or expired")
+
+// PersonalToken is a long-lived token a user issues for automation, limited
+// to Scopes. Only a hash of the token is stored; Token is set once, in the
+// value returned when it is created.
+type PersonalToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Token      string     `json:"token,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// newPersonalToken returns a fresh token for userID, and the hash it is
+// stored under.
+func newPersonalToken(userID, name string, scopes []string, expiresAt time.Time) (*PersonalToken, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+	pt := &PersonalToken{
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		Token:     PersonalTokenPrefix + token,
+		ExpiresAt: expiresAt,
+	}
+	return pt, hashToken(pt.Token), nil
+}
+
+// usedRecently reports whether LastUsedAt is within
+// personalTokenUseInterval of now, so recording this use can be skipped.
+func (pt *PersonalToken) usedRecently(now time.Time) bool {
+	return pt.LastUsedAt != nil && now.Sub(*pt.LastUsedAt) < personalTokenUseInterval
+}
+
+// joinScopes and splitScopes convert scopes to and from the space-separated
+// form they are stored in.
+func joinScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+func splitScopes(s string) []string {
+	return strings.Fields(s)
+}
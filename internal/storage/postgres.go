@@ -2,22 +2,63 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type PostgresStore struct {
-	pool *pgxpool.Pool
+	pool         *pgxpool.Pool
+	queryTimeout time.Duration
 }
 
-func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
-	return &PostgresStore{pool: pool}
+// NewPostgresStore creates a PostgresStore. Every call is bounded by
+// queryTimeout on top of the caller's context, so a slow query can't hold a
+// pooled connection indefinitely; zero disables the extra deadline.
+func NewPostgresStore(pool *pgxpool.Pool, queryTimeout time.Duration) *PostgresStore {
+	return &PostgresStore{pool: pool, queryTimeout: queryTimeout}
 }
 
-func (s *PostgresStore) CreateUser(email, password string) (*User, error) {
+func (s *PostgresStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, s.queryTimeout)
+}
+
+// pgForeignKeyViolation is the SQLSTATE for foreign_key_violation.
+const pgForeignKeyViolation = "23503"
+
+// pgUniqueViolation is the SQLSTATE for unique_violation.
+const pgUniqueViolation = "23505"
+
+// validID returns ErrNotFound if any of ids isn't a UUID, which Postgres
+// would otherwise reject with a syntax error.
+func validID(ids ...string) error {
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil {
+			return ErrNotFound
+		}
+	}
+	return nil
+}
+
+// notFound maps pgx.ErrNoRows to ErrNotFound so callers don't depend on pgx.
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *PostgresStore) CreateUser(ctx context.Context, email, password string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, err
@@ -26,7 +67,7 @@ func (s *PostgresStore) CreateUser(email, password string) (*User, error) {
 	user := &User{Email: email, PasswordHash: string(hashedPassword)}
 	query := `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`
 
-	err = s.pool.QueryRow(context.Background(), query, email, string(hashedPassword)).Scan(&user.ID)
+	err = s.pool.QueryRow(ctx, query, email, string(hashedPassword)).Scan(&user.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -34,156 +75,2394 @@ func (s *PostgresStore) CreateUser(email, password string) (*User, error) {
 	return user, nil
 }
 
-func (s *PostgresStore) GetUserByEmail(email string) (*User, error) {
-	user := &User{}
-	query := `SELECT id, email, password_hash FROM users WHERE email = $1`
+func (s *PostgresStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1 AND deleted_at IS NULL`
 
-	err := s.pool.QueryRow(context.Background(), query, email).Scan(&user.ID, &user.Email, &user.PasswordHash)
+	user, err := scanUser(s.pool.QueryRow(ctx, query, email))
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 
 	return user, nil
 }
 
-func (s *PostgresStore) CreateDocument(title, ownerID string) (*Document, error) {
+func (s *PostgresStore) CreateDocument(ctx context.Context, title, ownerID string) (*Document, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	doc := &Document{Title: title, OwnerID: ownerID}
-	query := `INSERT INTO documents (title, owner_id) VALUES ($1, $2) RETURNING id`
+	query := `INSERT INTO documents (title, owner_id) VALUES ($1, $2) RETURNING id, created_at, updated_at`
 
-	err := s.pool.QueryRow(context.Background(), query, title, ownerID).Scan(&doc.ID)
+	err := s.pool.QueryRow(ctx, query, title, ownerID).Scan(&doc.ID, &doc.CreatedAt, &doc.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return doc, nil
 }
 
-func (s *PostgresStore) GetUserByID(id string) (*User, error) {
-	user := &User{}
-	query := `SELECT id, email, password_hash FROM users WHERE id = $1`
+func (s *PostgresStore) GetUserByID(ctx context.Context, id string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	err := s.pool.QueryRow(context.Background(), query, id).Scan(&user.ID, &user.Email, &user.PasswordHash)
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND deleted_at IS NULL`
+
+	user, err := scanUser(s.pool.QueryRow(ctx, query, id))
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 
 	return user, nil
 }
 
-func (s *PostgresStore) CheckDocumentPermission(documentID, userID string) (bool, error) {
-	var exists bool
+func (s *PostgresStore) UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return nil, err
+	}
 	query := `
-        SELECT EXISTS (
-            SELECT 1 FROM documents WHERE id = $1 AND owner_id = $2
-            UNION ALL
-            SELECT 1 FROM document_permissions WHERE document_id = $1 AND user_id = $2
-        )
-    `
+		UPDATE users SET
+			display_name = COALESCE($2, display_name),
+			avatar_url = COALESCE($3, avatar_url),
+			locale = COALESCE($4, locale),
+			timezone = COALESCE($5, timezone)
+		WHERE id = $1
+		RETURNING ` + userColumns
+	user, err := scanUser(s.pool.QueryRow(ctx, query, userID, update.DisplayName, update.AvatarURL, update.Locale, update.Timezone))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return user, nil
+}
+
+func (s *PostgresStore) ChangePassword(ctx context.Context, userID, password string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	err := s.pool.QueryRow(context.Background(), query, documentID, userID).Scan(&exists)
+	if err := validID(userID); err != nil {
+		return err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return false, nil
+		return err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET password_hash = $2 WHERE id = $1`, userID, string(hashedPassword))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	revokeQuery := `UPDATE refresh_tokens SET revoked_at = COALESCE(revoked_at, NOW()) WHERE user_id = $1`
+	if _, err := tx.Exec(ctx, revokeQuery, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) ChangeEmail(ctx context.Context, userID, email string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return nil, err
+	}
+	query := `UPDATE users SET email = $2, email_verified_at = NULL WHERE id = $1 RETURNING ` + userColumns
+	user, err := scanUser(s.pool.QueryRow(ctx, query, userID, email))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return user, nil
+}
+
+// DeleteUser runs in one transaction. An account that still owns something
+// after opts.Documents has been applied (only under OwnedDocumentsOrphan) is
+// kept as an anonymised row with deleted_at set, because documents.owner_id
+// can't point at nothing; everything else of it is deleted.
+func (s *PostgresStore) DeleteUser(ctx context.Context, userID string, opts DeleteUserOptions) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return err
+	}
+	if !ValidOwnedDocumentsPolicy(opts.Documents) {
+		return fmt.Errorf("unknown owned documents policy %q", opts.Documents)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var id string
+	err = tx.QueryRow(ctx, `SELECT id FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, userID).Scan(&id)
+	if err != nil {
+		return notFound(err)
+	}
+
+	if opts.Documents == OwnedDocumentsTransfer {
+		if opts.TransferTo == userID || validID(opts.TransferTo) != nil {
+			return ErrInvalidTransferTarget
+		}
+		err := tx.QueryRow(ctx, `SELECT id FROM users WHERE id = $1 AND deleted_at IS NULL FOR SHARE`, opts.TransferTo).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInvalidTransferTarget
+		}
+		if err != nil {
+			return err
+		}
+
+		// The heir must already work with the account; see
+		// ErrInvalidTransferTarget.
+		relatedQuery := `
+			SELECT EXISTS(SELECT 1 FROM document_permissions p JOIN documents d ON d.id = p.document_id
+				WHERE d.owner_id = $1 AND p.user_id = $2)
+			OR EXISTS(SELECT 1 FROM folder_permissions p JOIN folders f ON f.id = p.folder_id
+				WHERE f.owner_id = $1 AND p.user_id = $2)
+			OR EXISTS(SELECT 1 FROM team_members a JOIN team_members b ON b.team_id = a.team_id
+				WHERE a.user_id = $1 AND b.user_id = $2)
+			OR EXISTS(SELECT 1 FROM workspaces w
+				WHERE (w.owner_id = $1 OR EXISTS(SELECT 1 FROM workspace_members m WHERE m.workspace_id = w.id AND m.user_id = $1))
+				AND (w.owner_id = $2 OR EXISTS(SELECT 1 FROM workspace_members m WHERE m.workspace_id = w.id AND m.user_id = $2)))
+		`
+		var related bool
+		if err := tx.QueryRow(ctx, relatedQuery, userID, opts.TransferTo).Scan(&related); err != nil {
+			return err
+		}
+		if !related {
+			return ErrInvalidTransferTarget
 		}
-		return false, err
 	}
 
-	return exists, nil
+	// Folders in other people's workspaces stay there, with the workspace
+	// owner, whatever the policy.
+	keepFoldersQuery := `
+		UPDATE folders SET owner_id = (SELECT owner_id FROM workspaces WHERE id = folders.workspace_id)
+		WHERE owner_id = $1 AND workspace_id IN (SELECT id FROM workspaces WHERE owner_id <> $1)
+	`
+	if _, err := tx.Exec(ctx, keepFoldersQuery, userID); err != nil {
+		return err
+	}
+
+	var queries []string
+	switch opts.Documents {
+	case OwnedDocumentsTransfer:
+		// The new owner's own grants on what they receive are implied by
+		// ownership now.
+		transferQueries := []string{
+			`DELETE FROM document_permissions WHERE user_id = $2 AND document_id IN (SELECT id FROM documents WHERE owner_id = $1)`,
+			`DELETE FROM access_requests WHERE user_id = $2 AND document_id IN (SELECT id FROM documents WHERE owner_id = $1)`,
+			`UPDATE documents SET owner_id = $2 WHERE owner_id = $1`,
+			`UPDATE share_links SET created_by = $2 WHERE created_by = $1`,
+			`UPDATE document_invitations SET invited_by = $2 WHERE invited_by = $1`,
+			`DELETE FROM workspace_members WHERE user_id = $2 AND workspace_id IN (SELECT id FROM workspaces WHERE owner_id = $1)`,
+			`UPDATE workspaces SET owner_id = $2 WHERE owner_id = $1`,
+			`DELETE FROM folder_permissions WHERE user_id = $2 AND folder_id IN (SELECT id FROM folders WHERE owner_id = $1)`,
+			`UPDATE folders SET owner_id = $2 WHERE owner_id = $1`,
+		}
+		for _, query := range transferQueries {
+			if _, err := tx.Exec(ctx, query, userID, opts.TransferTo); err != nil {
+				return err
+			}
+		}
+	case OwnedDocumentsDelete:
+		queries = []string{
+			`DELETE FROM documents WHERE owner_id = $1`,
+			`DELETE FROM workspaces WHERE owner_id = $1`,
+			`DELETE FROM folders WHERE owner_id = $1`,
+		}
+	case OwnedDocumentsOrphan:
+		queries = []string{
+			`DELETE FROM share_links WHERE document_id IN (SELECT id FROM documents WHERE owner_id = $1)`,
+			`DELETE FROM document_invitations WHERE document_id IN (SELECT id FROM documents WHERE owner_id = $1)`,
+			`DELETE FROM access_requests WHERE document_id IN (SELECT id FROM documents WHERE owner_id = $1)`,
+		}
+	}
+
+	queries = append(queries, `
+		UPDATE team_members SET role = 'owner'
+		WHERE team_id IN (SELECT team_id FROM team_members WHERE user_id = $1 AND role = 'owner')
+		AND user_id = (
+			SELECT m.user_id FROM team_members m
+			WHERE m.team_id = team_members.team_id AND m.user_id <> $1
+			ORDER BY m.role = 'admin' DESC, m.created_at, m.user_id
+			LIMIT 1
+		)
+	`, `
+		DELETE FROM teams
+		WHERE id IN (SELECT team_id FROM team_members WHERE user_id = $1)
+		AND NOT EXISTS (SELECT 1 FROM team_members m WHERE m.team_id = teams.id AND m.user_id <> $1)
+	`)
+	for _, query := range queries {
+		if _, err := tx.Exec(ctx, query, userID); err != nil {
+			return err
+		}
+	}
+
+	var ownsAnything bool
+	ownsQuery := `
+		SELECT EXISTS(SELECT 1 FROM documents WHERE owner_id = $1)
+			OR EXISTS(SELECT 1 FROM workspaces WHERE owner_id = $1)
+			OR EXISTS(SELECT 1 FROM folders WHERE owner_id = $1)
+	`
+	if err := tx.QueryRow(ctx, ownsQuery, userID).Scan(&ownsAnything); err != nil {
+		return err
+	}
+	if ownsAnything {
+		for _, table := range userRowTables {
+			if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, userID); err != nil {
+				return err
+			}
+		}
+		anonymizeQuery := `
+			UPDATE users SET email = 'deleted:' || id::text, password_hash = '', email_verified_at = NULL,
+				totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0,
+				display_name = '', avatar_url = '', locale = '', timezone = '', deleted_at = NOW()
+			WHERE id = $1
+		`
+		if _, err := tx.Exec(ctx, anonymizeQuery, userID); err != nil {
+			return err
+		}
+	} else if _, err := tx.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
-func (s *PostgresStore) GetDocument(documentID string) (*Document, error) {
-	doc := &Document{}
-	query := `SELECT id, title, owner_id, content, version FROM documents WHERE id = $1`
+func (s *PostgresStore) CreateRefreshToken(ctx context.Context, userID string, expiresAt time.Time) (*RefreshToken, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return nil, err
+	}
+	return insertRefreshToken(ctx, s.pool, userID, uuid.NewString(), expiresAt)
+}
+
+// pgQueryRower is satisfied by both *pgxpool.Pool and pgx.Tx.
+type pgQueryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func insertRefreshToken(ctx context.Context, q pgQueryRower, userID, sessionID string, expiresAt time.Time) (*RefreshToken, error) {
+	rt, tokenHash, err := newRefreshToken(userID, sessionID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+		INSERT INTO refresh_tokens (user_id, session_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	if err := q.QueryRow(ctx, query, userID, sessionID, tokenHash, expiresAt).Scan(&rt.ID, &rt.CreatedAt); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+func (s *PostgresStore) RotateRefreshToken(ctx context.Context, token string, expiresAt time.Time) (*RefreshToken, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
 
-	err := s.pool.QueryRow(context.Background(), query, documentID).Scan(
-		&doc.ID, &doc.Title, &doc.OwnerID, &doc.Content, &doc.Version,
+	// FOR UPDATE makes concurrent refreshes with the same token queue up, so
+	// only the first one rotates and the rest count as reuse.
+	var (
+		id    string
+		state refreshTokenState
 	)
+	query := `
+		SELECT id, user_id, session_id, expires_at, used_at IS NOT NULL, revoked_at IS NOT NULL
+		FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE
+	`
+	err = tx.QueryRow(ctx, query, hashToken(token)).
+		Scan(&id, &state.userID, &state.sessionID, &state.expiresAt, &state.used, &state.revoked)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidRefreshToken
+		}
 		return nil, err
 	}
-	return doc, nil
+	if err := state.check(time.Now()); err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			revokeQuery := `UPDATE refresh_tokens SET revoked_at = COALESCE(revoked_at, NOW()) WHERE session_id = $1`
+			if _, rerr := tx.Exec(ctx, revokeQuery, state.sessionID); rerr != nil {
+				return nil, rerr
+			}
+			if rerr := tx.Commit(ctx); rerr != nil {
+				return nil, rerr
+			}
+		}
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1`, id); err != nil {
+		return nil, err
+	}
+	next, err := insertRefreshToken(ctx, tx, state.userID, state.sessionID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return next, nil
 }
 
-func (s *PostgresStore) GetUserDocuments(userID string) ([]*Document, error) {
+func (s *PostgresStore) RevokeRefreshToken(ctx context.Context, userID, token string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var ownerID string
+	err := s.pool.QueryRow(ctx, `SELECT user_id FROM refresh_tokens WHERE token_hash = $1`, hashToken(token)).Scan(&ownerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ownerID != userID {
+		return ErrNotFound
+	}
+
 	query := `
-		SELECT DISTINCT d.id, d.title, d.owner_id, d.content, d.version 
-		FROM documents d 
-		LEFT JOIN document_permissions dp ON d.id = dp.document_id 
-		WHERE d.owner_id = $1 OR dp.user_id = $1
-		ORDER BY d.title
+		UPDATE refresh_tokens SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE session_id = (SELECT session_id FROM refresh_tokens WHERE token_hash = $1)
+	`
+	_, err = s.pool.Exec(ctx, query, hashToken(token))
+	return err
+}
+
+func (s *PostgresStore) CreatePersonalToken(ctx context.Context, userID, name string, scopes []string, expiresAt time.Time) (*PersonalToken, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return nil, err
+	}
+	pt, tokenHash, err := newPersonalToken(userID, name, scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+		INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at)
+		SELECT id, $2, $3, $4, $5 FROM users WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id, created_at
 	`
+	err = s.pool.QueryRow(ctx, query, userID, name, tokenHash, joinScopes(scopes), expiresAt).Scan(&pt.ID, &pt.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return pt, nil
+}
+
+// personalTokenColumns are scanned by scanPersonalToken.
+const personalTokenColumns = `id, user_id, name, scopes, expires_at, last_used_at, created_at`
+
+func scanPersonalToken(row interface{ Scan(...interface{}) error }) (*PersonalToken, error) {
+	pt := &PersonalToken{}
+	var scopes string
+	if err := row.Scan(&pt.ID, &pt.UserID, &pt.Name, &scopes, &pt.ExpiresAt, &pt.LastUsedAt, &pt.CreatedAt); err != nil {
+		return nil, err
+	}
+	pt.Scopes = splitScopes(scopes)
+	return pt, nil
+}
+
+func (s *PostgresStore) ListPersonalTokens(ctx context.Context, userID string) ([]*PersonalToken, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	rows, err := s.pool.Query(context.Background(), query, userID)
+	if err := validID(userID); err != nil {
+		return nil, err
+	}
+	query := `
+		SELECT ` + personalTokenColumns + ` FROM personal_access_tokens
+		WHERE user_id = $1 AND expires_at > NOW()
+		ORDER BY created_at DESC, id
+	`
+	rows, err := s.pool.Query(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var documents []*Document
+	tokens := []*PersonalToken{}
 	for rows.Next() {
-		doc := &Document{}
-		err := rows.Scan(&doc.ID, &doc.Title, &doc.OwnerID, &doc.Content, &doc.Version)
+		pt, err := scanPersonalToken(rows)
 		if err != nil {
 			return nil, err
 		}
-		documents = append(documents, doc)
+		tokens = append(tokens, pt)
 	}
-
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-
-	return documents, nil
+	return tokens, nil
 }
 
-func (s *PostgresStore) UpdateDocument(documentID, content string, version int) error {
-	query := `UPDATE documents SET content = $1, version = $2 WHERE id = $3`
+func (s *PostgresStore) RevokePersonalToken(ctx context.Context, userID, tokenID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	result, err := s.pool.Exec(context.Background(), query, content, version, documentID)
+	if err := validID(userID, tokenID); err != nil {
+		return err
+	}
+	tag, err := s.pool.Exec(ctx, `DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2`, tokenID, userID)
 	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
 
-	if result.RowsAffected() == 0 {
-		return pgx.ErrNoRows
+func (s *PostgresStore) UsePersonalToken(ctx context.Context, token string) (*PersonalToken, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + personalTokenColumns + ` FROM personal_access_tokens WHERE token_hash = $1 AND expires_at > NOW()`
+	pt, err := scanPersonalToken(s.pool.QueryRow(ctx, query, hashToken(token)))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidPersonalToken
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if !pt.usedRecently(now) {
+		if _, err := s.pool.Exec(ctx, `UPDATE personal_access_tokens SET last_used_at = $2 WHERE id = $1`, pt.ID, now); err != nil {
+			return nil, err
+		}
+		pt.LastUsedAt = &now
+	}
+	return pt, nil
+}
+
+func (s *PostgresStore) CreateAccountToken(ctx context.Context, userID, purpose string, expiresAt time.Time) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return "", err
+	}
+	token, err := newToken()
+	if err != nil {
+		return "", err
 	}
 
-	return nil
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var email string
+	if err := tx.QueryRow(ctx, `SELECT email FROM users WHERE id = $1`, userID).Scan(&email); err != nil {
+		return "", notFound(err)
+	}
+	supersede := `UPDATE account_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+	if _, err := tx.Exec(ctx, supersede, userID, purpose); err != nil {
+		return "", err
+	}
+	query := `
+		INSERT INTO account_tokens (user_id, purpose, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	if _, err := tx.Exec(ctx, query, userID, purpose, email, hashToken(token), expiresAt); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeAccountToken marks token used and returns who it was issued to,
+// provided it is valid for purpose.
+func consumeAccountToken(ctx context.Context, tx pgx.Tx, token, purpose string) (accountTokenState, error) {
+	var (
+		id string
+		st accountTokenState
+	)
+	query := `
+		SELECT id, user_id, purpose, email, expires_at, used_at IS NOT NULL
+		FROM account_tokens WHERE token_hash = $1 FOR UPDATE
+	`
+	err := tx.QueryRow(ctx, query, hashToken(token)).Scan(&id, &st.userID, &st.purpose, &st.email, &st.expiresAt, &st.used)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return st, ErrInvalidAccountToken
+		}
+		return st, err
+	}
+	if err := st.check(purpose, time.Now()); err != nil {
+		return st, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE account_tokens SET used_at = NOW() WHERE id = $1`, id); err != nil {
+		return st, err
+	}
+	return st, nil
 }
 
-func (s *PostgresStore) ShareDocument(documentID, ownerID, targetUserID, role string) error {
+func (s *PostgresStore) AccountTokenUser(ctx context.Context, token, purpose string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	// Use a transaction to ensure atomicity:
-	// 1. Verify the person sharing is the owner.
-	// 2. Insert the permission.
-	tx, err := s.pool.Begin(context.Background())
+	var st accountTokenState
+	query := `
+		SELECT user_id, purpose, email, expires_at, used_at IS NOT NULL
+		FROM account_tokens WHERE token_hash = $1
+	`
+	err := s.pool.QueryRow(ctx, query, hashToken(token)).Scan(&st.userID, &st.purpose, &st.email, &st.expiresAt, &st.used)
 	if err != nil {
-		return err
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+	if err := st.check(purpose, time.Now()); err != nil {
+		return nil, err
+	}
+	query = `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND email = $2`
+	user, err := scanUser(s.pool.QueryRow(ctx, query, st.userID, st.email))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *PostgresStore) VerifyEmail(ctx context.Context, token string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
 	}
-	defer tx.Rollback(context.Background()) // Rollback on error
+	defer tx.Rollback(ctx)
 
-	// 1. Verify ownership.
-	var isOwner bool
-	ownerCheckQuery := `SELECT EXISTS(SELECT 1 FROM documents WHERE id = $1 AND owner_id = $2)`
-	err = tx.QueryRow(context.Background(), ownerCheckQuery, documentID, ownerID).Scan(&isOwner)
+	st, err := consumeAccountToken(ctx, tx, token, TokenVerifyEmail)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if !isOwner {
-		return fmt.Errorf("permission denied: only the owner can share this document")
+	// Matching on email leaves the token useless once the address changes.
+	query := `
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW())
+		WHERE id = $1 AND email = $2
+		RETURNING ` + userColumns
+	user, err := scanUser(tx.QueryRow(ctx, query, st.userID, st.email))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
 	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
 
-	// 2. Insert the permission, ignoring conflicts if it already exists.
-	// "ON CONFLICT (document_id, user_id) DO NOTHING" is an "upsert" that prevents errors
-	// if you try to share with the same person twice.
-	insertQuery := `
-        INSERT INTO document_permissions (document_id, user_id, role)
-        VALUES ($1, $2, $3)
-        ON CONFLICT (document_id, user_id) DO NOTHING
-    `
-	_, err = tx.Exec(context.Background(), insertQuery, documentID, targetUserID, role)
+func (s *PostgresStore) ResetPassword(ctx context.Context, token, password string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return tx.Commit(context.Background()) // Commit the transaction
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	st, err := consumeAccountToken(ctx, tx, token, TokenResetPassword)
+	if err != nil {
+		return nil, err
+	}
+	// The reset link was delivered to the address, which proves it too.
+	query := `
+		UPDATE users SET password_hash = $3, email_verified_at = COALESCE(email_verified_at, NOW())
+		WHERE id = $1 AND email = $2
+		RETURNING ` + userColumns
+	user, err := scanUser(tx.QueryRow(ctx, query, st.userID, st.email, string(hashedPassword)))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+	revokeQuery := `UPDATE refresh_tokens SET revoked_at = COALESCE(revoked_at, NOW()) WHERE user_id = $1`
+	if _, err := tx.Exec(ctx, revokeQuery, user.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *PostgresStore) ConsumeAccountToken(ctx context.Context, token, purpose string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	st, err := consumeAccountToken(ctx, tx, token, purpose)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND email = $2`
+	user, err := scanUser(tx.QueryRow(ctx, query, st.userID, st.email))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *PostgresStore) GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + userColumns + ` FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2)
+	`
+	user, err := scanUser(s.pool.QueryRow(ctx, query, issuer, subject))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return user, nil
+}
+
+// pgExecer is satisfied by both *pgxpool.Pool and pgx.Tx.
+type pgExecer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func insertIdentity(ctx context.Context, db pgExecer, userID, issuer, subject string) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id) VALUES ($1, $2, $3)
+		ON CONFLICT (issuer, subject) DO NOTHING
+	`
+	tag, err := db.Exec(ctx, query, issuer, subject, userID)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrIdentityLinked
+	}
+	return nil
+}
+
+func (s *PostgresStore) LinkIdentity(ctx context.Context, userID, issuer, subject string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return err
+	}
+	return insertIdentity(ctx, s.pool, userID, issuer, subject)
+}
+
+func (s *PostgresStore) CreateUserWithIdentity(ctx context.Context, email string, emailVerified bool, issuer, subject string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// An empty password hash never matches, so the account has no password.
+	user := &User{Email: email, EmailVerified: emailVerified}
+	query := `
+		INSERT INTO users (email, password_hash, email_verified_at)
+		VALUES ($1, '', CASE WHEN $2 THEN NOW() END)
+		RETURNING id
+	`
+	if err := tx.QueryRow(ctx, query, email, emailVerified).Scan(&user.ID); err != nil {
+		return nil, err
+	}
+	if err := insertIdentity(ctx, tx, user.ID, issuer, subject); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *PostgresStore) SetTOTPSecret(ctx context.Context, userID string, secret []byte) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return err
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var enabled bool
+	query := `SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRow(ctx, query, userID).Scan(&enabled); err != nil {
+		return notFound(err)
+	}
+	if enabled {
+		return ErrTOTPEnabled
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET totp_secret = $2 WHERE id = $1`, userID, secret); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) GetTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return nil, err
+	}
+	totp := &TOTP{}
+	query := `SELECT totp_secret, totp_enabled_at IS NOT NULL, totp_last_step FROM users WHERE id = $1`
+	if err := s.pool.QueryRow(ctx, query, userID).Scan(&totp.Secret, &totp.Enabled, &totp.LastStep); err != nil {
+		return nil, notFound(err)
+	}
+	return totp, nil
+}
+
+func (s *PostgresStore) EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodes []string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return err
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var enabled bool
+	query := `SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = $1 AND totp_secret IS NOT NULL FOR UPDATE`
+	if err := tx.QueryRow(ctx, query, userID).Scan(&enabled); err != nil {
+		return notFound(err)
+	}
+	if enabled {
+		return ErrTOTPEnabled
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $2 WHERE id = $1`, userID, step); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, code := range recoveryCodes {
+		insert := `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		if _, err := tx.Exec(ctx, insert, userID, hashToken(code)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) RecordTOTPStep(ctx context.Context, userID string, step int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return err
+	}
+	query := `UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2`
+	tag, err := s.pool.Exec(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTOTPCodeReused
+	}
+	return nil
+}
+
+func (s *PostgresStore) UseRecoveryCode(ctx context.Context, userID, code string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return err
+	}
+	query := `UPDATE user_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	tag, err := s.pool.Exec(ctx, query, userID, hashToken(code))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrInvalidRecoveryCode
+	}
+	return nil
+}
+
+func (s *PostgresStore) DisableTOTP(ctx context.Context, userID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(userID); err != nil {
+		return err
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = $1`
+	tag, err := tx.Exec(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) CheckDocumentPermission(ctx context.Context, documentID, userID string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	query := documentAccessCTE("$1") + `
+		SELECT EXISTS (SELECT 1 FROM document_access WHERE document_id = $2)
+	`
+
+	err := s.pool.QueryRow(ctx, query, userID, documentID).Scan(&exists)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (s *PostgresStore) GetDocumentRole(ctx context.Context, documentID, userID string) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rank, err := s.documentRank(ctx, documentID, userID)
+	if err != nil {
+		return "", err
+	}
+	return rankRole(rank), nil
+}
+
+func (s *PostgresStore) GetDocument(ctx context.Context, documentID string) (*Document, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	doc := &Document{}
+	query := `SELECT id, title, owner_id, content, version, created_at, updated_at FROM documents WHERE id = $1`
+
+	err := s.pool.QueryRow(ctx, query, documentID).Scan(
+		&doc.ID, &doc.Title, &doc.OwnerID, &doc.Content, &doc.Version, &doc.CreatedAt, &doc.UpdatedAt,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return doc, nil
+}
+
+func (s *PostgresStore) GetUserDocuments(ctx context.Context, userID string) ([]*Document, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := documentAccessCTE("$1") + `
+		SELECT d.id, d.title, d.owner_id, d.content, d.version, d.created_at, d.updated_at
+		FROM documents d
+		JOIN document_access a ON a.document_id = d.id
+		ORDER BY d.title
+	`
+
+	rows, err := s.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var documents []*Document
+	for rows.Next() {
+		doc := &Document{}
+		err := rows.Scan(&doc.ID, &doc.Title, &doc.OwnerID, &doc.Content, &doc.Version, &doc.CreatedAt, &doc.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+func (s *PostgresStore) UpdateDocument(ctx context.Context, documentID, content string, version int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE documents SET content = $1, version = $2, updated_at = NOW() WHERE id = $3`
+
+	result, err := s.pool.Exec(ctx, query, content, version, documentID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) ShareDocument(ctx context.Context, documentID, ownerID, targetUserID, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// Use a transaction to ensure atomicity:
+	// 1. Verify the person sharing is the owner.
+	// 2. Insert the permission.
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // Rollback on error
+
+	// 1. Verify ownership.
+	var isOwner bool
+	ownerCheckQuery := `SELECT EXISTS(SELECT 1 FROM documents WHERE id = $1 AND owner_id = $2)`
+	err = tx.QueryRow(ctx, ownerCheckQuery, documentID, ownerID).Scan(&isOwner)
+	if err != nil {
+		return err
+	}
+	if !isOwner {
+		return ErrNotDocumentOwner
+	}
+
+	// 2. Insert the permission, ignoring conflicts if it already exists.
+	// "ON CONFLICT (document_id, user_id) DO NOTHING" is an "upsert" that prevents errors
+	// if you try to share with the same person twice.
+	insertQuery := `
+        INSERT INTO document_permissions (document_id, user_id, role)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (document_id, user_id) DO NOTHING
+    `
+	_, err = tx.Exec(ctx, insertQuery, documentID, targetUserID, role)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx) // Commit the transaction
+}
+
+// pgSortExprs maps sort keys to SQL; never-opened documents sort as if opened at the epoch.
+var pgSortExprs = map[string]string{
+	SortTitle:      "d.title",
+	SortCreated:    "d.created_at",
+	SortUpdated:    "d.updated_at",
+	SortLastOpened: "COALESCE(o.opened_at, 'epoch'::timestamptz)",
+}
+
+func (s *PostgresStore) ListDocuments(ctx context.Context, userID string, opts DocumentListOptions) (*DocumentPage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	opts = opts.normalize()
+	cursor, err := decodeCursor(opts.Cursor, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sortExpr := pgSortExprs[opts.Sort]
+	roleExpr := rankRoleSQL("a.role_rank")
+	contentExpr := `''`
+	if opts.IncludeContent {
+		contentExpr = `COALESCE(d.content, '')`
+	}
+
+	args := []interface{}{userID}
+	conditions := []string{"TRUE"}
+	switch opts.Ownership {
+	case OwnershipOwned:
+		conditions = append(conditions, "d.owner_id = $1")
+	case OwnershipShared:
+		conditions = append(conditions, "d.owner_id <> $1")
+	}
+	if opts.Role != "" {
+		args = append(args, opts.Role)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", roleExpr, len(args)))
+	}
+	if opts.FolderID != "" {
+		if validID(opts.FolderID) != nil {
+			return pageOf(nil, opts), nil
+		}
+		args = append(args, opts.FolderID)
+		conditions = append(conditions, fmt.Sprintf("d.folder_id = $%d", len(args)))
+	}
+	if opts.FavoritesOnly {
+		conditions = append(conditions, "fv.user_id IS NOT NULL")
+	}
+	for _, tag := range opts.Tags {
+		args = append(args, tag)
+		conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM document_tags t WHERE t.document_id = d.id AND t.tag = $%d)", len(args)))
+	}
+
+	direction, comparison := "ASC", ">"
+	if opts.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if cursor != nil {
+		if _, err := uuid.Parse(cursor.ID); err != nil {
+			return nil, ErrInvalidCursor
+		}
+		var key interface{} = cursor.Key
+		if opts.Sort != SortTitle {
+			if key, err = parseCursorTime(cursor.Key); err != nil {
+				return nil, err
+			}
+		}
+		args = append(args, key, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, d.id) %s ($%d, $%d::uuid)", sortExpr, comparison, len(args)-1, len(args)))
+	}
+	args = append(args, opts.Limit+1)
+
+	query := documentAccessCTE("$1") + fmt.Sprintf(`
+		SELECT d.id, d.title, d.owner_id, %s, COALESCE(d.folder_id::text, ''), fv.user_id IS NOT NULL,
+			d.version, d.created_at, d.updated_at, o.opened_at, %s
+		FROM documents d
+		JOIN document_access a ON a.document_id = d.id
+		LEFT JOIN document_opens o ON o.document_id = d.id AND o.user_id = $1
+		LEFT JOIN document_favorites fv ON fv.document_id = d.id AND fv.user_id = $1
+		WHERE %s
+		ORDER BY %s %s, d.id %s
+		LIMIT $%d
+	`, roleExpr, contentExpr, strings.Join(conditions, " AND "), sortExpr, direction, direction, len(args))
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []*DocumentSummary
+	for rows.Next() {
+		d := &DocumentSummary{}
+		err := rows.Scan(&d.ID, &d.Title, &d.OwnerID, &d.Role, &d.FolderID, &d.Favorite, &d.Version, &d.CreatedAt, &d.UpdatedAt, &d.LastOpenedAt, &d.Content)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := pageOf(summaries, opts)
+	if err := s.attachTags(ctx, page.Documents); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// attachTags fills in the Tags of summaries with a single query.
+func (s *PostgresStore) attachTags(ctx context.Context, summaries []*DocumentSummary) error {
+	if len(summaries) == 0 {
+		return nil
+	}
+	byID := make(map[string]*DocumentSummary, len(summaries))
+	ids := make([]string, 0, len(summaries))
+	for _, d := range summaries {
+		byID[d.ID] = d
+		ids = append(ids, d.ID)
+	}
+
+	query := `SELECT document_id, tag FROM document_tags WHERE document_id = ANY($1::text[]::uuid[]) ORDER BY tag`
+	rows, err := s.pool.Query(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var documentID, tag string
+		if err := rows.Scan(&documentID, &tag); err != nil {
+			return err
+		}
+		byID[documentID].Tags = append(byID[documentID].Tags, tag)
+	}
+	return rows.Err()
+}
+
+func (s *PostgresStore) RecordDocumentOpen(ctx context.Context, documentID, userID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO document_opens (document_id, user_id, opened_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (document_id, user_id) DO UPDATE SET opened_at = EXCLUDED.opened_at
+	`
+	_, err := s.pool.Exec(ctx, query, documentID, userID)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+		return ErrNotFound
+	}
+	return err
+}
+
+// htmlEscapeSQL escapes &, < and > in the text expression expr, as
+// snippetEscaper does, so ts_headline only adds markup of its own. Its
+// parser reads the entities as single tokens and copies them into the
+// headline unchanged.
+func htmlEscapeSQL(expr string) string {
+	return fmt.Sprintf(`replace(replace(replace(%s, '&', '&amp;'), '<', '&lt;'), '>', '&gt;')`, expr)
+}
+
+func (s *PostgresStore) SearchDocuments(ctx context.Context, userID, query string, limit int) ([]*SearchResult, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	headlineOptions := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=%d, MinWords=5, MaxFragments=2", HighlightStart, HighlightStop, snippetWords)
+	sqlQuery := documentAccessCTE("$1") + fmt.Sprintf(`
+		SELECT d.id, d.title, d.owner_id,
+			%s,
+			ts_rank(d.search_vector, q),
+			ts_headline('english', %s, q, $4)
+		FROM documents d
+		CROSS JOIN websearch_to_tsquery('english', $2) AS q
+		JOIN document_access a ON a.document_id = d.id
+		WHERE d.search_vector @@ q
+		ORDER BY 5 DESC, d.id
+		LIMIT $3
+	`, rankRoleSQL("a.role_rank"), htmlEscapeSQL("coalesce(d.content, '')"))
+
+	rows, err := s.pool.Query(ctx, sqlQuery, userID, query, limit, headlineOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+	for rows.Next() {
+		r := &SearchResult{}
+		var rank float32
+		if err := rows.Scan(&r.ID, &r.Title, &r.OwnerID, &r.Role, &rank, &r.Snippet); err != nil {
+			return nil, err
+		}
+		r.Rank = float64(rank)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *PostgresStore) MoveDocument(ctx context.Context, documentID, userID, folderID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(documentID); err != nil {
+		return err
+	}
+	var ownerID string
+	err := s.pool.QueryRow(ctx, `SELECT owner_id FROM documents WHERE id = $1`, documentID).Scan(&ownerID)
+	if err != nil {
+		return notFound(err)
+	}
+	if ownerID != userID {
+		return ErrPermissionDenied
+	}
+
+	var folder interface{}
+	if folderID != "" {
+		rank, err := s.folderRank(ctx, folderID, userID)
+		if err != nil {
+			return err
+		}
+		if rank < roleRank(RoleEditor) {
+			return ErrPermissionDenied
+		}
+		folder = folderID
+	}
+
+	_, err = s.pool.Exec(ctx, `UPDATE documents SET folder_id = $1 WHERE id = $2`, folder, documentID)
+	return err
+}
+
+// folderRank returns userID's rank on folderID, or ErrNotFound if the folder
+// doesn't exist.
+func (s *PostgresStore) folderRank(ctx context.Context, folderID, userID string) (int, error) {
+	if err := validID(folderID); err != nil {
+		return 0, err
+	}
+	query := folderAccessCTE("$1") + `
+		SELECT COALESCE(fa.role_rank, 0)
+		FROM folders f
+		LEFT JOIN folder_access fa ON fa.folder_id = f.id
+		WHERE f.id = $2
+	`
+	var rank int
+	if err := s.pool.QueryRow(ctx, query, userID, folderID).Scan(&rank); err != nil {
+		return 0, notFound(err)
+	}
+	return rank, nil
+}
+
+func (s *PostgresStore) CreateWorkspace(ctx context.Context, name, ownerID string) (*Workspace, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	ws := &Workspace{Name: name, OwnerID: ownerID, Role: RoleOwner}
+	query := `INSERT INTO workspaces (name, owner_id) VALUES ($1, $2) RETURNING id, created_at`
+
+	if err := s.pool.QueryRow(ctx, query, name, ownerID).Scan(&ws.ID, &ws.CreatedAt); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func (s *PostgresStore) ListWorkspaces(ctx context.Context, userID string) ([]*Workspace, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT w.id, w.name, w.owner_id, CASE WHEN w.owner_id = $1 THEN 'owner' ELSE wm.role END, w.created_at
+		FROM workspaces w
+		LEFT JOIN workspace_members wm ON wm.workspace_id = w.id AND wm.user_id = $1
+		WHERE w.owner_id = $1 OR wm.user_id IS NOT NULL
+		ORDER BY w.name, w.id
+	`
+
+	rows, err := s.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []*Workspace{}
+	for rows.Next() {
+		ws := &Workspace{}
+		if err := rows.Scan(&ws.ID, &ws.Name, &ws.OwnerID, &ws.Role, &ws.CreatedAt); err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, ws)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return workspaces, nil
+}
+
+// checkWorkspaceOwner returns ErrNotFound or ErrPermissionDenied unless
+// ownerID owns workspaceID.
+func (s *PostgresStore) checkWorkspaceOwner(ctx context.Context, workspaceID, ownerID string) error {
+	if err := validID(workspaceID); err != nil {
+		return err
+	}
+	var actualOwner string
+	err := s.pool.QueryRow(ctx, `SELECT owner_id FROM workspaces WHERE id = $1`, workspaceID).Scan(&actualOwner)
+	if err != nil {
+		return notFound(err)
+	}
+	if actualOwner != ownerID {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+func (s *PostgresStore) AddWorkspaceMember(ctx context.Context, workspaceID, ownerID, userID, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkWorkspaceOwner(ctx, workspaceID, ownerID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO workspace_members (workspace_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = EXCLUDED.role
+	`
+	_, err := s.pool.Exec(ctx, query, workspaceID, userID, role)
+	return err
+}
+
+func (s *PostgresStore) RemoveWorkspaceMember(ctx context.Context, workspaceID, ownerID, userID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkWorkspaceOwner(ctx, workspaceID, ownerID); err != nil {
+		return err
+	}
+	if err := validID(userID); err != nil {
+		return err
+	}
+
+	result, err := s.pool.Exec(ctx, `DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2`, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) CreateFolder(ctx context.Context, name, ownerID, parentID, workspaceID string) (*Folder, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	folder := &Folder{Name: name, OwnerID: ownerID, Role: RoleOwner}
+	switch {
+	case parentID != "":
+		rank, err := s.folderRank(ctx, parentID, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		if rank < roleRank(RoleEditor) {
+			return nil, ErrPermissionDenied
+		}
+		err = s.pool.QueryRow(ctx, `SELECT COALESCE(workspace_id::text, '') FROM folders WHERE id = $1`, parentID).Scan(&folder.WorkspaceID)
+		if err != nil {
+			return nil, notFound(err)
+		}
+		folder.ParentID = parentID
+	case workspaceID != "":
+		if err := validID(workspaceID); err != nil {
+			return nil, err
+		}
+		query := `
+			SELECT CASE WHEN w.owner_id = $1 THEN 'editor' ELSE COALESCE(wm.role, '') END
+			FROM workspaces w
+			LEFT JOIN workspace_members wm ON wm.workspace_id = w.id AND wm.user_id = $1
+			WHERE w.id = $2
+		`
+		var role string
+		if err := s.pool.QueryRow(ctx, query, ownerID, workspaceID).Scan(&role); err != nil {
+			return nil, notFound(err)
+		}
+		if roleRank(role) < roleRank(RoleEditor) {
+			return nil, ErrPermissionDenied
+		}
+		folder.WorkspaceID = workspaceID
+	}
+
+	query := `
+		INSERT INTO folders (name, owner_id, parent_id, workspace_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	err := s.pool.QueryRow(ctx, query, name, ownerID, nullableID(folder.ParentID), nullableID(folder.WorkspaceID)).Scan(&folder.ID, &folder.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+// nullableID maps an empty optional ID to SQL NULL.
+func nullableID(id string) interface{} {
+	if id == "" {
+		return nil
+	}
+	return id
+}
+
+// pgFolderColumns are scanned by scanFolder, followed by the caller's rank.
+const pgFolderColumns = `f.id, f.name, f.owner_id, COALESCE(f.parent_id::text, ''), COALESCE(f.workspace_id::text, ''), f.created_at, COALESCE(fa.role_rank, 0)`
+
+func scanFolder(row pgx.Row, userID string) (*Folder, int, error) {
+	f := &Folder{}
+	var rank int
+	if err := row.Scan(&f.ID, &f.Name, &f.OwnerID, &f.ParentID, &f.WorkspaceID, &f.CreatedAt, &rank); err != nil {
+		return nil, 0, err
+	}
+	f.Role = folderRole(f, userID, rank)
+	return f, rank, nil
+}
+
+func (s *PostgresStore) GetFolder(ctx context.Context, folderID, userID string) (*Folder, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(folderID); err != nil {
+		return nil, err
+	}
+	query := folderAccessCTE("$1") + `
+		SELECT ` + pgFolderColumns + `
+		FROM folders f
+		LEFT JOIN folder_access fa ON fa.folder_id = f.id
+		WHERE f.id = $2
+	`
+	folder, rank, err := scanFolder(s.pool.QueryRow(ctx, query, userID, folderID), userID)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if rank == 0 {
+		return nil, ErrPermissionDenied
+	}
+	return folder, nil
+}
+
+func (s *PostgresStore) ListFolders(ctx context.Context, userID, parentID string) ([]*Folder, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	args := []interface{}{userID}
+	// Without a parent, list the folders whose parent the user can't see:
+	// their own top-level folders, workspace roots and folders shared with them.
+	condition := `f.parent_id IS NULL OR f.parent_id NOT IN (SELECT folder_id FROM folder_access)`
+	if parentID != "" {
+		rank, err := s.folderRank(ctx, parentID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if rank == 0 {
+			return nil, ErrPermissionDenied
+		}
+		args = append(args, parentID)
+		condition = `f.parent_id = $2`
+	}
+
+	query := folderAccessCTE("$1") + `
+		SELECT ` + pgFolderColumns + `
+		FROM folders f
+		JOIN folder_access fa ON fa.folder_id = f.id
+		WHERE ` + condition + `
+		ORDER BY f.name, f.id
+	`
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []*Folder{}
+	for rows.Next() {
+		folder, _, err := scanFolder(rows, userID)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+func (s *PostgresStore) ShareFolder(ctx context.Context, folderID, ownerID, targetUserID, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(folderID); err != nil {
+		return err
+	}
+	var actualOwner string
+	err := s.pool.QueryRow(ctx, `SELECT owner_id FROM folders WHERE id = $1`, folderID).Scan(&actualOwner)
+	if err != nil {
+		return notFound(err)
+	}
+	if actualOwner != ownerID {
+		return ErrPermissionDenied
+	}
+
+	query := `
+		INSERT INTO folder_permissions (folder_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (folder_id, user_id) DO NOTHING
+	`
+	_, err = s.pool.Exec(ctx, query, folderID, targetUserID, role)
+	return err
+}
+
+// documentRank returns userID's rank on documentID, 0 for no access, or
+// ErrNotFound if documentID doesn't exist.
+func (s *PostgresStore) documentRank(ctx context.Context, documentID, userID string) (int, error) {
+	if err := validID(documentID); err != nil {
+		return 0, err
+	}
+	query := documentAccessCTE("$1") + `
+		SELECT COALESCE(a.role_rank, 0)
+		FROM documents d
+		LEFT JOIN document_access a ON a.document_id = d.id
+		WHERE d.id = $2
+	`
+	var rank int
+	if err := s.pool.QueryRow(ctx, query, userID, documentID).Scan(&rank); err != nil {
+		return 0, notFound(err)
+	}
+	return rank, nil
+}
+
+// requireDocumentRole returns ErrNotFound if documentID doesn't exist, or
+// ErrPermissionDenied unless userID's role on it is at least min.
+func (s *PostgresStore) requireDocumentRole(ctx context.Context, documentID, userID, min string) error {
+	rank, err := s.documentRank(ctx, documentID, userID)
+	if err != nil {
+		return err
+	}
+	if rank < roleRank(min) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+func (s *PostgresStore) SetFavorite(ctx context.Context, documentID, userID string, favorite bool) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.requireDocumentRole(ctx, documentID, userID, RoleViewer); err != nil {
+		return err
+	}
+
+	query := `DELETE FROM document_favorites WHERE document_id = $1 AND user_id = $2`
+	if favorite {
+		query = `
+			INSERT INTO document_favorites (document_id, user_id)
+			VALUES ($1, $2)
+			ON CONFLICT (document_id, user_id) DO NOTHING
+		`
+	}
+	_, err := s.pool.Exec(ctx, query, documentID, userID)
+	return err
+}
+
+func (s *PostgresStore) GetDocumentTags(ctx context.Context, documentID, userID string) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.requireDocumentRole(ctx, documentID, userID, RoleViewer); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT tag FROM document_tags WHERE document_id = $1 ORDER BY tag`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (s *PostgresStore) AddDocumentTag(ctx context.Context, documentID, userID, tag string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.requireDocumentRole(ctx, documentID, userID, RoleEditor); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO document_tags (document_id, tag)
+		VALUES ($1, $2)
+		ON CONFLICT (document_id, tag) DO NOTHING
+	`
+	_, err := s.pool.Exec(ctx, query, documentID, tag)
+	return err
+}
+
+func (s *PostgresStore) RemoveDocumentTag(ctx context.Context, documentID, userID, tag string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.requireDocumentRole(ctx, documentID, userID, RoleEditor); err != nil {
+		return err
+	}
+
+	_, err := s.pool.Exec(ctx, `DELETE FROM document_tags WHERE document_id = $1 AND tag = $2`, documentID, tag)
+	return err
+}
+
+func (s *PostgresStore) ListTags(ctx context.Context, userID string) ([]*TagCount, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := documentAccessCTE("$1") + `
+		SELECT t.tag, COUNT(*)
+		FROM document_tags t
+		JOIN document_access a ON a.document_id = t.document_id
+		GROUP BY t.tag
+		ORDER BY t.tag
+	`
+	rows, err := s.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*TagCount{}
+	for rows.Next() {
+		tc := &TagCount{}
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (s *PostgresStore) ShareDocumentWithTeam(ctx context.Context, documentID, ownerID, teamID, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(documentID, teamID); err != nil {
+		return err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var isOwner, teamExists bool
+	checkQuery := `
+		SELECT EXISTS(SELECT 1 FROM documents WHERE id = $1 AND owner_id = $2),
+			EXISTS(SELECT 1 FROM teams WHERE id = $3)
+	`
+	if err := tx.QueryRow(ctx, checkQuery, documentID, ownerID, teamID).Scan(&isOwner, &teamExists); err != nil {
+		return err
+	}
+	if !isOwner {
+		return ErrNotDocumentOwner
+	}
+	if !teamExists {
+		return ErrNotFound
+	}
+
+	insertQuery := `
+		INSERT INTO team_document_permissions (document_id, team_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (document_id, team_id) DO NOTHING
+	`
+	if _, err := tx.Exec(ctx, insertQuery, documentID, teamID, role); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+const pgInvitationColumns = `id, document_id, email, role, invited_by, created_at`
+
+func scanInvitation(row pgx.Row) (*Invitation, error) {
+	inv := &Invitation{}
+	if err := row.Scan(&inv.ID, &inv.DocumentID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.CreatedAt); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (s *PostgresStore) InviteToDocument(ctx context.Context, documentID, ownerID, email, role string) (*Invitation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	// The no-op update makes RETURNING yield the existing row on conflict.
+	query := `
+		INSERT INTO document_invitations (document_id, email, role, invited_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (document_id, email) DO UPDATE SET email = EXCLUDED.email
+		RETURNING ` + pgInvitationColumns
+	return scanInvitation(s.pool.QueryRow(ctx, query, documentID, invitationEmail(email), role, ownerID))
+}
+
+func (s *PostgresStore) ListDocumentShares(ctx context.Context, documentID, ownerID string) ([]*DocumentShare, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	shares := []*DocumentShare{}
+	userQuery := `
+		SELECT p.user_id, u.email, p.role
+		FROM document_permissions p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.document_id = $1
+		ORDER BY u.email
+	`
+	rows, err := s.pool.Query(ctx, userQuery, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		share := &DocumentShare{}
+		if err := rows.Scan(&share.UserID, &share.Email, &share.Role); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	teamQuery := `
+		SELECT tp.team_id, t.name, tp.role
+		FROM team_document_permissions tp
+		JOIN teams t ON t.id = tp.team_id
+		WHERE tp.document_id = $1
+		ORDER BY t.name
+	`
+	rows, err = s.pool.Query(ctx, teamQuery, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		share := &DocumentShare{}
+		if err := rows.Scan(&share.TeamID, &share.TeamName, &share.Role); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+func (s *PostgresStore) ListInvitations(ctx context.Context, documentID, ownerID string) ([]*Invitation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + pgInvitationColumns + ` FROM document_invitations WHERE document_id = $1 ORDER BY email`
+	rows, err := s.pool.Query(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (s *PostgresStore) CancelInvitation(ctx context.Context, documentID, invitationID, ownerID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return err
+	}
+	if err := validID(invitationID); err != nil {
+		return err
+	}
+
+	tag, err := s.pool.Exec(ctx, `DELETE FROM document_invitations WHERE id = $1 AND document_id = $2`, invitationID, documentID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) ClaimInvitations(ctx context.Context, email, userID string) ([]*Invitation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `DELETE FROM document_invitations WHERE email = $1 RETURNING ` + pgInvitationColumns
+	rows, err := tx.Query(ctx, query, invitationEmail(email))
+	if err != nil {
+		return nil, err
+	}
+	claimed := []*Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		claimed = append(claimed, inv)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	insertQuery := `
+		INSERT INTO document_permissions (document_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (document_id, user_id) DO NOTHING
+	`
+	for _, inv := range claimed {
+		if _, err := tx.Exec(ctx, insertQuery, inv.DocumentID, userID, inv.Role); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// pgAccessRequestColumns are scanned by scanAccessRequest; queries alias
+// access_requests as r and join users as u.
+const pgAccessRequestColumns = `r.id, r.document_id, r.user_id, u.email, r.role, r.message, r.status, r.created_at, r.decided_at`
+
+func scanAccessRequest(row pgx.Row) (*AccessRequest, error) {
+	req := &AccessRequest{}
+	err := row.Scan(&req.ID, &req.DocumentID, &req.UserID, &req.Email, &req.Role, &req.Message,
+		&req.Status, &req.CreatedAt, &req.DecidedAt)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// grantRoleSQL upserts a document_permissions row without ever lowering an
+// existing role.
+var grantRoleSQL = fmt.Sprintf(`
+	INSERT INTO document_permissions (document_id, user_id, role)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (document_id, user_id) DO UPDATE
+	SET role = CASE WHEN %s > %s THEN EXCLUDED.role ELSE document_permissions.role END
+`, roleRankSQL("EXCLUDED.role"), roleRankSQL("document_permissions.role"))
+
+func (s *PostgresStore) RequestAccess(ctx context.Context, documentID, userID, role, message string) (*AccessRequest, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rank, err := s.documentRank(ctx, documentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if rank >= roleRank(role) {
+		return nil, ErrAlreadyHasAccess
+	}
+
+	query := `
+		WITH r AS (
+			INSERT INTO access_requests (document_id, user_id, role, message)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (document_id, user_id) WHERE status = 'pending'
+			DO UPDATE SET role = EXCLUDED.role, message = EXCLUDED.message, created_at = NOW()
+			RETURNING *
+		)
+		SELECT ` + pgAccessRequestColumns + ` FROM r JOIN users u ON u.id = r.user_id
+	`
+	return scanAccessRequest(s.pool.QueryRow(ctx, query, documentID, userID, role, message))
+}
+
+func (s *PostgresStore) ListAccessRequests(ctx context.Context, documentID, ownerID string) ([]*AccessRequest, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + pgAccessRequestColumns + `
+		FROM access_requests r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.document_id = $1 AND r.status = 'pending'
+		ORDER BY r.created_at, r.id
+	`
+	rows, err := s.pool.Query(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []*AccessRequest{}
+	for rows.Next() {
+		req, err := scanAccessRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func (s *PostgresStore) DecideAccessRequest(ctx context.Context, documentID, requestID, ownerID string, approve bool) (*AccessRequest, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return nil, err
+	}
+	if err := validID(requestID); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	status := AccessRequestDenied
+	if approve {
+		status = AccessRequestApproved
+	}
+	query := `
+		WITH r AS (
+			UPDATE access_requests SET status = $3, decided_at = NOW()
+			WHERE id = $1 AND document_id = $2 AND status = 'pending'
+			RETURNING *
+		)
+		SELECT ` + pgAccessRequestColumns + ` FROM r JOIN users u ON u.id = r.user_id
+	`
+	req, err := scanAccessRequest(tx.QueryRow(ctx, query, requestID, documentID, status))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if approve {
+		if _, err := tx.Exec(ctx, grantRoleSQL, documentID, req.UserID, req.Role); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (s *PostgresStore) CreateShareLink(ctx context.Context, documentID, ownerID string, opts ShareLinkOptions) (*ShareLink, error) {
+	link, tokenHash, err := newShareLink(documentID, ownerID, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := validID(documentID, ownerID); err != nil {
+		return nil, ErrNotDocumentOwner
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// The INSERT ... SELECT only inserts when ownerID owns the document.
+	query := `
+		INSERT INTO share_links (document_id, token_hash, role, password_hash, expires_at, max_uses, created_by)
+		SELECT id, $3, $4, $5, $6, $7, owner_id FROM documents WHERE id = $1 AND owner_id = $2
+		RETURNING id, created_at
+	`
+	err = s.pool.QueryRow(ctx, query, documentID, ownerID, tokenHash, link.Role, link.PasswordHash, link.ExpiresAt, link.MaxUses).
+		Scan(&link.ID, &link.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotDocumentOwner
+		}
+		return nil, err
+	}
+	return link, nil
+}
+
+// pgShareLinkColumns are scanned by scanShareLink.
+const pgShareLinkColumns = `id, document_id, role, password_hash, expires_at, max_uses, uses, created_by, created_at, revoked_at`
+
+func scanShareLink(row pgx.Row) (*ShareLink, error) {
+	link := &ShareLink{}
+	err := row.Scan(&link.ID, &link.DocumentID, &link.Role, &link.PasswordHash, &link.ExpiresAt,
+		&link.MaxUses, &link.Uses, &link.CreatedBy, &link.CreatedAt, &link.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	link.HasPassword = link.PasswordHash != ""
+	return link, nil
+}
+
+func (s *PostgresStore) checkDocumentOwner(ctx context.Context, documentID, ownerID string) error {
+	if err := validID(documentID, ownerID); err != nil {
+		return ErrNotDocumentOwner
+	}
+	var isOwner bool
+	query := `SELECT EXISTS(SELECT 1 FROM documents WHERE id = $1 AND owner_id = $2)`
+	if err := s.pool.QueryRow(ctx, query, documentID, ownerID).Scan(&isOwner); err != nil {
+		return err
+	}
+	if !isOwner {
+		return ErrNotDocumentOwner
+	}
+	return nil
+}
+
+func (s *PostgresStore) ListShareLinks(ctx context.Context, documentID, ownerID string) ([]*ShareLink, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + pgShareLinkColumns + ` FROM share_links WHERE document_id = $1 ORDER BY created_at DESC, id`
+	rows, err := s.pool.Query(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []*ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (s *PostgresStore) RevokeShareLink(ctx context.Context, documentID, linkID, ownerID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return err
+	}
+	if err := validID(linkID); err != nil {
+		return err
+	}
+
+	query := `UPDATE share_links SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1 AND document_id = $2`
+	tag, err := s.pool.Exec(ctx, query, linkID, documentID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetShareLinkByToken(ctx context.Context, documentID, token string) (*ShareLink, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(documentID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + pgShareLinkColumns + ` FROM share_links WHERE token_hash = $1 AND document_id = $2`
+	link, err := scanShareLink(s.pool.QueryRow(ctx, query, hashToken(token), documentID))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return link, nil
+}
+
+func (s *PostgresStore) UseShareLink(ctx context.Context, linkID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(linkID); err != nil {
+		return err
+	}
+
+	// Checking and counting in one statement keeps concurrent opens from
+	// going over max_uses.
+	query := `
+		UPDATE share_links SET uses = uses + 1
+		WHERE id = $1 AND revoked_at IS NULL
+			AND (expires_at IS NULL OR expires_at > NOW())
+			AND (max_uses = 0 OR uses < max_uses)
+	`
+	tag, err := s.pool.Exec(ctx, query, linkID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLinkUnavailable
+	}
+	return nil
+}
+
+func (s *PostgresStore) CreateTeam(ctx context.Context, name, ownerID string) (*Team, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	team := &Team{Name: name, Role: TeamRoleOwner}
+	err = tx.QueryRow(ctx, `INSERT INTO teams (name) VALUES ($1) RETURNING id, created_at`, name).Scan(&team.ID, &team.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	memberQuery := `INSERT INTO team_members (team_id, user_id, role) VALUES ($1, $2, $3)`
+	if _, err := tx.Exec(ctx, memberQuery, team.ID, ownerID, TeamRoleOwner); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return team, nil
+}
+
+func (s *PostgresStore) ListTeams(ctx context.Context, userID string) ([]*Team, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT t.id, t.name, tm.role, t.created_at
+		FROM teams t
+		JOIN team_members tm ON tm.team_id = t.id
+		WHERE tm.user_id = $1
+		ORDER BY t.name, t.id
+	`
+	rows, err := s.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := []*Team{}
+	for rows.Next() {
+		team := &Team{}
+		if err := rows.Scan(&team.ID, &team.Name, &team.Role, &team.CreatedAt); err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+// teamRoles returns the roles of actorID and userID in teamID ("" for
+// non-members), or ErrNotFound if the team doesn't exist.
+func teamRoles(ctx context.Context, tx pgx.Tx, teamID, actorID, userID string) (string, string, error) {
+	query := `
+		SELECT EXISTS(SELECT 1 FROM teams WHERE id = $1),
+			COALESCE((SELECT role FROM team_members WHERE team_id = $1 AND user_id = $2), ''),
+			COALESCE((SELECT role FROM team_members WHERE team_id = $1 AND user_id = $3), '')
+	`
+	var exists bool
+	var actorRole, userRole string
+	if err := tx.QueryRow(ctx, query, teamID, actorID, userID).Scan(&exists, &actorRole, &userRole); err != nil {
+		return "", "", err
+	}
+	if !exists {
+		return "", "", ErrNotFound
+	}
+	return actorRole, userRole, nil
+}
+
+func (s *PostgresStore) GetTeamMembers(ctx context.Context, teamID, userID string) ([]*TeamMember, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(teamID); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	callerRole, _, err := teamRoles(ctx, tx, teamID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if callerRole == "" {
+		return nil, ErrPermissionDenied
+	}
+
+	query := `
+		SELECT tm.user_id, u.email, u.display_name, u.avatar_url, tm.role, tm.created_at
+		FROM team_members tm
+		JOIN users u ON u.id = tm.user_id
+		WHERE tm.team_id = $1
+		ORDER BY u.email
+	`
+	rows, err := tx.Query(ctx, query, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*TeamMember{}
+	for rows.Next() {
+		m := &TeamMember{}
+		if err := rows.Scan(&m.UserID, &m.Email, &m.DisplayName, &m.AvatarURL, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (s *PostgresStore) AddTeamMember(ctx context.Context, teamID, actorID, userID, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(teamID, userID); err != nil {
+		return err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	actorRole, userRole, err := teamRoles(ctx, tx, teamID, actorID, userID)
+	if err != nil {
+		return err
+	}
+	if !canChangeTeamMember(actorRole, userRole, role, actorID == userID) {
+		return ErrPermissionDenied
+	}
+
+	query := `
+		INSERT INTO team_members (team_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (team_id, user_id) DO UPDATE SET role = EXCLUDED.role
+	`
+	if _, err := tx.Exec(ctx, query, teamID, userID, role); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) RemoveTeamMember(ctx context.Context, teamID, actorID, userID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := validID(teamID, userID); err != nil {
+		return err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	actorRole, userRole, err := teamRoles(ctx, tx, teamID, actorID, userID)
+	if err != nil {
+		return err
+	}
+	if !canChangeTeamMember(actorRole, userRole, "", actorID == userID) {
+		return ErrPermissionDenied
+	}
+	if userRole == "" {
+		return ErrNotFound
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM team_members WHERE team_id = $1 AND user_id = $2`, teamID, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
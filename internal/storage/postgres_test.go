@@ -0,0 +1,35 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/config"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage/storagetest"
+)
+
+// TestPostgresStore runs the suite against the database named by
+// TEST_DATABASE_URL, migrating it first. Its data is left behind, so point
+// it at a throwaway database.
+func TestPostgresStore(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	cfg := &config.Config{
+		StorageBackend:   storage.BackendPostgres,
+		DatabaseURL:      url,
+		DBQueryTimeout:   5 * time.Second,
+		MigrateOnStartup: true,
+	}
+	store, closeStore, err := storage.NewStore(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	t.Cleanup(closeStore)
+
+	storagetest.TestStore(t, func(t *testing.T) storage.Store { return store })
+}
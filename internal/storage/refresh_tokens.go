@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for refresh tokens that don't exist,
+	// have expired or belong to a session that was ended.
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid or expired")
+	// ErrRefreshTokenReused is returned when a refresh token that was already
+	// rotated is presented again. The whole session is revoked, since either
+	// the client or an attacker holds a stolen copy.
+	ErrRefreshTokenReused = errors.New("refresh token was already used; session revoked")
+)
+
+// RefreshToken is one link in a login session's chain of refresh tokens.
+// Each refresh consumes the current token and issues the next one with the
+// same SessionID. Only a hash of the token is stored; Token is set once, in
+// the value returned when it is issued.
+type RefreshToken struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	SessionID string    `json:"session_id"`
+	Token     string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// refreshTokenState is what RotateRefreshToken needs to know about the
+// presented token before it issues a successor.
+type refreshTokenState struct {
+	userID    string
+	sessionID string
+	expiresAt time.Time
+	used      bool
+	revoked   bool
+}
+
+// check returns the error for presenting a token in this state at now, or
+// nil if it may be rotated.
+func (st refreshTokenState) check(now time.Time) error {
+	switch {
+	case st.revoked:
+		return ErrInvalidRefreshToken
+	case st.used:
+		return ErrRefreshTokenReused
+	case !now.Before(st.expiresAt):
+		return ErrInvalidRefreshToken
+	}
+	return nil
+}
+
+// newRefreshToken returns a fresh token for userID in sessionID, and the
+// hash it is stored under.
+func newRefreshToken(userID, sessionID string, expiresAt time.Time) (*RefreshToken, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+	rt := &RefreshToken{
+		UserID:    userID,
+		SessionID: sessionID,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}
+	return rt, hashToken(token), nil
+}
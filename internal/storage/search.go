@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Highlight markers wrapped around matched words in search snippets. The
+// rest of a snippet is HTML-escaped document text, so clients can render
+// snippets as HTML.
+const (
+	HighlightStart = "<mark>"
+	HighlightStop  = "</mark>"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// SearchResult is a document matching a search, best matches first.
+type SearchResult struct {
+	ID      string  `json:"id"`
+	Title   string  `json:"title"`
+	OwnerID string  `json:"owner_id"`
+	Role    string  `json:"role"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// SearchHit is a match returned by a SearchIndex.
+type SearchHit struct {
+	DocumentID string
+	Rank       float64
+	Snippet    string
+}
+
+// SearchIndex is the full-text index used by stores without native search
+// (MemoryStore and SQLiteStore). Implementations must be safe for concurrent use.
+type SearchIndex interface {
+	// Index adds or replaces the text of a document.
+	Index(documentID, title, content string)
+	Remove(documentID string)
+	// Search returns up to limit hits among the documents allowed accepts.
+	Search(query string, allowed func(documentID string) bool, limit int) []SearchHit
+}
+
+// Weights given to title and content matches, like setweight 'A' and 'B'
+// in the PostgreSQL index.
+const (
+	titleWeight   = 1.0
+	contentWeight = 0.4
+)
+
+// snippetWords is roughly the MaxWords of the PostgreSQL ts_headline call.
+const snippetWords = 20
+
+type indexedDocument struct {
+	titleTerms   map[string]int
+	contentTerms map[string]int
+	contentWords []string
+}
+
+// MemorySearchIndex is an in-process inverted index over titles and content.
+type MemorySearchIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*indexedDocument
+	postings map[string]map[string]struct{}
+}
+
+func NewMemorySearchIndex() *MemorySearchIndex {
+	return &MemorySearchIndex{
+		docs:     make(map[string]*indexedDocument),
+		postings: make(map[string]map[string]struct{}),
+	}
+}
+
+// tokenize splits text into lower-cased words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func termCounts(words []string) map[string]int {
+	counts := make(map[string]int, len(words))
+	for _, w := range words {
+		counts[w]++
+	}
+	return counts
+}
+
+func (idx *MemorySearchIndex) Index(documentID, title, content string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeLocked(documentID)
+	doc := &indexedDocument{
+		titleTerms:   termCounts(tokenize(title)),
+		contentTerms: termCounts(tokenize(content)),
+		contentWords: strings.Fields(content),
+	}
+	idx.docs[documentID] = doc
+	for _, terms := range []map[string]int{doc.titleTerms, doc.contentTerms} {
+		for term := range terms {
+			if idx.postings[term] == nil {
+				idx.postings[term] = make(map[string]struct{})
+			}
+			idx.postings[term][documentID] = struct{}{}
+		}
+	}
+}
+
+func (idx *MemorySearchIndex) Remove(documentID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(documentID)
+}
+
+func (idx *MemorySearchIndex) removeLocked(documentID string) {
+	doc, ok := idx.docs[documentID]
+	if !ok {
+		return
+	}
+	for _, terms := range []map[string]int{doc.titleTerms, doc.contentTerms} {
+		for term := range terms {
+			delete(idx.postings[term], documentID)
+			if len(idx.postings[term]) == 0 {
+				delete(idx.postings, term)
+			}
+		}
+	}
+	delete(idx.docs, documentID)
+}
+
+// Search requires every query term to appear in the title or content, like
+// the default AND of websearch_to_tsquery.
+func (idx *MemorySearchIndex) Search(query string, allowed func(documentID string) bool, limit int) []SearchHit {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var hits []SearchHit
+	for documentID := range idx.postings[terms[0]] {
+		doc := idx.docs[documentID]
+		matchesAll := true
+		for _, term := range terms[1:] {
+			if _, ok := idx.postings[term][documentID]; !ok {
+				matchesAll = false
+				break
+			}
+		}
+		if !matchesAll || !allowed(documentID) {
+			continue
+		}
+
+		rank := 0.0
+		for _, term := range terms {
+			rank += titleWeight*float64(doc.titleTerms[term]) + contentWeight*float64(doc.contentTerms[term])
+		}
+		// Normalise by length so long documents don't win on volume alone.
+		rank /= 1 + math.Log(1+float64(len(doc.contentWords)))
+
+		hits = append(hits, SearchHit{
+			DocumentID: documentID,
+			Rank:       rank,
+			Snippet:    snippet(doc.contentWords, terms),
+		})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Rank != hits[j].Rank {
+			return hits[i].Rank > hits[j].Rank
+		}
+		return hits[i].DocumentID < hits[j].DocumentID
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// snippetEscaper escapes text for use as HTML element content.
+var snippetEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// snippet returns about snippetWords words around the first match, escaped
+// for HTML, with matching words highlighted. Without a match it falls back
+// to the start of the content, as ts_headline does.
+func snippet(words []string, terms []string) string {
+	wanted := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		wanted[t] = true
+	}
+	matches := func(word string) bool {
+		for _, t := range tokenize(word) {
+			if wanted[t] {
+				return true
+			}
+		}
+		return false
+	}
+
+	start := 0
+	for i, w := range words {
+		if matches(w) {
+			start = i - snippetWords/4
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+
+	out := make([]string, 0, end-start)
+	for _, w := range words[start:end] {
+		escaped := snippetEscaper.Replace(w)
+		if matches(w) {
+			escaped = HighlightStart + escaped + HighlightStop
+		}
+		out = append(out, escaped)
+	}
+	return strings.Join(out, " ")
+}
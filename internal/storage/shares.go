@@ -0,0 +1,11 @@
+package storage
+
+// DocumentShare is a role granted directly on a document, to a user or,
+// when TeamID is set, to a team.
+type DocumentShare struct {
+	UserID   string `json:"user_id,omitempty"`
+	Email    string `json:"email,omitempty"`
+	TeamID   string `json:"team_id,omitempty"`
+	TeamName string `json:"team_name,omitempty"`
+	Role     string `json:"role"`
+}
@@ -0,0 +1,2442 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/migrate"
+	"github.com/pasanAbeysekara/collaborative-editor/migrations"
+	"golang.org/x/crypto/bcrypt"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore is a Store backed by a single SQLite file, for single-VM
+// deployments and hermetic integration tests. The driver is pure Go, so the
+// static service images include it.
+type SQLiteStore struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+	// search is rebuilt from the documents table when the store is opened;
+	// SQLite is only used by single-VM deployments, where it fits in memory.
+	search SearchIndex
+}
+
+// NewSQLiteStore opens (creating if needed) the database at path and applies
+// any pending embedded migrations. Use ":memory:" for a throwaway database.
+func NewSQLiteStore(path string, queryTimeout time.Duration) (*SQLiteStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := migrate.New(migrate.NewSQLiteDriver(db), migrations.SQLite, "sqlite")
+	if err == nil {
+		_, err = migrator.Up(context.Background())
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate sqlite database: %w", err)
+	}
+
+	s := &SQLiteStore{db: db, queryTimeout: queryTimeout, search: NewMemorySearchIndex()}
+	if err := s.buildSearchIndex(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to build search index: %w", err)
+	}
+	return s, nil
+}
+
+func (s *SQLiteStore) buildSearchIndex(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, title, COALESCE(content, '') FROM documents`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, title, content string
+		if err := rows.Scan(&id, &title, &content); err != nil {
+			return err
+		}
+		s.search.Index(id, title, content)
+	}
+	return rows.Err()
+}
+
+func openSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite serialises writers anyway; a single connection avoids SQLITE_BUSY
+	// and keeps ":memory:" databases from being opened once per connection.
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, s.queryTimeout)
+}
+
+// sqliteTime formats t for storage. The fixed-width layout makes SQLite's
+// text comparison agree with chronological order, which keyset pagination needs.
+func sqliteTime(t time.Time) string {
+	return formatCursorTime(t)
+}
+
+// sqliteConstraint reports whether err is a violation of a constraint of
+// kind ("UNIQUE", "FOREIGN KEY", ...). SQLite words these the same whatever
+// the driver, so the message is matched rather than a driver's error type.
+func sqliteConstraint(err error, kind string) bool {
+	return err != nil && strings.Contains(err.Error(), kind+" constraint failed")
+}
+
+// sqlNotFound maps sql.ErrNoRows to ErrNotFound.
+func sqlNotFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *SQLiteStore) CreateUser(ctx context.Context, email, password string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{ID: uuid.NewString(), Email: email, PasswordHash: string(hashedPassword)}
+	query := `INSERT INTO users (id, email, password_hash) VALUES (?, ?, ?)`
+
+	if _, err := s.db.ExecContext(ctx, query, user.ID, email, user.PasswordHash); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *SQLiteStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = ? AND deleted_at IS NULL`
+
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, email))
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+	return user, nil
+}
+
+func (s *SQLiteStore) GetUserByID(ctx context.Context, id string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ? AND deleted_at IS NULL`
+
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+	return user, nil
+}
+
+func (s *SQLiteStore) UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users SET
+			display_name = COALESCE(?, display_name),
+			avatar_url = COALESCE(?, avatar_url),
+			locale = COALESCE(?, locale),
+			timezone = COALESCE(?, timezone)
+		WHERE id = ?
+		RETURNING ` + userColumns
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, update.DisplayName, update.AvatarURL, update.Locale, update.Timezone, userID))
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+	return user, nil
+}
+
+func (s *SQLiteStore) ChangePassword(ctx context.Context, userID, password string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET password_hash = ? WHERE id = ?`, string(hashedPassword), userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	revokeQuery := `UPDATE refresh_tokens SET revoked_at = COALESCE(revoked_at, ?) WHERE user_id = ?`
+	if _, err := tx.ExecContext(ctx, revokeQuery, sqliteTime(time.Now().UTC()), userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ChangeEmail(ctx context.Context, userID, email string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE users SET email = ?, email_verified_at = NULL WHERE id = ? RETURNING ` + userColumns
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, email, userID))
+	if sqliteConstraint(err, "UNIQUE") {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+	return user, nil
+}
+
+// DeleteUser works like PostgresStore.DeleteUser.
+func (s *SQLiteStore) DeleteUser(ctx context.Context, userID string, opts DeleteUserOptions) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if !ValidOwnedDocumentsPolicy(opts.Documents) {
+		return fmt.Errorf("unknown owned documents policy %q", opts.Documents)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	existsQuery := `SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL)`
+	if err := tx.QueryRowContext(ctx, existsQuery, userID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	if opts.Documents == OwnedDocumentsTransfer {
+		if opts.TransferTo == userID {
+			return ErrInvalidTransferTarget
+		}
+		if err := tx.QueryRowContext(ctx, existsQuery, opts.TransferTo).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrInvalidTransferTarget
+		}
+
+		// The heir must already work with the account; see
+		// ErrInvalidTransferTarget.
+		relatedQuery := `
+			SELECT EXISTS(SELECT 1 FROM document_permissions p JOIN documents d ON d.id = p.document_id
+				WHERE d.owner_id = ?1 AND p.user_id = ?2)
+			OR EXISTS(SELECT 1 FROM folder_permissions p JOIN folders f ON f.id = p.folder_id
+				WHERE f.owner_id = ?1 AND p.user_id = ?2)
+			OR EXISTS(SELECT 1 FROM team_members a JOIN team_members b ON b.team_id = a.team_id
+				WHERE a.user_id = ?1 AND b.user_id = ?2)
+			OR EXISTS(SELECT 1 FROM workspaces w
+				WHERE (w.owner_id = ?1 OR EXISTS(SELECT 1 FROM workspace_members m WHERE m.workspace_id = w.id AND m.user_id = ?1))
+				AND (w.owner_id = ?2 OR EXISTS(SELECT 1 FROM workspace_members m WHERE m.workspace_id = w.id AND m.user_id = ?2)))
+		`
+		if err := tx.QueryRowContext(ctx, relatedQuery, userID, opts.TransferTo).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrInvalidTransferTarget
+		}
+	}
+
+	// Deleted documents have to leave the search index once the transaction
+	// commits.
+	var deletedDocuments []string
+	if opts.Documents == OwnedDocumentsDelete {
+		rows, err := tx.QueryContext(ctx, `SELECT id FROM documents WHERE owner_id = ?`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			deletedDocuments = append(deletedDocuments, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	// Folders in other people's workspaces stay there, with the workspace
+	// owner, whatever the policy.
+	keepFoldersQuery := `
+		UPDATE folders SET owner_id = (SELECT owner_id FROM workspaces WHERE id = folders.workspace_id)
+		WHERE owner_id = ?1 AND workspace_id IN (SELECT id FROM workspaces WHERE owner_id <> ?1)
+	`
+	if _, err := tx.ExecContext(ctx, keepFoldersQuery, userID); err != nil {
+		return err
+	}
+
+	var queries []string
+	switch opts.Documents {
+	case OwnedDocumentsTransfer:
+		// The new owner's own grants on what they receive are implied by
+		// ownership now.
+		transferQueries := []string{
+			`DELETE FROM document_permissions WHERE user_id = ?2 AND document_id IN (SELECT id FROM documents WHERE owner_id = ?1)`,
+			`DELETE FROM access_requests WHERE user_id = ?2 AND document_id IN (SELECT id FROM documents WHERE owner_id = ?1)`,
+			`UPDATE documents SET owner_id = ?2 WHERE owner_id = ?1`,
+			`UPDATE share_links SET created_by = ?2 WHERE created_by = ?1`,
+			`UPDATE document_invitations SET invited_by = ?2 WHERE invited_by = ?1`,
+			`DELETE FROM workspace_members WHERE user_id = ?2 AND workspace_id IN (SELECT id FROM workspaces WHERE owner_id = ?1)`,
+			`UPDATE workspaces SET owner_id = ?2 WHERE owner_id = ?1`,
+			`DELETE FROM folder_permissions WHERE user_id = ?2 AND folder_id IN (SELECT id FROM folders WHERE owner_id = ?1)`,
+			`UPDATE folders SET owner_id = ?2 WHERE owner_id = ?1`,
+		}
+		for _, query := range transferQueries {
+			if _, err := tx.ExecContext(ctx, query, userID, opts.TransferTo); err != nil {
+				return err
+			}
+		}
+	case OwnedDocumentsDelete:
+		queries = []string{
+			`DELETE FROM documents WHERE owner_id = ?1`,
+			`DELETE FROM workspaces WHERE owner_id = ?1`,
+			`DELETE FROM folders WHERE owner_id = ?1`,
+		}
+	case OwnedDocumentsOrphan:
+		queries = []string{
+			`DELETE FROM share_links WHERE document_id IN (SELECT id FROM documents WHERE owner_id = ?1)`,
+			`DELETE FROM document_invitations WHERE document_id IN (SELECT id FROM documents WHERE owner_id = ?1)`,
+			`DELETE FROM access_requests WHERE document_id IN (SELECT id FROM documents WHERE owner_id = ?1)`,
+		}
+	}
+
+	queries = append(queries, `
+		UPDATE team_members SET role = 'owner'
+		WHERE team_id IN (SELECT team_id FROM team_members WHERE user_id = ?1 AND role = 'owner')
+		AND user_id = (
+			SELECT m.user_id FROM team_members m
+			WHERE m.team_id = team_members.team_id AND m.user_id <> ?1
+			ORDER BY m.role = 'admin' DESC, m.created_at, m.user_id
+			LIMIT 1
+		)
+	`, `
+		DELETE FROM teams
+		WHERE id IN (SELECT team_id FROM team_members WHERE user_id = ?1)
+		AND NOT EXISTS (SELECT 1 FROM team_members m WHERE m.team_id = teams.id AND m.user_id <> ?1)
+	`)
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+	}
+
+	var ownsAnything bool
+	ownsQuery := `
+		SELECT EXISTS(SELECT 1 FROM documents WHERE owner_id = ?1)
+			OR EXISTS(SELECT 1 FROM workspaces WHERE owner_id = ?1)
+			OR EXISTS(SELECT 1 FROM folders WHERE owner_id = ?1)
+	`
+	if err := tx.QueryRowContext(ctx, ownsQuery, userID).Scan(&ownsAnything); err != nil {
+		return err
+	}
+	if ownsAnything {
+		for _, table := range userRowTables {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = ?`, userID); err != nil {
+				return err
+			}
+		}
+		anonymizeQuery := `
+			UPDATE users SET email = 'deleted:' || id, password_hash = '', email_verified_at = NULL,
+				totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0,
+				display_name = '', avatar_url = '', locale = '', timezone = '', deleted_at = ?
+			WHERE id = ?
+		`
+		if _, err := tx.ExecContext(ctx, anonymizeQuery, sqliteTime(time.Now().UTC()), userID); err != nil {
+			return err
+		}
+	} else if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, id := range deletedDocuments {
+		s.search.Remove(id)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) CreateRefreshToken(ctx context.Context, userID string, expiresAt time.Time) (*RefreshToken, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)`, userID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return insertSQLiteRefreshToken(ctx, s.db, userID, uuid.NewString(), expiresAt)
+}
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertSQLiteRefreshToken(ctx context.Context, db sqlExecer, userID, sessionID string, expiresAt time.Time) (*RefreshToken, error) {
+	rt, tokenHash, err := newRefreshToken(userID, sessionID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	rt.ID = uuid.NewString()
+	rt.CreatedAt = time.Now().UTC()
+	query := `
+		INSERT INTO refresh_tokens (id, user_id, session_id, token_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err = db.ExecContext(ctx, query, rt.ID, userID, sessionID, tokenHash, sqliteTime(expiresAt.UTC()), sqliteTime(rt.CreatedAt))
+	if err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+func (s *SQLiteStore) RotateRefreshToken(ctx context.Context, token string, expiresAt time.Time) (*RefreshToken, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		id    string
+		state refreshTokenState
+	)
+	query := `
+		SELECT id, user_id, session_id, expires_at, used_at IS NOT NULL, revoked_at IS NOT NULL
+		FROM refresh_tokens WHERE token_hash = ?
+	`
+	err = tx.QueryRowContext(ctx, query, hashToken(token)).
+		Scan(&id, &state.userID, &state.sessionID, &state.expiresAt, &state.used, &state.revoked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if err := state.check(now); err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			revokeQuery := `UPDATE refresh_tokens SET revoked_at = COALESCE(revoked_at, ?) WHERE session_id = ?`
+			if _, rerr := tx.ExecContext(ctx, revokeQuery, sqliteTime(now), state.sessionID); rerr != nil {
+				return nil, rerr
+			}
+			if rerr := tx.Commit(); rerr != nil {
+				return nil, rerr
+			}
+		}
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = ? WHERE id = ?`, sqliteTime(now), id); err != nil {
+		return nil, err
+	}
+	next, err := insertSQLiteRefreshToken(ctx, tx, state.userID, state.sessionID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+func (s *SQLiteStore) RevokeRefreshToken(ctx context.Context, userID, token string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var ownerID string
+	err := s.db.QueryRowContext(ctx, `SELECT user_id FROM refresh_tokens WHERE token_hash = ?`, hashToken(token)).Scan(&ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ownerID != userID {
+		return ErrNotFound
+	}
+
+	query := `
+		UPDATE refresh_tokens SET revoked_at = COALESCE(revoked_at, ?)
+		WHERE session_id = (SELECT session_id FROM refresh_tokens WHERE token_hash = ?)
+	`
+	_, err = s.db.ExecContext(ctx, query, sqliteTime(time.Now().UTC()), hashToken(token))
+	return err
+}
+
+func (s *SQLiteStore) CreatePersonalToken(ctx context.Context, userID, name string, scopes []string, expiresAt time.Time) (*PersonalToken, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	pt, tokenHash, err := newPersonalToken(userID, name, scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	pt.ID = uuid.NewString()
+	pt.CreatedAt = time.Now().UTC()
+	query := `
+		INSERT INTO personal_access_tokens (id, user_id, name, token_hash, scopes, expires_at, created_at)
+		SELECT ?, id, ?, ?, ?, ?, ? FROM users WHERE id = ? AND deleted_at IS NULL
+	`
+	res, err := s.db.ExecContext(ctx, query, pt.ID, name, tokenHash, joinScopes(scopes),
+		sqliteTime(expiresAt.UTC()), sqliteTime(pt.CreatedAt), userID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrNotFound
+	}
+	return pt, nil
+}
+
+func (s *SQLiteStore) ListPersonalTokens(ctx context.Context, userID string) ([]*PersonalToken, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + personalTokenColumns + ` FROM personal_access_tokens
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY created_at DESC, id
+	`
+	rows, err := s.db.QueryContext(ctx, query, userID, sqliteTime(time.Now().UTC()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*PersonalToken{}
+	for rows.Next() {
+		pt, err := scanPersonalToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, pt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (s *SQLiteStore) RevokePersonalToken(ctx context.Context, userID, tokenID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM personal_access_tokens WHERE id = ? AND user_id = ?`, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UsePersonalToken(ctx context.Context, token string) (*PersonalToken, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	query := `SELECT ` + personalTokenColumns + ` FROM personal_access_tokens WHERE token_hash = ? AND expires_at > ?`
+	pt, err := scanPersonalToken(s.db.QueryRowContext(ctx, query, hashToken(token), sqliteTime(now)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidPersonalToken
+		}
+		return nil, err
+	}
+	if !pt.usedRecently(now) {
+		query := `UPDATE personal_access_tokens SET last_used_at = ? WHERE id = ?`
+		if _, err := s.db.ExecContext(ctx, query, sqliteTime(now), pt.ID); err != nil {
+			return nil, err
+		}
+		pt.LastUsedAt = &now
+	}
+	return pt, nil
+}
+
+func (s *SQLiteStore) CreateAccountToken(ctx context.Context, userID, purpose string, expiresAt time.Time) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var email string
+	if err := tx.QueryRowContext(ctx, `SELECT email FROM users WHERE id = ?`, userID).Scan(&email); err != nil {
+		return "", sqlNotFound(err)
+	}
+	now := sqliteTime(time.Now().UTC())
+	supersede := `UPDATE account_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL`
+	if _, err := tx.ExecContext(ctx, supersede, now, userID, purpose); err != nil {
+		return "", err
+	}
+	query := `
+		INSERT INTO account_tokens (id, user_id, purpose, email, token_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.ExecContext(ctx, query, uuid.NewString(), userID, purpose, email, hashToken(token), sqliteTime(expiresAt.UTC()), now)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeSQLiteAccountToken marks token used and returns who it was issued
+// to, provided it is valid for purpose.
+func consumeSQLiteAccountToken(ctx context.Context, tx *sql.Tx, token, purpose string) (accountTokenState, error) {
+	var (
+		id string
+		st accountTokenState
+	)
+	query := `
+		SELECT id, user_id, purpose, email, expires_at, used_at IS NOT NULL
+		FROM account_tokens WHERE token_hash = ?
+	`
+	err := tx.QueryRowContext(ctx, query, hashToken(token)).Scan(&id, &st.userID, &st.purpose, &st.email, &st.expiresAt, &st.used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return st, ErrInvalidAccountToken
+		}
+		return st, err
+	}
+	now := time.Now().UTC()
+	if err := st.check(purpose, now); err != nil {
+		return st, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE account_tokens SET used_at = ? WHERE id = ?`, sqliteTime(now), id); err != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+func (s *SQLiteStore) AccountTokenUser(ctx context.Context, token, purpose string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var st accountTokenState
+	query := `
+		SELECT user_id, purpose, email, expires_at, used_at IS NOT NULL
+		FROM account_tokens WHERE token_hash = ?
+	`
+	err := s.db.QueryRowContext(ctx, query, hashToken(token)).Scan(&st.userID, &st.purpose, &st.email, &st.expiresAt, &st.used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+	if err := st.check(purpose, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	query = `SELECT ` + userColumns + ` FROM users WHERE id = ? AND email = ?`
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, st.userID, st.email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *SQLiteStore) VerifyEmail(ctx context.Context, token string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	st, err := consumeSQLiteAccountToken(ctx, tx, token, TokenVerifyEmail)
+	if err != nil {
+		return nil, err
+	}
+	// Matching on email leaves the token useless once the address changes.
+	query := `
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, ?)
+		WHERE id = ? AND email = ?
+		RETURNING ` + userColumns
+	user, err := scanUser(tx.QueryRowContext(ctx, query, sqliteTime(time.Now().UTC()), st.userID, st.email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *SQLiteStore) ResetPassword(ctx context.Context, token, password string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	st, err := consumeSQLiteAccountToken(ctx, tx, token, TokenResetPassword)
+	if err != nil {
+		return nil, err
+	}
+	// The reset link was delivered to the address, which proves it too.
+	now := sqliteTime(time.Now().UTC())
+	query := `
+		UPDATE users SET password_hash = ?, email_verified_at = COALESCE(email_verified_at, ?)
+		WHERE id = ? AND email = ?
+		RETURNING ` + userColumns
+	user, err := scanUser(tx.QueryRowContext(ctx, query, string(hashedPassword), now, st.userID, st.email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+	revokeQuery := `UPDATE refresh_tokens SET revoked_at = COALESCE(revoked_at, ?) WHERE user_id = ?`
+	if _, err := tx.ExecContext(ctx, revokeQuery, now, user.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *SQLiteStore) ConsumeAccountToken(ctx context.Context, token, purpose string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	st, err := consumeSQLiteAccountToken(ctx, tx, token, purpose)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ? AND email = ?`
+	user, err := scanUser(tx.QueryRowContext(ctx, query, st.userID, st.email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *SQLiteStore) GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + userColumns + ` FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?)
+	`
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, issuer, subject))
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+	return user, nil
+}
+
+func insertSQLiteIdentity(ctx context.Context, db sqlExecer, userID, issuer, subject string) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (issuer, subject) DO NOTHING
+	`
+	res, err := db.ExecContext(ctx, query, issuer, subject, userID, sqliteTime(time.Now().UTC()))
+	if sqliteConstraint(err, "FOREIGN KEY") {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrIdentityLinked
+	}
+	return nil
+}
+
+func (s *SQLiteStore) LinkIdentity(ctx context.Context, userID, issuer, subject string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return insertSQLiteIdentity(ctx, s.db, userID, issuer, subject)
+}
+
+func (s *SQLiteStore) CreateUserWithIdentity(ctx context.Context, email string, emailVerified bool, issuer, subject string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// An empty password hash never matches, so the account has no password.
+	user := &User{ID: uuid.NewString(), Email: email, EmailVerified: emailVerified}
+	var verifiedAt *time.Time
+	if emailVerified {
+		now := time.Now().UTC()
+		verifiedAt = &now
+	}
+	query := `INSERT INTO users (id, email, password_hash, email_verified_at) VALUES (?, ?, '', ?)`
+	if _, err := tx.ExecContext(ctx, query, user.ID, email, nullableTime(verifiedAt)); err != nil {
+		return nil, err
+	}
+	if err := insertSQLiteIdentity(ctx, tx, user.ID, issuer, subject); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *SQLiteStore) SetTOTPSecret(ctx context.Context, userID string, secret []byte) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var enabled bool
+	query := `SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = ?`
+	if err := tx.QueryRowContext(ctx, query, userID).Scan(&enabled); err != nil {
+		return sqlNotFound(err)
+	}
+	if enabled {
+		return ErrTOTPEnabled
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET totp_secret = ? WHERE id = ?`, secret, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) GetTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	totp := &TOTP{}
+	query := `SELECT totp_secret, totp_enabled_at IS NOT NULL, totp_last_step FROM users WHERE id = ?`
+	if err := s.db.QueryRowContext(ctx, query, userID).Scan(&totp.Secret, &totp.Enabled, &totp.LastStep); err != nil {
+		return nil, sqlNotFound(err)
+	}
+	return totp, nil
+}
+
+func (s *SQLiteStore) EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodes []string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var enabled bool
+	query := `SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = ? AND totp_secret IS NOT NULL`
+	if err := tx.QueryRowContext(ctx, query, userID).Scan(&enabled); err != nil {
+		return sqlNotFound(err)
+	}
+	if enabled {
+		return ErrTOTPEnabled
+	}
+	update := `UPDATE users SET totp_enabled_at = ?, totp_last_step = ? WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, update, sqliteTime(time.Now().UTC()), step, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, code := range recoveryCodes {
+		insert := `INSERT OR IGNORE INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)`
+		if _, err := tx.ExecContext(ctx, insert, userID, hashToken(code)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) RecordTOTPStep(ctx context.Context, userID string, step int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`
+	res, err := s.db.ExecContext(ctx, query, step, userID, step)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTOTPCodeReused
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UseRecoveryCode(ctx context.Context, userID, code string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE user_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`
+	res, err := s.db.ExecContext(ctx, query, sqliteTime(time.Now().UTC()), userID, hashToken(code))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrInvalidRecoveryCode
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DisableTOTP(ctx context.Context, userID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ?`
+	res, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) CheckDocumentPermission(ctx context.Context, documentID, userID string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	query := documentAccessCTE("?1") + `
+		SELECT EXISTS (SELECT 1 FROM document_access WHERE document_id = ?2)
+	`
+
+	if err := s.db.QueryRowContext(ctx, query, userID, documentID).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (s *SQLiteStore) CreateDocument(ctx context.Context, title, ownerID string) (*Document, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	doc := &Document{ID: uuid.NewString(), Title: title, OwnerID: ownerID, CreatedAt: now, UpdatedAt: now}
+	query := `INSERT INTO documents (id, title, owner_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`
+
+	if _, err := s.db.ExecContext(ctx, query, doc.ID, title, ownerID, sqliteTime(now), sqliteTime(now)); err != nil {
+		return nil, err
+	}
+	s.search.Index(doc.ID, title, "")
+	return doc, nil
+}
+
+func (s *SQLiteStore) GetDocumentRole(ctx context.Context, documentID, userID string) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rank, err := s.documentRank(ctx, documentID, userID)
+	if err != nil {
+		return "", err
+	}
+	return rankRole(rank), nil
+}
+
+func (s *SQLiteStore) GetDocument(ctx context.Context, documentID string) (*Document, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	doc := &Document{}
+	query := `SELECT id, title, owner_id, COALESCE(content, ''), version, created_at, updated_at FROM documents WHERE id = ?`
+
+	err := s.db.QueryRowContext(ctx, query, documentID).Scan(
+		&doc.ID, &doc.Title, &doc.OwnerID, &doc.Content, &doc.Version, &doc.CreatedAt, &doc.UpdatedAt,
+	)
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+	return doc, nil
+}
+
+func (s *SQLiteStore) GetUserDocuments(ctx context.Context, userID string) ([]*Document, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := documentAccessCTE("?1") + `
+		SELECT d.id, d.title, d.owner_id, COALESCE(d.content, ''), d.version, d.created_at, d.updated_at
+		FROM documents d
+		JOIN document_access a ON a.document_id = d.id
+		ORDER BY d.title, d.id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var documents []*Document
+	for rows.Next() {
+		doc := &Document{}
+		if err := rows.Scan(&doc.ID, &doc.Title, &doc.OwnerID, &doc.Content, &doc.Version, &doc.CreatedAt, &doc.UpdatedAt); err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
+
+func (s *SQLiteStore) UpdateDocument(ctx context.Context, documentID, content string, version int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE documents SET content = ?, version = ?, updated_at = ? WHERE id = ? RETURNING title`
+
+	var title string
+	err := s.db.QueryRowContext(ctx, query, content, version, sqliteTime(time.Now()), documentID).Scan(&title)
+	if err != nil {
+		return sqlNotFound(err)
+	}
+	s.search.Index(documentID, title, content)
+	return nil
+}
+
+func (s *SQLiteStore) ShareDocument(ctx context.Context, documentID, ownerID, targetUserID, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var isOwner bool
+	ownerCheckQuery := `SELECT EXISTS(SELECT 1 FROM documents WHERE id = ? AND owner_id = ?)`
+	if err := tx.QueryRowContext(ctx, ownerCheckQuery, documentID, ownerID).Scan(&isOwner); err != nil {
+		return err
+	}
+	if !isOwner {
+		return ErrNotDocumentOwner
+	}
+
+	insertQuery := `
+		INSERT INTO document_permissions (document_id, user_id, role)
+		VALUES (?, ?, ?)
+		ON CONFLICT (document_id, user_id) DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, insertQuery, documentID, targetUserID, role); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// sqliteSortExprs mirrors pgSortExprs; times are stored as fixed-width text.
+var sqliteSortExprs = map[string]string{
+	SortTitle:      "d.title",
+	SortCreated:    "d.created_at",
+	SortUpdated:    "d.updated_at",
+	SortLastOpened: "COALESCE(o.opened_at, '1970-01-01T00:00:00.000000000Z')",
+}
+
+func (s *SQLiteStore) ListDocuments(ctx context.Context, userID string, opts DocumentListOptions) (*DocumentPage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	opts = opts.normalize()
+	cursor, err := decodeCursor(opts.Cursor, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sortExpr := sqliteSortExprs[opts.Sort]
+	roleExpr := rankRoleSQL("a.role_rank")
+	contentExpr := `''`
+	if opts.IncludeContent {
+		contentExpr = `COALESCE(d.content, '')`
+	}
+
+	args := []interface{}{userID}
+	conditions := []string{"TRUE"}
+	switch opts.Ownership {
+	case OwnershipOwned:
+		conditions = append(conditions, "d.owner_id = ?1")
+	case OwnershipShared:
+		conditions = append(conditions, "d.owner_id <> ?1")
+	}
+	if opts.Role != "" {
+		args = append(args, opts.Role)
+		conditions = append(conditions, fmt.Sprintf("%s = ?%d", roleExpr, len(args)))
+	}
+	if opts.FolderID != "" {
+		args = append(args, opts.FolderID)
+		conditions = append(conditions, fmt.Sprintf("d.folder_id = ?%d", len(args)))
+	}
+	if opts.FavoritesOnly {
+		conditions = append(conditions, "fv.user_id IS NOT NULL")
+	}
+	for _, tag := range opts.Tags {
+		args = append(args, tag)
+		conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM document_tags t WHERE t.document_id = d.id AND t.tag = ?%d)", len(args)))
+	}
+
+	direction, comparison := "ASC", ">"
+	if opts.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if cursor != nil {
+		args = append(args, cursor.Key, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, d.id) %s (?%d, ?%d)", sortExpr, comparison, len(args)-1, len(args)))
+	}
+	args = append(args, opts.Limit+1)
+
+	query := documentAccessCTE("?1") + fmt.Sprintf(`
+		SELECT d.id, d.title, d.owner_id, %s, COALESCE(d.folder_id, ''), fv.user_id IS NOT NULL,
+			d.version, d.created_at, d.updated_at, o.opened_at, %s
+		FROM documents d
+		JOIN document_access a ON a.document_id = d.id
+		LEFT JOIN document_opens o ON o.document_id = d.id AND o.user_id = ?1
+		LEFT JOIN document_favorites fv ON fv.document_id = d.id AND fv.user_id = ?1
+		WHERE %s
+		ORDER BY %s %s, d.id %s
+		LIMIT ?%d
+	`, roleExpr, contentExpr, strings.Join(conditions, " AND "), sortExpr, direction, direction, len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []*DocumentSummary
+	for rows.Next() {
+		d := &DocumentSummary{}
+		var openedAt sql.NullTime
+		err := rows.Scan(&d.ID, &d.Title, &d.OwnerID, &d.Role, &d.FolderID, &d.Favorite, &d.Version, &d.CreatedAt, &d.UpdatedAt, &openedAt, &d.Content)
+		if err != nil {
+			return nil, err
+		}
+		if openedAt.Valid {
+			d.LastOpenedAt = &openedAt.Time
+		}
+		summaries = append(summaries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Release the single connection before attachTags needs it.
+	rows.Close()
+
+	page := pageOf(summaries, opts)
+	if err := s.attachTags(ctx, page.Documents); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// attachTags fills in the Tags of summaries with a single query.
+func (s *SQLiteStore) attachTags(ctx context.Context, summaries []*DocumentSummary) error {
+	if len(summaries) == 0 {
+		return nil
+	}
+	byID := make(map[string]*DocumentSummary, len(summaries))
+	args := make([]interface{}, 0, len(summaries))
+	for _, d := range summaries {
+		byID[d.ID] = d
+		args = append(args, d.ID)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	query := `SELECT document_id, tag FROM document_tags WHERE document_id IN (` + placeholders + `) ORDER BY tag`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var documentID, tag string
+		if err := rows.Scan(&documentID, &tag); err != nil {
+			return err
+		}
+		byID[documentID].Tags = append(byID[documentID].Tags, tag)
+	}
+	return rows.Err()
+}
+
+func (s *SQLiteStore) RecordDocumentOpen(ctx context.Context, documentID, userID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO document_opens (document_id, user_id, opened_at)
+		VALUES (?1, ?2, ?3)
+		ON CONFLICT (document_id, user_id) DO UPDATE SET opened_at = excluded.opened_at
+	`
+	_, err := s.db.ExecContext(ctx, query, documentID, userID, sqliteTime(time.Now()))
+	if sqliteConstraint(err, "FOREIGN KEY") {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *SQLiteStore) SearchDocuments(ctx context.Context, userID, query string, limit int) ([]*SearchResult, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	accessQuery := documentAccessCTE("?1") + `
+		SELECT d.id, d.title, d.owner_id, ` + rankRoleSQL("a.role_rank") + `
+		FROM documents d
+		JOIN document_access a ON a.document_id = d.id
+	`
+	rows, err := s.db.QueryContext(ctx, accessQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accessible := make(map[string]*SearchResult)
+	for rows.Next() {
+		r := &SearchResult{}
+		if err := rows.Scan(&r.ID, &r.Title, &r.OwnerID, &r.Role); err != nil {
+			return nil, err
+		}
+		accessible[r.ID] = r
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	hits := s.search.Search(query, func(documentID string) bool {
+		_, ok := accessible[documentID]
+		return ok
+	}, limit)
+
+	results := make([]*SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		r := accessible[hit.DocumentID]
+		r.Rank = hit.Rank
+		r.Snippet = hit.Snippet
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (s *SQLiteStore) MoveDocument(ctx context.Context, documentID, userID, folderID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var ownerID string
+	err := s.db.QueryRowContext(ctx, `SELECT owner_id FROM documents WHERE id = ?`, documentID).Scan(&ownerID)
+	if err != nil {
+		return sqlNotFound(err)
+	}
+	if ownerID != userID {
+		return ErrPermissionDenied
+	}
+
+	if folderID != "" {
+		rank, err := s.folderRank(ctx, folderID, userID)
+		if err != nil {
+			return err
+		}
+		if rank < roleRank(RoleEditor) {
+			return ErrPermissionDenied
+		}
+	}
+
+	_, err = s.db.ExecContext(ctx, `UPDATE documents SET folder_id = ? WHERE id = ?`, nullableID(folderID), documentID)
+	return err
+}
+
+// folderRank returns userID's rank on folderID, or ErrNotFound if the folder
+// doesn't exist.
+func (s *SQLiteStore) folderRank(ctx context.Context, folderID, userID string) (int, error) {
+	query := folderAccessCTE("?1") + `
+		SELECT COALESCE(fa.role_rank, 0)
+		FROM folders f
+		LEFT JOIN folder_access fa ON fa.folder_id = f.id
+		WHERE f.id = ?2
+	`
+	var rank int
+	if err := s.db.QueryRowContext(ctx, query, userID, folderID).Scan(&rank); err != nil {
+		return 0, sqlNotFound(err)
+	}
+	return rank, nil
+}
+
+func (s *SQLiteStore) CreateWorkspace(ctx context.Context, name, ownerID string) (*Workspace, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	ws := &Workspace{ID: uuid.NewString(), Name: name, OwnerID: ownerID, Role: RoleOwner, CreatedAt: now}
+	query := `INSERT INTO workspaces (id, name, owner_id, created_at) VALUES (?, ?, ?, ?)`
+
+	if _, err := s.db.ExecContext(ctx, query, ws.ID, name, ownerID, sqliteTime(now)); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func (s *SQLiteStore) ListWorkspaces(ctx context.Context, userID string) ([]*Workspace, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT w.id, w.name, w.owner_id, CASE WHEN w.owner_id = ?1 THEN 'owner' ELSE wm.role END, w.created_at
+		FROM workspaces w
+		LEFT JOIN workspace_members wm ON wm.workspace_id = w.id AND wm.user_id = ?1
+		WHERE w.owner_id = ?1 OR wm.user_id IS NOT NULL
+		ORDER BY w.name, w.id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []*Workspace{}
+	for rows.Next() {
+		ws := &Workspace{}
+		if err := rows.Scan(&ws.ID, &ws.Name, &ws.OwnerID, &ws.Role, &ws.CreatedAt); err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, ws)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return workspaces, nil
+}
+
+// checkWorkspaceOwner returns ErrNotFound or ErrPermissionDenied unless
+// ownerID owns workspaceID.
+func (s *SQLiteStore) checkWorkspaceOwner(ctx context.Context, workspaceID, ownerID string) error {
+	var actualOwner string
+	err := s.db.QueryRowContext(ctx, `SELECT owner_id FROM workspaces WHERE id = ?`, workspaceID).Scan(&actualOwner)
+	if err != nil {
+		return sqlNotFound(err)
+	}
+	if actualOwner != ownerID {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+func (s *SQLiteStore) AddWorkspaceMember(ctx context.Context, workspaceID, ownerID, userID, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkWorkspaceOwner(ctx, workspaceID, ownerID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO workspace_members (workspace_id, user_id, role)
+		VALUES (?, ?, ?)
+		ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = excluded.role
+	`
+	_, err := s.db.ExecContext(ctx, query, workspaceID, userID, role)
+	return err
+}
+
+func (s *SQLiteStore) RemoveWorkspaceMember(ctx context.Context, workspaceID, ownerID, userID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkWorkspaceOwner(ctx, workspaceID, ownerID); err != nil {
+		return err
+	}
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?`, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) CreateFolder(ctx context.Context, name, ownerID, parentID, workspaceID string) (*Folder, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	folder := &Folder{ID: uuid.NewString(), Name: name, OwnerID: ownerID, Role: RoleOwner, CreatedAt: now}
+	switch {
+	case parentID != "":
+		rank, err := s.folderRank(ctx, parentID, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		if rank < roleRank(RoleEditor) {
+			return nil, ErrPermissionDenied
+		}
+		err = s.db.QueryRowContext(ctx, `SELECT COALESCE(workspace_id, '') FROM folders WHERE id = ?`, parentID).Scan(&folder.WorkspaceID)
+		if err != nil {
+			return nil, sqlNotFound(err)
+		}
+		folder.ParentID = parentID
+	case workspaceID != "":
+		query := `
+			SELECT CASE WHEN w.owner_id = ?1 THEN 'editor' ELSE COALESCE(wm.role, '') END
+			FROM workspaces w
+			LEFT JOIN workspace_members wm ON wm.workspace_id = w.id AND wm.user_id = ?1
+			WHERE w.id = ?2
+		`
+		var role string
+		if err := s.db.QueryRowContext(ctx, query, ownerID, workspaceID).Scan(&role); err != nil {
+			return nil, sqlNotFound(err)
+		}
+		if roleRank(role) < roleRank(RoleEditor) {
+			return nil, ErrPermissionDenied
+		}
+		folder.WorkspaceID = workspaceID
+	}
+
+	query := `INSERT INTO folders (id, name, owner_id, parent_id, workspace_id, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, folder.ID, name, ownerID, nullableID(folder.ParentID), nullableID(folder.WorkspaceID), sqliteTime(now))
+	if err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+// sqliteFolderColumns are scanned by scanSQLiteFolder, followed by the caller's rank.
+const sqliteFolderColumns = `f.id, f.name, f.owner_id, COALESCE(f.parent_id, ''), COALESCE(f.workspace_id, ''), f.created_at, COALESCE(fa.role_rank, 0)`
+
+func scanSQLiteFolder(row interface{ Scan(...interface{}) error }, userID string) (*Folder, int, error) {
+	f := &Folder{}
+	var rank int
+	if err := row.Scan(&f.ID, &f.Name, &f.OwnerID, &f.ParentID, &f.WorkspaceID, &f.CreatedAt, &rank); err != nil {
+		return nil, 0, err
+	}
+	f.Role = folderRole(f, userID, rank)
+	return f, rank, nil
+}
+
+func (s *SQLiteStore) GetFolder(ctx context.Context, folderID, userID string) (*Folder, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := folderAccessCTE("?1") + `
+		SELECT ` + sqliteFolderColumns + `
+		FROM folders f
+		LEFT JOIN folder_access fa ON fa.folder_id = f.id
+		WHERE f.id = ?2
+	`
+	folder, rank, err := scanSQLiteFolder(s.db.QueryRowContext(ctx, query, userID, folderID), userID)
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+	if rank == 0 {
+		return nil, ErrPermissionDenied
+	}
+	return folder, nil
+}
+
+func (s *SQLiteStore) ListFolders(ctx context.Context, userID, parentID string) ([]*Folder, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	args := []interface{}{userID}
+	// See PostgresStore.ListFolders.
+	condition := `f.parent_id IS NULL OR f.parent_id NOT IN (SELECT folder_id FROM folder_access)`
+	if parentID != "" {
+		rank, err := s.folderRank(ctx, parentID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if rank == 0 {
+			return nil, ErrPermissionDenied
+		}
+		args = append(args, parentID)
+		condition = `f.parent_id = ?2`
+	}
+
+	query := folderAccessCTE("?1") + `
+		SELECT ` + sqliteFolderColumns + `
+		FROM folders f
+		JOIN folder_access fa ON fa.folder_id = f.id
+		WHERE ` + condition + `
+		ORDER BY f.name, f.id
+	`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []*Folder{}
+	for rows.Next() {
+		folder, _, err := scanSQLiteFolder(rows, userID)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+func (s *SQLiteStore) ShareFolder(ctx context.Context, folderID, ownerID, targetUserID, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var actualOwner string
+	err := s.db.QueryRowContext(ctx, `SELECT owner_id FROM folders WHERE id = ?`, folderID).Scan(&actualOwner)
+	if err != nil {
+		return sqlNotFound(err)
+	}
+	if actualOwner != ownerID {
+		return ErrPermissionDenied
+	}
+
+	query := `
+		INSERT INTO folder_permissions (folder_id, user_id, role)
+		VALUES (?, ?, ?)
+		ON CONFLICT (folder_id, user_id) DO NOTHING
+	`
+	_, err = s.db.ExecContext(ctx, query, folderID, targetUserID, role)
+	return err
+}
+
+// documentRank returns userID's rank on documentID, 0 for no access, or
+// ErrNotFound if documentID doesn't exist.
+func (s *SQLiteStore) documentRank(ctx context.Context, documentID, userID string) (int, error) {
+	query := documentAccessCTE("?1") + `
+		SELECT COALESCE(a.role_rank, 0)
+		FROM documents d
+		LEFT JOIN document_access a ON a.document_id = d.id
+		WHERE d.id = ?2
+	`
+	var rank int
+	if err := s.db.QueryRowContext(ctx, query, userID, documentID).Scan(&rank); err != nil {
+		return 0, sqlNotFound(err)
+	}
+	return rank, nil
+}
+
+// requireDocumentRole returns ErrNotFound if documentID doesn't exist, or
+// ErrPermissionDenied unless userID's role on it is at least min.
+func (s *SQLiteStore) requireDocumentRole(ctx context.Context, documentID, userID, min string) error {
+	rank, err := s.documentRank(ctx, documentID, userID)
+	if err != nil {
+		return err
+	}
+	if rank < roleRank(min) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetFavorite(ctx context.Context, documentID, userID string, favorite bool) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.requireDocumentRole(ctx, documentID, userID, RoleViewer); err != nil {
+		return err
+	}
+
+	if !favorite {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM document_favorites WHERE document_id = ? AND user_id = ?`, documentID, userID)
+		return err
+	}
+	query := `
+		INSERT INTO document_favorites (document_id, user_id, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (document_id, user_id) DO NOTHING
+	`
+	_, err := s.db.ExecContext(ctx, query, documentID, userID, sqliteTime(time.Now()))
+	return err
+}
+
+func (s *SQLiteStore) GetDocumentTags(ctx context.Context, documentID, userID string) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.requireDocumentRole(ctx, documentID, userID, RoleViewer); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT tag FROM document_tags WHERE document_id = ? ORDER BY tag`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (s *SQLiteStore) AddDocumentTag(ctx context.Context, documentID, userID, tag string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.requireDocumentRole(ctx, documentID, userID, RoleEditor); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO document_tags (document_id, tag, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (document_id, tag) DO NOTHING
+	`
+	_, err := s.db.ExecContext(ctx, query, documentID, tag, sqliteTime(time.Now()))
+	return err
+}
+
+func (s *SQLiteStore) RemoveDocumentTag(ctx context.Context, documentID, userID, tag string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.requireDocumentRole(ctx, documentID, userID, RoleEditor); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM document_tags WHERE document_id = ? AND tag = ?`, documentID, tag)
+	return err
+}
+
+func (s *SQLiteStore) ListTags(ctx context.Context, userID string) ([]*TagCount, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := documentAccessCTE("?1") + `
+		SELECT t.tag, COUNT(*)
+		FROM document_tags t
+		JOIN document_access a ON a.document_id = t.document_id
+		GROUP BY t.tag
+		ORDER BY t.tag
+	`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*TagCount{}
+	for rows.Next() {
+		tc := &TagCount{}
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (s *SQLiteStore) ShareDocumentWithTeam(ctx context.Context, documentID, ownerID, teamID, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var isOwner, teamExists bool
+	checkQuery := `
+		SELECT EXISTS(SELECT 1 FROM documents WHERE id = ?1 AND owner_id = ?2),
+			EXISTS(SELECT 1 FROM teams WHERE id = ?3)
+	`
+	if err := tx.QueryRowContext(ctx, checkQuery, documentID, ownerID, teamID).Scan(&isOwner, &teamExists); err != nil {
+		return err
+	}
+	if !isOwner {
+		return ErrNotDocumentOwner
+	}
+	if !teamExists {
+		return ErrNotFound
+	}
+
+	insertQuery := `
+		INSERT INTO team_document_permissions (document_id, team_id, role)
+		VALUES (?, ?, ?)
+		ON CONFLICT (document_id, team_id) DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, insertQuery, documentID, teamID, role); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const sqliteInvitationColumns = `id, document_id, email, role, invited_by, created_at`
+
+func scanSQLiteInvitation(row interface{ Scan(...interface{}) error }) (*Invitation, error) {
+	inv := &Invitation{}
+	if err := row.Scan(&inv.ID, &inv.DocumentID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.CreatedAt); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (s *SQLiteStore) InviteToDocument(ctx context.Context, documentID, ownerID, email, role string) (*Invitation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	email = invitationEmail(email)
+	insertQuery := `
+		INSERT INTO document_invitations (id, document_id, email, role, invited_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (document_id, email) DO NOTHING
+	`
+	_, err := s.db.ExecContext(ctx, insertQuery, uuid.NewString(), documentID, email, role, ownerID, sqliteTime(time.Now().UTC()))
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + sqliteInvitationColumns + ` FROM document_invitations WHERE document_id = ? AND email = ?`
+	return scanSQLiteInvitation(s.db.QueryRowContext(ctx, query, documentID, email))
+}
+
+func (s *SQLiteStore) ListDocumentShares(ctx context.Context, documentID, ownerID string) ([]*DocumentShare, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	shares := []*DocumentShare{}
+	userQuery := `
+		SELECT p.user_id, u.email, p.role
+		FROM document_permissions p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.document_id = ?
+		ORDER BY u.email
+	`
+	rows, err := s.db.QueryContext(ctx, userQuery, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		share := &DocumentShare{}
+		if err := rows.Scan(&share.UserID, &share.Email, &share.Role); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	teamQuery := `
+		SELECT tp.team_id, t.name, tp.role
+		FROM team_document_permissions tp
+		JOIN teams t ON t.id = tp.team_id
+		WHERE tp.document_id = ?
+		ORDER BY t.name
+	`
+	rows, err = s.db.QueryContext(ctx, teamQuery, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		share := &DocumentShare{}
+		if err := rows.Scan(&share.TeamID, &share.TeamName, &share.Role); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+func (s *SQLiteStore) ListInvitations(ctx context.Context, documentID, ownerID string) ([]*Invitation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + sqliteInvitationColumns + ` FROM document_invitations WHERE document_id = ? ORDER BY email`
+	rows, err := s.db.QueryContext(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+	for rows.Next() {
+		inv, err := scanSQLiteInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (s *SQLiteStore) CancelInvitation(ctx context.Context, documentID, invitationID, ownerID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM document_invitations WHERE id = ? AND document_id = ?`, invitationID, documentID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ClaimInvitations(ctx context.Context, email, userID string) ([]*Invitation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	email = invitationEmail(email)
+	query := `SELECT ` + sqliteInvitationColumns + ` FROM document_invitations WHERE email = ?`
+	rows, err := tx.QueryContext(ctx, query, email)
+	if err != nil {
+		return nil, err
+	}
+	claimed := []*Invitation{}
+	for rows.Next() {
+		inv, err := scanSQLiteInvitation(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		claimed = append(claimed, inv)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	insertQuery := `
+		INSERT INTO document_permissions (document_id, user_id, role)
+		VALUES (?, ?, ?)
+		ON CONFLICT (document_id, user_id) DO NOTHING
+	`
+	for _, inv := range claimed {
+		if _, err := tx.ExecContext(ctx, insertQuery, inv.DocumentID, userID, inv.Role); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM document_invitations WHERE email = ?`, email); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// sqliteAccessRequestColumns are scanned by scanSQLiteAccessRequest; queries
+// alias access_requests as r and join users as u.
+const sqliteAccessRequestColumns = `r.id, r.document_id, r.user_id, u.email, r.role, r.message, r.status, r.created_at, r.decided_at`
+
+func scanSQLiteAccessRequest(row interface{ Scan(...interface{}) error }) (*AccessRequest, error) {
+	req := &AccessRequest{}
+	err := row.Scan(&req.ID, &req.DocumentID, &req.UserID, &req.Email, &req.Role, &req.Message,
+		&req.Status, &req.CreatedAt, &req.DecidedAt)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// sqliteGrantRoleSQL is grantRoleSQL for SQLite.
+var sqliteGrantRoleSQL = fmt.Sprintf(`
+	INSERT INTO document_permissions (document_id, user_id, role)
+	VALUES (?, ?, ?)
+	ON CONFLICT (document_id, user_id) DO UPDATE
+	SET role = CASE WHEN %s > %s THEN excluded.role ELSE document_permissions.role END
+`, roleRankSQL("excluded.role"), roleRankSQL("document_permissions.role"))
+
+func (s *SQLiteStore) RequestAccess(ctx context.Context, documentID, userID, role, message string) (*AccessRequest, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rank, err := s.documentRank(ctx, documentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if rank >= roleRank(role) {
+		return nil, ErrAlreadyHasAccess
+	}
+
+	now := sqliteTime(time.Now().UTC())
+	upsertQuery := `
+		INSERT INTO access_requests (id, document_id, user_id, role, message, status, created_at)
+		VALUES (?, ?, ?, ?, ?, 'pending', ?)
+		ON CONFLICT (document_id, user_id) WHERE status = 'pending'
+		DO UPDATE SET role = excluded.role, message = excluded.message, created_at = excluded.created_at
+	`
+	if _, err := s.db.ExecContext(ctx, upsertQuery, uuid.NewString(), documentID, userID, role, message, now); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + sqliteAccessRequestColumns + `
+		FROM access_requests r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.document_id = ? AND r.user_id = ? AND r.status = 'pending'
+	`
+	return scanSQLiteAccessRequest(s.db.QueryRowContext(ctx, query, documentID, userID))
+}
+
+func (s *SQLiteStore) ListAccessRequests(ctx context.Context, documentID, ownerID string) ([]*AccessRequest, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + sqliteAccessRequestColumns + `
+		FROM access_requests r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.document_id = ? AND r.status = 'pending'
+		ORDER BY r.created_at, r.id
+	`
+	rows, err := s.db.QueryContext(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []*AccessRequest{}
+	for rows.Next() {
+		req, err := scanSQLiteAccessRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func (s *SQLiteStore) DecideAccessRequest(ctx context.Context, documentID, requestID, ownerID string, approve bool) (*AccessRequest, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	status := AccessRequestDenied
+	if approve {
+		status = AccessRequestApproved
+	}
+	updateQuery := `
+		UPDATE access_requests SET status = ?, decided_at = ?
+		WHERE id = ? AND document_id = ? AND status = 'pending'
+	`
+	res, err := tx.ExecContext(ctx, updateQuery, status, sqliteTime(time.Now().UTC()), requestID, documentID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrNotFound
+	}
+
+	query := `SELECT ` + sqliteAccessRequestColumns + ` FROM access_requests r JOIN users u ON u.id = r.user_id WHERE r.id = ?`
+	req, err := scanSQLiteAccessRequest(tx.QueryRowContext(ctx, query, requestID))
+	if err != nil {
+		return nil, err
+	}
+	if approve {
+		if _, err := tx.ExecContext(ctx, sqliteGrantRoleSQL, documentID, req.UserID, req.Role); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// nullableTime stores a nil *time.Time as NULL.
+func nullableTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return sqliteTime(*t)
+}
+
+func (s *SQLiteStore) CreateShareLink(ctx context.Context, documentID, ownerID string, opts ShareLinkOptions) (*ShareLink, error) {
+	link, tokenHash, err := newShareLink(documentID, ownerID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	link.ID = uuid.NewString()
+	link.CreatedAt = time.Now().UTC()
+	// The INSERT ... SELECT only inserts when ownerID owns the document.
+	query := `
+		INSERT INTO share_links (id, document_id, token_hash, role, password_hash, expires_at, max_uses, created_by, created_at)
+		SELECT ?, id, ?, ?, ?, ?, ?, owner_id, ? FROM documents WHERE id = ? AND owner_id = ?
+	`
+	res, err := s.db.ExecContext(ctx, query, link.ID, tokenHash, link.Role, link.PasswordHash,
+		nullableTime(link.ExpiresAt), link.MaxUses, sqliteTime(link.CreatedAt), documentID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrNotDocumentOwner
+	}
+	return link, nil
+}
+
+// sqliteShareLinkColumns are scanned by scanSQLiteShareLink.
+const sqliteShareLinkColumns = `id, document_id, role, password_hash, expires_at, max_uses, uses, created_by, created_at, revoked_at`
+
+func scanSQLiteShareLink(row interface{ Scan(...interface{}) error }) (*ShareLink, error) {
+	link := &ShareLink{}
+	err := row.Scan(&link.ID, &link.DocumentID, &link.Role, &link.PasswordHash, &link.ExpiresAt,
+		&link.MaxUses, &link.Uses, &link.CreatedBy, &link.CreatedAt, &link.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	link.HasPassword = link.PasswordHash != ""
+	return link, nil
+}
+
+func (s *SQLiteStore) checkDocumentOwner(ctx context.Context, documentID, ownerID string) error {
+	var isOwner bool
+	query := `SELECT EXISTS(SELECT 1 FROM documents WHERE id = ? AND owner_id = ?)`
+	if err := s.db.QueryRowContext(ctx, query, documentID, ownerID).Scan(&isOwner); err != nil {
+		return err
+	}
+	if !isOwner {
+		return ErrNotDocumentOwner
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListShareLinks(ctx context.Context, documentID, ownerID string) ([]*ShareLink, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + sqliteShareLinkColumns + ` FROM share_links WHERE document_id = ? ORDER BY created_at DESC, id`
+	rows, err := s.db.QueryContext(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []*ShareLink{}
+	for rows.Next() {
+		link, err := scanSQLiteShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (s *SQLiteStore) RevokeShareLink(ctx context.Context, documentID, linkID, ownerID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.checkDocumentOwner(ctx, documentID, ownerID); err != nil {
+		return err
+	}
+
+	query := `UPDATE share_links SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ? AND document_id = ?`
+	res, err := s.db.ExecContext(ctx, query, sqliteTime(time.Now().UTC()), linkID, documentID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetShareLinkByToken(ctx context.Context, documentID, token string) (*ShareLink, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + sqliteShareLinkColumns + ` FROM share_links WHERE token_hash = ? AND document_id = ?`
+	link, err := scanSQLiteShareLink(s.db.QueryRowContext(ctx, query, hashToken(token), documentID))
+	if err != nil {
+		return nil, sqlNotFound(err)
+	}
+	return link, nil
+}
+
+func (s *SQLiteStore) UseShareLink(ctx context.Context, linkID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE share_links SET uses = uses + 1
+		WHERE id = ?1 AND revoked_at IS NULL
+			AND (expires_at IS NULL OR expires_at > ?2)
+			AND (max_uses = 0 OR uses < max_uses)
+	`
+	res, err := s.db.ExecContext(ctx, query, linkID, sqliteTime(time.Now().UTC()))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrLinkUnavailable
+	}
+	return nil
+}
+
+func (s *SQLiteStore) CreateTeam(ctx context.Context, name, ownerID string) (*Team, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	team := &Team{ID: uuid.NewString(), Name: name, Role: TeamRoleOwner, CreatedAt: now}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO teams (id, name, created_at) VALUES (?, ?, ?)`, team.ID, name, sqliteTime(now)); err != nil {
+		return nil, err
+	}
+	memberQuery := `INSERT INTO team_members (team_id, user_id, role, created_at) VALUES (?, ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, memberQuery, team.ID, ownerID, TeamRoleOwner, sqliteTime(now)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return team, nil
+}
+
+func (s *SQLiteStore) ListTeams(ctx context.Context, userID string) ([]*Team, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT t.id, t.name, tm.role, t.created_at
+		FROM teams t
+		JOIN team_members tm ON tm.team_id = t.id
+		WHERE tm.user_id = ?
+		ORDER BY t.name, t.id
+	`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := []*Team{}
+	for rows.Next() {
+		team := &Team{}
+		if err := rows.Scan(&team.ID, &team.Name, &team.Role, &team.CreatedAt); err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+// sqliteTeamRoles mirrors teamRoles for SQLite.
+func sqliteTeamRoles(ctx context.Context, tx *sql.Tx, teamID, actorID, userID string) (string, string, error) {
+	query := `
+		SELECT EXISTS(SELECT 1 FROM teams WHERE id = ?1),
+			COALESCE((SELECT role FROM team_members WHERE team_id = ?1 AND user_id = ?2), ''),
+			COALESCE((SELECT role FROM team_members WHERE team_id = ?1 AND user_id = ?3), '')
+	`
+	var exists bool
+	var actorRole, userRole string
+	if err := tx.QueryRowContext(ctx, query, teamID, actorID, userID).Scan(&exists, &actorRole, &userRole); err != nil {
+		return "", "", err
+	}
+	if !exists {
+		return "", "", ErrNotFound
+	}
+	return actorRole, userRole, nil
+}
+
+func (s *SQLiteStore) GetTeamMembers(ctx context.Context, teamID, userID string) ([]*TeamMember, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	callerRole, _, err := sqliteTeamRoles(ctx, tx, teamID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if callerRole == "" {
+		return nil, ErrPermissionDenied
+	}
+
+	query := `
+		SELECT tm.user_id, u.email, u.display_name, u.avatar_url, tm.role, tm.created_at
+		FROM team_members tm
+		JOIN users u ON u.id = tm.user_id
+		WHERE tm.team_id = ?
+		ORDER BY u.email
+	`
+	rows, err := tx.QueryContext(ctx, query, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*TeamMember{}
+	for rows.Next() {
+		m := &TeamMember{}
+		if err := rows.Scan(&m.UserID, &m.Email, &m.DisplayName, &m.AvatarURL, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (s *SQLiteStore) AddTeamMember(ctx context.Context, teamID, actorID, userID, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	actorRole, userRole, err := sqliteTeamRoles(ctx, tx, teamID, actorID, userID)
+	if err != nil {
+		return err
+	}
+	if !canChangeTeamMember(actorRole, userRole, role, actorID == userID) {
+		return ErrPermissionDenied
+	}
+
+	query := `
+		INSERT INTO team_members (team_id, user_id, role, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (team_id, user_id) DO UPDATE SET role = excluded.role
+	`
+	if _, err := tx.ExecContext(ctx, query, teamID, userID, role, sqliteTime(time.Now())); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) RemoveTeamMember(ctx context.Context, teamID, actorID, userID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	actorRole, userRole, err := sqliteTeamRoles(ctx, tx, teamID, actorID, userID)
+	if err != nil {
+		return err
+	}
+	if !canChangeTeamMember(actorRole, userRole, "", actorID == userID) {
+		return ErrPermissionDenied
+	}
+	if userRole == "" {
+		return ErrNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM team_members WHERE team_id = ? AND user_id = ?`, teamID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
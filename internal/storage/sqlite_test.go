@@ -0,0 +1,43 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage/storagetest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	storagetest.TestStore(t, func(t *testing.T) storage.Store {
+		store, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"), 5*time.Second)
+		if err != nil {
+			t.Fatalf("NewSQLiteStore: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+// Services sharing a database file migrate it when they start, possibly at
+// the same time.
+func TestSQLiteStoreConcurrentMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.db")
+	const services = 4
+	errs := make(chan error, services)
+	for i := 0; i < services; i++ {
+		go func() {
+			store, err := storage.NewSQLiteStore(path, 5*time.Second)
+			if err == nil {
+				store.Close()
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < services; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("NewSQLiteStore: %v", err)
+		}
+	}
+}
@@ -1,14 +1,220 @@
 package storage
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested user or document does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrNotDocumentOwner is returned when a non-owner tries an owner-only action.
+	ErrNotDocumentOwner = errors.New("permission denied: only the owner can share this document")
+)
+
+// Document roles. The owner role is implicit and never stored in document_permissions.
+// Commenters can read but not edit, like viewers.
+const (
+	RoleOwner     = "owner"
+	RoleEditor    = "editor"
+	RoleCommenter = "commenter"
+	RoleViewer    = "viewer"
+)
+
+// ValidShareRole reports whether role can be granted through ShareDocument
+// or a share link.
+func ValidShareRole(role string) bool {
+	return role == RoleEditor || role == RoleCommenter || role == RoleViewer
+}
+
 type Store interface {
-	CreateUser(email, password string) (*User, error)
-	GetUserByEmail(email string) (*User, error)
-	GetUserByID(id string) (*User, error)
-
-	CheckDocumentPermission(documentID, userID string) (bool, error)
-	CreateDocument(title, ownerID string) (*Document, error)
-	GetDocument(documentID string) (*Document, error)
-	GetUserDocuments(userID string) ([]*Document, error)
-	UpdateDocument(documentID, content string, version int) error
-	ShareDocument(documentID, ownerID, targetUserID, role string) error
+	CreateUser(ctx context.Context, email, password string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserByID(ctx context.Context, id string) (*User, error)
+	// UpdateProfile changes userID's profile and returns the updated user.
+	UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) (*User, error)
+	// ChangePassword sets userID's password and ends all of their sessions.
+	ChangePassword(ctx context.Context, userID, password string) error
+	// ChangeEmail changes userID's email address, which is unverified until
+	// they verify it again. It returns ErrEmailTaken if another account has
+	// the address.
+	ChangeEmail(ctx context.Context, userID, email string) (*User, error)
+	// DeleteUser deletes userID's account, its sessions and its access to
+	// other people's documents. What it owns is dealt with as opts says
+	// first; teams it owns pass to their longest-standing admin, or member,
+	// and are deleted if it was the only member.
+	DeleteUser(ctx context.Context, userID string, opts DeleteUserOptions) error
+
+	// CreateRefreshToken starts a login session for userID and returns its
+	// first refresh token.
+	CreateRefreshToken(ctx context.Context, userID string, expiresAt time.Time) (*RefreshToken, error)
+	// RotateRefreshToken consumes token and returns the next one in its
+	// session, expiring at expiresAt. Presenting a token that was already
+	// rotated revokes the session and returns ErrRefreshTokenReused.
+	RotateRefreshToken(ctx context.Context, token string, expiresAt time.Time) (*RefreshToken, error)
+	// RevokeRefreshToken ends the session token belongs to. Unknown tokens
+	// are ignored, so logging out twice is not an error; tokens of anyone but
+	// userID return ErrNotFound.
+	RevokeRefreshToken(ctx context.Context, userID, token string) error
+
+	// CreatePersonalToken issues a personal access token for userID limited
+	// to scopes. The returned token is the only copy of its secret.
+	CreatePersonalToken(ctx context.Context, userID, name string, scopes []string, expiresAt time.Time) (*PersonalToken, error)
+	// ListPersonalTokens returns userID's unexpired tokens, newest first.
+	ListPersonalTokens(ctx context.Context, userID string) ([]*PersonalToken, error)
+	// RevokePersonalToken deletes one of userID's tokens.
+	RevokePersonalToken(ctx context.Context, userID, tokenID string) error
+	// UsePersonalToken returns the token with secret token and records that
+	// it was used, or returns ErrInvalidPersonalToken.
+	UsePersonalToken(ctx context.Context, token string) (*PersonalToken, error)
+
+	// CreateAccountToken issues a single-use token of purpose (TokenVerifyEmail
+	// or TokenResetPassword) for userID's current email address. Unused
+	// tokens issued earlier for the same purpose stop working.
+	CreateAccountToken(ctx context.Context, userID, purpose string, expiresAt time.Time) (string, error)
+	// VerifyEmail consumes a TokenVerifyEmail token and marks the address it
+	// was sent to as verified.
+	VerifyEmail(ctx context.Context, token string) (*User, error)
+	// AccountTokenUser returns the user a token of purpose was issued to
+	// without consuming it, or ErrInvalidAccountToken if it couldn't be
+	// consumed.
+	AccountTokenUser(ctx context.Context, token, purpose string) (*User, error)
+	// ResetPassword consumes a TokenResetPassword token, sets the user's
+	// password and ends all of their sessions.
+	ResetPassword(ctx context.Context, token, password string) (*User, error)
+	// ConsumeAccountToken consumes a token of any other purpose and returns
+	// the user it was issued to.
+	ConsumeAccountToken(ctx context.Context, token, purpose string) (*User, error)
+
+	// SetTOTPSecret stores a new, not yet enabled, two-factor secret for
+	// userID, replacing any earlier one. secret is encrypted by the caller.
+	// It returns ErrTOTPEnabled if two-factor authentication is enabled.
+	SetTOTPSecret(ctx context.Context, userID string, secret []byte) error
+	// GetTOTP returns userID's two-factor setup; Secret is nil if they never
+	// started enrolling.
+	GetTOTP(ctx context.Context, userID string) (*TOTP, error)
+	// EnableTOTP turns on two-factor authentication with the stored secret,
+	// recording step as used, and replaces userID's recovery codes.
+	EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodes []string) error
+	// RecordTOTPStep records that a code for time step was accepted. It
+	// returns ErrTOTPCodeReused unless step is later than the last one.
+	RecordTOTPStep(ctx context.Context, userID string, step int64) error
+	// UseRecoveryCode consumes one of userID's recovery codes, or returns
+	// ErrInvalidRecoveryCode.
+	UseRecoveryCode(ctx context.Context, userID, code string) error
+	// DisableTOTP turns off two-factor authentication and forgets the secret
+	// and recovery codes.
+	DisableTOTP(ctx context.Context, userID string) error
+
+	// GetUserByIdentity returns the user an external identity (an OpenID
+	// Connect issuer and subject) is linked to, or ErrNotFound.
+	GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error)
+	// LinkIdentity links an external identity to userID. It returns
+	// ErrIdentityLinked if the identity is already linked.
+	LinkIdentity(ctx context.Context, userID, issuer, subject string) error
+	// CreateUserWithIdentity provisions a user without a password, who signs
+	// in through the linked identity (or sets a password by resetting it).
+	CreateUserWithIdentity(ctx context.Context, email string, emailVerified bool, issuer, subject string) (*User, error)
+
+	CheckDocumentPermission(ctx context.Context, documentID, userID string) (bool, error)
+	// GetDocumentRole returns userID's effective role on documentID, or ""
+	// if they have no access.
+	GetDocumentRole(ctx context.Context, documentID, userID string) (string, error)
+	CreateDocument(ctx context.Context, title, ownerID string) (*Document, error)
+	GetDocument(ctx context.Context, documentID string) (*Document, error)
+	GetUserDocuments(ctx context.Context, userID string) ([]*Document, error)
+	// ListDocuments returns one page of the documents userID owns or can
+	// access through a share, a folder or a workspace.
+	ListDocuments(ctx context.Context, userID string, opts DocumentListOptions) (*DocumentPage, error)
+	// SearchDocuments full-text searches the titles and content of the
+	// documents userID can access, best matches first.
+	SearchDocuments(ctx context.Context, userID, query string, limit int) ([]*SearchResult, error)
+	// RecordDocumentOpen remembers that userID opened documentID now, for SortLastOpened.
+	RecordDocumentOpen(ctx context.Context, documentID, userID string) error
+	UpdateDocument(ctx context.Context, documentID, content string, version int) error
+	ShareDocument(ctx context.Context, documentID, ownerID, targetUserID, role string) error
+	// ShareDocumentWithTeam grants role on documentID to every current and
+	// future member of teamID. Like ShareDocument, only the owner may share.
+	ShareDocumentWithTeam(ctx context.Context, documentID, ownerID, teamID, role string) error
+	// InviteToDocument records that email, which has no account yet, gets
+	// role on documentID once it registers. Like ShareDocument, only the
+	// owner may invite, and inviting the same email again keeps the first
+	// invitation. Emails are matched case-insensitively.
+	InviteToDocument(ctx context.Context, documentID, ownerID, email, role string) (*Invitation, error)
+	// ListDocumentShares returns the users and teams documentID is shared
+	// with; owner only.
+	ListDocumentShares(ctx context.Context, documentID, ownerID string) ([]*DocumentShare, error)
+	// ListInvitations returns documentID's pending invitations; owner only.
+	ListInvitations(ctx context.Context, documentID, ownerID string) ([]*Invitation, error)
+	CancelInvitation(ctx context.Context, documentID, invitationID, ownerID string) error
+	// ClaimInvitations turns every pending invitation for email into a share
+	// with userID, and returns the invitations it converted.
+	ClaimInvitations(ctx context.Context, email, userID string) ([]*Invitation, error)
+	// RequestAccess asks documentID's owner to give userID role. A pending
+	// request from the same user is updated instead of duplicated. It returns
+	// ErrAlreadyHasAccess if userID's role is already role or higher.
+	RequestAccess(ctx context.Context, documentID, userID, role, message string) (*AccessRequest, error)
+	// ListAccessRequests returns documentID's pending requests; owner only.
+	ListAccessRequests(ctx context.Context, documentID, ownerID string) ([]*AccessRequest, error)
+	// DecideAccessRequest approves or denies a pending request; owner only.
+	// Approving grants the requested role unless the user already has a
+	// higher one.
+	DecideAccessRequest(ctx context.Context, documentID, requestID, ownerID string, approve bool) (*AccessRequest, error)
+	// CreateShareLink creates a link granting opts.Role on documentID to
+	// anyone with its token. Only the owner may create, list or revoke links.
+	CreateShareLink(ctx context.Context, documentID, ownerID string, opts ShareLinkOptions) (*ShareLink, error)
+	ListShareLinks(ctx context.Context, documentID, ownerID string) ([]*ShareLink, error)
+	RevokeShareLink(ctx context.Context, documentID, linkID, ownerID string) error
+	// GetShareLinkByToken returns documentID's link with token, whether or
+	// not it is still usable.
+	GetShareLinkByToken(ctx context.Context, documentID, token string) (*ShareLink, error)
+	// UseShareLink counts one use of linkID, or returns ErrLinkUnavailable if
+	// it is no longer usable.
+	UseShareLink(ctx context.Context, linkID string) error
+	// MoveDocument files documentID, which userID must own, under folderID;
+	// an empty folderID unfiles it. userID needs RoleEditor on the folder.
+	MoveDocument(ctx context.Context, documentID, userID, folderID string) error
+
+	// SetFavorite stars or unstars documentID for userID, who must have access to it.
+	SetFavorite(ctx context.Context, documentID, userID string, favorite bool) error
+	// GetDocumentTags returns documentID's tags in order; userID needs access.
+	GetDocumentTags(ctx context.Context, documentID, userID string) ([]string, error)
+	// AddDocumentTag and RemoveDocumentTag require RoleEditor on documentID.
+	// Tags are expected to be normalized with NormalizeTag.
+	AddDocumentTag(ctx context.Context, documentID, userID, tag string) error
+	RemoveDocumentTag(ctx context.Context, documentID, userID, tag string) error
+	// ListTags returns every tag on the documents userID can access, with counts.
+	ListTags(ctx context.Context, userID string) ([]*TagCount, error)
+
+	CreateWorkspace(ctx context.Context, name, ownerID string) (*Workspace, error)
+	// ListWorkspaces returns the workspaces userID owns or is a member of.
+	ListWorkspaces(ctx context.Context, userID string) ([]*Workspace, error)
+	// AddWorkspaceMember grants userID role in the workspace, replacing any
+	// previous role. Only the workspace owner may add members.
+	AddWorkspaceMember(ctx context.Context, workspaceID, ownerID, userID, role string) error
+	RemoveWorkspaceMember(ctx context.Context, workspaceID, ownerID, userID string) error
+
+	// CreateFolder creates a folder inside parentID, or at the top level of
+	// workspaceID, or as a personal top-level folder when both are empty.
+	CreateFolder(ctx context.Context, name, ownerID, parentID, workspaceID string) (*Folder, error)
+	// GetFolder returns folderID with userID's role on it, or
+	// ErrPermissionDenied if userID can't see it.
+	GetFolder(ctx context.Context, folderID, userID string) (*Folder, error)
+	// ListFolders returns the folders directly inside parentID. With an empty
+	// parentID it returns the top-most folders userID can see.
+	ListFolders(ctx context.Context, userID, parentID string) ([]*Folder, error)
+	ShareFolder(ctx context.Context, folderID, ownerID, targetUserID, role string) error
+
+	// CreateTeam creates a team with ownerID as its TeamRoleOwner.
+	CreateTeam(ctx context.Context, name, ownerID string) (*Team, error)
+	// ListTeams returns the teams userID belongs to, with userID's role.
+	ListTeams(ctx context.Context, userID string) ([]*Team, error)
+	// GetTeamMembers lists teamID's members; userID must be one of them.
+	GetTeamMembers(ctx context.Context, teamID, userID string) ([]*TeamMember, error)
+	// AddTeamMember adds userID to teamID with role, or changes their role.
+	// actorID must be allowed to by the team role rules.
+	AddTeamMember(ctx context.Context, teamID, actorID, userID, role string) error
+	// RemoveTeamMember removes userID from teamID; members may remove themselves.
+	RemoveTeamMember(ctx context.Context, teamID, actorID, userID string) error
 }
@@ -0,0 +1,2027 @@
+// Package storagetest provides a conformance suite that every storage.Store
+// implementation is expected to pass, in the spirit of testing/fstest.
+//
+// A backend wires it up from its own test with:
+//
+//	storagetest.TestStore(t, func(t *testing.T) storage.Store { return storage.NewMemoryStore() })
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pasanAbeysekara/collaborative-editor/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Factory returns a Store to run a single behaviour against. Stores may be
+// shared between behaviours: every case uses its own unique emails.
+type Factory func(t *testing.T) storage.Store
+
+type behaviour struct {
+	name string
+	run  func(t *testing.T, s storage.Store)
+}
+
+var behaviours = []behaviour{
+	{"CreateUserAndLookup", testCreateUserAndLookup},
+	{"CreateUserDuplicateEmail", testCreateUserDuplicateEmail},
+	{"UserNotFound", testUserNotFound},
+	{"CreateAndGetDocument", testCreateAndGetDocument},
+	{"DocumentNotFound", testDocumentNotFound},
+	{"UnknownUserRejected", testUnknownUserRejected},
+	{"UpdateDocument", testUpdateDocument},
+	{"OwnerHasPermission", testOwnerHasPermission},
+	{"ShareGrantsPermission", testShareGrantsPermission},
+	{"ShareRequiresOwner", testShareRequiresOwner},
+	{"ShareTwiceKeepsFirstRole", testShareTwiceKeepsFirstRole},
+	{"UserDocumentsIncludeShared", testUserDocumentsIncludeShared},
+	{"ListDocumentsPaginates", testListDocumentsPaginates},
+	{"ListDocumentsSortsAndFilters", testListDocumentsSortsAndFilters},
+	{"ListDocumentsProjection", testListDocumentsProjection},
+	{"ListDocumentsRejectsBadCursor", testListDocumentsRejectsBadCursor},
+	{"SearchDocuments", testSearchDocuments},
+	{"FolderShareInheritsToDocuments", testFolderShareInheritsToDocuments},
+	{"HighestRoleWins", testHighestRoleWins},
+	{"WorkspaceMembership", testWorkspaceMembership},
+	{"MoveDocument", testMoveDocument},
+	{"ListFolders", testListFolders},
+	{"Favorites", testFavorites},
+	{"DocumentTags", testDocumentTags},
+	{"TeamMembership", testTeamMembership},
+	{"ShareDocumentWithTeam", testShareDocumentWithTeam},
+	{"GetDocumentRole", testGetDocumentRole},
+	{"Invitations", testInvitations},
+	{"AccessRequests", testAccessRequests},
+	{"ShareLinks", testShareLinks},
+	{"ShareLinkLimits", testShareLinkLimits},
+	{"RefreshTokenRotation", testRefreshTokenRotation},
+	{"RefreshTokenReuseRevokesSession", testRefreshTokenReuseRevokesSession},
+	{"VerifyEmail", testVerifyEmail},
+	{"ResetPassword", testResetPassword},
+	{"Identities", testIdentities},
+	{"TOTP", testTOTP},
+	{"Profile", testProfile},
+	{"ListDocumentShares", testListDocumentShares},
+	{"DeleteUserTransfer", testDeleteUserTransfer},
+	{"DeleteUserTransferToColleague", testDeleteUserTransferToColleague},
+	{"DeleteUserDelete", testDeleteUserDelete},
+	{"DeleteUserOrphan", testDeleteUserOrphan},
+	{"DeleteUserTeams", testDeleteUserTeams},
+	{"PersonalTokens", testPersonalTokens},
+}
+
+// TestStore runs every behaviour in the suite as a subtest.
+func TestStore(t *testing.T, newStore Factory) {
+	t.Helper()
+	for _, b := range behaviours {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			b.run(t, newStore(t))
+		})
+	}
+}
+
+func uniqueEmail() string {
+	return "user-" + uuid.NewString() + "@example.com"
+}
+
+func mustCreateUser(t *testing.T, s storage.Store) *storage.User {
+	t.Helper()
+	ctx := context.Background()
+	u, err := s.CreateUser(ctx, uniqueEmail(), "password123")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return u
+}
+
+func mustCreateDocument(t *testing.T, s storage.Store, title, ownerID string) *storage.Document {
+	t.Helper()
+	ctx := context.Background()
+	doc, err := s.CreateDocument(ctx, title, ownerID)
+	if err != nil {
+		t.Fatalf("CreateDocument: %v", err)
+	}
+	return doc
+}
+
+func testCreateUserAndLookup(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	email := uniqueEmail()
+	u, err := s.CreateUser(ctx, email, "password123")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if u.ID == "" || u.Email != email {
+		t.Fatalf("CreateUser returned %+v", u)
+	}
+	if u.PasswordHash == "" || u.PasswordHash == "password123" {
+		t.Fatalf("password was not hashed")
+	}
+
+	byEmail, err := s.GetUserByEmail(ctx, email)
+	if err != nil || byEmail.ID != u.ID {
+		t.Fatalf("GetUserByEmail = %+v, %v; want ID %s", byEmail, err, u.ID)
+	}
+	byID, err := s.GetUserByID(ctx, u.ID)
+	if err != nil || byID.Email != email {
+		t.Fatalf("GetUserByID = %+v, %v; want email %s", byID, err, email)
+	}
+}
+
+func testCreateUserDuplicateEmail(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	email := uniqueEmail()
+	if _, err := s.CreateUser(ctx, email, "password123"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := s.CreateUser(ctx, email, "other"); err == nil {
+		t.Fatalf("CreateUser with duplicate email succeeded")
+	}
+}
+
+func testUserNotFound(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	if _, err := s.GetUserByEmail(ctx, uniqueEmail()); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetUserByEmail error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetUserByID(ctx, uuid.NewString()); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetUserByID error = %v, want ErrNotFound", err)
+	}
+}
+
+func testCreateAndGetDocument(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Design notes", owner.ID)
+	if doc.ID == "" || doc.OwnerID != owner.ID || doc.Title != "Design notes" {
+		t.Fatalf("CreateDocument returned %+v", doc)
+	}
+
+	got, err := s.GetDocument(ctx, doc.ID)
+	if err != nil {
+		t.Fatalf("GetDocument: %v", err)
+	}
+	if got.Title != doc.Title || got.Content != "" || got.Version != 0 {
+		t.Fatalf("GetDocument = %+v, want empty document at version 0", got)
+	}
+}
+
+func testDocumentNotFound(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	if _, err := s.GetDocument(ctx, uuid.NewString()); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetDocument error = %v, want ErrNotFound", err)
+	}
+	if err := s.UpdateDocument(ctx, uuid.NewString(), "x", 1); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("UpdateDocument error = %v, want ErrNotFound", err)
+	}
+}
+
+// Rows owned by or requested for a user must reference an existing account,
+// which the SQL backends enforce through foreign keys.
+// Sessions, tokens, identities and teams belong to accounts in user-service's
+// own store, so they are refused for users it doesn't have.
+func testUnknownUserRejected(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	unknown := uuid.NewString()
+	if _, err := s.CreateRefreshToken(ctx, unknown, time.Now().Add(time.Hour)); err == nil {
+		t.Fatal("CreateRefreshToken for unknown user succeeded")
+	}
+	if _, err := s.CreatePersonalToken(ctx, unknown, "CI", []string{"read-docs"}, time.Time{}); err == nil {
+		t.Fatal("CreatePersonalToken for unknown user succeeded")
+	}
+	if err := s.LinkIdentity(ctx, unknown, "https://idp.example.com", "sub-unknown"); err == nil {
+		t.Fatal("LinkIdentity for unknown user succeeded")
+	}
+	if _, err := s.CreateTeam(ctx, "Orphans", unknown); err == nil {
+		t.Fatal("CreateTeam for unknown owner succeeded")
+	}
+}
+
+func testUpdateDocument(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Draft", owner.ID)
+
+	if err := s.UpdateDocument(ctx, doc.ID, "hello world", 7); err != nil {
+		t.Fatalf("UpdateDocument: %v", err)
+	}
+	got, err := s.GetDocument(ctx, doc.ID)
+	if err != nil {
+		t.Fatalf("GetDocument: %v", err)
+	}
+	if got.Content != "hello world" || got.Version != 7 {
+		t.Fatalf("GetDocument = %+v, want updated content at version 7", got)
+	}
+}
+
+func testOwnerHasPermission(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	stranger := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Private", owner.ID)
+
+	if ok, err := s.CheckDocumentPermission(ctx, doc.ID, owner.ID); err != nil || !ok {
+		t.Fatalf("owner permission = %v, %v; want true", ok, err)
+	}
+	if ok, err := s.CheckDocumentPermission(ctx, doc.ID, stranger.ID); err != nil || ok {
+		t.Fatalf("stranger permission = %v, %v; want false", ok, err)
+	}
+}
+
+func testShareGrantsPermission(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	collaborator := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Shared", owner.ID)
+
+	if err := s.ShareDocument(ctx, doc.ID, owner.ID, collaborator.ID, storage.RoleViewer); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+	if ok, err := s.CheckDocumentPermission(ctx, doc.ID, collaborator.ID); err != nil || !ok {
+		t.Fatalf("collaborator permission = %v, %v; want true", ok, err)
+	}
+}
+
+func testShareRequiresOwner(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	collaborator := mustCreateUser(t, s)
+	other := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Owned", owner.ID)
+
+	if err := s.ShareDocument(ctx, doc.ID, owner.ID, collaborator.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+	// Collaborators cannot re-share, and unknown documents behave the same way.
+	err := s.ShareDocument(ctx, doc.ID, collaborator.ID, other.ID, storage.RoleEditor)
+	if !errors.Is(err, storage.ErrNotDocumentOwner) {
+		t.Fatalf("ShareDocument by non-owner error = %v, want ErrNotDocumentOwner", err)
+	}
+	err = s.ShareDocument(ctx, uuid.NewString(), owner.ID, other.ID, storage.RoleEditor)
+	if !errors.Is(err, storage.ErrNotDocumentOwner) {
+		t.Fatalf("ShareDocument on missing document error = %v, want ErrNotDocumentOwner", err)
+	}
+	if ok, _ := s.CheckDocumentPermission(ctx, doc.ID, other.ID); ok {
+		t.Fatalf("rejected share still granted permission")
+	}
+}
+
+func testShareTwiceKeepsFirstRole(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	collaborator := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Twice", owner.ID)
+
+	if err := s.ShareDocument(ctx, doc.ID, owner.ID, collaborator.ID, storage.RoleViewer); err != nil {
+		t.Fatalf("first ShareDocument: %v", err)
+	}
+	if err := s.ShareDocument(ctx, doc.ID, owner.ID, collaborator.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("second ShareDocument: %v", err)
+	}
+	docs, err := s.GetUserDocuments(ctx, collaborator.ID)
+	if err != nil {
+		t.Fatalf("GetUserDocuments: %v", err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("GetUserDocuments returned %d documents, want 1", len(docs))
+	}
+}
+
+func testUserDocumentsIncludeShared(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s)
+	bob := mustCreateUser(t, s)
+	mustCreateDocument(t, s, "b-owned", bob.ID)
+	shared := mustCreateDocument(t, s, "a-shared", alice.ID)
+	mustCreateDocument(t, s, "c-private", alice.ID)
+
+	if err := s.ShareDocument(ctx, shared.ID, alice.ID, bob.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+
+	docs, err := s.GetUserDocuments(ctx, bob.ID)
+	if err != nil {
+		t.Fatalf("GetUserDocuments: %v", err)
+	}
+	var titles []string
+	for _, d := range docs {
+		titles = append(titles, d.Title)
+	}
+	if len(titles) != 2 || titles[0] != "a-shared" || titles[1] != "b-owned" {
+		t.Fatalf("GetUserDocuments titles = %v, want [a-shared b-owned]", titles)
+	}
+}
+
+func listTitles(t *testing.T, s storage.Store, userID string, opts storage.DocumentListOptions) ([]string, string) {
+	t.Helper()
+	page, err := s.ListDocuments(context.Background(), userID, opts)
+	if err != nil {
+		t.Fatalf("ListDocuments(%+v): %v", opts, err)
+	}
+	titles := []string{}
+	for _, d := range page.Documents {
+		titles = append(titles, d.Title)
+	}
+	return titles, page.NextCursor
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testListDocumentsPaginates(t *testing.T, s storage.Store) {
+	owner := mustCreateUser(t, s)
+	for _, title := range []string{"e", "c", "a", "d", "b"} {
+		mustCreateDocument(t, s, title, owner.ID)
+	}
+
+	var all []string
+	opts := storage.DocumentListOptions{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("pagination did not terminate, got %v", all)
+		}
+		titles, next := listTitles(t, s, owner.ID, opts)
+		all = append(all, titles...)
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	if !equal(all, []string{"a", "b", "c", "d", "e"}) {
+		t.Fatalf("paginated titles = %v, want [a b c d e]", all)
+	}
+
+	desc, _ := listTitles(t, s, owner.ID, storage.DocumentListOptions{Descending: true, Limit: 3})
+	if !equal(desc, []string{"e", "d", "c"}) {
+		t.Fatalf("descending titles = %v, want [e d c]", desc)
+	}
+}
+
+func testListDocumentsSortsAndFilters(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s)
+	bob := mustCreateUser(t, s)
+	first := mustCreateDocument(t, s, "z-first", alice.ID)
+	second := mustCreateDocument(t, s, "y-second", bob.ID)
+	third := mustCreateDocument(t, s, "x-third", bob.ID)
+	if err := s.ShareDocument(ctx, second.ID, bob.ID, alice.ID, storage.RoleViewer); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+	if err := s.ShareDocument(ctx, third.ID, bob.ID, alice.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+
+	byCreated, _ := listTitles(t, s, alice.ID, storage.DocumentListOptions{Sort: storage.SortCreated})
+	if !equal(byCreated, []string{"z-first", "y-second", "x-third"}) {
+		t.Fatalf("created order = %v", byCreated)
+	}
+
+	if err := s.UpdateDocument(ctx, first.ID, "edited", 1); err != nil {
+		t.Fatalf("UpdateDocument: %v", err)
+	}
+	byUpdated, _ := listTitles(t, s, alice.ID, storage.DocumentListOptions{Sort: storage.SortUpdated, Descending: true})
+	if len(byUpdated) != 3 || byUpdated[0] != "z-first" {
+		t.Fatalf("updated order = %v, want z-first first", byUpdated)
+	}
+
+	if err := s.RecordDocumentOpen(ctx, second.ID, alice.ID); err != nil {
+		t.Fatalf("RecordDocumentOpen: %v", err)
+	}
+	byOpened, _ := listTitles(t, s, alice.ID, storage.DocumentListOptions{Sort: storage.SortLastOpened, Descending: true})
+	if len(byOpened) != 3 || byOpened[0] != "y-second" {
+		t.Fatalf("last opened order = %v, want y-second first", byOpened)
+	}
+
+	owned, _ := listTitles(t, s, alice.ID, storage.DocumentListOptions{Ownership: storage.OwnershipOwned})
+	if !equal(owned, []string{"z-first"}) {
+		t.Fatalf("owned = %v, want [z-first]", owned)
+	}
+	shared, _ := listTitles(t, s, alice.ID, storage.DocumentListOptions{Ownership: storage.OwnershipShared})
+	if !equal(shared, []string{"x-third", "y-second"}) {
+		t.Fatalf("shared = %v, want [x-third y-second]", shared)
+	}
+	viewer, _ := listTitles(t, s, alice.ID, storage.DocumentListOptions{Role: storage.RoleViewer})
+	if !equal(viewer, []string{"y-second"}) {
+		t.Fatalf("role=viewer = %v, want [y-second]", viewer)
+	}
+}
+
+func testListDocumentsProjection(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "body", owner.ID)
+	if err := s.UpdateDocument(ctx, doc.ID, "large content", 3); err != nil {
+		t.Fatalf("UpdateDocument: %v", err)
+	}
+
+	page, err := s.ListDocuments(ctx, owner.ID, storage.DocumentListOptions{})
+	if err != nil || len(page.Documents) != 1 {
+		t.Fatalf("ListDocuments = %+v, %v", page, err)
+	}
+	got := page.Documents[0]
+	if got.Content != "" || got.Version != 3 || got.Role != storage.RoleOwner {
+		t.Fatalf("summary = %+v, want no content, version 3, owner role", got)
+	}
+
+	page, err = s.ListDocuments(ctx, owner.ID, storage.DocumentListOptions{IncludeContent: true})
+	if err != nil || len(page.Documents) != 1 || page.Documents[0].Content != "large content" {
+		t.Fatalf("ListDocuments with content = %+v, %v", page, err)
+	}
+}
+
+func testListDocumentsRejectsBadCursor(t *testing.T, s storage.Store) {
+	owner := mustCreateUser(t, s)
+	_, err := s.ListDocuments(context.Background(), owner.ID, storage.DocumentListOptions{Cursor: "not-a-cursor"})
+	if !errors.Is(err, storage.ErrInvalidCursor) {
+		t.Fatalf("ListDocuments error = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func testSearchDocuments(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	reader := mustCreateUser(t, s)
+	// A unique word keeps the test independent of other documents in a shared store.
+	word := "zq" + strings.ReplaceAll(uuid.NewString()[:8], "-", "")
+
+	inTitle := mustCreateDocument(t, s, "Roadmap "+word, owner.ID)
+	inContent := mustCreateDocument(t, s, "Meeting notes", owner.ID)
+	private := mustCreateDocument(t, s, "Private "+word, owner.ID)
+	if err := s.UpdateDocument(ctx, inContent.ID, "we agreed the "+word+" launch slips a week", 1); err != nil {
+		t.Fatalf("UpdateDocument: %v", err)
+	}
+	for _, doc := range []*storage.Document{inTitle, inContent} {
+		if err := s.ShareDocument(ctx, doc.ID, owner.ID, reader.ID, storage.RoleViewer); err != nil {
+			t.Fatalf("ShareDocument: %v", err)
+		}
+	}
+
+	results, err := s.SearchDocuments(ctx, reader.ID, word, 10)
+	if err != nil {
+		t.Fatalf("SearchDocuments: %v", err)
+	}
+	found := map[string]*storage.SearchResult{}
+	for _, r := range results {
+		found[r.ID] = r
+	}
+	if len(results) != 2 || found[inTitle.ID] == nil || found[inContent.ID] == nil {
+		t.Fatalf("SearchDocuments returned %d results, want the two shared documents", len(results))
+	}
+	if found[private.ID] != nil {
+		t.Fatalf("SearchDocuments returned an inaccessible document")
+	}
+	if !strings.Contains(found[inContent.ID].Snippet, storage.HighlightStart+word) {
+		t.Fatalf("snippet %q does not highlight %q", found[inContent.ID].Snippet, word)
+	}
+	if found[inContent.ID].Role != storage.RoleViewer {
+		t.Fatalf("role = %q, want viewer", found[inContent.ID].Role)
+	}
+
+	// Snippets are escaped, so only the highlight markers are markup.
+	if err := s.UpdateDocument(ctx, inContent.ID, `<img src=x onerror="alert(1)"> `+word+` <b>bold</b>`, 2); err != nil {
+		t.Fatalf("UpdateDocument: %v", err)
+	}
+	results, err = s.SearchDocuments(ctx, reader.ID, word, 10)
+	if err != nil {
+		t.Fatalf("SearchDocuments: %v", err)
+	}
+	for _, r := range results {
+		if r.ID != inContent.ID {
+			continue
+		}
+		markup := strings.NewReplacer(storage.HighlightStart, "", storage.HighlightStop, "").Replace(r.Snippet)
+		if strings.ContainsAny(markup, "<>") || !strings.Contains(r.Snippet, "&lt;b&gt;") {
+			t.Fatalf("snippet %q is not HTML-escaped", r.Snippet)
+		}
+	}
+
+	// The index follows UpdateDocument.
+	if err := s.UpdateDocument(ctx, inContent.ID, "nothing to see", 3); err != nil {
+		t.Fatalf("UpdateDocument: %v", err)
+	}
+	results, err = s.SearchDocuments(ctx, reader.ID, word, 10)
+	if err != nil || len(results) != 1 || results[0].ID != inTitle.ID {
+		t.Fatalf("SearchDocuments after update = %v, %v; want only the title match", results, err)
+	}
+}
+
+func mustCreateFolder(t *testing.T, s storage.Store, name, ownerID, parentID, workspaceID string) *storage.Folder {
+	t.Helper()
+	f, err := s.CreateFolder(context.Background(), name, ownerID, parentID, workspaceID)
+	if err != nil {
+		t.Fatalf("CreateFolder(%q): %v", name, err)
+	}
+	return f
+}
+
+func mustMoveDocument(t *testing.T, s storage.Store, documentID, userID, folderID string) {
+	t.Helper()
+	if err := s.MoveDocument(context.Background(), documentID, userID, folderID); err != nil {
+		t.Fatalf("MoveDocument: %v", err)
+	}
+}
+
+// roleOf returns userID's role on documentID as reported by ListDocuments, or "".
+func roleOf(t *testing.T, s storage.Store, userID, documentID string) string {
+	t.Helper()
+	page, err := s.ListDocuments(context.Background(), userID, storage.DocumentListOptions{Limit: storage.MaxListLimit})
+	if err != nil {
+		t.Fatalf("ListDocuments: %v", err)
+	}
+	for _, d := range page.Documents {
+		if d.ID == documentID {
+			return d.Role
+		}
+	}
+	return ""
+}
+
+func testFolderShareInheritsToDocuments(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s)
+	bob := mustCreateUser(t, s)
+	top := mustCreateFolder(t, s, "Projects", alice.ID, "", "")
+	nested := mustCreateFolder(t, s, "Roadmap", alice.ID, top.ID, "")
+	doc := mustCreateDocument(t, s, "Q3 plan", alice.ID)
+	mustMoveDocument(t, s, doc.ID, alice.ID, nested.ID)
+
+	if ok, err := s.CheckDocumentPermission(ctx, doc.ID, bob.ID); err != nil || ok {
+		t.Fatalf("permission before share = %v, %v; want false", ok, err)
+	}
+	if err := s.ShareFolder(ctx, top.ID, alice.ID, bob.ID, storage.RoleViewer); err != nil {
+		t.Fatalf("ShareFolder: %v", err)
+	}
+	if ok, err := s.CheckDocumentPermission(ctx, doc.ID, bob.ID); err != nil || !ok {
+		t.Fatalf("permission after sharing parent folder = %v, %v; want true", ok, err)
+	}
+	if role := roleOf(t, s, bob.ID, doc.ID); role != storage.RoleViewer {
+		t.Fatalf("inherited role = %q, want viewer", role)
+	}
+	docs, err := s.GetUserDocuments(ctx, bob.ID)
+	if err != nil || len(docs) != 1 || docs[0].ID != doc.ID {
+		t.Fatalf("GetUserDocuments = %v, %v; want the inherited document", docs, err)
+	}
+	results, err := s.SearchDocuments(ctx, bob.ID, "plan", 10)
+	if err != nil || len(results) != 1 || results[0].Role != storage.RoleViewer {
+		t.Fatalf("SearchDocuments = %v, %v; want the inherited document as viewer", results, err)
+	}
+
+	folder, err := s.GetFolder(ctx, nested.ID, bob.ID)
+	if err != nil || folder.Role != storage.RoleViewer || folder.ParentID != top.ID {
+		t.Fatalf("GetFolder = %+v, %v; want viewer role under %s", folder, err, top.ID)
+	}
+	if folder, err := s.GetFolder(ctx, nested.ID, alice.ID); err != nil || folder.Role != storage.RoleOwner {
+		t.Fatalf("GetFolder by owner = %+v, %v; want owner role", folder, err)
+	}
+
+	// Viewers can't add to the folder, and only its owner can share it.
+	if _, err := s.CreateFolder(ctx, "Mine", bob.ID, nested.ID, ""); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("CreateFolder by viewer error = %v, want ErrPermissionDenied", err)
+	}
+	if err := s.ShareFolder(ctx, top.ID, bob.ID, bob.ID, storage.RoleEditor); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("ShareFolder by non-owner error = %v, want ErrPermissionDenied", err)
+	}
+	if err := s.ShareFolder(ctx, uuid.NewString(), alice.ID, bob.ID, storage.RoleEditor); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("ShareFolder on missing folder error = %v, want ErrNotFound", err)
+	}
+}
+
+func testHighestRoleWins(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s)
+	bob := mustCreateUser(t, s)
+	folder := mustCreateFolder(t, s, "Shared", alice.ID, "", "")
+	doc := mustCreateDocument(t, s, "Notes", alice.ID)
+	mustMoveDocument(t, s, doc.ID, alice.ID, folder.ID)
+
+	if err := s.ShareDocument(ctx, doc.ID, alice.ID, bob.ID, storage.RoleViewer); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+	if role := roleOf(t, s, bob.ID, doc.ID); role != storage.RoleViewer {
+		t.Fatalf("role with direct viewer share = %q, want viewer", role)
+	}
+	if err := s.ShareFolder(ctx, folder.ID, alice.ID, bob.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("ShareFolder: %v", err)
+	}
+	if role := roleOf(t, s, bob.ID, doc.ID); role != storage.RoleEditor {
+		t.Fatalf("role with editor folder share = %q, want editor", role)
+	}
+	titles, _ := listTitles(t, s, bob.ID, storage.DocumentListOptions{Role: storage.RoleEditor})
+	if !equal(titles, []string{"Notes"}) {
+		t.Fatalf("role=editor titles = %v, want [Notes]", titles)
+	}
+	titles, _ = listTitles(t, s, bob.ID, storage.DocumentListOptions{Ownership: storage.OwnershipShared})
+	if !equal(titles, []string{"Notes"}) {
+		t.Fatalf("shared titles = %v, want [Notes]", titles)
+	}
+}
+
+func testWorkspaceMembership(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s)
+	carol := mustCreateUser(t, s)
+	ws, err := s.CreateWorkspace(ctx, "Engineering", alice.ID)
+	if err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	root := mustCreateFolder(t, s, "Specs", alice.ID, "", ws.ID)
+	doc := mustCreateDocument(t, s, "API spec", alice.ID)
+	mustMoveDocument(t, s, doc.ID, alice.ID, root.ID)
+
+	if ok, _ := s.CheckDocumentPermission(ctx, doc.ID, carol.ID); ok {
+		t.Fatalf("non-member has permission")
+	}
+	if _, err := s.CreateFolder(ctx, "Intruder", carol.ID, "", ws.ID); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("CreateFolder by non-member error = %v, want ErrPermissionDenied", err)
+	}
+	if err := s.AddWorkspaceMember(ctx, ws.ID, carol.ID, carol.ID, storage.RoleEditor); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("AddWorkspaceMember by non-owner error = %v, want ErrPermissionDenied", err)
+	}
+
+	if err := s.AddWorkspaceMember(ctx, ws.ID, alice.ID, carol.ID, storage.RoleViewer); err != nil {
+		t.Fatalf("AddWorkspaceMember: %v", err)
+	}
+	if role := roleOf(t, s, carol.ID, doc.ID); role != storage.RoleViewer {
+		t.Fatalf("workspace viewer role = %q, want viewer", role)
+	}
+	if _, err := s.CreateFolder(ctx, "Drafts", carol.ID, root.ID, ""); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("CreateFolder by workspace viewer error = %v, want ErrPermissionDenied", err)
+	}
+
+	// Adding again replaces the role.
+	if err := s.AddWorkspaceMember(ctx, ws.ID, alice.ID, carol.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("AddWorkspaceMember: %v", err)
+	}
+	child := mustCreateFolder(t, s, "Drafts", carol.ID, root.ID, "")
+	if child.WorkspaceID != ws.ID {
+		t.Fatalf("nested folder workspace = %q, want %q", child.WorkspaceID, ws.ID)
+	}
+	workspaces, err := s.ListWorkspaces(ctx, carol.ID)
+	if err != nil || len(workspaces) != 1 || workspaces[0].ID != ws.ID || workspaces[0].Role != storage.RoleEditor {
+		t.Fatalf("ListWorkspaces = %v, %v; want Engineering as editor", workspaces, err)
+	}
+	if workspaces, _ := s.ListWorkspaces(ctx, alice.ID); len(workspaces) != 1 || workspaces[0].Role != storage.RoleOwner {
+		t.Fatalf("ListWorkspaces for owner = %v; want Engineering as owner", workspaces)
+	}
+
+	if err := s.RemoveWorkspaceMember(ctx, ws.ID, alice.ID, carol.ID); err != nil {
+		t.Fatalf("RemoveWorkspaceMember: %v", err)
+	}
+	if ok, _ := s.CheckDocumentPermission(ctx, doc.ID, carol.ID); ok {
+		t.Fatalf("removed member still has permission")
+	}
+	if err := s.RemoveWorkspaceMember(ctx, ws.ID, alice.ID, carol.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("second RemoveWorkspaceMember error = %v, want ErrNotFound", err)
+	}
+}
+
+func testMoveDocument(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s)
+	bob := mustCreateUser(t, s)
+	mine := mustCreateFolder(t, s, "Mine", alice.ID, "", "")
+	theirs := mustCreateFolder(t, s, "Theirs", bob.ID, "", "")
+	filed := mustCreateDocument(t, s, "filed", alice.ID)
+	mustCreateDocument(t, s, "loose", alice.ID)
+
+	if err := s.MoveDocument(ctx, filed.ID, bob.ID, theirs.ID); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("MoveDocument by non-owner error = %v, want ErrPermissionDenied", err)
+	}
+	if err := s.MoveDocument(ctx, filed.ID, alice.ID, theirs.ID); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("MoveDocument into inaccessible folder error = %v, want ErrPermissionDenied", err)
+	}
+	if err := s.MoveDocument(ctx, filed.ID, alice.ID, uuid.NewString()); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("MoveDocument into missing folder error = %v, want ErrNotFound", err)
+	}
+	if err := s.MoveDocument(ctx, uuid.NewString(), alice.ID, mine.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("MoveDocument of missing document error = %v, want ErrNotFound", err)
+	}
+
+	mustMoveDocument(t, s, filed.ID, alice.ID, mine.ID)
+	page, err := s.ListDocuments(ctx, alice.ID, storage.DocumentListOptions{FolderID: mine.ID})
+	if err != nil || len(page.Documents) != 1 || page.Documents[0].ID != filed.ID || page.Documents[0].FolderID != mine.ID {
+		t.Fatalf("ListDocuments in folder = %+v, %v; want only the filed document", page, err)
+	}
+
+	// An editor share on someone else's folder is enough to file into it.
+	if err := s.ShareFolder(ctx, theirs.ID, bob.ID, alice.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("ShareFolder: %v", err)
+	}
+	mustMoveDocument(t, s, filed.ID, alice.ID, theirs.ID)
+	if ok, _ := s.CheckDocumentPermission(ctx, filed.ID, bob.ID); !ok {
+		t.Fatalf("folder owner can't open a document filed in their folder")
+	}
+
+	mustMoveDocument(t, s, filed.ID, alice.ID, "")
+	if ok, _ := s.CheckDocumentPermission(ctx, filed.ID, bob.ID); ok {
+		t.Fatalf("unfiled document is still accessible through its old folder")
+	}
+	titles, _ := listTitles(t, s, alice.ID, storage.DocumentListOptions{FolderID: theirs.ID})
+	if len(titles) != 0 {
+		t.Fatalf("titles in old folder = %v, want none", titles)
+	}
+}
+
+func testListFolders(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s)
+	bob := mustCreateUser(t, s)
+	stranger := mustCreateUser(t, s)
+	top := mustCreateFolder(t, s, "b-top", alice.ID, "", "")
+	sharedChild := mustCreateFolder(t, s, "shared", alice.ID, top.ID, "")
+	mustCreateFolder(t, s, "private", alice.ID, top.ID, "")
+	mustCreateFolder(t, s, "a-top", alice.ID, "", "")
+	mustCreateFolder(t, s, "grandchild", alice.ID, sharedChild.ID, "")
+
+	names := func(userID, parentID string) []string {
+		t.Helper()
+		folders, err := s.ListFolders(ctx, userID, parentID)
+		if err != nil {
+			t.Fatalf("ListFolders(%q): %v", parentID, err)
+		}
+		names := []string{}
+		for _, f := range folders {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+
+	if got := names(alice.ID, ""); !equal(got, []string{"a-top", "b-top"}) {
+		t.Fatalf("top-level folders = %v, want [a-top b-top]", got)
+	}
+	if got := names(alice.ID, top.ID); !equal(got, []string{"private", "shared"}) {
+		t.Fatalf("children = %v, want [private shared]", got)
+	}
+
+	if err := s.ShareFolder(ctx, sharedChild.ID, alice.ID, bob.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("ShareFolder: %v", err)
+	}
+	// A folder shared on its own is a top-level entry for the recipient.
+	if got := names(bob.ID, ""); !equal(got, []string{"shared"}) {
+		t.Fatalf("recipient top-level folders = %v, want [shared]", got)
+	}
+	if got := names(bob.ID, sharedChild.ID); !equal(got, []string{"grandchild"}) {
+		t.Fatalf("recipient children = %v, want [grandchild]", got)
+	}
+	if _, err := s.ListFolders(ctx, bob.ID, top.ID); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("ListFolders of unshared parent error = %v, want ErrPermissionDenied", err)
+	}
+	if _, err := s.GetFolder(ctx, top.ID, stranger.ID); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("GetFolder by stranger error = %v, want ErrPermissionDenied", err)
+	}
+	if _, err := s.GetFolder(ctx, uuid.NewString(), alice.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetFolder of missing folder error = %v, want ErrNotFound", err)
+	}
+	if got := names(stranger.ID, ""); len(got) != 0 {
+		t.Fatalf("stranger top-level folders = %v, want none", got)
+	}
+}
+
+func testFavorites(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s)
+	bob := mustCreateUser(t, s)
+	starred := mustCreateDocument(t, s, "starred", alice.ID)
+	mustCreateDocument(t, s, "plain", alice.ID)
+	if err := s.ShareDocument(ctx, starred.ID, alice.ID, bob.ID, storage.RoleViewer); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+
+	if err := s.SetFavorite(ctx, starred.ID, alice.ID, true); err != nil {
+		t.Fatalf("SetFavorite: %v", err)
+	}
+	// Starring twice is harmless.
+	if err := s.SetFavorite(ctx, starred.ID, alice.ID, true); err != nil {
+		t.Fatalf("second SetFavorite: %v", err)
+	}
+	titles, _ := listTitles(t, s, alice.ID, storage.DocumentListOptions{FavoritesOnly: true})
+	if !equal(titles, []string{"starred"}) {
+		t.Fatalf("favorite titles = %v, want [starred]", titles)
+	}
+	page, err := s.ListDocuments(ctx, alice.ID, storage.DocumentListOptions{})
+	if err != nil || len(page.Documents) != 2 || !page.Documents[1].Favorite || page.Documents[0].Favorite {
+		t.Fatalf("ListDocuments = %+v, %v; want only starred marked favorite", page, err)
+	}
+
+	// Favorites are per user.
+	titles, _ = listTitles(t, s, bob.ID, storage.DocumentListOptions{FavoritesOnly: true})
+	if len(titles) != 0 {
+		t.Fatalf("bob's favorite titles = %v, want none", titles)
+	}
+
+	if err := s.SetFavorite(ctx, starred.ID, alice.ID, false); err != nil {
+		t.Fatalf("SetFavorite(false): %v", err)
+	}
+	titles, _ = listTitles(t, s, alice.ID, storage.DocumentListOptions{FavoritesOnly: true})
+	if len(titles) != 0 {
+		t.Fatalf("favorite titles after unstarring = %v, want none", titles)
+	}
+
+	stranger := mustCreateUser(t, s)
+	if err := s.SetFavorite(ctx, starred.ID, stranger.ID, true); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("SetFavorite without access error = %v, want ErrPermissionDenied", err)
+	}
+	if err := s.SetFavorite(ctx, uuid.NewString(), alice.ID, true); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("SetFavorite on missing document error = %v, want ErrNotFound", err)
+	}
+}
+
+func testDocumentTags(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s)
+	viewer := mustCreateUser(t, s)
+	both := mustCreateDocument(t, s, "both", alice.ID)
+	onlyDesign := mustCreateDocument(t, s, "design-only", alice.ID)
+	mustCreateDocument(t, s, "untagged", alice.ID)
+
+	for _, tag := range []string{"design", "q3"} {
+		if err := s.AddDocumentTag(ctx, both.ID, alice.ID, tag); err != nil {
+			t.Fatalf("AddDocumentTag(%q): %v", tag, err)
+		}
+	}
+	if err := s.AddDocumentTag(ctx, onlyDesign.ID, alice.ID, "design"); err != nil {
+		t.Fatalf("AddDocumentTag: %v", err)
+	}
+	// Tagging twice is harmless.
+	if err := s.AddDocumentTag(ctx, onlyDesign.ID, alice.ID, "design"); err != nil {
+		t.Fatalf("second AddDocumentTag: %v", err)
+	}
+
+	tags, err := s.GetDocumentTags(ctx, both.ID, alice.ID)
+	if err != nil || !equal(tags, []string{"design", "q3"}) {
+		t.Fatalf("GetDocumentTags = %v, %v; want [design q3]", tags, err)
+	}
+	titles, _ := listTitles(t, s, alice.ID, storage.DocumentListOptions{Tags: []string{"design"}})
+	if !equal(titles, []string{"both", "design-only"}) {
+		t.Fatalf("tag=design titles = %v, want [both design-only]", titles)
+	}
+	titles, _ = listTitles(t, s, alice.ID, storage.DocumentListOptions{Tags: []string{"design", "q3"}})
+	if !equal(titles, []string{"both"}) {
+		t.Fatalf("tag=design&tag=q3 titles = %v, want [both]", titles)
+	}
+	page, err := s.ListDocuments(ctx, alice.ID, storage.DocumentListOptions{Limit: 1})
+	if err != nil || len(page.Documents) != 1 || !equal(page.Documents[0].Tags, []string{"design", "q3"}) {
+		t.Fatalf("ListDocuments = %+v, %v; want summaries carrying their tags", page, err)
+	}
+
+	counts, err := s.ListTags(ctx, alice.ID)
+	if err != nil || len(counts) != 2 || counts[0].Tag != "design" || counts[0].Count != 2 || counts[1].Tag != "q3" || counts[1].Count != 1 {
+		t.Fatalf("ListTags = %v, %v; want design:2 q3:1", counts, err)
+	}
+
+	// Viewers can read tags but not change them, and only count what they can see.
+	if err := s.ShareDocument(ctx, both.ID, alice.ID, viewer.ID, storage.RoleViewer); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+	if tags, err := s.GetDocumentTags(ctx, both.ID, viewer.ID); err != nil || len(tags) != 2 {
+		t.Fatalf("GetDocumentTags by viewer = %v, %v; want 2 tags", tags, err)
+	}
+	if err := s.AddDocumentTag(ctx, both.ID, viewer.ID, "hacked"); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("AddDocumentTag by viewer error = %v, want ErrPermissionDenied", err)
+	}
+	if counts, _ := s.ListTags(ctx, viewer.ID); len(counts) != 2 || counts[0].Count != 1 {
+		t.Fatalf("ListTags for viewer = %v; want design:1 q3:1", counts)
+	}
+	if _, err := s.GetDocumentTags(ctx, onlyDesign.ID, viewer.ID); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("GetDocumentTags without access error = %v, want ErrPermissionDenied", err)
+	}
+
+	if err := s.RemoveDocumentTag(ctx, both.ID, alice.ID, "design"); err != nil {
+		t.Fatalf("RemoveDocumentTag: %v", err)
+	}
+	titles, _ = listTitles(t, s, alice.ID, storage.DocumentListOptions{Tags: []string{"design"}})
+	if !equal(titles, []string{"design-only"}) {
+		t.Fatalf("tag=design titles after removal = %v, want [design-only]", titles)
+	}
+}
+
+func mustCreateTeam(t *testing.T, s storage.Store, name, ownerID string) *storage.Team {
+	t.Helper()
+	team, err := s.CreateTeam(context.Background(), name, ownerID)
+	if err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	return team
+}
+
+func mustAddTeamMember(t *testing.T, s storage.Store, teamID, actorID, userID, role string) {
+	t.Helper()
+	if err := s.AddTeamMember(context.Background(), teamID, actorID, userID, role); err != nil {
+		t.Fatalf("AddTeamMember(%s): %v", role, err)
+	}
+}
+
+func testTeamMembership(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	admin := mustCreateUser(t, s)
+	member := mustCreateUser(t, s)
+	newcomer := mustCreateUser(t, s)
+	outsider := mustCreateUser(t, s)
+
+	team := mustCreateTeam(t, s, "Platform", owner.ID)
+	if team.Role != storage.TeamRoleOwner {
+		t.Fatalf("CreateTeam role = %q, want owner", team.Role)
+	}
+	mustAddTeamMember(t, s, team.ID, owner.ID, admin.ID, storage.TeamRoleAdmin)
+	mustAddTeamMember(t, s, team.ID, owner.ID, member.ID, storage.TeamRoleMember)
+
+	// Admins manage members but can't appoint admins or touch the owner.
+	mustAddTeamMember(t, s, team.ID, admin.ID, newcomer.ID, storage.TeamRoleMember)
+	if err := s.AddTeamMember(ctx, team.ID, admin.ID, newcomer.ID, storage.TeamRoleAdmin); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("admin promoting to admin error = %v, want ErrPermissionDenied", err)
+	}
+	if err := s.RemoveTeamMember(ctx, team.ID, admin.ID, owner.ID); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("admin removing owner error = %v, want ErrPermissionDenied", err)
+	}
+	// Plain members can't manage anyone.
+	if err := s.AddTeamMember(ctx, team.ID, member.ID, outsider.ID, storage.TeamRoleMember); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("member adding error = %v, want ErrPermissionDenied", err)
+	}
+
+	members, err := s.GetTeamMembers(ctx, team.ID, member.ID)
+	if err != nil || len(members) != 4 {
+		t.Fatalf("GetTeamMembers = %v, %v; want 4 members", members, err)
+	}
+	roles := map[string]string{}
+	for _, m := range members {
+		roles[m.UserID] = m.Role
+		if m.Email == "" {
+			t.Fatalf("member %s has no email", m.UserID)
+		}
+	}
+	if roles[owner.ID] != storage.TeamRoleOwner || roles[admin.ID] != storage.TeamRoleAdmin || roles[newcomer.ID] != storage.TeamRoleMember {
+		t.Fatalf("member roles = %v", roles)
+	}
+	if _, err := s.GetTeamMembers(ctx, team.ID, outsider.ID); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("GetTeamMembers by outsider error = %v, want ErrPermissionDenied", err)
+	}
+	if _, err := s.GetTeamMembers(ctx, uuid.NewString(), owner.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetTeamMembers of missing team error = %v, want ErrNotFound", err)
+	}
+
+	teams, err := s.ListTeams(ctx, admin.ID)
+	if err != nil || len(teams) != 1 || teams[0].ID != team.ID || teams[0].Role != storage.TeamRoleAdmin {
+		t.Fatalf("ListTeams = %v, %v; want Platform as admin", teams, err)
+	}
+
+	// Members can leave; the owner can't.
+	if err := s.RemoveTeamMember(ctx, team.ID, member.ID, member.ID); err != nil {
+		t.Fatalf("member leaving: %v", err)
+	}
+	if err := s.RemoveTeamMember(ctx, team.ID, owner.ID, owner.ID); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Fatalf("owner leaving error = %v, want ErrPermissionDenied", err)
+	}
+	if err := s.RemoveTeamMember(ctx, team.ID, admin.ID, newcomer.ID); err != nil {
+		t.Fatalf("admin removing member: %v", err)
+	}
+	if err := s.RemoveTeamMember(ctx, team.ID, owner.ID, newcomer.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("removing non-member error = %v, want ErrNotFound", err)
+	}
+	if teams, _ := s.ListTeams(ctx, member.ID); len(teams) != 0 {
+		t.Fatalf("ListTeams after leaving = %v, want none", teams)
+	}
+}
+
+func testShareDocumentWithTeam(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	member := mustCreateUser(t, s)
+	outsider := mustCreateUser(t, s)
+	team := mustCreateTeam(t, s, "Department", owner.ID)
+	mustAddTeamMember(t, s, team.ID, owner.ID, member.ID, storage.TeamRoleMember)
+	doc := mustCreateDocument(t, s, "Handbook", owner.ID)
+
+	if err := s.ShareDocumentWithTeam(ctx, doc.ID, member.ID, team.ID, storage.RoleEditor); !errors.Is(err, storage.ErrNotDocumentOwner) {
+		t.Fatalf("ShareDocumentWithTeam by non-owner error = %v, want ErrNotDocumentOwner", err)
+	}
+	if err := s.ShareDocumentWithTeam(ctx, doc.ID, owner.ID, uuid.NewString(), storage.RoleEditor); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("ShareDocumentWithTeam to missing team error = %v, want ErrNotFound", err)
+	}
+	if err := s.ShareDocumentWithTeam(ctx, doc.ID, owner.ID, team.ID, storage.RoleViewer); err != nil {
+		t.Fatalf("ShareDocumentWithTeam: %v", err)
+	}
+
+	if ok, err := s.CheckDocumentPermission(ctx, doc.ID, member.ID); err != nil || !ok {
+		t.Fatalf("team member permission = %v, %v; want true", ok, err)
+	}
+	if role := roleOf(t, s, member.ID, doc.ID); role != storage.RoleViewer {
+		t.Fatalf("team member role = %q, want viewer", role)
+	}
+	if ok, _ := s.CheckDocumentPermission(ctx, doc.ID, outsider.ID); ok {
+		t.Fatalf("outsider has permission")
+	}
+
+	// Joining the team later grants access; leaving revokes it.
+	mustAddTeamMember(t, s, team.ID, owner.ID, outsider.ID, storage.TeamRoleMember)
+	if ok, _ := s.CheckDocumentPermission(ctx, doc.ID, outsider.ID); !ok {
+		t.Fatalf("new team member has no permission")
+	}
+	if err := s.RemoveTeamMember(ctx, team.ID, owner.ID, member.ID); err != nil {
+		t.Fatalf("RemoveTeamMember: %v", err)
+	}
+	if ok, _ := s.CheckDocumentPermission(ctx, doc.ID, member.ID); ok {
+		t.Fatalf("removed team member still has permission")
+	}
+}
+
+func testGetDocumentRole(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	commenter := mustCreateUser(t, s)
+	outsider := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Review", owner.ID)
+
+	if err := s.ShareDocument(ctx, doc.ID, owner.ID, commenter.ID, storage.RoleCommenter); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+
+	for _, tc := range []struct {
+		userID, want string
+	}{
+		{owner.ID, storage.RoleOwner},
+		{commenter.ID, storage.RoleCommenter},
+		{outsider.ID, ""},
+	} {
+		if role, err := s.GetDocumentRole(ctx, doc.ID, tc.userID); err != nil || role != tc.want {
+			t.Fatalf("GetDocumentRole = %q, %v; want %q", role, err, tc.want)
+		}
+	}
+	if _, err := s.GetDocumentRole(ctx, uuid.NewString(), owner.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetDocumentRole on missing document error = %v, want ErrNotFound", err)
+	}
+}
+
+func testInvitations(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	other := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Welcome pack", owner.ID)
+	second := mustCreateDocument(t, s, "Second", owner.ID)
+	email := uniqueEmail()
+
+	if _, err := s.InviteToDocument(ctx, doc.ID, other.ID, email, storage.RoleViewer); !errors.Is(err, storage.ErrNotDocumentOwner) {
+		t.Fatalf("InviteToDocument by non-owner error = %v, want ErrNotDocumentOwner", err)
+	}
+	inv, err := s.InviteToDocument(ctx, doc.ID, owner.ID, strings.ToUpper(email), storage.RoleViewer)
+	if err != nil {
+		t.Fatalf("InviteToDocument: %v", err)
+	}
+	if inv.Email != email || inv.Role != storage.RoleViewer || inv.InvitedBy != owner.ID {
+		t.Fatalf("InviteToDocument = %+v, want a viewer invite for %s", inv, email)
+	}
+	// Inviting again keeps the first invitation, like ShareDocument.
+	again, err := s.InviteToDocument(ctx, doc.ID, owner.ID, email, storage.RoleEditor)
+	if err != nil || again.ID != inv.ID || again.Role != storage.RoleViewer {
+		t.Fatalf("second InviteToDocument = %+v, %v; want the first invitation", again, err)
+	}
+	cancelled, err := s.InviteToDocument(ctx, second.ID, owner.ID, email, storage.RoleEditor)
+	if err != nil {
+		t.Fatalf("InviteToDocument: %v", err)
+	}
+
+	if _, err := s.ListInvitations(ctx, doc.ID, other.ID); !errors.Is(err, storage.ErrNotDocumentOwner) {
+		t.Fatalf("ListInvitations by non-owner error = %v, want ErrNotDocumentOwner", err)
+	}
+	invitations, err := s.ListInvitations(ctx, doc.ID, owner.ID)
+	if err != nil || len(invitations) != 1 || invitations[0].ID != inv.ID {
+		t.Fatalf("ListInvitations = %v, %v; want just %s", invitations, err, inv.ID)
+	}
+
+	if err := s.CancelInvitation(ctx, doc.ID, cancelled.ID, owner.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("CancelInvitation through another document error = %v, want ErrNotFound", err)
+	}
+	if err := s.CancelInvitation(ctx, second.ID, cancelled.ID, owner.ID); err != nil {
+		t.Fatalf("CancelInvitation: %v", err)
+	}
+
+	invitee, err := s.CreateUser(ctx, email, "password123")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	claimed, err := s.ClaimInvitations(ctx, email, invitee.ID)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != inv.ID {
+		t.Fatalf("ClaimInvitations = %v, %v; want just %s", claimed, err, inv.ID)
+	}
+	if role := roleOf(t, s, invitee.ID, doc.ID); role != storage.RoleViewer {
+		t.Fatalf("invitee role = %q, want viewer", role)
+	}
+	if ok, _ := s.CheckDocumentPermission(ctx, second.ID, invitee.ID); ok {
+		t.Fatalf("cancelled invitation was claimed")
+	}
+	if claimed, err := s.ClaimInvitations(ctx, email, invitee.ID); err != nil || len(claimed) != 0 {
+		t.Fatalf("second ClaimInvitations = %v, %v; want nothing left", claimed, err)
+	}
+}
+
+func testAccessRequests(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	requester := mustCreateUser(t, s)
+	denied := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Roadmap", owner.ID)
+
+	if _, err := s.RequestAccess(ctx, doc.ID, owner.ID, storage.RoleEditor, ""); !errors.Is(err, storage.ErrAlreadyHasAccess) {
+		t.Fatalf("RequestAccess by owner error = %v, want ErrAlreadyHasAccess", err)
+	}
+	if _, err := s.RequestAccess(ctx, uuid.NewString(), requester.ID, storage.RoleViewer, ""); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("RequestAccess on missing document error = %v, want ErrNotFound", err)
+	}
+
+	first, err := s.RequestAccess(ctx, doc.ID, requester.ID, storage.RoleViewer, "please")
+	if err != nil {
+		t.Fatalf("RequestAccess: %v", err)
+	}
+	// Asking again updates the pending request.
+	req, err := s.RequestAccess(ctx, doc.ID, requester.ID, storage.RoleEditor, "need to edit")
+	if err != nil {
+		t.Fatalf("RequestAccess again: %v", err)
+	}
+	if req.ID != first.ID || req.Role != storage.RoleEditor || req.Message != "need to edit" ||
+		req.Status != storage.AccessRequestPending || req.Email != requester.Email {
+		t.Fatalf("RequestAccess again = %+v, want request %s updated to editor", req, first.ID)
+	}
+	other, err := s.RequestAccess(ctx, doc.ID, denied.ID, storage.RoleViewer, "")
+	if err != nil {
+		t.Fatalf("RequestAccess: %v", err)
+	}
+
+	if _, err := s.ListAccessRequests(ctx, doc.ID, requester.ID); !errors.Is(err, storage.ErrNotDocumentOwner) {
+		t.Fatalf("ListAccessRequests by non-owner error = %v, want ErrNotDocumentOwner", err)
+	}
+	pending, err := s.ListAccessRequests(ctx, doc.ID, owner.ID)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("ListAccessRequests = %v, %v; want 2 pending", pending, err)
+	}
+
+	if _, err := s.DecideAccessRequest(ctx, doc.ID, req.ID, requester.ID, true); !errors.Is(err, storage.ErrNotDocumentOwner) {
+		t.Fatalf("DecideAccessRequest by non-owner error = %v, want ErrNotDocumentOwner", err)
+	}
+	approved, err := s.DecideAccessRequest(ctx, doc.ID, req.ID, owner.ID, true)
+	if err != nil || approved.Status != storage.AccessRequestApproved || approved.DecidedAt == nil {
+		t.Fatalf("DecideAccessRequest(approve) = %+v, %v", approved, err)
+	}
+	if role := roleOf(t, s, requester.ID, doc.ID); role != storage.RoleEditor {
+		t.Fatalf("approved requester role = %q, want editor", role)
+	}
+	if _, err := s.DecideAccessRequest(ctx, doc.ID, req.ID, owner.ID, false); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("deciding twice error = %v, want ErrNotFound", err)
+	}
+
+	if _, err := s.DecideAccessRequest(ctx, doc.ID, other.ID, owner.ID, false); err != nil {
+		t.Fatalf("DecideAccessRequest(deny): %v", err)
+	}
+	if ok, _ := s.CheckDocumentPermission(ctx, doc.ID, denied.ID); ok {
+		t.Fatalf("denied requester has permission")
+	}
+	if pending, _ := s.ListAccessRequests(ctx, doc.ID, owner.ID); len(pending) != 0 {
+		t.Fatalf("ListAccessRequests after deciding = %v, want none", pending)
+	}
+
+	// Approving never lowers a role granted in the meantime.
+	viewerReq, err := s.RequestAccess(ctx, doc.ID, denied.ID, storage.RoleViewer, "")
+	if err != nil {
+		t.Fatalf("RequestAccess after denial: %v", err)
+	}
+	if err := s.ShareDocument(ctx, doc.ID, owner.ID, denied.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+	if _, err := s.DecideAccessRequest(ctx, doc.ID, viewerReq.ID, owner.ID, true); err != nil {
+		t.Fatalf("DecideAccessRequest: %v", err)
+	}
+	if role := roleOf(t, s, denied.ID, doc.ID); role != storage.RoleEditor {
+		t.Fatalf("role after approving a lesser request = %q, want editor", role)
+	}
+}
+
+func testShareLinks(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	other := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Public notes", owner.ID)
+	otherDoc := mustCreateDocument(t, s, "Other", owner.ID)
+
+	opts := storage.ShareLinkOptions{Role: storage.RoleViewer, Password: "s3cret"}
+	if _, err := s.CreateShareLink(ctx, doc.ID, other.ID, opts); !errors.Is(err, storage.ErrNotDocumentOwner) {
+		t.Fatalf("CreateShareLink by non-owner error = %v, want ErrNotDocumentOwner", err)
+	}
+	link, err := s.CreateShareLink(ctx, doc.ID, owner.ID, opts)
+	if err != nil {
+		t.Fatalf("CreateShareLink: %v", err)
+	}
+	if link.Token == "" || !link.HasPassword || link.Role != storage.RoleViewer {
+		t.Fatalf("CreateShareLink = %+v, want a token, a password and the viewer role", link)
+	}
+
+	found, err := s.GetShareLinkByToken(ctx, doc.ID, link.Token)
+	if err != nil || found.ID != link.ID {
+		t.Fatalf("GetShareLinkByToken = %v, %v; want link %s", found, err, link.ID)
+	}
+	if found.Token != "" {
+		t.Fatalf("GetShareLinkByToken returned the token")
+	}
+	if found.CheckPassword("wrong") || !found.CheckPassword("s3cret") {
+		t.Fatalf("CheckPassword does not match the link password")
+	}
+	if _, err := s.GetShareLinkByToken(ctx, otherDoc.ID, link.Token); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetShareLinkByToken for another document error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetShareLinkByToken(ctx, doc.ID, "not-a-token"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetShareLinkByToken with bad token error = %v, want ErrNotFound", err)
+	}
+
+	if _, err := s.ListShareLinks(ctx, doc.ID, other.ID); !errors.Is(err, storage.ErrNotDocumentOwner) {
+		t.Fatalf("ListShareLinks by non-owner error = %v, want ErrNotDocumentOwner", err)
+	}
+	links, err := s.ListShareLinks(ctx, doc.ID, owner.ID)
+	if err != nil || len(links) != 1 || links[0].ID != link.ID || links[0].Token != "" {
+		t.Fatalf("ListShareLinks = %v, %v; want just link %s without its token", links, err, link.ID)
+	}
+
+	if err := s.UseShareLink(ctx, link.ID); err != nil {
+		t.Fatalf("UseShareLink: %v", err)
+	}
+	if err := s.RevokeShareLink(ctx, doc.ID, link.ID, other.ID); !errors.Is(err, storage.ErrNotDocumentOwner) {
+		t.Fatalf("RevokeShareLink by non-owner error = %v, want ErrNotDocumentOwner", err)
+	}
+	if err := s.RevokeShareLink(ctx, otherDoc.ID, link.ID, owner.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("RevokeShareLink through another document error = %v, want ErrNotFound", err)
+	}
+	if err := s.RevokeShareLink(ctx, doc.ID, link.ID, owner.ID); err != nil {
+		t.Fatalf("RevokeShareLink: %v", err)
+	}
+	if err := s.UseShareLink(ctx, link.ID); !errors.Is(err, storage.ErrLinkUnavailable) {
+		t.Fatalf("UseShareLink after revoke error = %v, want ErrLinkUnavailable", err)
+	}
+	found, err = s.GetShareLinkByToken(ctx, doc.ID, link.Token)
+	if err != nil || found.RevokedAt == nil || found.Uses != 1 || found.Usable(time.Now()) {
+		t.Fatalf("revoked link = %+v, %v; want revoked after one use", found, err)
+	}
+}
+
+func testShareLinkLimits(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Limited", owner.ID)
+
+	limited, err := s.CreateShareLink(ctx, doc.ID, owner.ID, storage.ShareLinkOptions{Role: storage.RoleEditor, MaxUses: 2})
+	if err != nil {
+		t.Fatalf("CreateShareLink: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.UseShareLink(ctx, limited.ID); err != nil {
+			t.Fatalf("UseShareLink #%d: %v", i+1, err)
+		}
+	}
+	if err := s.UseShareLink(ctx, limited.ID); !errors.Is(err, storage.ErrLinkUnavailable) {
+		t.Fatalf("UseShareLink past max uses error = %v, want ErrLinkUnavailable", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	expired, err := s.CreateShareLink(ctx, doc.ID, owner.ID, storage.ShareLinkOptions{Role: storage.RoleViewer, ExpiresAt: &past})
+	if err != nil {
+		t.Fatalf("CreateShareLink: %v", err)
+	}
+	if err := s.UseShareLink(ctx, expired.ID); !errors.Is(err, storage.ErrLinkUnavailable) {
+		t.Fatalf("UseShareLink on expired link error = %v, want ErrLinkUnavailable", err)
+	}
+	found, err := s.GetShareLinkByToken(ctx, doc.ID, expired.Token)
+	if err != nil || found.ExpiresAt == nil || found.Usable(time.Now()) {
+		t.Fatalf("expired link = %+v, %v; want an expiry in the past", found, err)
+	}
+}
+
+func testRefreshTokenRotation(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	user := mustCreateUser(t, s)
+	expiry := time.Now().Add(time.Hour)
+
+	first, err := s.CreateRefreshToken(ctx, user.ID, expiry)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if first.Token == "" || first.UserID != user.ID || first.SessionID == "" {
+		t.Fatalf("CreateRefreshToken = %+v, want a token for %s in a session", first, user.ID)
+	}
+
+	second, err := s.RotateRefreshToken(ctx, first.Token, expiry)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if second.Token == first.Token || second.SessionID != first.SessionID || second.UserID != user.ID {
+		t.Fatalf("RotateRefreshToken = %+v, want a new token in session %s", second, first.SessionID)
+	}
+
+	if _, err := s.RotateRefreshToken(ctx, "no-such-token", expiry); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Fatalf("RotateRefreshToken(unknown) error = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	other := mustCreateUser(t, s)
+	if err := s.RevokeRefreshToken(ctx, other.ID, second.Token); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("RevokeRefreshToken(someone else's) error = %v, want ErrNotFound", err)
+	}
+	// The session survives, so it can still be refreshed and then logged out.
+	third, err := s.RotateRefreshToken(ctx, second.Token, expiry)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken after someone else's logout: %v", err)
+	}
+	if err := s.RevokeRefreshToken(ctx, user.ID, third.Token); err != nil {
+		t.Fatalf("RevokeRefreshToken: %v", err)
+	}
+	if err := s.RevokeRefreshToken(ctx, user.ID, third.Token); err != nil {
+		t.Fatalf("RevokeRefreshToken twice: %v", err)
+	}
+	if _, err := s.RotateRefreshToken(ctx, third.Token, expiry); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Fatalf("RotateRefreshToken after logout error = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	expired, err := s.CreateRefreshToken(ctx, user.ID, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if _, err := s.RotateRefreshToken(ctx, expired.Token, expiry); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Fatalf("RotateRefreshToken(expired) error = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func testRefreshTokenReuseRevokesSession(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	user := mustCreateUser(t, s)
+	expiry := time.Now().Add(time.Hour)
+
+	first, err := s.CreateRefreshToken(ctx, user.ID, expiry)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	other, err := s.CreateRefreshToken(ctx, user.ID, expiry)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	second, err := s.RotateRefreshToken(ctx, first.Token, expiry)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+
+	if _, err := s.RotateRefreshToken(ctx, first.Token, expiry); !errors.Is(err, storage.ErrRefreshTokenReused) {
+		t.Fatalf("RotateRefreshToken(reused) error = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := s.RotateRefreshToken(ctx, second.Token, expiry); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Fatalf("RotateRefreshToken in revoked session error = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := s.RotateRefreshToken(ctx, other.Token, expiry); err != nil {
+		t.Fatalf("RotateRefreshToken in another session: %v", err)
+	}
+}
+
+func testVerifyEmail(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	user := mustCreateUser(t, s)
+	expiry := time.Now().Add(time.Hour)
+
+	if user.EmailVerified {
+		t.Fatalf("new user has EmailVerified = true")
+	}
+	first, err := s.CreateAccountToken(ctx, user.ID, storage.TokenVerifyEmail, expiry)
+	if err != nil {
+		t.Fatalf("CreateAccountToken: %v", err)
+	}
+	second, err := s.CreateAccountToken(ctx, user.ID, storage.TokenVerifyEmail, expiry)
+	if err != nil {
+		t.Fatalf("CreateAccountToken: %v", err)
+	}
+	if _, err := s.VerifyEmail(ctx, first); !errors.Is(err, storage.ErrInvalidAccountToken) {
+		t.Fatalf("VerifyEmail(superseded) error = %v, want ErrInvalidAccountToken", err)
+	}
+
+	verified, err := s.VerifyEmail(ctx, second)
+	if err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if verified.ID != user.ID || !verified.EmailVerified {
+		t.Fatalf("VerifyEmail = %+v, want %s verified", verified, user.ID)
+	}
+	got, err := s.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if !got.EmailVerified {
+		t.Fatalf("GetUserByID after VerifyEmail has EmailVerified = false")
+	}
+	if _, err := s.VerifyEmail(ctx, second); !errors.Is(err, storage.ErrInvalidAccountToken) {
+		t.Fatalf("VerifyEmail(used) error = %v, want ErrInvalidAccountToken", err)
+	}
+
+	reset, err := s.CreateAccountToken(ctx, user.ID, storage.TokenResetPassword, expiry)
+	if err != nil {
+		t.Fatalf("CreateAccountToken: %v", err)
+	}
+	if _, err := s.VerifyEmail(ctx, reset); !errors.Is(err, storage.ErrInvalidAccountToken) {
+		t.Fatalf("VerifyEmail(reset token) error = %v, want ErrInvalidAccountToken", err)
+	}
+	expired, err := s.CreateAccountToken(ctx, user.ID, storage.TokenVerifyEmail, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("CreateAccountToken: %v", err)
+	}
+	if _, err := s.VerifyEmail(ctx, expired); !errors.Is(err, storage.ErrInvalidAccountToken) {
+		t.Fatalf("VerifyEmail(expired) error = %v, want ErrInvalidAccountToken", err)
+	}
+	if _, err := s.CreateAccountToken(ctx, uuid.NewString(), storage.TokenVerifyEmail, expiry); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("CreateAccountToken(unknown user) error = %v, want ErrNotFound", err)
+	}
+}
+
+func testResetPassword(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	user := mustCreateUser(t, s)
+	expiry := time.Now().Add(time.Hour)
+
+	session, err := s.CreateRefreshToken(ctx, user.ID, expiry)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	token, err := s.CreateAccountToken(ctx, user.ID, storage.TokenResetPassword, expiry)
+	if err != nil {
+		t.Fatalf("CreateAccountToken: %v", err)
+	}
+
+	// Looking the token up doesn't use it.
+	for i := 0; i < 2; i++ {
+		owner, err := s.AccountTokenUser(ctx, token, storage.TokenResetPassword)
+		if err != nil || owner.ID != user.ID || owner.Email != user.Email {
+			t.Fatalf("AccountTokenUser = %+v, %v; want %s", owner, err, user.ID)
+		}
+	}
+	if _, err := s.AccountTokenUser(ctx, token, storage.TokenVerifyEmail); !errors.Is(err, storage.ErrInvalidAccountToken) {
+		t.Fatalf("AccountTokenUser(wrong purpose) error = %v, want ErrInvalidAccountToken", err)
+	}
+	if _, err := s.AccountTokenUser(ctx, "no-such-token", storage.TokenResetPassword); !errors.Is(err, storage.ErrInvalidAccountToken) {
+		t.Fatalf("AccountTokenUser(unknown) error = %v, want ErrInvalidAccountToken", err)
+	}
+
+	updated, err := s.ResetPassword(ctx, token, "new-password")
+	if err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	if updated.ID != user.ID || updated.PasswordHash == user.PasswordHash {
+		t.Fatalf("ResetPassword = %+v, want a new password hash for %s", updated, user.ID)
+	}
+	got, err := s.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(got.PasswordHash), []byte("new-password")); err != nil {
+		t.Fatalf("stored password does not match the new one: %v", err)
+	}
+	if !got.EmailVerified {
+		t.Fatalf("ResetPassword did not mark the email verified")
+	}
+
+	if _, err := s.RotateRefreshToken(ctx, session.Token, expiry); !errors.Is(err, storage.ErrInvalidRefreshToken) {
+		t.Fatalf("RotateRefreshToken after reset error = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := s.ResetPassword(ctx, token, "another-password"); !errors.Is(err, storage.ErrInvalidAccountToken) {
+		t.Fatalf("ResetPassword(used) error = %v, want ErrInvalidAccountToken", err)
+	}
+	if _, err := s.AccountTokenUser(ctx, token, storage.TokenResetPassword); !errors.Is(err, storage.ErrInvalidAccountToken) {
+		t.Fatalf("AccountTokenUser(used) error = %v, want ErrInvalidAccountToken", err)
+	}
+}
+
+func testIdentities(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	user := mustCreateUser(t, s)
+	issuer := "https://idp.example.com"
+	subject := uuid.NewString()
+
+	if _, err := s.GetUserByIdentity(ctx, issuer, subject); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetUserByIdentity(unlinked) error = %v, want ErrNotFound", err)
+	}
+	if err := s.LinkIdentity(ctx, user.ID, issuer, subject); err != nil {
+		t.Fatalf("LinkIdentity: %v", err)
+	}
+	got, err := s.GetUserByIdentity(ctx, issuer, subject)
+	if err != nil {
+		t.Fatalf("GetUserByIdentity: %v", err)
+	}
+	if got.ID != user.ID || got.Email != user.Email {
+		t.Fatalf("GetUserByIdentity = %+v, want %s", got, user.ID)
+	}
+	other := mustCreateUser(t, s)
+	if err := s.LinkIdentity(ctx, other.ID, issuer, subject); !errors.Is(err, storage.ErrIdentityLinked) {
+		t.Fatalf("LinkIdentity(linked) error = %v, want ErrIdentityLinked", err)
+	}
+	if err := s.LinkIdentity(ctx, uuid.NewString(), issuer, uuid.NewString()); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("LinkIdentity(unknown user) error = %v, want ErrNotFound", err)
+	}
+
+	ssoSubject := uuid.NewString()
+	provisioned, err := s.CreateUserWithIdentity(ctx, uniqueEmail(), true, issuer, ssoSubject)
+	if err != nil {
+		t.Fatalf("CreateUserWithIdentity: %v", err)
+	}
+	if !provisioned.EmailVerified || provisioned.PasswordHash != "" {
+		t.Fatalf("CreateUserWithIdentity = %+v, want a verified user without a password", provisioned)
+	}
+	got, err = s.GetUserByIdentity(ctx, issuer, ssoSubject)
+	if err != nil {
+		t.Fatalf("GetUserByIdentity: %v", err)
+	}
+	if got.ID != provisioned.ID || !got.EmailVerified {
+		t.Fatalf("GetUserByIdentity = %+v, want verified %s", got, provisioned.ID)
+	}
+	if _, err := s.CreateUserWithIdentity(ctx, uniqueEmail(), false, issuer, subject); !errors.Is(err, storage.ErrIdentityLinked) {
+		t.Fatalf("CreateUserWithIdentity(linked) error = %v, want ErrIdentityLinked", err)
+	}
+	if _, err := s.CreateUserWithIdentity(ctx, user.Email, false, issuer, uuid.NewString()); err == nil {
+		t.Fatalf("CreateUserWithIdentity with a taken email succeeded")
+	}
+}
+
+func testTOTP(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	user := mustCreateUser(t, s)
+
+	totp, err := s.GetTOTP(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetTOTP: %v", err)
+	}
+	if totp.Secret != nil || totp.Enabled {
+		t.Fatalf("GetTOTP(new user) = %+v, want no secret", totp)
+	}
+	if err := s.EnableTOTP(ctx, user.ID, 1, nil); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("EnableTOTP(no secret) error = %v, want ErrNotFound", err)
+	}
+	if err := s.SetTOTPSecret(ctx, user.ID, []byte("first")); err != nil {
+		t.Fatalf("SetTOTPSecret: %v", err)
+	}
+	if err := s.SetTOTPSecret(ctx, user.ID, []byte("second")); err != nil {
+		t.Fatalf("SetTOTPSecret(again): %v", err)
+	}
+	if err := s.EnableTOTP(ctx, user.ID, 100, []string{"AAAA-BBBB", "CCCC-DDDD"}); err != nil {
+		t.Fatalf("EnableTOTP: %v", err)
+	}
+	totp, err = s.GetTOTP(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetTOTP: %v", err)
+	}
+	if string(totp.Secret) != "second" || !totp.Enabled || totp.LastStep != 100 {
+		t.Fatalf("GetTOTP = %+v, want the second secret enabled at step 100", totp)
+	}
+	if got, err := s.GetUserByID(ctx, user.ID); err != nil || !got.TOTPEnabled {
+		t.Fatalf("GetUserByID = %+v, %v; want TOTPEnabled", got, err)
+	}
+	if err := s.SetTOTPSecret(ctx, user.ID, []byte("third")); !errors.Is(err, storage.ErrTOTPEnabled) {
+		t.Fatalf("SetTOTPSecret(enabled) error = %v, want ErrTOTPEnabled", err)
+	}
+	if err := s.EnableTOTP(ctx, user.ID, 101, nil); !errors.Is(err, storage.ErrTOTPEnabled) {
+		t.Fatalf("EnableTOTP(enabled) error = %v, want ErrTOTPEnabled", err)
+	}
+
+	if err := s.RecordTOTPStep(ctx, user.ID, 100); !errors.Is(err, storage.ErrTOTPCodeReused) {
+		t.Fatalf("RecordTOTPStep(same step) error = %v, want ErrTOTPCodeReused", err)
+	}
+	if err := s.RecordTOTPStep(ctx, user.ID, 101); err != nil {
+		t.Fatalf("RecordTOTPStep: %v", err)
+	}
+	if err := s.RecordTOTPStep(ctx, user.ID, 99); !errors.Is(err, storage.ErrTOTPCodeReused) {
+		t.Fatalf("RecordTOTPStep(earlier step) error = %v, want ErrTOTPCodeReused", err)
+	}
+
+	if err := s.UseRecoveryCode(ctx, user.ID, "AAAA-BBBB"); err != nil {
+		t.Fatalf("UseRecoveryCode: %v", err)
+	}
+	if err := s.UseRecoveryCode(ctx, user.ID, "AAAA-BBBB"); !errors.Is(err, storage.ErrInvalidRecoveryCode) {
+		t.Fatalf("UseRecoveryCode(used) error = %v, want ErrInvalidRecoveryCode", err)
+	}
+	other := mustCreateUser(t, s)
+	if err := s.UseRecoveryCode(ctx, other.ID, "CCCC-DDDD"); !errors.Is(err, storage.ErrInvalidRecoveryCode) {
+		t.Fatalf("UseRecoveryCode(another user's code) error = %v, want ErrInvalidRecoveryCode", err)
+	}
+
+	challenge, err := s.CreateAccountToken(ctx, user.ID, storage.TokenLoginChallenge, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("CreateAccountToken: %v", err)
+	}
+	if _, err := s.ConsumeAccountToken(ctx, challenge, storage.TokenVerifyEmail); !errors.Is(err, storage.ErrInvalidAccountToken) {
+		t.Fatalf("ConsumeAccountToken(wrong purpose) error = %v, want ErrInvalidAccountToken", err)
+	}
+	challenge, err = s.CreateAccountToken(ctx, user.ID, storage.TokenLoginChallenge, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("CreateAccountToken: %v", err)
+	}
+	got, err := s.ConsumeAccountToken(ctx, challenge, storage.TokenLoginChallenge)
+	if err != nil {
+		t.Fatalf("ConsumeAccountToken: %v", err)
+	}
+	if got.ID != user.ID || !got.TOTPEnabled {
+		t.Fatalf("ConsumeAccountToken = %+v, want %s with TOTPEnabled", got, user.ID)
+	}
+	if _, err := s.ConsumeAccountToken(ctx, challenge, storage.TokenLoginChallenge); !errors.Is(err, storage.ErrInvalidAccountToken) {
+		t.Fatalf("ConsumeAccountToken(used) error = %v, want ErrInvalidAccountToken", err)
+	}
+
+	if err := s.DisableTOTP(ctx, user.ID); err != nil {
+		t.Fatalf("DisableTOTP: %v", err)
+	}
+	totp, err = s.GetTOTP(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetTOTP: %v", err)
+	}
+	if totp.Secret != nil || totp.Enabled {
+		t.Fatalf("GetTOTP(disabled) = %+v, want no secret", totp)
+	}
+	if err := s.UseRecoveryCode(ctx, user.ID, "CCCC-DDDD"); !errors.Is(err, storage.ErrInvalidRecoveryCode) {
+		t.Fatalf("UseRecoveryCode(disabled) error = %v, want ErrInvalidRecoveryCode", err)
+	}
+	if got, err := s.GetUserByEmail(ctx, user.Email); err != nil || got.TOTPEnabled {
+		t.Fatalf("GetUserByEmail = %+v, %v; want TOTPEnabled false", got, err)
+	}
+	if err := s.DisableTOTP(ctx, uuid.NewString()); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("DisableTOTP(unknown user) error = %v, want ErrNotFound", err)
+	}
+}
+
+func testProfile(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	user := mustCreateUser(t, s)
+
+	name, locale := "Ada Lovelace", "en-GB"
+	got, err := s.UpdateProfile(ctx, user.ID, storage.ProfileUpdate{DisplayName: &name, Locale: &locale})
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if got.DisplayName != name || got.Locale != locale || got.Timezone != "" || got.Email != user.Email {
+		t.Fatalf("UpdateProfile = %+v, want name and locale set", got)
+	}
+	zone, empty := "Europe/London", ""
+	if _, err := s.UpdateProfile(ctx, user.ID, storage.ProfileUpdate{Timezone: &zone, Locale: &empty}); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	got, err = s.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.DisplayName != name || got.Locale != "" || got.Timezone != zone {
+		t.Fatalf("GetUserByID = %+v, want name kept, locale cleared and timezone set", got)
+	}
+	if _, err := s.UpdateProfile(ctx, uuid.NewString(), storage.ProfileUpdate{DisplayName: &name}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("UpdateProfile(unknown user) error = %v, want ErrNotFound", err)
+	}
+
+	team, err := s.CreateTeam(ctx, "Profiles", user.ID)
+	if err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	members, err := s.GetTeamMembers(ctx, team.ID, user.ID)
+	if err != nil {
+		t.Fatalf("GetTeamMembers: %v", err)
+	}
+	if len(members) != 1 || members[0].DisplayName != name {
+		t.Fatalf("GetTeamMembers = %+v, want the member's display name", members)
+	}
+
+	refresh, err := s.CreateRefreshToken(ctx, user.ID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if err := s.ChangePassword(ctx, user.ID, "a-new-password"); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	got, err = s.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(got.PasswordHash), []byte("a-new-password")) != nil {
+		t.Fatalf("ChangePassword did not set the password")
+	}
+	if _, err := s.RotateRefreshToken(ctx, refresh.Token, time.Now().Add(time.Hour)); err == nil {
+		t.Fatalf("RotateRefreshToken after ChangePassword succeeded, want the session ended")
+	}
+	if err := s.ChangePassword(ctx, uuid.NewString(), "a-new-password"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("ChangePassword(unknown user) error = %v, want ErrNotFound", err)
+	}
+
+	verify, err := s.CreateAccountToken(ctx, user.ID, storage.TokenVerifyEmail, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateAccountToken: %v", err)
+	}
+	if _, err := s.VerifyEmail(ctx, verify); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	stale, err := s.CreateAccountToken(ctx, user.ID, storage.TokenResetPassword, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateAccountToken: %v", err)
+	}
+	newEmail := uniqueEmail()
+	got, err = s.ChangeEmail(ctx, user.ID, newEmail)
+	if err != nil {
+		t.Fatalf("ChangeEmail: %v", err)
+	}
+	if got.Email != newEmail || got.EmailVerified || got.DisplayName != name {
+		t.Fatalf("ChangeEmail = %+v, want unverified %s", got, newEmail)
+	}
+	if _, err := s.ResetPassword(ctx, stale, "another-password"); !errors.Is(err, storage.ErrInvalidAccountToken) {
+		t.Fatalf("ResetPassword(token for the old email) error = %v, want ErrInvalidAccountToken", err)
+	}
+	other := mustCreateUser(t, s)
+	if _, err := s.ChangeEmail(ctx, user.ID, other.Email); !errors.Is(err, storage.ErrEmailTaken) {
+		t.Fatalf("ChangeEmail(taken) error = %v, want ErrEmailTaken", err)
+	}
+	if _, err := s.ChangeEmail(ctx, uuid.NewString(), uniqueEmail()); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("ChangeEmail(unknown user) error = %v, want ErrNotFound", err)
+	}
+}
+
+func testListDocumentShares(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	owner := mustCreateUser(t, s)
+	reader := mustCreateUser(t, s)
+	team := mustCreateTeam(t, s, "Readers", owner.ID)
+	doc := mustCreateDocument(t, s, "Shared", owner.ID)
+
+	if err := s.ShareDocument(ctx, doc.ID, owner.ID, reader.ID, storage.RoleViewer); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+	if err := s.ShareDocumentWithTeam(ctx, doc.ID, owner.ID, team.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("ShareDocumentWithTeam: %v", err)
+	}
+	shares, err := s.ListDocumentShares(ctx, doc.ID, owner.ID)
+	if err != nil {
+		t.Fatalf("ListDocumentShares: %v", err)
+	}
+	if len(shares) != 2 ||
+		shares[0].UserID != reader.ID || shares[0].Email != reader.Email || shares[0].Role != storage.RoleViewer ||
+		shares[1].TeamID != team.ID || shares[1].TeamName != "Readers" || shares[1].Role != storage.RoleEditor {
+		t.Fatalf("ListDocumentShares = %+v, want the reader, then the team", shares)
+	}
+	if _, err := s.ListDocumentShares(ctx, doc.ID, reader.ID); !errors.Is(err, storage.ErrNotDocumentOwner) {
+		t.Fatalf("ListDocumentShares by non-owner error = %v, want ErrNotDocumentOwner", err)
+	}
+}
+
+func mustDeleteUser(t *testing.T, s storage.Store, userID string, opts storage.DeleteUserOptions) {
+	t.Helper()
+	if err := s.DeleteUser(context.Background(), userID, opts); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := s.GetUserByID(context.Background(), userID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetUserByID after DeleteUser error = %v, want ErrNotFound", err)
+	}
+}
+
+func testDeleteUserTransfer(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	leaver := mustCreateUser(t, s)
+	heir := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Handover", leaver.ID)
+	if err := s.ShareDocument(ctx, doc.ID, leaver.ID, heir.ID, storage.RoleViewer); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+	folder := mustCreateFolder(t, s, "Projects", leaver.ID, "", "")
+	mustMoveDocument(t, s, doc.ID, leaver.ID, folder.ID)
+
+	if err := s.DeleteUser(ctx, leaver.ID, storage.DeleteUserOptions{Documents: storage.OwnedDocumentsTransfer, TransferTo: leaver.ID}); !errors.Is(err, storage.ErrInvalidTransferTarget) {
+		t.Fatalf("DeleteUser transferring to itself error = %v, want ErrInvalidTransferTarget", err)
+	}
+	if err := s.DeleteUser(ctx, leaver.ID, storage.DeleteUserOptions{Documents: storage.OwnedDocumentsTransfer, TransferTo: uuid.NewString()}); !errors.Is(err, storage.ErrInvalidTransferTarget) {
+		t.Fatalf("DeleteUser transferring to a missing user error = %v, want ErrInvalidTransferTarget", err)
+	}
+	stranger := mustCreateUser(t, s)
+	if err := s.DeleteUser(ctx, leaver.ID, storage.DeleteUserOptions{Documents: storage.OwnedDocumentsTransfer, TransferTo: stranger.ID}); !errors.Is(err, storage.ErrInvalidTransferTarget) {
+		t.Fatalf("DeleteUser transferring to a stranger error = %v, want ErrInvalidTransferTarget", err)
+	}
+	mustDeleteUser(t, s, leaver.ID, storage.DeleteUserOptions{Documents: storage.OwnedDocumentsTransfer, TransferTo: heir.ID})
+
+	got, err := s.GetDocument(ctx, doc.ID)
+	if err != nil {
+		t.Fatalf("GetDocument: %v", err)
+	}
+	if got.OwnerID != heir.ID {
+		t.Fatalf("document owner = %s, want %s", got.OwnerID, heir.ID)
+	}
+	if shares, err := s.ListDocumentShares(ctx, doc.ID, heir.ID); err != nil || len(shares) != 0 {
+		t.Fatalf("ListDocumentShares = %+v, %v; want the heir's own share gone", shares, err)
+	}
+	if f, err := s.GetFolder(ctx, folder.ID, heir.ID); err != nil || f.OwnerID != heir.ID {
+		t.Fatalf("GetFolder = %+v, %v; want the folder transferred", f, err)
+	}
+	if err := s.DeleteUser(ctx, leaver.ID, storage.DeleteUserOptions{Documents: storage.OwnedDocumentsDelete}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("DeleteUser twice error = %v, want ErrNotFound", err)
+	}
+}
+
+// Team-mates and people in the same workspace can inherit an account's
+// documents without having been given any of them.
+func testDeleteUserTransferToColleague(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	leaver := mustCreateUser(t, s)
+	teammate := mustCreateUser(t, s)
+	team := mustCreateTeam(t, s, "Platform", teammate.ID)
+	mustAddTeamMember(t, s, team.ID, teammate.ID, leaver.ID, storage.TeamRoleMember)
+	doc := mustCreateDocument(t, s, "Runbook", leaver.ID)
+	mustDeleteUser(t, s, leaver.ID, storage.DeleteUserOptions{Documents: storage.OwnedDocumentsTransfer, TransferTo: teammate.ID})
+	if got, err := s.GetDocument(ctx, doc.ID); err != nil || got.OwnerID != teammate.ID {
+		t.Fatalf("GetDocument = %+v, %v; want it transferred to the team-mate", got, err)
+	}
+
+	leaver = mustCreateUser(t, s)
+	owner := mustCreateUser(t, s)
+	colleague := mustCreateUser(t, s)
+	ws, err := s.CreateWorkspace(ctx, "Shared space", owner.ID)
+	if err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	for _, member := range []string{leaver.ID, colleague.ID} {
+		if err := s.AddWorkspaceMember(ctx, ws.ID, owner.ID, member, storage.RoleViewer); err != nil {
+			t.Fatalf("AddWorkspaceMember: %v", err)
+		}
+	}
+	doc = mustCreateDocument(t, s, "Notes", leaver.ID)
+	mustDeleteUser(t, s, leaver.ID, storage.DeleteUserOptions{Documents: storage.OwnedDocumentsTransfer, TransferTo: colleague.ID})
+	if got, err := s.GetDocument(ctx, doc.ID); err != nil || got.OwnerID != colleague.ID {
+		t.Fatalf("GetDocument = %+v, %v; want it transferred to the workspace colleague", got, err)
+	}
+}
+
+func testDeleteUserDelete(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	leaver := mustCreateUser(t, s)
+	other := mustCreateUser(t, s)
+	owned := mustCreateDocument(t, s, "Mine", leaver.ID)
+	kept := mustCreateDocument(t, s, "Theirs", other.ID)
+	if err := s.ShareDocument(ctx, kept.ID, other.ID, leaver.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+
+	// A folder the leaver made in someone else's workspace stays there.
+	ws, err := s.CreateWorkspace(ctx, "Shared space", other.ID)
+	if err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	if err := s.AddWorkspaceMember(ctx, ws.ID, other.ID, leaver.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("AddWorkspaceMember: %v", err)
+	}
+	folder := mustCreateFolder(t, s, "Leaver's folder", leaver.ID, "", ws.ID)
+
+	mustDeleteUser(t, s, leaver.ID, storage.DeleteUserOptions{Documents: storage.OwnedDocumentsDelete})
+
+	if _, err := s.GetDocument(ctx, owned.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetDocument(owned) error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetDocument(ctx, kept.ID); err != nil {
+		t.Fatalf("GetDocument(someone else's) error = %v, want it kept", err)
+	}
+	if shares, err := s.ListDocumentShares(ctx, kept.ID, other.ID); err != nil || len(shares) != 0 {
+		t.Fatalf("ListDocumentShares = %+v, %v; want the leaver's share gone", shares, err)
+	}
+	if f, err := s.GetFolder(ctx, folder.ID, other.ID); err != nil || f.OwnerID != other.ID {
+		t.Fatalf("GetFolder = %+v, %v; want the folder kept for the workspace owner", f, err)
+	}
+}
+
+func testDeleteUserOrphan(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	leaver := mustCreateUser(t, s)
+	editor := mustCreateUser(t, s)
+	doc := mustCreateDocument(t, s, "Orphan", leaver.ID)
+	if err := s.ShareDocument(ctx, doc.ID, leaver.ID, editor.ID, storage.RoleEditor); err != nil {
+		t.Fatalf("ShareDocument: %v", err)
+	}
+	link, err := s.CreateShareLink(ctx, doc.ID, leaver.ID, storage.ShareLinkOptions{Role: storage.RoleViewer})
+	if err != nil {
+		t.Fatalf("CreateShareLink: %v", err)
+	}
+	refresh, err := s.CreateRefreshToken(ctx, leaver.ID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	mustDeleteUser(t, s, leaver.ID, storage.DeleteUserOptions{Documents: storage.OwnedDocumentsOrphan})
+
+	if _, err := s.GetUserByEmail(ctx, leaver.Email); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetUserByEmail after DeleteUser error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.RotateRefreshToken(ctx, refresh.Token, time.Now().Add(time.Hour)); err == nil {
+		t.Fatalf("RotateRefreshToken after DeleteUser succeeded, want the session gone")
+	}
+	if role := roleOf(t, s, editor.ID, doc.ID); role != storage.RoleEditor {
+		t.Fatalf("collaborator role = %q, want editor kept", role)
+	}
+	if _, err := s.GetShareLinkByToken(ctx, doc.ID, link.Token); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetShareLinkByToken error = %v, want the orphan's links dropped", err)
+	}
+
+	// The address can be registered again.
+	if _, err := s.CreateUser(ctx, leaver.Email, "password123"); err != nil {
+		t.Fatalf("CreateUser with a deleted account's email: %v", err)
+	}
+}
+
+// testDeleteUserTeams covers what happens to the teams of a deleted owner.
+func testDeleteUserTeams(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	leaver := mustCreateUser(t, s)
+	admin := mustCreateUser(t, s)
+	member := mustCreateUser(t, s)
+	team := mustCreateTeam(t, s, "Inherited", leaver.ID)
+	mustAddTeamMember(t, s, team.ID, leaver.ID, member.ID, storage.TeamRoleMember)
+	mustAddTeamMember(t, s, team.ID, leaver.ID, admin.ID, storage.TeamRoleAdmin)
+	solo := mustCreateTeam(t, s, "Solo", leaver.ID)
+
+	mustDeleteUser(t, s, leaver.ID, storage.DeleteUserOptions{Documents: storage.OwnedDocumentsDelete})
+
+	members, err := s.GetTeamMembers(ctx, team.ID, member.ID)
+	if err != nil {
+		t.Fatalf("GetTeamMembers: %v", err)
+	}
+	roles := map[string]string{}
+	for _, m := range members {
+		roles[m.UserID] = m.Role
+	}
+	if len(roles) != 2 || roles[admin.ID] != storage.TeamRoleOwner || roles[member.ID] != storage.TeamRoleMember {
+		t.Fatalf("team members = %v, want the admin promoted to owner", roles)
+	}
+	if _, err := s.GetTeamMembers(ctx, solo.ID, leaver.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetTeamMembers(solo team) error = %v, want ErrNotFound", err)
+	}
+}
+
+func testPersonalTokens(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	user := mustCreateUser(t, s)
+	other := mustCreateUser(t, s)
+	expiry := time.Now().Add(time.Hour)
+
+	pt, err := s.CreatePersonalToken(ctx, user.ID, "release bot", []string{"read-docs", "write-docs"}, expiry)
+	if err != nil {
+		t.Fatalf("CreatePersonalToken: %v", err)
+	}
+	if !strings.HasPrefix(pt.Token, storage.PersonalTokenPrefix) || pt.UserID != user.ID || pt.LastUsedAt != nil {
+		t.Fatalf("CreatePersonalToken = %+v, want an unused %s... token for %s", pt, storage.PersonalTokenPrefix, user.ID)
+	}
+	if _, err := s.CreatePersonalToken(ctx, uuid.NewString(), "nobody", []string{"read-docs"}, expiry); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("CreatePersonalToken(unknown user) error = %v, want ErrNotFound", err)
+	}
+
+	used, err := s.UsePersonalToken(ctx, pt.Token)
+	if err != nil {
+		t.Fatalf("UsePersonalToken: %v", err)
+	}
+	if used.ID != pt.ID || used.UserID != user.ID || strings.Join(used.Scopes, " ") != "read-docs write-docs" || used.LastUsedAt == nil {
+		t.Fatalf("UsePersonalToken = %+v, want token %s with its scopes, marked used", used, pt.ID)
+	}
+	if _, err := s.UsePersonalToken(ctx, storage.PersonalTokenPrefix+"no-such-token"); !errors.Is(err, storage.ErrInvalidPersonalToken) {
+		t.Fatalf("UsePersonalToken(unknown) error = %v, want ErrInvalidPersonalToken", err)
+	}
+
+	expired, err := s.CreatePersonalToken(ctx, user.ID, "old bot", []string{"read-docs"}, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("CreatePersonalToken(expired): %v", err)
+	}
+	if _, err := s.UsePersonalToken(ctx, expired.Token); !errors.Is(err, storage.ErrInvalidPersonalToken) {
+		t.Fatalf("UsePersonalToken(expired) error = %v, want ErrInvalidPersonalToken", err)
+	}
+
+	tokens, err := s.ListPersonalTokens(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("ListPersonalTokens: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].ID != pt.ID || tokens[0].Token != "" || tokens[0].LastUsedAt == nil {
+		t.Fatalf("ListPersonalTokens = %+v, want only the live token, without its secret", tokens)
+	}
+
+	if err := s.RevokePersonalToken(ctx, other.ID, pt.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("RevokePersonalToken(other user) error = %v, want ErrNotFound", err)
+	}
+	if err := s.RevokePersonalToken(ctx, user.ID, pt.ID); err != nil {
+		t.Fatalf("RevokePersonalToken: %v", err)
+	}
+	if _, err := s.UsePersonalToken(ctx, pt.Token); !errors.Is(err, storage.ErrInvalidPersonalToken) {
+		t.Fatalf("UsePersonalToken(revoked) error = %v, want ErrInvalidPersonalToken", err)
+	}
+
+	kept, err := s.CreatePersonalToken(ctx, other.ID, "ci", []string{"share"}, expiry)
+	if err != nil {
+		t.Fatalf("CreatePersonalToken: %v", err)
+	}
+	mustDeleteUser(t, s, other.ID, storage.DeleteUserOptions{Documents: storage.OwnedDocumentsDelete})
+	if _, err := s.UsePersonalToken(ctx, kept.Token); !errors.Is(err, storage.ErrInvalidPersonalToken) {
+		t.Fatalf("UsePersonalToken(deleted user) error = %v, want ErrInvalidPersonalToken", err)
+	}
+}
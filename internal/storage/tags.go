@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxTagLength is the longest tag, in characters, that NormalizeTag accepts.
+const MaxTagLength = 50
+
+// TagCount is a tag and the number of the caller's documents carrying it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// NormalizeTag trims and lower-cases tag so "Design " and "design" are the
+// same tag. It reports false for empty or overlong tags.
+func NormalizeTag(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || utf8.RuneCountInString(tag) > MaxTagLength {
+		return "", false
+	}
+	return tag, true
+}
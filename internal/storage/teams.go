@@ -0,0 +1,55 @@
+package storage
+
+import "time"
+
+// Team roles. Every team has exactly one owner, its creator; owners and
+// admins manage membership, but only the owner can appoint admins.
+const (
+	TeamRoleOwner  = "owner"
+	TeamRoleAdmin  = "admin"
+	TeamRoleMember = "member"
+)
+
+// ValidTeamRole reports whether role can be given through AddTeamMember.
+func ValidTeamRole(role string) bool {
+	return role == TeamRoleAdmin || role == TeamRoleMember
+}
+
+// Team is a named group of users that documents can be shared with as a
+// whole. Role is the caller's role in the team.
+type Team struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TeamMember is one user's membership of a team.
+type TeamMember struct {
+	UserID      string    `json:"user_id"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+	Role        string    `json:"role"`
+	JoinedAt    time.Time `json:"joined_at"`
+}
+
+// canChangeTeamMember reports whether a member with actorRole may change a
+// member whose current role is targetRole ("" if not yet a member) to
+// newRole ("" to remove them). self is true when actor and target are the
+// same user. All backends enforce membership rules through this function.
+func canChangeTeamMember(actorRole, targetRole, newRole string, self bool) bool {
+	switch {
+	case targetRole == TeamRoleOwner:
+		// The owner can neither be demoted nor leave.
+		return false
+	case self && newRole == "":
+		// Anyone else may leave.
+		return actorRole != ""
+	case actorRole == TeamRoleOwner:
+		return true
+	case actorRole == TeamRoleAdmin:
+		return targetRole != TeamRoleAdmin && newRole != TeamRoleAdmin
+	}
+	return false
+}
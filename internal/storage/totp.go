@@ -0,0 +1,24 @@
+package storage
+
+import "errors"
+
+var (
+	// ErrTOTPEnabled is returned when enrolling a user who already has
+	// two-factor authentication, or enabling it twice.
+	ErrTOTPEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTOTPCodeReused is returned by RecordTOTPStep for a code that, or a
+	// code older than one that, was already accepted.
+	ErrTOTPCodeReused = errors.New("code was already used")
+	// ErrInvalidRecoveryCode is returned for recovery codes that were never
+	// issued to the user or were already used.
+	ErrInvalidRecoveryCode = errors.New("recovery code is invalid or already used")
+)
+
+// TOTP is a user's two-factor authentication setup.
+type TOTP struct {
+	// Secret is the shared secret as encrypted by the caller.
+	Secret  []byte
+	Enabled bool
+	// LastStep is the time step of the last accepted code.
+	LastStep int64
+}
@@ -0,0 +1,101 @@
+package storage
+
+import "errors"
+
+// ErrEmailTaken is returned when changing a user's email to one another
+// account has.
+var ErrEmailTaken = errors.New("email address is already in use")
+
+// ProfileUpdate changes the profile fields that are not nil.
+type ProfileUpdate struct {
+	DisplayName *string
+	AvatarURL   *string
+	Locale      *string
+	Timezone    *string
+}
+
+// apply changes user as update says.
+func (update ProfileUpdate) apply(user *User) {
+	if update.DisplayName != nil {
+		user.DisplayName = *update.DisplayName
+	}
+	if update.AvatarURL != nil {
+		user.AvatarURL = *update.AvatarURL
+	}
+	if update.Locale != nil {
+		user.Locale = *update.Locale
+	}
+	if update.Timezone != nil {
+		user.Timezone = *update.Timezone
+	}
+}
+
+// userColumns are the users columns scanUser reads, in order. They are
+// unqualified, so they can't be selected next to a joined table that has a
+// column of the same name.
+const userColumns = `id, email, password_hash, email_verified_at IS NOT NULL, totp_enabled_at IS NOT NULL,
+	display_name, avatar_url, locale, timezone`
+
+// scanUser scans a row of userColumns; row is a pgx.Row or an *sql.Row.
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	user := &User{}
+	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.EmailVerified, &user.TOTPEnabled,
+		&user.DisplayName, &user.AvatarURL, &user.Locale, &user.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// What DeleteUser does with the documents, workspaces and folders the
+// account owns.
+const (
+	// OwnedDocumentsTransfer hands them to DeleteUserOptions.TransferTo.
+	OwnedDocumentsTransfer = "transfer"
+	// OwnedDocumentsDelete deletes them.
+	OwnedDocumentsDelete = "delete"
+	// OwnedDocumentsOrphan leaves them to the people they are shared with,
+	// who keep their access. Nobody can share them any more, so their share
+	// links, invitations and access requests are dropped.
+	OwnedDocumentsOrphan = "orphan"
+)
+
+// ValidOwnedDocumentsPolicy reports whether policy is one of the
+// OwnedDocuments policies.
+func ValidOwnedDocumentsPolicy(policy string) bool {
+	return policy == OwnedDocumentsTransfer || policy == OwnedDocumentsDelete || policy == OwnedDocumentsOrphan
+}
+
+// ErrInvalidTransferTarget is returned by DeleteUser when the documents are
+// to be transferred to the account itself, to a user that doesn't exist, or
+// to one it has nothing to do with: the heir must be a collaborator on
+// something the account owns, or share a team or workspace with it.
+var ErrInvalidTransferTarget = errors.New("documents can't be transferred to that user")
+
+// DeleteUserOptions says what DeleteUser does with what the account owns.
+type DeleteUserOptions struct {
+	// Documents is one of the OwnedDocuments policies.
+	Documents string
+	// TransferTo is the ID of the user who gets everything under
+	// OwnedDocumentsTransfer.
+	TransferTo string
+}
+
+// userRowTables are the tables whose user_id rows belong to the account
+// alone. They go with the users row through ON DELETE CASCADE, except when
+// the SQL backends keep the row for orphaned documents and delete them
+// explicitly.
+var userRowTables = []string{
+	"document_permissions",
+	"document_opens",
+	"document_favorites",
+	"folder_permissions",
+	"workspace_members",
+	"team_members",
+	"access_requests",
+	"refresh_tokens",
+	"account_tokens",
+	"user_identities",
+	"user_recovery_codes",
+	"personal_access_tokens",
+}
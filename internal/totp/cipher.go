@@ -0,0 +1,59 @@
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Cipher encrypts secrets for storage with AES-256-GCM. Each secret is bound
+// to its user, so a secret copied onto another account's row won't decrypt.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher returns a Cipher for key, a base64-encoded 32-byte key such as
+// the output of `openssl rand -base64 32`.
+func NewCipher(key string) (*Cipher, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("TOTP encryption key is not valid base64: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("TOTP encryption key is %d bytes, want 32", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Encrypt seals userID's secret, prefixed with a random nonce.
+func (c *Cipher) Encrypt(userID, secret string) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(secret)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, []byte(secret), []byte(userID)), nil
+}
+
+// Decrypt opens a secret sealed by Encrypt for userID.
+func (c *Cipher) Decrypt(userID string, sealed []byte) (string, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("encrypted TOTP secret is truncated")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	secret, err := c.aead.Open(nil, nonce, ciphertext, []byte(userID))
+	if err != nil {
+		return "", fmt.Errorf("decrypting TOTP secret: %w", err)
+	}
+	return string(secret), nil
+}
@@ -0,0 +1,30 @@
+package totp
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// RecoveryCodeCount is how many recovery codes a user gets.
+const RecoveryCodeCount = 10
+
+// GenerateRecoveryCodes returns RecoveryCodeCount new single-use codes
+// formatted like "ABCD-EFGH-IJKL-MNOP" (80 bits each).
+func GenerateRecoveryCodes() []string {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		text := rand.Text()[:16]
+		codes[i] = text[:4] + "-" + text[4:8] + "-" + text[8:12] + "-" + text[12:]
+	}
+	return codes
+}
+
+// NormalizeRecoveryCode returns code the way GenerateRecoveryCodes formats
+// it, so users may type it in lower case or without dashes.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 16 {
+		return code
+	}
+	return code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:]
+}
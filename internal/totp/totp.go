@@ -0,0 +1,110 @@
+// Package totp implements time-based one-time passwords (RFC 6238) as
+// generated by authenticator apps: HMAC-SHA1, six digits and 30-second
+// steps, the parameters every app supports.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is how long each code is valid.
+	Period = 30 * time.Second
+	// Digits is the length of a code.
+	Digits = 6
+	// Skew is how many steps either side of the current one are accepted,
+	// to allow for clock drift and slow typing.
+	Skew = 1
+)
+
+// encoding is how secrets are shown to users and put in otpauth URIs.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random 160-bit secret, base32-encoded.
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(buf), nil
+}
+
+// URI returns the otpauth:// URI authenticator apps enroll from, usually by
+// scanning it as a QR code. issuer names the service and account the user.
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Period.Seconds()))},
+	}
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step returns the time step t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code for secret at time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return codeFor(key, step), nil
+}
+
+// Validate checks code against secret at time now and returns the step it
+// was generated for, which callers should remember so the code can't be
+// used again.
+func Validate(secret, code string, now time.Time) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+	current := Step(now)
+	for step := current - Skew; step <= current+Skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(codeFor(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.TrimRight(strings.ToUpper(strings.ReplaceAll(secret, " ", "")), "=")
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	return key, nil
+}
+
+// codeFor is the HOTP value (RFC 4226) of key for counter step.
+func codeFor(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
@@ -0,0 +1,96 @@
+package totp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pasanAbeysekara/collaborative-editor/internal/totp"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238's test vectors, "12345678901234567890",
+// in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 appendix B lists eight-digit codes; six-digit ones are their last
+// six digits.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		code, err := totp.Code(rfcSecret, totp.Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		if code != v.code {
+			t.Errorf("code at %d = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, v := range rfcVectors {
+		now := time.Unix(v.unix, 0)
+		step, ok := totp.Validate(rfcSecret, v.code, now)
+		if !ok || step != totp.Step(now) {
+			t.Errorf("Validate(%s at %d) = %d, %v; want step %d", v.code, v.unix, step, ok, totp.Step(now))
+		}
+	}
+
+	now := time.Unix(1111111111, 0)
+	// Secrets are accepted as typed by hand, and codes as apps group them.
+	if _, ok := totp.Validate("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", "050 471", now); !ok {
+		t.Error("Validate rejected a spaced, lower-case secret and code")
+	}
+	for _, code := range []string{"", "05047", "0504711", "150471"} {
+		if _, ok := totp.Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) accepted", code)
+		}
+	}
+	if _, ok := totp.Validate("not base32!", "050471", now); ok {
+		t.Error("Validate accepted an invalid secret")
+	}
+}
+
+func TestValidateAllowsOneStepOfSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := totp.Step(now)
+	for offset := int64(-2); offset <= 2; offset++ {
+		code, err := totp.Code(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		step, ok := totp.Validate(rfcSecret, code, now)
+		if want := offset >= -totp.Skew && offset <= totp.Skew; ok != want {
+			t.Errorf("Validate(code for step %+d) = %v, want %v", offset, ok, want)
+		} else if ok && step != current+offset {
+			t.Errorf("Validate(code for step %+d) returned step %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Fatalf("secret %q has %d characters, want 32 (160 bits)", secret, len(secret))
+	}
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+	if _, ok := totp.Validate(secret, code, time.Now()); !ok {
+		t.Fatal("a generated secret's current code was rejected")
+	}
+}
@@ -1,2 +1,2 @@
 ALTER TABLE documents
-ADD COLUMN content TEXT DEFAULT '';
\ No newline at end of file
+ADD COLUMN IF NOT EXISTS content TEXT DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE documents
+DROP COLUMN IF EXISTS version;
@@ -1,2 +1,2 @@
 ALTER TABLE documents
-ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
\ No newline at end of file
+ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 0;
\ No newline at end of file
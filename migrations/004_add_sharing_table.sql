@@ -1,5 +1,5 @@
 -- This table will store permissions for document sharing.
-CREATE TABLE document_permissions (
+CREATE TABLE IF NOT EXISTS document_permissions (
     document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     -- 'role' can be used for future enhancements (e.g., 'viewer', 'editor')
@@ -11,4 +11,4 @@ CREATE TABLE document_permissions (
 );
 
 -- Create an index for efficient lookups by user.
-CREATE INDEX idx_document_permissions_user_id ON document_permissions(user_id);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_document_permissions_user_id ON document_permissions(user_id);
\ No newline at end of file
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_documents_owner_id;
+DROP TABLE IF EXISTS document_opens;
+ALTER TABLE documents
+DROP COLUMN IF EXISTS updated_at;
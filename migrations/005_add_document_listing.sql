@@ -0,0 +1,13 @@
+-- Track modification times and per-user opens so document lists can be sorted by them.
+ALTER TABLE documents
+ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE TABLE IF NOT EXISTS document_opens (
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    opened_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (document_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_opens_user_id ON document_opens(user_id);
+CREATE INDEX IF NOT EXISTS idx_documents_owner_id ON documents(owner_id);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_documents_folder_id;
+ALTER TABLE documents
+DROP COLUMN IF EXISTS folder_id;
+DROP TABLE IF EXISTS folder_permissions;
+DROP TABLE IF EXISTS folders;
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
@@ -0,0 +1,17 @@
+-- Share links grant a role on a document to anyone holding the token. Only
+-- the SHA-256 of the token is stored; max_uses = 0 means unlimited.
+CREATE TABLE IF NOT EXISTS share_links (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    role VARCHAR(20) NOT NULL,
+    password_hash VARCHAR(255) NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    uses INTEGER NOT NULL DEFAULT 0,
+    created_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_document_id ON share_links(document_id);
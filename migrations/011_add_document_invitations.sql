@@ -0,0 +1,13 @@
+-- Pending shares with email addresses that have no account yet. They are
+-- converted into document_permissions rows when the address registers.
+CREATE TABLE IF NOT EXISTS document_invitations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'editor',
+    invited_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (document_id, email)
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_invitations_email ON document_invitations(email);
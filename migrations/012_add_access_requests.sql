@@ -0,0 +1,16 @@
+-- Requests from users without (enough) access, decided by the document
+-- owner. Approving one upserts the requester's document_permissions row.
+CREATE TABLE IF NOT EXISTS access_requests (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    decided_at TIMESTAMPTZ
+);
+
+-- At most one pending request per user and document.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_access_requests_pending
+    ON access_requests(document_id, user_id) WHERE status = 'pending';
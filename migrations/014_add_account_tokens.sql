@@ -0,0 +1,18 @@
+-- Email verification and password reset. account_tokens holds the
+-- single-use tokens mailed to users; only the SHA-256 of a token is stored,
+-- along with the address it was sent to so that changing the email address
+-- invalidates it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS account_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(32) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_tokens_user_id ON account_tokens(user_id, purpose);
@@ -0,0 +1,12 @@
+-- External identities (OpenID Connect issuer and subject) users sign in
+-- with. Accounts provisioned through single sign-on have an empty
+-- password_hash, which never matches a password.
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
@@ -0,0 +1,15 @@
+-- Two-factor authentication. totp_secret is encrypted by user-service before
+-- it is stored; totp_enabled_at is set once the user has proven their
+-- authenticator works. totp_last_step is the time step of the last accepted
+-- code, so no code is accepted twice. Only the SHA-256 of recovery codes is
+-- stored.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret BYTEA;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ,
+    PRIMARY KEY (user_id, code_hash)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN IF EXISTS timezone;
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_url;
+ALTER TABLE users DROP COLUMN IF EXISTS display_name;
@@ -0,0 +1,5 @@
+ALTER TABLE documents DROP CONSTRAINT IF EXISTS documents_owner_id_fkey;
+ALTER TABLE documents ADD CONSTRAINT documents_owner_id_fkey
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,10 @@
+-- Deleting a user used to cascade to the documents they own, destroying
+-- documents other people were still working on. Accounts are now deleted
+-- through Store.DeleteUser, which deals with owned documents first, and the
+-- database refuses to delete a user who still owns any. An account whose
+-- documents are orphaned is kept as an anonymised row marked by deleted_at.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+ALTER TABLE documents DROP CONSTRAINT IF EXISTS documents_owner_id_fkey;
+ALTER TABLE documents ADD CONSTRAINT documents_owner_id_fkey
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE RESTRICT;
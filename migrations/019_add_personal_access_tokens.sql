@@ -0,0 +1,15 @@
+-- Personal access tokens let automation act as a user within a set of
+-- scopes, stored space-separated. Only the SHA-256 of the token is stored.
+-- last_used_at is refreshed at most once a minute.
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_id ON personal_access_tokens(user_id);
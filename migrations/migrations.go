@@ -0,0 +1,17 @@
+// Package migrations embeds the SQL schema so binaries can apply it themselves
+// through internal/migrate. NNN_name.sql files are "up" scripts and
+// NNN_name.down.sql files revert them. Up scripts for PostgreSQL stay
+// idempotent so databases that were migrated by hand can adopt the runner.
+package migrations
+
+import "embed"
+
+// Postgres holds the PostgreSQL schema, in the root of the FS.
+//
+//go:embed *.sql
+var Postgres embed.FS
+
+// SQLite holds the SQLite flavour of the schema, under sqlite/.
+//
+//go:embed sqlite/*.sql
+var SQLite embed.FS
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_documents_owner_id;
+DROP TABLE IF EXISTS document_opens;
+ALTER TABLE documents DROP COLUMN updated_at;
@@ -0,0 +1,16 @@
+-- Timestamps written by the application use a fixed-width UTC format so they
+-- sort lexically; convert the CURRENT_TIMESTAMP defaults to match.
+UPDATE documents SET created_at = strftime('%Y-%m-%dT%H:%M:%S.000000000Z', created_at);
+
+ALTER TABLE documents ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '';
+UPDATE documents SET updated_at = created_at;
+
+CREATE TABLE document_opens (
+    document_id TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    opened_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (document_id, user_id)
+);
+
+CREATE INDEX idx_document_opens_user_id ON document_opens(user_id);
+CREATE INDEX idx_documents_owner_id ON documents(owner_id);
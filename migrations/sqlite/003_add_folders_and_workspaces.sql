@@ -0,0 +1,47 @@
+-- SQLite equivalent of migration 007.
+
+CREATE TABLE workspaces (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    owner_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE workspace_members (
+    workspace_id TEXT NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL DEFAULT 'editor',
+    PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE TABLE folders (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    owner_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id TEXT REFERENCES folders(id) ON DELETE CASCADE,
+    workspace_id TEXT REFERENCES workspaces(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE folder_permissions (
+    folder_id TEXT NOT NULL REFERENCES folders(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL DEFAULT 'editor',
+    PRIMARY KEY (folder_id, user_id)
+);
+
+-- SQLite can't drop a column that takes part in a foreign key, so instead of
+-- ON DELETE SET NULL a trigger unfiles documents when their folder goes away.
+ALTER TABLE documents ADD COLUMN folder_id TEXT;
+
+CREATE TRIGGER folders_unfile_documents AFTER DELETE ON folders
+BEGIN
+    UPDATE documents SET folder_id = NULL WHERE folder_id = OLD.id;
+END;
+
+CREATE INDEX idx_documents_folder_id ON documents(folder_id);
+CREATE INDEX idx_folders_parent_id ON folders(parent_id);
+CREATE INDEX idx_folders_workspace_id ON folders(workspace_id);
+CREATE INDEX idx_folders_owner_id ON folders(owner_id);
+CREATE INDEX idx_folder_permissions_user_id ON folder_permissions(user_id);
+CREATE INDEX idx_workspace_members_user_id ON workspace_members(user_id);
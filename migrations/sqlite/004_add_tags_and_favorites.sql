@@ -0,0 +1,18 @@
+-- SQLite equivalent of migration 008.
+
+CREATE TABLE document_tags (
+    document_id TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    tag TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (document_id, tag)
+);
+
+CREATE TABLE document_favorites (
+    document_id TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (document_id, user_id)
+);
+
+CREATE INDEX idx_document_tags_tag ON document_tags(tag);
+CREATE INDEX idx_document_favorites_user_id ON document_favorites(user_id);
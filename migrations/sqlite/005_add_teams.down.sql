@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS team_document_permissions;
+DROP TABLE IF EXISTS team_members;
+DROP TABLE IF EXISTS teams;
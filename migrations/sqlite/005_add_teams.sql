@@ -0,0 +1,25 @@
+-- SQLite equivalent of migration 009.
+
+CREATE TABLE teams (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE team_members (
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL DEFAULT 'member',
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (team_id, user_id)
+);
+
+CREATE TABLE team_document_permissions (
+    document_id TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    team_id TEXT NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    role TEXT NOT NULL DEFAULT 'editor',
+    PRIMARY KEY (document_id, team_id)
+);
+
+CREATE INDEX idx_team_members_user_id ON team_members(user_id);
+CREATE INDEX idx_team_document_permissions_team_id ON team_document_permissions(team_id);
@@ -0,0 +1,13 @@
+-- SQLite equivalent of migration 011.
+
+CREATE TABLE document_invitations (
+    id TEXT PRIMARY KEY,
+    document_id TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'editor',
+    invited_by TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (document_id, email)
+);
+
+CREATE INDEX idx_document_invitations_email ON document_invitations(email);
@@ -0,0 +1,15 @@
+-- SQLite equivalent of migration 012.
+
+CREATE TABLE access_requests (
+    id TEXT PRIMARY KEY,
+    document_id TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP NOT NULL,
+    decided_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_access_requests_pending
+    ON access_requests(document_id, user_id) WHERE status = 'pending';
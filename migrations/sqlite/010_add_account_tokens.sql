@@ -0,0 +1,16 @@
+-- SQLite equivalent of migration 014.
+
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+
+CREATE TABLE account_tokens (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose TEXT NOT NULL,
+    email TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX idx_account_tokens_user_id ON account_tokens(user_id, purpose);
@@ -0,0 +1,6 @@
+-- SQLite equivalent of migration 017.
+
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN locale TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT '';
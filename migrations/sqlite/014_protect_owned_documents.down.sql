@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS users_keep_owned_documents;
+ALTER TABLE users DROP COLUMN deleted_at;
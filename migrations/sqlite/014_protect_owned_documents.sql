@@ -0,0 +1,11 @@
+-- SQLite equivalent of migration 018. SQLite can't change a foreign key's
+-- ON DELETE action without rebuilding the table, so a trigger refuses to
+-- delete a user who still owns documents instead.
+
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE TRIGGER users_keep_owned_documents BEFORE DELETE ON users
+WHEN EXISTS (SELECT 1 FROM documents WHERE owner_id = OLD.id)
+BEGIN
+    SELECT RAISE(ABORT, 'user still owns documents');
+END;
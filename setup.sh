@@ -10,6 +10,11 @@ docker build -t notification-service:v1 -f ./cmd/notification-service/Dockerfile
 
 kubectl apply -f k8s/secrets.yaml
 
+# The key user-service signs access tokens with
+mkdir -p keys
+[ -f keys/jwt-signing.pem ] || openssl genpkey -algorithm ed25519 -out keys/jwt-signing.pem
+kubectl create secret generic jwt-signing-key --from-file=jwt-signing.pem=keys/jwt-signing.pem --dry-run=client -o yaml | kubectl apply -f -
+
 kubectl apply -f k8s/
 
 kubectl get pods
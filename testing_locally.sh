@@ -1,8 +1,8 @@
 export MINIKUBE_IP=$(minikube ip)
 
 echo -e "\n--- 2. Logging in Users ---"
-export TOKEN_A=$(curl -s -X POST -H "Content-Type: application/json" -d '{"email":"owner@example.com", "password":"password123"}' http://$MINIKUBE_IP/auth/login | jq -r .token)
-export TOKEN_B=$(curl -s -X POST -H "Content-Type: application/json" -d '{"email":"collab@example.com", "password":"password123"}' http://$MINIKUBE_IP/auth/login | jq -r .token)
+export TOKEN_A=$(curl -s -X POST -H "Content-Type: application/json" -d '{"email":"owner@example.com", "password":"a-very-strong-password"}' http://$MINIKUBE_IP/auth/login | jq -r .token)
+export TOKEN_B=$(curl -s -X POST -H "Content-Type: application/json" -d '{"email":"collab@example.com", "password":"a-very-strong-password"}' http://$MINIKUBE_IP/auth/login | jq -r .token)
 
 echo "--- 3. Creating Document ---"
 export DOCUMENT_ID=$(curl -s -X POST -H "Content-Type: application/json" -H "Authorization: Bearer $TOKEN_A" -d '{"title":"Live Test Doc"}' http://$MINIKUBE_IP/documents | jq -r .ID)
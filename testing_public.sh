@@ -1,8 +1,8 @@
 export SOURCE_URL=cautious-dollop-rwwq5vgpxx6369g-8080.app.github.dev
 
 echo -e "\n--- 2. Logging in Users ---"
-export TOKEN_A=$(curl -s -X POST -H "Content-Type: application/json" -d '{"email":"owner@example.com", "password":"password123"}' https://$SOURCE_URL/auth/login | jq -r .token)
-export TOKEN_B=$(curl -s -X POST -H "Content-Type: application/json" -d '{"email":"collab@example.com", "password":"password123"}' https://$SOURCE_URL/auth/login | jq -r .token)
+export TOKEN_A=$(curl -s -X POST -H "Content-Type: application/json" -d '{"email":"owner@example.com", "password":"a-very-strong-password"}' https://$SOURCE_URL/auth/login | jq -r .token)
+export TOKEN_B=$(curl -s -X POST -H "Content-Type: application/json" -d '{"email":"collab@example.com", "password":"a-very-strong-password"}' https://$SOURCE_URL/auth/login | jq -r .token)
 
 echo "--- 3. Creating Document ---"
 export DOCUMENT_ID=$(curl -s -X POST -H "Content-Type: application/json" -H "Authorization: Bearer $TOKEN_A" -d '{"title":"Live Test Doc"}' https://$SOURCE_URL/documents | jq -r .ID)